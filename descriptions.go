@@ -363,7 +363,7 @@ func (contents IssueWizardContents) ChoosePath(conf *Configuration, creds map[Cr
 }
 
 func (wizard *IssueWizard) Validate(conf *Configuration) error {
-	conf.validateTranslations(fmt.Sprintf("issue wizard %s", wizard.ID), wizard)
+	conf.warn(validateTranslations(fmt.Sprintf("issue wizard %s", wizard.ID), wizard)...)
 
 	if (wizard.SuccessHeader == nil) != (wizard.SuccessText == nil) {
 		return errors.New("wizard contents must have success header and text either both specified, or both empty")
@@ -411,13 +411,13 @@ func (wizard *IssueWizard) Validate(conf *Configuration) error {
 				if err := item.validate(conf); err != nil {
 					return errors.Errorf("item %d.%d.%d: %w", i, j, k, err)
 				}
-				conf.validateTranslations(fmt.Sprintf("item %d.%d.%d", i, j, k), item)
+				conf.warn(validateTranslations(fmt.Sprintf("item %d.%d.%d", i, j, k), item)...)
 			}
 		}
 	}
-	conf.validateTranslations("issue wizard", wizard)
+	conf.warn(validateTranslations("issue wizard", wizard)...)
 	for i, qa := range wizard.FAQ {
-		conf.validateTranslations(fmt.Sprintf("QA %d", i), qa)
+		conf.warn(validateTranslations(fmt.Sprintf("QA %d", i), qa)...)
 	}
 
 	return nil
@@ -693,10 +693,15 @@ type xmlTranslatedString struct {
 
 // MarshalXML implements xml.Marshaler.
 func (ts *TranslatedString) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	langs := make([]string, 0, len(*ts))
+	for lang := range *ts {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs) // for deterministic output
 	temp := &xmlTranslatedString{}
-	for lang, text := range *ts {
+	for _, lang := range langs {
 		temp.Translations = append(temp.Translations,
-			xmlTranslation{XMLName: xml.Name{Local: lang}, Text: text},
+			xmlTranslation{XMLName: xml.Name{Local: lang}, Text: (*ts)[lang]},
 		)
 	}
 	return e.EncodeElement(temp, start)
@@ -10,6 +10,7 @@ require (
 	github.com/certifi/gocertifi v0.0.0-20180118203423-deb3ae2ef261 // indirect
 	github.com/cockroachdb/apd v1.1.0 // indirect
 	github.com/eknkc/basex v1.0.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/fxamacker/cbor v1.5.0
 	github.com/getsentry/raven-go v0.0.0-20180121060056-563b81fc02b7
 	github.com/go-chi/chi v3.3.3+incompatible
@@ -27,13 +28,16 @@ require (
 	github.com/jinzhu/gorm v1.9.12
 	github.com/lib/pq v1.3.0 // indirect
 	github.com/mattn/go-isatty v0.0.4 // indirect
+	github.com/mattn/go-sqlite3 v2.0.3+incompatible // indirect
 	github.com/mdp/qrterminal v1.0.1
 	github.com/mgutz/ansi v0.0.0-20170206155736-9520e82c474b // indirect
+	github.com/miekg/pkcs11 v1.1.1
 	github.com/mitchellh/mapstructure v1.1.2
 	github.com/privacybydesign/gabi v0.0.0-20210714094051-ba80a6a8c5d8
+	github.com/prometheus/client_golang v1.10.0
 	github.com/shopspring/decimal v1.2.0 // indirect
 	github.com/sietseringers/go-sse v0.0.0-20200801161811-e2cf2c63ca50
-	github.com/sirupsen/logrus v1.4.2
+	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/afero v1.2.0 // indirect
 	github.com/spf13/cast v1.3.0
 	github.com/spf13/cobra v1.1.3
@@ -42,5 +46,6 @@ require (
 	github.com/stretchr/testify v1.7.0
 	github.com/timshannon/bolthold v0.0.0-20190812165541-a85bcc049a2e // indirect
 	github.com/x-cray/logrus-prefixed-formatter v0.5.2
-	go.etcd.io/bbolt v1.3.2
+	go.etcd.io/bbolt v1.3.3
+	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
 )
@@ -0,0 +1,165 @@
+package sessiontest
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/test"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/privacybydesign/irmago/server/oidcprovider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oidcIssuer      = "http://localhost:48690"
+	oidcRedirectURI = "http://localhost:48691/callback"
+)
+
+func startOIDCProvider(t *testing.T) (*oidcprovider.Server, *http.Server) {
+	testdata := test.FindTestdataFolder(t)
+	provider, err := oidcprovider.New(&oidcprovider.Configuration{
+		Configuration: &server.Configuration{
+			SchemesPath:       filepath.Join(testdata, "irma_configuration"),
+			JwtPrivateKeyFile: filepath.Join(testdata, "jwtkeys", "sk.pem"),
+			Logger:            logger,
+		},
+		Issuer: oidcIssuer,
+		Clients: map[string]oidcprovider.Client{
+			"myapp": {Secret: "secret", RedirectURIs: []string{oidcRedirectURI}},
+		},
+		Scopes: map[string]irma.AttributeConDisCon{
+			"student": {{{irma.NewAttributeRequest("irma-demo.RU.studentCard.studentID")}}},
+		},
+		Claims: map[string]string{"irma-demo.RU.studentCard.studentID": "student_id"},
+	})
+	require.NoError(t, err)
+
+	serv := &http.Server{Addr: "localhost:48690", Handler: provider.Handler()}
+	go func() {
+		err := serv.ListenAndServe()
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+		assert.NoError(t, err)
+	}()
+	time.Sleep(200 * time.Millisecond) // Give server time to start
+
+	return provider, serv
+}
+
+func TestOIDCProvider(t *testing.T) {
+	provider, serv := startOIDCProvider(t)
+	defer func() {
+		provider.Stop()
+		_ = serv.Close()
+	}()
+	client, handler := parseStorage(t)
+	defer test.ClearTestStorage(t, handler.storage)
+
+	// The browser of the user, which should not follow redirects to the relying party
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	browser := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	location := func(res *http.Response, err error) *url.URL {
+		require.NoError(t, err)
+		require.Equal(t, http.StatusFound, res.StatusCode)
+		u, err := url.Parse(res.Header.Get("Location"))
+		require.NoError(t, err)
+		return u
+	}
+
+	// The relying party sends the user to the authorization endpoint, which sends the user on to the login page
+	login := location(browser.Get(oidcIssuer + "/authorize?" + url.Values{
+		"client_id":     {"myapp"},
+		"redirect_uri":  {oidcRedirectURI},
+		"response_type": {"code"},
+		"scope":         {"openid student"},
+		"state":         {"state"},
+		"nonce":         {"nonce"},
+	}.Encode()))
+	require.True(t, strings.HasPrefix(login.String(), oidcIssuer+"/login/"))
+
+	// The login page fetches the session pointer, which the IRMA app uses to disclose the attribute
+	var pkg server.SessionPackage
+	require.NoError(t, irma.NewHTTPTransport(login.String(), false).Get("session", &pkg))
+	qrjson, err := json.Marshal(pkg.SessionPtr)
+	require.NoError(t, err)
+	c := make(chan *SessionResult, 1)
+	client.NewSession(string(qrjson), &TestHandler{t: t, c: c, client: client})
+	if result := <-c; result != nil {
+		require.NoError(t, result.Err)
+	}
+
+	// After the session the user is sent back to the relying party with an authorization code
+	callback := location(browser.Get(login.String() + "/done"))
+	require.Equal(t, oidcRedirectURI, callback.Scheme+"://"+callback.Host+callback.Path)
+	require.Equal(t, "state", callback.Query().Get("state"))
+	code := callback.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	// The relying party exchanges the code for tokens
+	req, err := http.NewRequest(http.MethodPost, oidcIssuer+"/token", strings.NewReader(url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {oidcRedirectURI},
+	}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("myapp", "secret")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+
+	// The ID token is verified against the JWKS of the provider and contains the disclosed attribute
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	require.NoError(t, irma.NewHTTPTransport(oidcIssuer, false).Get("jwks", &jwks))
+	require.Len(t, jwks.Keys, 1)
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(tokens.IDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		require.Equal(t, jwks.Keys[0].Kid, token.Header["kid"])
+		n, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+		require.NoError(t, err)
+		e, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+		require.NoError(t, err)
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, oidcIssuer, claims["iss"])
+	require.Equal(t, "myapp", claims["aud"])
+	require.Equal(t, "nonce", claims["nonce"])
+	require.Equal(t, "456", claims["student_id"])
+	require.NotEmpty(t, claims["sub"])
+
+	// The same claims are available at the userinfo endpoint
+	transport := irma.NewHTTPTransport(oidcIssuer, false)
+	transport.SetHeader("Authorization", "Bearer "+tokens.AccessToken)
+	var userinfo map[string]string
+	require.NoError(t, transport.Get("userinfo", &userinfo))
+	require.Equal(t, map[string]string{"sub": claims["sub"].(string), "student_id": "456"}, userinfo)
+}
@@ -138,6 +138,9 @@ func TestRedis(t *testing.T) {
 	t.Run("TestIssuedCredentialIsStored", TestIssuedCredentialIsStored)
 	t.Run("TestChainedSessions", TestChainedSessions)
 	t.Run("TestUnknownRequestorToken", TestUnknownRequestorToken)
+	t.Run("TestRequestorLimits", TestRequestorLimits)
+	t.Run("TestRequestorConcurrentSessionsLimit", TestRequestorConcurrentSessionsLimit)
+	t.Run("TestAdminSessionsAPI", TestAdminSessionsAPI)
 }
 
 func TestRedisTLSConfig(t *testing.T) {
@@ -5,14 +5,19 @@ package sessiontest
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-errors/errors"
 	"github.com/jinzhu/gorm"
 	"github.com/privacybydesign/gabi"
 	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
 	"github.com/privacybydesign/gabi/revocation"
 	irma "github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/internal/test"
@@ -93,6 +98,22 @@ func testRevocation(t *testing.T, attr irma.AttributeTypeIdentifier, client *irm
 }
 
 func TestRevocationAll(t *testing.T) {
+	testRevocationAll(t)
+}
+
+func TestRevocationSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "irmarevocation")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	dbtype, dbstr := revocationDbType, revocationDbStr
+	defer func() { revocationDbType, revocationDbStr = dbtype, dbstr }()
+	revocationDbType, revocationDbStr = "sqlite", filepath.Join(dir, "revocation.db")
+
+	testRevocationAll(t)
+}
+
+func testRevocationAll(t *testing.T) {
 	t.Run("Revocation", func(t *testing.T) {
 		startRevocationServer(t, true)
 		defer stopRevocationServer()
@@ -138,6 +159,107 @@ func TestRevocationAll(t *testing.T) {
 		require.NotEmpty(t, result.Missing)
 	})
 
+	t.Run("SelfRevocation", func(t *testing.T) {
+		revocationConfiguration = revocationConf(t)
+		revocationConfiguration.RevocationSettings[revocationTestCred].SelfRevocation = true
+		audit := &memoryAuditLog{}
+		revocationConfiguration.AuditLog = audit
+		startRevocationServer(t, true)
+		defer func() {
+			stopRevocationServer()
+			revocationConfiguration = nil
+		}()
+		irmaServer = revocationServer
+
+		client, handler := parseStorage(t)
+		defer test.ClearTestStorage(t, handler.storage)
+		request := revocationIssuanceRequest(t, revocationTestCred)
+		result := requestorSessionHelper(t, request, client, sessionOptionReuseServer)
+		require.Nil(t, result.Err)
+
+		var credid *irma.CredentialIdentifier
+		for _, info := range client.CredentialInfoList() {
+			if info.Identifier() == revocationTestCred {
+				credid = &irma.CredentialIdentifier{Type: revocationTestCred, Hash: info.Hash}
+			}
+		}
+		require.NotNil(t, credid)
+
+		// the holder revokes its credential
+		c := make(chan *SessionResult, 1)
+		client.RevokeCredential(credid, &TestHandler{t: t, c: c, client: client})
+		require.Nil(t, <-c)
+		require.Equal(t, credid, handler.revoked)
+
+		// the revocation attribute is disclosed, but not included in the attributes disclosed in the session
+		logs, err := client.LoadNewestLogs(1)
+		require.NoError(t, err)
+		require.Len(t, logs, 1)
+		credtype := client.Configuration.CredentialTypes[revocationTestCred]
+		revattr := credtype.AttributeTypes[credtype.RevocationIndex].GetAttributeTypeIdentifier()
+		identifiers := func(disclosed [][]*irma.DisclosedAttribute) []irma.AttributeTypeIdentifier {
+			var ids []irma.AttributeTypeIdentifier
+			for _, attrs := range disclosed {
+				for _, attr := range attrs {
+					ids = append(ids, attr.Identifier)
+				}
+			}
+			return ids
+		}
+		_, all, err := logs[0].Disclosure.DisclosedAttributes(client.Configuration, nil, nil)
+		require.NoError(t, err)
+		require.Contains(t, identifiers(all), revattr)
+		disclosed, err := logs[0].GetDisclosedCredentials(client.Configuration)
+		require.NoError(t, err)
+		require.NotContains(t, identifiers(disclosed), revattr)
+		for _, info := range client.CredentialInfoList() {
+			if info.Identifier() == revocationTestCred {
+				require.True(t, info.Revoked)
+			}
+		}
+
+		records, err := revocationConfiguration.IrmaConfiguration.Revocation.FindIssuanceRecords(
+			revocationTestCred, irma.IssuanceRecordFilter{Key: request.Credentials[0].RevocationKey},
+		)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.NotZero(t, records[0].RevokedAt)
+		require.Eventually(t, func() bool {
+			return audit.contains(server.AuditEventRevoked, revocationTestCred.String())
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// the revoked credential can no longer be used
+		result = revocationSession(t, client, nil, sessionOptionUnsatisfiableRequest, sessionOptionReuseServer)
+		require.NotEmpty(t, result.Missing)
+	})
+
+	t.Run("SelfRevocationDisabled", func(t *testing.T) {
+		startRevocationServer(t, true)
+		defer stopRevocationServer()
+		irmaServer = revocationServer
+
+		client, handler := parseStorage(t)
+		defer test.ClearTestStorage(t, handler.storage)
+		request := revocationIssuanceRequest(t, revocationTestCred)
+		result := requestorSessionHelper(t, request, client, sessionOptionReuseServer)
+		require.Nil(t, result.Err)
+
+		var credid *irma.CredentialIdentifier
+		for _, info := range client.CredentialInfoList() {
+			if info.Identifier() == revocationTestCred {
+				credid = &irma.CredentialIdentifier{Type: revocationTestCred, Hash: info.Hash}
+			}
+		}
+		require.NotNil(t, credid)
+
+		c := make(chan *SessionResult, 1)
+		client.RevokeCredential(credid, &TestHandler{t: t, c: c, client: client})
+		clientResult := <-c
+		require.NotNil(t, clientResult)
+		require.Error(t, clientResult.Err)
+		require.Nil(t, handler.revoked)
+	})
+
 	t.Run("MixRevocationNonRevocation", func(t *testing.T) {
 		client, handler := revocationSetup(t)
 		defer test.ClearTestStorage(t, handler.storage)
@@ -470,7 +592,7 @@ func TestRevocationAll(t *testing.T) {
 		startRevocationServer(t, true)
 		defer stopRevocationServer()
 
-		// Insert expired issuance record
+		// Insert expired issuance record of a revoked credential
 		rev := revocationConfiguration.IrmaConfiguration.Revocation
 		require.NoError(t, rev.AddIssuanceRecord(&irma.IssuanceRecord{
 			Key:        "1",
@@ -479,9 +601,10 @@ func TestRevocationAll(t *testing.T) {
 			Attr:       (*irma.RevocationAttribute)(big.NewInt(42)),
 			Issued:     time.Now().Add(-2 * time.Hour).UnixNano(),
 			ValidUntil: time.Now().Add(-1 * time.Hour).UnixNano(),
+			RevokedAt:  time.Now().Add(-90 * time.Minute).UnixNano(),
 		}))
 		// Check existence of insterted record
-		rec, err := rev.IssuanceRecords(revocationTestCred, "1", time.Time{})
+		rec, err := rev.FindIssuanceRecords(revocationTestCred, irma.IssuanceRecordFilter{Key: "1"})
 		require.NoError(t, err)
 		require.NotEmpty(t, rec)
 
@@ -489,8 +612,63 @@ func TestRevocationAll(t *testing.T) {
 		revocationConfiguration.IrmaConfiguration.Scheduler.RunAll()
 
 		// Check that issuance record is gone
-		_, err = rev.IssuanceRecords(revocationTestCred, "1", time.Time{})
-		require.Equal(t, irma.ErrUnknownRevocationKey, err)
+		rec, err = rev.FindIssuanceRecords(revocationTestCred, irma.IssuanceRecordFilter{Key: "1"})
+		require.NoError(t, err)
+		require.Empty(t, rec)
+	})
+
+
+	t.Run("PruneExpiredIssuanceRecords", func(t *testing.T) {
+		startRevocationServer(t, true)
+		defer stopRevocationServer()
+
+		// Insert expired issuance records
+		rev := revocationConfiguration.IrmaConfiguration.Revocation
+		insert := func(key string, expired time.Duration, revoked bool) {
+			record := &irma.IssuanceRecord{
+				Key:        key,
+				CredType:   revocationTestCred,
+				PKCounter:  &revocationPkCounter,
+				Attr:       (*irma.RevocationAttribute)(big.NewInt(42)),
+				Issued:     time.Now().Add(-expired - time.Hour).UnixNano(),
+				ValidUntil: time.Now().Add(-expired).UnixNano(),
+			}
+			if revoked {
+				record.RevokedAt = time.Now().UnixNano()
+			}
+			require.NoError(t, rev.AddIssuanceRecord(record))
+		}
+		insert("1", time.Hour, false)
+		insert("2", 3*time.Hour, false)
+		insert("3", 3*time.Hour, true)
+		keys := func() []string {
+			records, err := rev.FindIssuanceRecords(revocationTestCred, irma.IssuanceRecordFilter{})
+			require.NoError(t, err)
+			var keys []string
+			for _, r := range records {
+				keys = append(keys, r.Key)
+			}
+			return keys
+		}
+
+		deleted := map[bool]int64{}
+		rev.PruneListener = func(id irma.CredentialTypeIdentifier, revoked bool, count int64) {
+			require.Equal(t, revocationTestCred, id)
+			deleted[revoked] += count
+		}
+		defer func() { rev.PruneListener = nil }()
+
+		// Run jobs; pruning is disabled by default, so only the record of the revoked credential is deleted
+		revocationConfiguration.IrmaConfiguration.Scheduler.RunAll()
+		require.Equal(t, []string{"1", "2"}, keys())
+		require.Equal(t, map[bool]int64{true: 1}, deleted)
+
+		// Enable pruning, and run jobs again, triggering DELETE of unrevoked records past the grace period
+		settings := revocationConfiguration.RevocationSettings[revocationTestCred]
+		settings.PruneGracePeriod = 2 * 60 * 60
+		revocationConfiguration.IrmaConfiguration.Scheduler.RunAll()
+		require.Equal(t, []string{"1"}, keys())
+		require.Equal(t, map[bool]int64{true: 1, false: 1}, deleted)
 	})
 
 	t.Run("RevokeMany", func(t *testing.T) {
@@ -538,6 +716,394 @@ func TestRevocationAll(t *testing.T) {
 		}
 	})
 
+	t.Run("RevokeBatch", func(t *testing.T) {
+		startRevocationServer(t, true)
+		defer stopRevocationServer()
+		rev := revocationConfiguration.IrmaConfiguration.Revocation
+		sacc, err := rev.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+
+		insertIssuanceRecord(t, "1", rev, sacc.Accumulator)
+		insertIssuanceRecord(t, "2", rev, sacc.Accumulator)
+		insertIssuanceRecord(t, "3", rev, sacc.Accumulator)
+		insertIssuanceRecord(t, "3", rev, sacc.Accumulator)
+
+		// Nothing is revoked if one of the keys is unknown
+		err = rev.RevokeBatch(revocationTestCred, []irma.RevocationKey{{Key: "1"}, {Key: "4"}})
+		require.True(t, errors.Is(err, irma.ErrUnknownRevocationKey))
+		_, err = rev.IssuanceRecords(revocationTestCred, "1", time.Time{})
+		require.NoError(t, err)
+
+		// Credentials specified more than once are revoked once
+		require.NoError(t, rev.RevokeBatch(revocationTestCred, []irma.RevocationKey{
+			{Key: "1"}, {Key: "2"}, {Key: "3"}, {Key: "1"},
+		}))
+		for _, key := range []string{"1", "2", "3"} {
+			_, err = rev.IssuanceRecords(revocationTestCred, key, time.Time{})
+			require.Equal(t, irma.ErrUnknownRevocationKey, err)
+		}
+
+		// All credentials are revoked in a single, valid update
+		update, err := rev.UpdateLatest(revocationTestCred, 10, &revocationPkCounter)
+		require.NoError(t, err)
+		pk, err := rev.Keys.PublicKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+		require.NoError(t, err)
+		acc, err := update[revocationPkCounter].Verify(pk)
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), acc.Index)
+		require.Len(t, update[revocationPkCounter].Events, 5)
+	})
+
+	t.Run("RecordsAndState", func(t *testing.T) {
+		startRevocationServer(t, true)
+		defer stopRevocationServer()
+		rev := revocationConfiguration.IrmaConfiguration.Revocation
+		sacc, err := rev.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+
+		insertIssuanceRecord(t, "1", rev, sacc.Accumulator)
+		insertIssuanceRecord(t, "2", rev, sacc.Accumulator)
+		between := time.Now()
+		insertIssuanceRecord(t, "3", rev, sacc.Accumulator)
+		require.NoError(t, rev.Revoke(revocationTestCred, "2", time.Time{}))
+
+		keys := func(filter irma.IssuanceRecordFilter) []string {
+			records, err := rev.FindIssuanceRecords(revocationTestCred, filter)
+			require.NoError(t, err)
+			var keys []string
+			for _, r := range records {
+				keys = append(keys, r.Key)
+			}
+			return keys
+		}
+		revoked, notRevoked := true, false
+		require.Equal(t, []string{"1", "2", "3"}, keys(irma.IssuanceRecordFilter{}))
+		require.Equal(t, []string{"3"}, keys(irma.IssuanceRecordFilter{Key: "3"}))
+		require.Equal(t, []string{"2"}, keys(irma.IssuanceRecordFilter{Revoked: &revoked}))
+		require.Equal(t, []string{"1", "3"}, keys(irma.IssuanceRecordFilter{Revoked: &notRevoked}))
+		require.Equal(t, []string{"3"}, keys(irma.IssuanceRecordFilter{IssuedFrom: between}))
+		require.Equal(t, []string{"1"}, keys(irma.IssuanceRecordFilter{IssuedUntil: between, Revoked: &notRevoked}))
+
+		states, err := rev.AccumulatorStates(revocationTestCred)
+		require.NoError(t, err)
+		var state *irma.AccumulatorState
+		for _, s := range states {
+			if s.PKCounter == revocationPkCounter {
+				state = s
+			}
+		}
+		require.NotNil(t, state)
+		require.Equal(t, uint64(1), state.Index)
+		require.Equal(t, 2, state.Events)
+		require.Equal(t, 3, state.IssuanceRecords)
+		require.Equal(t, 1, state.Revoked)
+	})
+
+	t.Run("DumpRestore", func(t *testing.T) {
+		startRevocationServer(t, true)
+		rev := revocationConfiguration.IrmaConfiguration.Revocation
+		sacc, err := rev.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+		insertIssuanceRecord(t, "1", rev, sacc.Accumulator)
+		fakeRevocation(t, "2", rev, sacc.Accumulator)
+		fakeRevocation(t, "3", rev, sacc.Accumulator)
+
+		archive, err := rev.DumpRevocationState(revocationTestCred)
+		require.NoError(t, err)
+		records, err := rev.FindIssuanceRecords(revocationTestCred, irma.IssuanceRecordFilter{})
+		require.NoError(t, err)
+		update, err := rev.UpdateLatest(revocationTestCred, 10, &revocationPkCounter)
+		require.NoError(t, err)
+		stopRevocationServer()
+
+		// Restore the archive to an empty database
+		dropRevocationTables(t)
+		rev = openRevocationStorage(t, nil).Revocation
+		defer func() { _ = rev.Close() }()
+
+		// Archives that have been tampered with are rejected without writing anything
+		tampered := *archive
+		tampered.Data = append([]byte{}, archive.Data...)
+		tampered.Data[len(tampered.Data)/2] ^= 1
+		require.Error(t, rev.RestoreRevocationState(&tampered))
+		exists, err := rev.Exists(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		require.NoError(t, rev.RestoreRevocationState(archive))
+		restored, err := rev.FindIssuanceRecords(revocationTestCred, irma.IssuanceRecordFilter{})
+		require.NoError(t, err)
+		require.Equal(t, records, restored)
+		restoredUpdate, err := rev.UpdateLatest(revocationTestCred, 10, &revocationPkCounter)
+		require.NoError(t, err)
+		require.Equal(t, update[revocationPkCounter].SignedAccumulator.Data, restoredUpdate[revocationPkCounter].SignedAccumulator.Data)
+		require.Len(t, restoredUpdate[revocationPkCounter].Events, 3)
+
+		// Existing revocation state is not overwritten
+		require.Error(t, rev.RestoreRevocationState(archive))
+	})
+
+	t.Run("FetchUpdateFromOneMissingEvent", func(t *testing.T) {
+		startRevocationServer(t, true)
+		defer stopRevocationServer()
+		rev := revocationConfiguration.IrmaConfiguration.Revocation
+		sacc, err := rev.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+		fakeMultipleRevocations(t, 20, rev, sacc.Accumulator)
+
+		// The latest update contains the last events; request those and exactly one event before them
+		client := irma.RevocationClient{Conf: revocationConfiguration.IrmaConfiguration}
+		count := revocationConfiguration.IrmaConfiguration.CredentialTypes[revocationTestCred].RevocationUpdateCount
+		from := 20 - count
+		update, err := client.FetchUpdateFrom(revocationTestCred, revocationPkCounter, from)
+		require.NoError(t, err)
+		require.LessOrEqual(t, update.Events[0].Index, from)
+		require.Equal(t, uint64(20), update.Events[len(update.Events)-1].Index)
+	})
+
+	t.Run("Mirror", func(t *testing.T) {
+		startRevocationServer(t, true)
+		defer stopRevocationServer()
+		rev := revocationConfiguration.IrmaConfiguration.Revocation
+		sacc, err := rev.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+		fakeMultipleRevocations(t, 20, rev, sacc.Accumulator)
+
+		dir, err := ioutil.TempDir("", "irmarevocationmirror")
+		require.NoError(t, err)
+		defer func() { _ = os.RemoveAll(dir) }()
+		mirror, stopMirror := startRevocationMirror(t, filepath.Join(dir, "mirror.db"))
+		defer stopMirror()
+		mirrorRev := mirror.IrmaConfiguration.Revocation
+
+		// The mirror fetches the events that are not included in the latest update of the authority
+		require.NoError(t, mirrorRev.SyncDB(revocationTestCred))
+		expected, err := rev.UpdateLatest(revocationTestCred, 0, &revocationPkCounter)
+		require.NoError(t, err)
+		update, err := mirrorRev.UpdateLatest(revocationTestCred, 0, &revocationPkCounter)
+		require.NoError(t, err)
+		require.Equal(t, uint64(20), update[revocationPkCounter].SignedAccumulator.Accumulator.Index)
+		require.Equal(t, expected[revocationPkCounter].SignedAccumulator.Data, update[revocationPkCounter].SignedAccumulator.Data)
+
+		// The mirror serves the complete event chain
+		events := &revocation.EventList{}
+		transport := irma.NewHTTPTransport("http://localhost:48684", false)
+		transport.Binary = true
+		path := fmt.Sprintf("revocation/%s/events/%d/0/16", revocationTestCred, revocationPkCounter)
+		require.NoError(t, transport.Get(path, events))
+		require.Len(t, events.Events, 16)
+
+		// Syncing again does not change anything
+		require.NoError(t, mirrorRev.SyncDB(revocationTestCred))
+
+		// Revocations at the authority are pushed to the mirror
+		fakeRevocation(t, "1", rev, sacc.Accumulator)
+		require.Eventually(t, func() bool {
+			update, err := mirrorRev.UpdateLatest(revocationTestCred, 0, &revocationPkCounter)
+			return err == nil && update[revocationPkCounter].SignedAccumulator.Accumulator.Index == 21
+		}, 5*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("Health", func(t *testing.T) {
+		startRevocationServer(t, true)
+		defer stopRevocationServer()
+		rev := revocationConfiguration.IrmaConfiguration.Revocation
+		sacc, err := rev.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+
+		// The authority knows its own accumulators, and never syncs them
+		health := revocationServer.GetRevocationHealth()
+		require.True(t, health.Healthy)
+		status := revocationHealthStatus(t, health, revocationTestCred)
+		require.Equal(t, time.Unix(sacc.Accumulator.Time, 0), status.AccumulatorTime)
+		require.True(t, status.LastSync.IsZero())
+		require.Equal(t, irma.RevocationParameters.DefaultTolerance, status.MaxAge)
+
+		// The state becomes unhealthy once the accumulator is older than the maximum age
+		revocationConfiguration.RevocationMaxAge = 1
+		require.Eventually(t, func() bool {
+			return !revocationServer.GetRevocationHealth().Healthy
+		}, 5*time.Second, 100*time.Millisecond)
+		revocationConfiguration.IrmaConfiguration.Scheduler.RunAll() // updates the accumulator times
+		require.True(t, revocationServer.GetRevocationHealth().Healthy)
+
+		// Other servers track when they last synced with the authority
+		dir, err := ioutil.TempDir("", "irmarevocationmirror")
+		require.NoError(t, err)
+		defer func() { _ = os.RemoveAll(dir) }()
+		mirror, stopMirror := startRevocationMirror(t, filepath.Join(dir, "mirror.db"))
+		defer stopMirror()
+		require.NoError(t, mirror.IrmaConfiguration.Revocation.SyncDB(revocationTestCred))
+		freshness := mirror.IrmaConfiguration.Revocation.Freshness()
+		require.Len(t, freshness, 2) // one for each public key of the issuer
+		for _, f := range freshness {
+			require.Equal(t, revocationTestCred, f.CredType)
+			require.False(t, f.LastSync.IsZero())
+			require.False(t, f.AccumulatorTime.IsZero())
+		}
+	})
+
+	t.Run("AccumulatorSnapshot", func(t *testing.T) {
+		client, handler := revocationSetup(t)
+		defer test.ClearTestStorage(t, handler.storage)
+
+		snapshot, err := revocationConfiguration.IrmaConfiguration.Revocation.AccumulatorSnapshot(revocationTestCred)
+		require.NoError(t, err)
+		require.NotEmpty(t, snapshot.Updates)
+
+		// stop revocation server so the verifier cannot fetch revocation state
+		stopRevocationServer()
+
+		// A snapshot must be signed by the issuer of its credential type
+		StartIrmaServer(t, false, "")
+		defer StopIrmaServer()
+		rev := irmaServerConfiguration.IrmaConfiguration.Revocation
+		require.Error(t, rev.LoadAccumulatorSnapshot(&irma.AccumulatorSnapshot{
+			CredType: revKeyshareTestCred,
+			Updates:  snapshot.Updates,
+		}, 0))
+
+		// Verify a nonrevocation proof against the snapshot
+		require.NoError(t, rev.LoadAccumulatorSnapshot(snapshot, 0))
+		result := revocationSession(t, client, nil, sessionOptionReuseServer)
+		require.Equal(t, irma.ProofStatusValid, result.ProofStatus)
+		require.NotEmpty(t, result.Disclosed)
+
+		// Sessions requiring nonrevocation proofs fail once the snapshot is too old
+		require.NoError(t, rev.LoadAccumulatorSnapshot(snapshot, 1))
+		time.Sleep(2 * time.Second)
+		result = revocationSession(t, client, nil, sessionOptionReuseServer, sessionOptionIgnoreError)
+		require.Equal(t, irma.ServerStatusCancelled, result.Status)
+		require.NotNil(t, result.Err)
+		require.Equal(t, result.Err.ErrorName, string(server.ErrorRevocation.Type))
+
+		// Snapshots are also read from the revocation settings
+		dir, err := ioutil.TempDir("", "irmarevocationsnapshot")
+		require.NoError(t, err)
+		defer func() { _ = os.RemoveAll(dir) }()
+		path := filepath.Join(dir, "snapshot.json")
+		bts, err := json.Marshal(snapshot)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(path, bts, 0644))
+		conf := openRevocationStorage(t, irma.RevocationSettings{revocationTestCred: {Snapshot: path}})
+		defer func() { _ = conf.Revocation.Close() }()
+		require.NoError(t, conf.Revocation.SyncDB(revocationTestCred)) // does not contact the revocation server
+	})
+
+	t.Run("RotateAccumulator", func(t *testing.T) {
+		dropRevocationTables(t)
+		conf := openRevocationStorage(t, irma.RevocationSettings{revocationTestCred: {Authority: true}})
+		rev := conf.Revocation
+		defer func() { _ = rev.Close() }()
+		issuer := revocationTestCred.IssuerIdentifier()
+		sk1, err := rev.Keys.PrivateKey(issuer, 1)
+		require.NoError(t, err)
+		sk2, err := rev.Keys.PrivateKey(issuer, 2)
+		require.NoError(t, err)
+
+		// Issue a credential with the old key
+		_, err = rev.RotateAccumulator(revocationTestCred, sk1, false)
+		require.NoError(t, err)
+		sacc, err := rev.Accumulator(revocationTestCred, 1)
+		require.NoError(t, err)
+		witness, err := revocation.RandomWitness(sk1, sacc.Accumulator)
+		require.NoError(t, err)
+		require.NoError(t, rev.AddIssuanceRecord(&irma.IssuanceRecord{
+			Key:        "1",
+			CredType:   revocationTestCred,
+			PKCounter:  &sk1.Counter,
+			Attr:       (*irma.RevocationAttribute)(witness.E),
+			Issued:     time.Now().UnixNano(),
+			ValidUntil: time.Now().Add(time.Hour).UnixNano(),
+		}))
+
+		// Rotating to the new key does not freeze the old accumulator while it is in use
+		states, err := rev.RotateAccumulator(revocationTestCred, sk2, true)
+		require.NoError(t, err)
+		require.Len(t, states, 2)
+		require.Equal(t, uint(1), states[0].PKCounter)
+		require.Equal(t, 1, states[0].Valid)
+		require.False(t, states[0].Frozen)
+		require.Equal(t, uint(2), states[1].PKCounter)
+		require.Equal(t, 0, states[1].Valid)
+		require.Equal(t, irma.ErrAccumulatorInUse, rev.FreezeAccumulator(revocationTestCred, 1))
+
+		// Once no valid credentials remain it is frozen
+		require.NoError(t, rev.Revoke(revocationTestCred, "1", time.Time{}))
+		states, err = rev.RotateAccumulator(revocationTestCred, sk2, true)
+		require.NoError(t, err)
+		require.True(t, states[0].Frozen)
+		require.False(t, states[1].Frozen)
+
+		// Frozen accumulators are not updated and cannot be used for issuance
+		before, err := rev.UpdateLatest(revocationTestCred, 0, nil)
+		require.NoError(t, err)
+		conf.Scheduler.RunAll()
+		after, err := rev.UpdateLatest(revocationTestCred, 0, nil)
+		require.NoError(t, err)
+		require.Equal(t, before[1].SignedAccumulator.Data, after[1].SignedAccumulator.Data)
+		require.NotEqual(t, before[2].SignedAccumulator.Data, after[2].SignedAccumulator.Data)
+		err = rev.AddIssuanceRecord(&irma.IssuanceRecord{
+			Key:        "2",
+			CredType:   revocationTestCred,
+			PKCounter:  &sk1.Counter,
+			Attr:       (*irma.RevocationAttribute)(witness.E),
+			Issued:     time.Now().UnixNano(),
+			ValidUntil: time.Now().Add(time.Hour).UnixNano(),
+		})
+		require.Equal(t, irma.ErrAccumulatorFrozen, err)
+	})
+
+	t.Run("RevokeFrozenAccumulator", func(t *testing.T) {
+		dropRevocationTables(t)
+		conf := openRevocationStorage(t, irma.RevocationSettings{revocationTestCred: {Authority: true}})
+		rev := conf.Revocation
+		defer func() { _ = rev.Close() }()
+		issuer := revocationTestCred.IssuerIdentifier()
+		sk1, err := rev.Keys.PrivateKey(issuer, 1)
+		require.NoError(t, err)
+		sk2, err := rev.Keys.PrivateKey(issuer, 2)
+		require.NoError(t, err)
+		addRecord := func(sk *gabikeys.PrivateKey, validUntil time.Time) {
+			sacc, err := rev.Accumulator(revocationTestCred, sk.Counter)
+			require.NoError(t, err)
+			witness, err := revocation.RandomWitness(sk, sacc.Accumulator)
+			require.NoError(t, err)
+			require.NoError(t, rev.AddIssuanceRecord(&irma.IssuanceRecord{
+				Key:        "1",
+				CredType:   revocationTestCred,
+				PKCounter:  &sk.Counter,
+				Attr:       (*irma.RevocationAttribute)(witness.E),
+				Issued:     validUntil.Add(-time.Hour).UnixNano(),
+				ValidUntil: validUntil.UnixNano(),
+			}))
+		}
+
+		// An expired credential with the old key does not prevent freezing its accumulator
+		_, err = rev.RotateAccumulator(revocationTestCred, sk1, false)
+		require.NoError(t, err)
+		addRecord(sk1, time.Now().Add(-time.Minute))
+		states, err := rev.RotateAccumulator(revocationTestCred, sk2, true)
+		require.NoError(t, err)
+		require.True(t, states[0].Frozen)
+
+		// A valid credential with the same revocation key and the new key is revoked, while the
+		// expired one is skipped
+		addRecord(sk2, time.Now().Add(time.Hour))
+		require.NoError(t, rev.Revoke(revocationTestCred, "1", time.Time{}))
+		revoked := true
+		records, err := rev.FindIssuanceRecords(revocationTestCred, irma.IssuanceRecordFilter{Revoked: &revoked})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, sk2.Counter, *records[0].PKCounter)
+		update, err := rev.UpdateLatest(revocationTestCred, 10, &sk2.Counter)
+		require.NoError(t, err)
+		require.Len(t, update[sk2.Counter].Events, 2)
+
+		// Revoking only credentials of frozen accumulators fails
+		require.True(t, errors.Is(rev.RevokeBatch(revocationTestCred, []irma.RevocationKey{{Key: "1"}}), irma.ErrAccumulatorFrozen))
+	})
+
 	t.Run("RevocationTolerance", func(t *testing.T) {
 		client, handler := revocationSetup(t)
 		defer test.ClearTestStorage(t, handler.storage)
@@ -793,15 +1359,7 @@ func startRevocationServer(t *testing.T, droptables bool) {
 
 	// Connect to database and clear records from previous test runs
 	if droptables {
-		g, err := gorm.Open(revocationDbType, revocationDbStr)
-		require.NoError(t, err)
-		require.NoError(t, g.DropTableIfExists((*irma.EventRecord)(nil)).Error)
-		require.NoError(t, g.DropTableIfExists((*irma.AccumulatorRecord)(nil)).Error)
-		require.NoError(t, g.DropTableIfExists((*irma.IssuanceRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.EventRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.AccumulatorRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.IssuanceRecord)(nil)).Error)
-		require.NoError(t, g.Close())
+		dropRevocationTables(t)
 	}
 
 	// Start revocation server
@@ -818,8 +1376,110 @@ func startRevocationServer(t *testing.T, droptables bool) {
 	}()
 }
 
+func startRevocationMirror(t *testing.T, dbfile string) (*server.Configuration, func()) {
+	conf := &server.Configuration{
+		URL:                  "http://localhost:48684",
+		Logger:               logger,
+		EnableSSE:            true,
+		DisableSchemesUpdate: true,
+		SchemesPath:          filepath.Join(testdata, "irma_configuration"),
+		RevocationSettings: irma.RevocationSettings{
+			revocationTestCred: {Mirror: true, RevocationServerURL: "http://localhost:48683"},
+		},
+		RevocationDBConnStr: dbfile,
+		RevocationDBType:    "sqlite",
+	}
+	irmaServer, err := irmaserver.New(conf)
+	require.NoError(t, err)
+	httpServer := &http.Server{Addr: "localhost:48684", Handler: irmaServer.HandlerFunc()}
+	go func() {
+		_ = httpServer.ListenAndServe()
+	}()
+	return conf, func() {
+		irmaServer.Stop()
+		_ = httpServer.Close()
+	}
+}
+
+// openRevocationStorage opens the revocation database without starting a revocation server.
+func openRevocationStorage(t *testing.T, settings irma.RevocationSettings) *irma.Configuration {
+	conf, err := irma.NewConfiguration(filepath.Join(testdata, "irma_configuration"), irma.ConfigurationOptions{
+		ReadOnly:            true,
+		RevocationDBType:    revocationDbType,
+		RevocationDBConnStr: revocationDbStr,
+		RevocationSettings:  settings,
+	})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	ring, err := irma.NewPrivateKeyRingFolder(filepath.Join(testdata, "privatekeys"), conf)
+	require.NoError(t, err)
+	require.NoError(t, conf.AddPrivateKeyRing(ring))
+	return conf
+}
+
+func dropRevocationTables(t *testing.T) {
+	dialect := revocationDbType
+	if dialect == "sqlite" {
+		dialect = "sqlite3"
+	}
+	g, err := gorm.Open(dialect, revocationDbStr)
+	require.NoError(t, err)
+	require.NoError(t, g.DropTableIfExists((*irma.EventRecord)(nil)).Error)
+	require.NoError(t, g.DropTableIfExists((*irma.AccumulatorRecord)(nil)).Error)
+	require.NoError(t, g.DropTableIfExists((*irma.IssuanceRecord)(nil)).Error)
+	require.NoError(t, g.AutoMigrate((*irma.EventRecord)(nil)).Error)
+	require.NoError(t, g.AutoMigrate((*irma.AccumulatorRecord)(nil)).Error)
+	require.NoError(t, g.AutoMigrate((*irma.IssuanceRecord)(nil)).Error)
+	require.NoError(t, g.Close())
+}
+
+func revocationHealthStatus(t *testing.T, health *irmaserver.RevocationHealth, id irma.CredentialTypeIdentifier) *irmaserver.RevocationFreshnessStatus {
+	for _, status := range health.Revocation {
+		if status.CredType == id && status.PKCounter == revocationPkCounter {
+			return status
+		}
+	}
+	require.FailNow(t, "credential type not found in revocation health")
+	return nil
+}
+
 func stopRevocationServer() {
 	revocationServer.Stop()
 	_ = revocationHttpServer.Close()
 	revocationConfiguration = nil
 }
+
+// memoryAuditLog is an AuditLog keeping its events in memory, without MACs.
+type memoryAuditLog struct {
+	sync.Mutex
+	events []*server.AuditEvent
+}
+
+func (l *memoryAuditLog) Record(event *server.AuditEvent) error {
+	l.Lock()
+	defer l.Unlock()
+	l.events = append(l.events, event)
+	return nil
+}
+
+func (l *memoryAuditLog) Events() ([]*server.AuditEvent, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.events, nil
+}
+
+func (l *memoryAuditLog) Close() error {
+	return nil
+}
+
+// contains returns whether the log contains an event of the specified type about the credential type.
+func (l *memoryAuditLog) contains(typ server.AuditEventType, credtype string) bool {
+	l.Lock()
+	defer l.Unlock()
+	for _, event := range l.events {
+		if event.Type == typ && len(event.Credentials) == 1 && event.Credentials[0] == credtype {
+			return true
+		}
+	}
+	return false
+}
@@ -8,8 +8,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,6 +21,8 @@ import (
 	"github.com/privacybydesign/irmago/internal/test"
 	"github.com/privacybydesign/irmago/irmaclient"
 	"github.com/privacybydesign/irmago/server"
+	"github.com/privacybydesign/irmago/server/irmaserver"
+	"github.com/privacybydesign/irmago/server/requestorserver"
 	sseclient "github.com/sietseringers/go-sse"
 
 	"github.com/go-errors/errors"
@@ -787,3 +792,263 @@ func listenStatusEventsSSE(t *testing.T, url string) (chan irma.ServerStatus, fu
 
 	return statuschan, cancel
 }
+
+func TestMetrics(t *testing.T) {
+	conf := JwtServerConfiguration()
+	conf.EnableMetrics = true
+	rs := StartRequestorServer(t, conf)
+	defer rs.Stop()
+
+	// Start a session and cancel it
+	request := irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	sesPkg, _ := startSession(t, request, "verification", true)
+	transport := irma.NewHTTPTransport(fmt.Sprintf("http://localhost:%d/session/%s", conf.Port, sesPkg.Token), false)
+	require.NoError(t, transport.Delete())
+
+	res, err := http.Get(fmt.Sprintf("http://localhost:%d/metrics", conf.Port))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	bts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+
+	metrics := string(bts)
+	require.Contains(t, metrics, `irma_sessions_started_total{action="disclosing",requestor="requestor1"}`)
+	require.Contains(t, metrics, `irma_sessions_finished_total{action="disclosing",requestor="requestor1",status="CANCELLED"}`)
+	require.Contains(t, metrics, `irma_session_duration_seconds_count{action="disclosing",status="CANCELLED"}`)
+	require.Contains(t, metrics, "irma_session_store_size ")
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	conf := JwtServerConfiguration()
+	rs := StartRequestorServer(t, conf)
+	defer rs.Stop()
+
+	res, err := http.Get(fmt.Sprintf("http://localhost:%d/metrics", conf.Port))
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestAdminCallbacksAPI(t *testing.T) {
+	conf := IrmaServerConfiguration()
+	conf.AdminToken = "admintoken"
+	rs := StartRequestorServer(t, conf)
+	defer rs.Stop()
+
+	transport := irma.NewHTTPTransport(fmt.Sprintf("http://localhost:%d/admin", conf.Port), false)
+	var deliveries []*irmaserver.CallbackDelivery
+	err := transport.Get("callbacks", &deliveries)
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, err.(*irma.SessionError).RemoteStatus)
+
+	transport.SetHeader("Authorization", conf.AdminToken)
+	require.NoError(t, transport.Get("callbacks", &deliveries))
+	require.Empty(t, deliveries)
+
+	err = transport.Post("callbacks/nonexisting/replay", nil, nil)
+	require.Error(t, err)
+	require.Equal(t, http.StatusNotFound, err.(*irma.SessionError).RemoteStatus)
+}
+
+func TestAdminSessionsAPI(t *testing.T) {
+	conf := JwtServerConfiguration()
+	conf.AdminToken = "admintoken"
+	rs := StartRequestorServer(t, conf)
+	defer rs.Stop()
+
+	request := irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	sesPkg1, _ := startSession(t, request, "verification", true)
+	sesPkg2, _ := startSession(t, request, "verification", true)
+	transport := irma.NewHTTPTransport(fmt.Sprintf("http://localhost:%d", conf.Port), false)
+	transport.SetHeader("Authorization", TokenAuthenticationKey)
+	var sesPkg3 server.SessionPackage
+	require.NoError(t, transport.Post("session", &sesPkg3, request))
+
+	admin := irma.NewHTTPTransport(fmt.Sprintf("http://localhost:%d/admin", conf.Port), false)
+	admin.SetHeader("Authorization", conf.AdminToken)
+	var sessions []*irmaserver.SessionInfo
+	require.NoError(t, admin.Get("sessions", &sessions))
+	require.Len(t, sessions, 3)
+	require.NoError(t, admin.Get("sessions?requestor=requestor2", &sessions))
+	require.Len(t, sessions, 1)
+	require.Equal(t, sesPkg3.Token, sessions[0].Token)
+	require.Equal(t, irma.ActionDisclosing, sessions[0].Action)
+	require.Equal(t, irma.ServerStatusInitialized, sessions[0].Status)
+
+	var info struct {
+		irmaserver.SessionInfo
+		Request *irma.ServiceProviderRequest `json:"request"`
+	}
+	require.NoError(t, admin.Get("sessions/"+string(sesPkg1.Token), &info))
+	require.Equal(t, "requestor1", info.Requestor)
+	require.NotNil(t, info.Request)
+	err := admin.Get("sessions/"+string(irma.RequestorToken("Sxqcpng37mAdBKgoAJXl")), &info)
+	require.Error(t, err)
+	require.Equal(t, http.StatusBadRequest, err.(*irma.SessionError).RemoteStatus)
+
+	// Cancel by token and by requestor
+	var res requestorserver.CancelSessionsResponse
+	require.NoError(t, admin.Post("sessions/cancel", &res, requestorserver.CancelSessionsRequest{
+		Tokens: []irma.RequestorToken{sesPkg2.Token},
+	}))
+	require.Equal(t, []irma.RequestorToken{sesPkg2.Token}, res.Cancelled)
+	require.NoError(t, admin.Post("sessions/cancel", &res, requestorserver.CancelSessionsRequest{Requestor: "requestor1"}))
+	require.Equal(t, []irma.RequestorToken{sesPkg1.Token}, res.Cancelled)
+
+	require.NoError(t, admin.Get("sessions", &sessions))
+	require.Len(t, sessions, 1)
+	require.NoError(t, admin.Get("sessions?finished=true", &sessions))
+	require.Len(t, sessions, 3)
+
+	require.NoError(t, admin.Post("sessions/cancel", &res, requestorserver.CancelSessionsRequest{All: true}))
+	require.Equal(t, []irma.RequestorToken{sesPkg3.Token}, res.Cancelled)
+	require.Error(t, admin.Post("sessions/cancel", &res, requestorserver.CancelSessionsRequest{}))
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "irmaaudit")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	conf := JwtServerConfiguration()
+	conf.AuditLogFile = filepath.Join(dir, "audit.log")
+	conf.AuditLogKey = "0123456789abcdef0123456789abcdef"
+	rs := StartRequestorServer(t, conf)
+
+	request := irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	sesPkg, _ := startSession(t, request, "verification", true)
+	transport := irma.NewHTTPTransport(fmt.Sprintf("http://localhost:%d/session/%s", conf.Port, sesPkg.Token), false)
+	require.NoError(t, transport.Delete())
+	rs.Stop()
+
+	events, err := server.ReadAuditLogFile(conf.AuditLogFile)
+	require.NoError(t, err)
+	require.NoError(t, server.VerifyAuditLog(events, []byte(conf.AuditLogKey)))
+	require.Len(t, events, 2)
+	require.Equal(t, server.AuditEventCreated, events[0].Type)
+	require.Equal(t, server.AuditEventCancelled, events[1].Type)
+	for _, event := range events {
+		require.Equal(t, "requestor1", event.Requestor)
+		require.Equal(t, sesPkg.Token, event.Session)
+		require.Equal(t, []string{"irma-demo.RU.studentCard.studentID"}, event.Attributes)
+	}
+}
+
+func TestReloadRequestors(t *testing.T) {
+	conf := JwtServerConfiguration()
+	rs := StartRequestorServer(t, conf)
+	defer rs.Stop()
+
+	request := irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	startTokenSession := func(token string) error {
+		transport := irma.NewHTTPTransport(fmt.Sprintf("http://localhost:%d", conf.Port), false)
+		transport.SetHeader("Authorization", token)
+		var sesPkg server.SessionPackage
+		return transport.Post("session", &sesPkg, request)
+	}
+	require.NoError(t, startTokenSession(TokenAuthenticationKey))
+
+	// Replace requestor2 by a new requestor with a different token
+	requestors := map[string]requestorserver.Requestor{
+		"requestor4": {
+			AuthenticationMethod: requestorserver.AuthenticationMethodToken,
+			AuthenticationKey:    "newtoken",
+		},
+	}
+	require.NoError(t, rs.ReloadRequestors(requestors, conf.Permissions))
+	require.Error(t, startTokenSession(TokenAuthenticationKey))
+	require.NoError(t, startTokenSession("newtoken"))
+
+	// Invalid configurations are rejected, and the current configuration remains in use
+	requestors["requestor5"] = requestorserver.Requestor{
+		Permissions:          requestorserver.Permissions{Disclosing: []string{"irma-demo.*.*"}},
+		AuthenticationMethod: requestorserver.AuthenticationMethodToken,
+		AuthenticationKey:    "othertoken",
+	}
+	require.Error(t, rs.ReloadRequestors(requestors, conf.Permissions))
+	delete(requestors, "requestor5")
+	requestors["requestor6"] = requestorserver.Requestor{
+		AuthenticationMethod:  requestorserver.AuthenticationMethodPublicKey,
+		AuthenticationKeyFile: "nonexisting.pem",
+	}
+	require.Error(t, rs.ReloadRequestors(requestors, conf.Permissions))
+	require.NoError(t, startTokenSession("newtoken"))
+	require.Error(t, startTokenSession("othertoken"))
+}
+
+func TestRequestorLimits(t *testing.T) {
+	conf := JwtServerConfiguration()
+	requestor := conf.Requestors["requestor2"]
+	requestor.MaxSessionsPerMinute = 3
+	requestor.MaxConcurrentSessions = 2
+	conf.Requestors["requestor2"] = requestor
+	rs := StartRequestorServer(t, conf)
+	defer rs.Stop()
+
+	url := fmt.Sprintf("http://localhost:%d", conf.Port)
+	request := irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	startTokenSession := func() (*server.SessionPackage, error) {
+		transport := irma.NewHTTPTransport(url, false)
+		transport.SetHeader("Authorization", TokenAuthenticationKey)
+		var sesPkg server.SessionPackage
+		return &sesPkg, transport.Post("session", &sesPkg, request)
+	}
+	requireTooManyRequests := func(err error) {
+		require.Error(t, err)
+		require.Equal(t, http.StatusTooManyRequests, err.(*irma.SessionError).RemoteStatus)
+	}
+
+	// Concurrent sessions
+	sesPkg, err := startTokenSession()
+	require.NoError(t, err)
+	_, err = startTokenSession()
+	require.NoError(t, err)
+	_, err = startTokenSession()
+	requireTooManyRequests(err)
+
+	// Finished sessions are no longer live
+	require.NoError(t, irma.NewHTTPTransport(fmt.Sprintf("%s/session/%s", url, sesPkg.Token), false).Delete())
+	sesPkg, err = startTokenSession()
+	require.NoError(t, err)
+	require.NoError(t, irma.NewHTTPTransport(fmt.Sprintf("%s/session/%s", url, sesPkg.Token), false).Delete())
+
+	// Sessions per minute
+	_, err = startTokenSession()
+	requireTooManyRequests(err)
+
+	// Other requestors are not affected
+	_, _ = startSession(t, request, "verification", true)
+}
+
+func TestRequestorConcurrentSessionsLimit(t *testing.T) {
+	conf := JwtServerConfiguration()
+	requestor := conf.Requestors["requestor2"]
+	requestor.MaxConcurrentSessions = 2
+	conf.Requestors["requestor2"] = requestor
+	rs := StartRequestorServer(t, conf)
+	defer rs.Stop()
+
+	// Of sessions started simultaneously, no more than the maximum succeed
+	request := irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+	var started, refused int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transport := irma.NewHTTPTransport(fmt.Sprintf("http://localhost:%d", conf.Port), false)
+			transport.SetHeader("Authorization", TokenAuthenticationKey)
+			var sesPkg server.SessionPackage
+			err := transport.Post("session", &sesPkg, request)
+			if err == nil {
+				atomic.AddInt32(&started, 1)
+			} else if err.(*irma.SessionError).RemoteStatus == http.StatusTooManyRequests {
+				atomic.AddInt32(&refused, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(2), started)
+	require.Equal(t, int32(8), refused)
+}
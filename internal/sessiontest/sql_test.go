@@ -0,0 +1,50 @@
+// +build !local_tests
+
+package sessiontest
+
+import (
+	"testing"
+
+	"github.com/privacybydesign/irmago/internal/test"
+	"github.com/privacybydesign/irmago/server/requestorserver"
+	"github.com/stretchr/testify/require"
+)
+
+func sqlConfigDecorator(fn func() *requestorserver.Configuration) func() *requestorserver.Configuration {
+	return func() *requestorserver.Configuration {
+		c := fn()
+		c.StoreType = "postgres"
+		c.StoreDBConnStr = test.PostgresTestUrl
+		return c
+	}
+}
+
+func TestSQLSessionStore(t *testing.T) {
+	defaultIrmaServerConfiguration := IrmaServerConfiguration
+	defaultJwtServerConfiguration := JwtServerConfiguration
+
+	IrmaServerConfiguration = sqlConfigDecorator(IrmaServerConfiguration)
+	JwtServerConfiguration = sqlConfigDecorator(JwtServerConfiguration)
+
+	defer func() {
+		IrmaServerConfiguration = defaultIrmaServerConfiguration
+		JwtServerConfiguration = defaultJwtServerConfiguration
+	}()
+
+	t.Run("TestSigningSession", TestSigningSession)
+	t.Run("TestDisclosureSession", TestDisclosureSession)
+	t.Run("TestIssuanceSession", TestIssuanceSession)
+	t.Run("TestIssuedCredentialIsStored", TestIssuedCredentialIsStored)
+	t.Run("TestChainedSessions", TestChainedSessions)
+	t.Run("TestUnknownRequestorToken", TestUnknownRequestorToken)
+	t.Run("TestRequestorLimits", TestRequestorLimits)
+	t.Run("TestRequestorConcurrentSessionsLimit", TestRequestorConcurrentSessionsLimit)
+	t.Run("TestAdminSessionsAPI", TestAdminSessionsAPI)
+}
+
+func TestSQLSessionStoreMissingConnStr(t *testing.T) {
+	config := IrmaServerConfiguration()
+	config.StoreType = "postgres"
+	_, err := requestorserver.New(config)
+	require.EqualError(t, err, "When postgres is used as session data store, a connection string must be specified.")
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the audit log of an IRMA server",
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify [<file>]",
+	Short: "Verify the MAC chain of an IRMA server audit log",
+	Long: `Verify the MAC chain of an IRMA server audit log.
+
+The verify command checks that no events have been removed from, inserted into, reordered in or
+modified in the audit log written by the IRMA server, either to the specified file or, if no file
+is specified, to the database specified with --db-type and --db-str. The key with which the IRMA
+server authenticated the events must be specified with --key or --key-file. Verification also fails
+if the IRMA server recorded that it had to drop events because it could not write them in time.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		dbtype, _ := flags.GetString("db-type")
+		dbstr, _ := flags.GetString("db-str")
+		keystr, _ := flags.GetString("key")
+		keyfile, _ := flags.GetString("key-file")
+
+		key, err := common.ReadKey(keystr, keyfile)
+		if err != nil {
+			die("failed to read audit log key", err)
+		}
+
+		var events []*server.AuditEvent
+		if len(args) == 1 {
+			events, err = server.ReadAuditLogFile(args[0])
+		} else {
+			if dbstr == "" {
+				die("specify either an audit log file or --db-str", nil)
+			}
+			var log server.AuditLog
+			if log, err = server.OpenAuditLog("", dbtype, dbstr, key); err != nil {
+				die("failed to open audit log", err)
+			}
+			events, err = log.Events()
+			_ = log.Close()
+		}
+		if err != nil {
+			die("failed to read audit log", err)
+		}
+
+		if err = server.VerifyAuditLog(events, key); err != nil {
+			die("audit log verification failed", err)
+		}
+		if dropped, count := server.DroppedAuditEvents(events); count > 0 {
+			for _, event := range dropped {
+				fmt.Printf("Event %d at %s: %d events were dropped\n", event.Sequence, event.Time, event.Dropped)
+			}
+			die(fmt.Sprintf("audit log is incomplete: %d events were dropped", count), nil)
+		}
+		if len(events) == 0 {
+			fmt.Println("Audit log is empty")
+			return
+		}
+		last := events[len(events)-1]
+		fmt.Printf("Audit log verified: %d events, last event at %s with MAC %s\n", len(events), last.Time, last.MAC)
+	},
+}
+
+func init() {
+	flags := auditVerifyCmd.Flags()
+	flags.String("db-type", "postgres", "database type of the audit log (supported: mysql, postgres)")
+	flags.String("db-str", "", "connection string of the audit log database")
+	flags.String("key", "", "key with which the audit log events were authenticated")
+	flags.String("key-file", "", "path to key with which the audit log events were authenticated")
+
+	auditCmd.AddCommand(auditVerifyCmd)
+	RootCmd.AddCommand(auditCmd)
+}
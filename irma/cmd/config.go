@@ -50,11 +50,14 @@ func configureIRMAServer() *server.Configuration {
 		RevocationDBType:       viper.GetString("revocation_db_type"),
 		RevocationDBConnStr:    viper.GetString("revocation_db_str"),
 		RevocationSettings:     irma.RevocationSettings{},
+		RevocationMaxAge:       viper.GetUint64("revocation_max_age"),
 		URL:                    viper.GetString("url"),
 		DisableTLS:             viper.GetBool("no_tls"),
 		Email:                  viper.GetString("email"),
 		EnableSSE:              viper.GetBool("sse"),
+		EnableMetrics:          viper.GetBool("metrics"),
 		StoreType:              viper.GetString("store_type"),
+		StoreDBConnStr:         viper.GetString("store_db_str"),
 		Verbose:                viper.GetInt("verbose"),
 		Quiet:                  viper.GetBool("quiet"),
 		LogJSON:                viper.GetBool("log_json"),
@@ -65,7 +68,19 @@ func configureIRMAServer() *server.Configuration {
 		JwtPrivateKey:          viper.GetString("jwt_privkey"),
 		JwtPrivateKeyFile:      viper.GetString("jwt_privkey_file"),
 		AllowUnsignedCallbacks: viper.GetBool("allow_unsigned_callbacks"),
+		CallbackHMACKey:        viper.GetString("callback_hmac_key"),
+		CallbackHMACKeyFile:    viper.GetString("callback_hmac_key_file"),
+		CallbackMaxAttempts:    viper.GetInt("callback_max_attempts"),
+		CallbackRetention:      viper.GetInt("callback_retention"),
 		AugmentClientReturnURL: viper.GetBool("augment_client_return_url"),
+		AuditLogFile:           viper.GetString("audit_log_file"),
+		AuditLogDBType:         viper.GetString("audit_log_db_type"),
+		AuditLogDBConnStr:      viper.GetString("audit_log_db_str"),
+		AuditLogKey:            viper.GetString("audit_log_key"),
+		AuditLogKeyFile:        viper.GetString("audit_log_key_file"),
+
+		SelfRevocationMaxConcurrentSessions: viper.GetInt("self_revocation_max_concurrent_sessions"),
+		SelfRevocationMaxSessionsPerIP:      viper.GetInt("self_revocation_max_sessions_per_ip"),
 	}
 }
 
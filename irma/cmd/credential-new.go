@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/spf13/cobra"
+)
+
+type (
+	// credentialDescription is the part of irma.CredentialType that is written to the
+	// description.xml of new credential types, also used as the YAML or JSON spec from which that
+	// file is generated.
+	credentialDescription struct {
+		XMLName           xml.Name                `xml:"IssueSpecification" mapstructure:"-"`
+		XMLVersion        int                     `xml:"version,attr" mapstructure:"-"`
+		Name              irma.TranslatedString   `xml:"Name" mapstructure:"name"`
+		SchemeManagerID   string                  `xml:"SchemeManager" mapstructure:"-"`
+		IssuerID          string                  `xml:"IssuerID" mapstructure:"-"`
+		ID                string                  `xml:"CredentialID" mapstructure:"-"`
+		Description       irma.TranslatedString   `xml:"Description" mapstructure:"description"`
+		IsSingleton       bool                    `xml:"ShouldBeSingleton,omitempty" mapstructure:"singleton"`
+		RevocationServers []string                `xml:"RevocationServers>RevocationServer,omitempty" mapstructure:"revocation_servers"`
+		AttributeTypes    []*attributeDescription `xml:"Attributes>Attribute" mapstructure:"attributes"`
+	}
+
+	// attributeDescription is the part of irma.AttributeType that is written to the description.xml
+	// of new credential types.
+	attributeDescription struct {
+		ID                  string                `xml:"id,attr,omitempty" mapstructure:"id"`
+		Optional            bool                  `xml:"optional,attr,omitempty" mapstructure:"optional"`
+		RandomBlind         bool                  `xml:"randomblind,attr,omitempty" mapstructure:"randomblind"`
+		RevocationAttribute bool                  `xml:"revocation,attr,omitempty" mapstructure:"revocation"`
+		DisplayIndex        *int                  `xml:"displayIndex,attr,omitempty" mapstructure:"display_index"`
+		DisplayHint         string                `xml:"displayHint,attr,omitempty" mapstructure:"display_hint"`
+		Name                irma.TranslatedString `xml:"Name,omitempty" mapstructure:"name"`
+		Description         irma.TranslatedString `xml:"Description,omitempty" mapstructure:"description"`
+	}
+)
+
+// credentialNewCmd represents the credential new command
+var credentialNewCmd = &cobra.Command{
+	Use:   "new <path>",
+	Short: "Create the description of a new IRMA credential type",
+	Long: `Create the description of a new IRMA credential type
+
+The new command writes the description.xml of a new IRMA credential type in the directory specified
+by the "path" parameter, whose name is used as the credential type identifier, and which must be
+contained in the Issues directory of an IRMA issuer. The contents of the description are taken from
+the flags, or from the YAML or JSON file specified with --spec, in which case the flags override the
+values from that file (except for attributes specified with --attribute, which are appended to the
+attributes from the file). For example:
+
+    name:
+      en: Email address
+      nl: E-mailadres
+    description:
+      en: Your verified email address
+      nl: Uw geverifieerde e-mailadres
+    revocation_servers:
+      - https://example.com/irma
+    attributes:
+      - id: email
+        name:
+          en: Email address
+          nl: E-mailadres
+        description:
+          en: Your email address
+          nl: Uw e-mailadres
+      - id: domain
+        optional: true
+        name:
+          en: Domain
+          nl: Domein
+        description:
+          en: The domain of your email address
+          nl: Het domein van uw e-mailadres
+
+If revocation servers are specified, a revocation attribute is added to the credential type unless
+one of the attributes is already marked as such with "revocation: true".
+
+The description is validated along with the rest of the scheme after it has been written.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		if filepath.Base(filepath.Dir(path)) != "Issues" {
+			return errors.New("path must be contained in the Issues directory of an issuer")
+		}
+		issuerdir := filepath.Dir(filepath.Dir(path))
+		if err = common.AssertPathExists(filepath.Join(issuerdir, "description.xml")); err != nil {
+			return errors.Errorf("%s contains no issuer description.xml", issuerdir)
+		}
+		schemedir := filepath.Dir(issuerdir)
+		scheme, err := readSchemeDescription(schemedir)
+		if err != nil {
+			return err
+		}
+		descpath := filepath.Join(path, "description.xml")
+		if err = common.AssertPathNotExists(descpath); err != nil {
+			return errors.Errorf("File %s already exists, not overwriting", descpath)
+		}
+
+		description := &credentialDescription{}
+		if err = readSpec(cmd, description); err != nil {
+			return err
+		}
+		flags := cmd.Flags()
+		flagTranslations(cmd, "name", &description.Name)
+		flagTranslations(cmd, "description", &description.Description)
+		if flags.Changed("singleton") {
+			description.IsSingleton, _ = flags.GetBool("singleton")
+		}
+		if flags.Changed("revocation-server") {
+			description.RevocationServers, _ = flags.GetStringArray("revocation-server")
+		}
+		attrs, _ := flags.GetStringArray("attribute")
+		for _, a := range attrs {
+			attr, err := parseAttributeFlag(a)
+			if err != nil {
+				return err
+			}
+			description.AttributeTypes = append(description.AttributeTypes, attr)
+		}
+		if len(description.RevocationServers) > 0 && !hasRevocationAttribute(description.AttributeTypes) {
+			description.AttributeTypes = append(description.AttributeTypes, &attributeDescription{RevocationAttribute: true})
+		}
+		description.XMLVersion = 4
+		description.ID = filepath.Base(path)
+		description.IssuerID = filepath.Base(issuerdir)
+		description.SchemeManagerID = scheme.ID
+
+		if err = common.EnsureDirectoryExists(path); err != nil {
+			return err
+		}
+		return writeDescription(schemedir, descpath, description)
+	},
+}
+
+// parseAttributeFlag parses an attribute of the form id[:optional][:randomblind], using its
+// identifier as its name and description in English and Dutch.
+func parseAttributeFlag(flag string) (*attributeDescription, error) {
+	parts := strings.Split(flag, ":")
+	if parts[0] == "" {
+		return nil, errors.Errorf("attribute %s has no identifier", flag)
+	}
+	attr := &attributeDescription{
+		ID:          parts[0],
+		Name:        irma.TranslatedString{"en": parts[0], "nl": parts[0]},
+		Description: irma.TranslatedString{"en": parts[0], "nl": parts[0]},
+	}
+	for _, option := range parts[1:] {
+		switch option {
+		case "optional":
+			attr.Optional = true
+		case "randomblind":
+			attr.RandomBlind = true
+		default:
+			return nil, errors.Errorf("unknown option %s of attribute %s", option, parts[0])
+		}
+	}
+	return attr, nil
+}
+
+func hasRevocationAttribute(attrs []*attributeDescription) bool {
+	for _, attr := range attrs {
+		if attr.RevocationAttribute {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	credentialCmd.AddCommand(credentialNewCmd)
+
+	flags := credentialNewCmd.Flags()
+	flags.SortFlags = false
+	flags.String("spec", "", "YAML or JSON file containing the credential type description")
+	addTranslationFlag(credentialNewCmd, "name", "credential type name")
+	addTranslationFlag(credentialNewCmd, "description", "credential type description")
+	flags.StringArrayP("attribute", "a", nil, "attribute of the form id[:optional][:randomblind] (may be repeated); its name and description are set to its id")
+	flags.Bool("singleton", false, "whether users can have at most one instance of the credential type")
+	flags.StringArray("revocation-server", nil, "URL of a revocation server (may be repeated); adds a revocation attribute")
+}
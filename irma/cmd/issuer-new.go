@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"path/filepath"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/spf13/cobra"
+)
+
+// issuerDescription is the part of irma.Issuer that is written to the description.xml of new
+// issuers, also used as the YAML or JSON spec from which that file is generated.
+type issuerDescription struct {
+	XMLName         xml.Name              `xml:"Issuer" mapstructure:"-"`
+	XMLVersion      int                   `xml:"version,attr" mapstructure:"-"`
+	ID              string                `xml:"ID" mapstructure:"-"`
+	Name            irma.TranslatedString `xml:"Name" mapstructure:"name"`
+	SchemeManagerID string                `xml:"SchemeManager" mapstructure:"-"`
+	ContactAddress  string                `xml:"ContactAddress,omitempty" mapstructure:"contact_address"`
+	ContactEMail    string                `xml:"ContactEMail,omitempty" mapstructure:"contact_email"`
+}
+
+// issuerNewCmd represents the issuer new command
+var issuerNewCmd = &cobra.Command{
+	Use:   "new <path>",
+	Short: "Create the description of a new IRMA issuer",
+	Long: `Create the description of a new IRMA issuer
+
+The new command writes the description.xml of a new IRMA issuer in the directory specified by the
+"path" parameter, whose name is used as the issuer identifier, and which must be contained in the
+directory of an IRMA scheme. The contents of the description are taken from the flags, or from the
+YAML or JSON file specified with --spec, in which case the flags override the values from that
+file. For example:
+
+    name:
+      en: Example organization
+      nl: Voorbeeldorganisatie
+    contact_email: info@example.com
+
+The description is validated along with the rest of the scheme after it has been written. Next,
+credential types can be added to the issuer using "irma scheme credential new", and the issuer
+must be provided with a keypair using "irma scheme issuer keygen".`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		schemedir := filepath.Dir(path)
+		scheme, err := readSchemeDescription(schemedir)
+		if err != nil {
+			return err
+		}
+		descpath := filepath.Join(path, "description.xml")
+		if err = common.AssertPathNotExists(descpath); err != nil {
+			return errors.Errorf("File %s already exists, not overwriting", descpath)
+		}
+
+		description := &issuerDescription{}
+		if err = readSpec(cmd, description); err != nil {
+			return err
+		}
+		flagTranslations(cmd, "name", &description.Name)
+		flagString(cmd, "contact-address", &description.ContactAddress)
+		flagString(cmd, "contact-email", &description.ContactEMail)
+		description.XMLVersion = 4
+		description.ID = filepath.Base(path)
+		description.SchemeManagerID = scheme.ID
+
+		if err = common.EnsureDirectoryExists(path); err != nil {
+			return err
+		}
+		return writeDescription(schemedir, descpath, description)
+	},
+}
+
+func init() {
+	issuerCmd.AddCommand(issuerNewCmd)
+
+	flags := issuerNewCmd.Flags()
+	flags.SortFlags = false
+	flags.String("spec", "", "YAML or JSON file containing the issuer description")
+	addTranslationFlag(issuerNewCmd, "name", "issuer name")
+	flags.String("contact-address", "", "postal address of the issuer")
+	flags.String("contact-email", "", "email address of the issuer")
+}
@@ -88,6 +88,7 @@ func init() {
 	flags.BoolP("quiet", "q", false, "quiet")
 	flags.Bool("log-json", false, "Log in JSON format")
 	flags.Bool("production", false, "Production mode")
+	flags.Bool("metrics", false, "Expose Prometheus metrics at /metrics")
 }
 
 func configureKeyshareServer(cmd *cobra.Command) (*keyshareserver.Configuration, error) {
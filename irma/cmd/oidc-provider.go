@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/json"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/privacybydesign/irmago/server/oidcprovider"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var oidcProviderCmd = &cobra.Command{
+	Use:   "oidc-provider",
+	Short: "OpenID Connect provider authenticating users with IRMA attributes",
+	Long: `OpenID Connect provider authenticating users with IRMA attributes.
+
+The provider implements the OpenID Connect authorization code flow. For each authorization
+request it starts an IRMA disclosure session, requesting the attributes that are configured
+for the requested scopes. Once the user has disclosed them, they are included as claims in
+the ID token and at the userinfo endpoint.
+
+Clients, scopes and claims are configured in the configuration file or as JSON in the flags.
+For example:
+
+  clients:
+    myapp:
+      secret: "..."
+      redirect_uris: [ "https://example.com/callback" ]
+  scopes:
+    email: [ [ [ "pbdf.sidn-pbdf.email.email" ] ] ]
+  claims:
+    pbdf.sidn-pbdf.email.email: email`,
+	Run: func(command *cobra.Command, args []string) {
+		conf, err := configureOIDCProvider(command)
+		if err != nil {
+			die("failed to read configuration", err)
+		}
+
+		provider, err := oidcprovider.New(conf)
+		if err != nil {
+			die("", err)
+		}
+
+		runServer(provider, conf.Logger)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(oidcProviderCmd)
+
+	oidcProviderCmd.SetUsageTemplate(headerFlagsTemplate)
+	headers := map[string]string{}
+	flagHeaders["irma oidc-provider"] = headers
+
+	flags := oidcProviderCmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringP("config", "c", "", "path to configuration file")
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.String("schemes-assets-path", "", "if specified, copy schemes from here into --schemes-path")
+	flags.Int("schemes-update", 60, "update IRMA schemes every x minutes (0 to disable)")
+	flags.StringP("url", "u", "", "external URL to server to which the IRMA client connects, \":port\" being replaced by --port value (default --issuer)")
+
+	headers["port"] = "Server address and port to listen on"
+	flags.IntP("port", "p", 8080, "port at which to listen")
+	flags.StringP("listen-addr", "l", "", "address at which to listen (default 0.0.0.0)")
+
+	headers["issuer"] = "OpenID Connect configuration"
+	flags.String("issuer", "", "issuer identifier: external URL at which the provider is reachable")
+	flags.String("clients", "", "clients as JSON, mapping client IDs to their secret and redirect URIs")
+	flags.String("scopes", "", "scopes as JSON, mapping each scope to the attribute condiscon to be disclosed for it")
+	flags.String("claims", "", "claim names as JSON, mapping attribute identifiers to the claim in which they are included")
+	flags.String("subject-attribute", "", "attribute from which the subject identifier is derived (default all disclosed attributes)")
+	flags.Int("token-lifetime", oidcprovider.TokenLifetimeDefault, "lifetime of ID tokens and access tokens in seconds")
+	flags.String("frontend-script", oidcprovider.FrontendScriptDefault, "URL of the irma-frontend script used on the login page")
+
+	headers["jwt-privkey"] = "Cryptographic keys"
+	flags.String("jwt-privkey", "", "private key with which ID tokens are signed")
+	flags.String("jwt-privkey-file", "", "path to private key with which ID tokens are signed")
+
+	headers["tls-cert"] = "TLS configuration (leave empty to disable TLS)"
+	flags.String("tls-cert", "", "TLS certificate (chain)")
+	flags.String("tls-cert-file", "", "path to TLS certificate (chain)")
+	flags.String("tls-privkey", "", "TLS private key")
+	flags.String("tls-privkey-file", "", "path to TLS private key")
+	flags.Bool("no-tls", false, "Disable TLS")
+
+	headers["verbose"] = "Other options"
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+	flags.BoolP("quiet", "q", false, "quiet")
+	flags.Bool("log-json", false, "Log in JSON format")
+	flags.Bool("production", false, "Production mode")
+}
+
+func configureOIDCProvider(cmd *cobra.Command) (*oidcprovider.Configuration, error) {
+	readConfig(cmd, "oidcprovider", "oidc-provider", []string{".", "/etc/oidc-provider/"}, nil)
+
+	conf := &oidcprovider.Configuration{
+		Configuration: configureIRMAServer(),
+
+		Issuer:           viper.GetString("issuer"),
+		SubjectAttribute: viper.GetString("subject_attribute"),
+		TokenLifetime:    viper.GetInt("token_lifetime"),
+		FrontendScript:   viper.GetString("frontend_script"),
+	}
+	conf.URL = server.ReplacePortString(viper.GetString("url"), viper.GetInt("port"))
+
+	if err := handleMapOrString("clients", &conf.Clients); err != nil {
+		return nil, err
+	}
+	if err := handleMapOrString("claims", &conf.Claims); err != nil {
+		return nil, err
+	}
+
+	// Condiscons can contain attribute requests in string form, which mapstructure does not
+	// understand, so we convert them using their JSON representation instead
+	var scopes map[string]interface{}
+	if err := handleMapOrString("scopes", &scopes); err != nil {
+		return nil, err
+	}
+	bts, err := json.Marshal(scopes)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to unmarshal scopes", 0)
+	}
+	if err = json.Unmarshal(bts, &conf.Scopes); err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to unmarshal scopes", 0)
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/spf13/cobra"
+)
+
+var revocationDumpCmd = &cobra.Command{
+	Use:   "dump <credentialtype> [<file>]",
+	Short: "Dump the revocation state of a credential type to a signed archive",
+	Long: `Dump the revocation state of a credential type to a signed archive.
+
+The archive contains all accumulators, revocation events and issuance records of the credential type
+in the revocation database, and is signed with the latest private key of the issuer. It is written to
+the specified file, or to standard output if no file is specified. Use "irma revocation restore" to
+write it to another revocation database.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := irma.NewCredentialTypeIdentifier(args[0])
+		conf := openRevocationDB(cmd, id)
+		defer closeRevocationDB(conf)
+
+		archive, err := conf.Revocation.DumpRevocationState(id)
+		if err != nil {
+			die("failed to dump revocation state", err)
+		}
+		bts, err := json.Marshal(archive)
+		if err != nil {
+			die("failed to marshal revocation archive", err)
+		}
+		if len(args) == 1 {
+			fmt.Println(string(bts))
+			return
+		}
+		if err = ioutil.WriteFile(args[1], bts, 0600); err != nil {
+			die("failed to write revocation archive", err)
+		}
+	},
+}
+
+var revocationRestoreCmd = &cobra.Command{
+	Use:   "restore [<file>]",
+	Short: "Restore the revocation state of a credential type from a signed archive",
+	Long: `Restore the revocation state of a credential type from a signed archive.
+
+Before anything is written to the revocation database, the signature of the archive and of the
+accumulators in it are verified against the public keys of the issuer, as well as the hash chains of
+the revocation events. The database must not yet contain revocation state for the credential type.
+The archive is read from the specified file, or from standard input if no file is specified.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var (
+			bts []byte
+			err error
+		)
+		if len(args) == 0 {
+			bts, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			bts, err = ioutil.ReadFile(args[0])
+		}
+		if err != nil {
+			die("failed to read revocation archive", err)
+		}
+		var archive irma.SignedRevocationArchive
+		if err = json.Unmarshal(bts, &archive); err != nil {
+			die("failed to parse revocation archive", err)
+		}
+
+		conf := openRevocationDB(cmd, archive.CredType)
+		defer closeRevocationDB(conf)
+		if err = conf.Revocation.RestoreRevocationState(&archive); err != nil {
+			die("failed to restore revocation state", err)
+		}
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{revocationDumpCmd, revocationRestoreCmd} {
+		flags := cmd.Flags()
+		flags.String("db-type", "postgres", "database type of the revocation database (supported: mysql, postgres, sqlite)")
+		flags.String("db-str", "", "connection string of the revocation database")
+		flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+		flags.CountP("verbose", "v", "verbose (repeatable)")
+		revocationCmd.AddCommand(cmd)
+	}
+	revocationDumpCmd.Flags().String("privkeys", "", "path to IRMA private keys")
+}
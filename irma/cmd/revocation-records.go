@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var revocationRecordsCmd = &cobra.Command{
+	Use:   "records",
+	Short: "Inspect and export the issuance records in a revocation database",
+	Long: `Inspect and export the issuance records in a revocation database.
+
+The records commands read directly from the revocation database of an IRMA server that is the
+revocation authority of the credential type, as specified with --db-type and --db-str.`,
+}
+
+var revocationRecordsListCmd = &cobra.Command{
+	Use:   "list <credentialtype>",
+	Short: "List the issuance records of a credential type",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := irma.NewCredentialTypeIdentifier(args[0])
+		conf := openRevocationDB(cmd, id)
+		defer closeRevocationDB(conf)
+
+		records := findIssuanceRecords(cmd, conf, id)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tISSUED\tPKCOUNTER\tVALID UNTIL\tREVOKED AT")
+		for _, r := range records {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Key, formatNanoTime(r.Issued), formatPKCounter(r.PKCounter),
+				formatNanoTime(r.ValidUntil), formatNanoTime(r.RevokedAt))
+		}
+		_ = w.Flush()
+	},
+}
+
+var revocationRecordsStateCmd = &cobra.Command{
+	Use:   "state <credentialtype>",
+	Short: "Show the accumulator state of a credential type per issuer public key",
+	Long: `Show the accumulator state of a credential type per issuer public key.
+
+For each public key of the issuer for which the database contains an accumulator, the index and
+time of the current accumulator are shown, along with the number of revocation events and of
+(revoked) issuance records, how many credentials issued with the key are still valid (i.e., neither
+expired nor revoked) and until when, and whether the accumulator is frozen.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := irma.NewCredentialTypeIdentifier(args[0])
+		conf := openRevocationDB(cmd, id)
+		defer closeRevocationDB(conf)
+
+		states, err := conf.Revocation.AccumulatorStates(id)
+		if err != nil {
+			die("failed to read accumulator states", err)
+		}
+		printAccumulatorStates(states)
+	},
+}
+
+func printAccumulatorStates(states []*irma.AccumulatorState) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PKCOUNTER\tINDEX\tTIME\tEVENTS\tRECORDS\tREVOKED\tVALID\tVALID UNTIL\tFROZEN")
+	for _, s := range states {
+		validUntil := "-"
+		if s.Valid > 0 {
+			validUntil = s.ValidUntil.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%d\t%d\t%d\t%s\t%t\n", s.PKCounter, s.Index, s.Time.Format(time.RFC3339),
+			s.Events, s.IssuanceRecords, s.Revoked, s.Valid, validUntil, s.Frozen)
+	}
+	_ = w.Flush()
+}
+
+var revocationRecordsExportCmd = &cobra.Command{
+	Use:   "export <credentialtype>",
+	Short: "Export the issuance records of a credential type to JSON or CSV",
+	Long: `Export the issuance records of a credential type to JSON or CSV.
+
+Timestamps are exported in Unix nanoseconds, as they are stored in the database; a zero revokedAt
+means that the credential has not been revoked. The revocation attribute of the records is not exported.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+		if format != "json" && format != "csv" {
+			die("invalid format (must be json or csv)", nil)
+		}
+
+		id := irma.NewCredentialTypeIdentifier(args[0])
+		conf := openRevocationDB(cmd, id)
+		defer closeRevocationDB(conf)
+		records := findIssuanceRecords(cmd, conf, id)
+
+		var w io.Writer = os.Stdout
+		if output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				die("failed to create output file", err)
+			}
+			defer func() { _ = f.Close() }()
+			w = f
+		}
+		if err := exportIssuanceRecords(w, format, records); err != nil {
+			die("failed to export issuance records", err)
+		}
+	},
+}
+
+// exportedIssuanceRecord is the representation of irma.IssuanceRecord in exports.
+type exportedIssuanceRecord struct {
+	Key        string                        `json:"key"`
+	CredType   irma.CredentialTypeIdentifier `json:"type"`
+	Issued     int64                         `json:"issued"`
+	PKCounter  *uint                         `json:"pkCounter"`
+	ValidUntil int64                         `json:"validUntil"`
+	RevokedAt  int64                         `json:"revokedAt"`
+}
+
+func exportIssuanceRecords(w io.Writer, format string, records []*irma.IssuanceRecord) error {
+	if format == "json" {
+		exported := make([]exportedIssuanceRecord, 0, len(records))
+		for _, r := range records {
+			exported = append(exported, exportedIssuanceRecord{
+				Key:        r.Key,
+				CredType:   r.CredType,
+				Issued:     r.Issued,
+				PKCounter:  r.PKCounter,
+				ValidUntil: r.ValidUntil,
+				RevokedAt:  r.RevokedAt,
+			})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exported)
+	}
+
+	c := csv.NewWriter(w)
+	if err := c.Write([]string{"key", "type", "issued", "pkCounter", "validUntil", "revokedAt"}); err != nil {
+		return err
+	}
+	for _, r := range records {
+		pkcounter := ""
+		if r.PKCounter != nil {
+			pkcounter = strconv.FormatUint(uint64(*r.PKCounter), 10)
+		}
+		if err := c.Write([]string{
+			r.Key,
+			r.CredType.String(),
+			strconv.FormatInt(r.Issued, 10),
+			pkcounter,
+			strconv.FormatInt(r.ValidUntil, 10),
+			strconv.FormatInt(r.RevokedAt, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// openRevocationDB parses the schemes and connects to the revocation database specified in the flags.
+func openRevocationDB(cmd *cobra.Command, id irma.CredentialTypeIdentifier) *irma.Configuration {
+	flags := cmd.Flags()
+	schemespath, _ := flags.GetString("schemes-path")
+	dbtype, _ := flags.GetString("db-type")
+	dbstr, _ := flags.GetString("db-str")
+	privkeys, _ := flags.GetString("privkeys")
+	verbosity, _ := flags.GetCount("verbose")
+
+	logger.Level = server.Verbosity(verbosity)
+	irma.SetLogger(logger)
+
+	if dbstr == "" {
+		die("no revocation database specified (use --db-str)", nil)
+	}
+	conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{
+		ReadOnly:            true,
+		RevocationDBType:    dbtype,
+		RevocationDBConnStr: dbstr,
+	})
+	if err != nil {
+		die("failed to open irma_configuration", err)
+	}
+	if err = conf.ParseFolder(); err != nil {
+		die("failed to parse irma_configuration", err)
+	}
+	if privkeys != "" {
+		ring, err := irma.NewPrivateKeyRingFolder(privkeys, conf)
+		if err != nil {
+			die("failed to read private keys", err)
+		}
+		if err = conf.AddPrivateKeyRing(ring); err != nil {
+			die("failed to add private keys", err)
+		}
+	}
+
+	credtype, known := conf.CredentialTypes[id]
+	if !known {
+		die("unknown credential type", nil)
+	}
+	if !credtype.RevocationSupported() {
+		die("credential type does not support revocation", nil)
+	}
+	return conf
+}
+
+func closeRevocationDB(conf *irma.Configuration) {
+	_ = conf.Revocation.Close()
+}
+
+func findIssuanceRecords(cmd *cobra.Command, conf *irma.Configuration, id irma.CredentialTypeIdentifier) []*irma.IssuanceRecord {
+	filter, err := issuanceRecordFilter(cmd.Flags())
+	if err != nil {
+		die("invalid filter", err)
+	}
+	records, err := conf.Revocation.FindIssuanceRecords(id, filter)
+	if err != nil {
+		die("failed to read issuance records", err)
+	}
+	return records
+}
+
+func issuanceRecordFilter(flags *pflag.FlagSet) (irma.IssuanceRecordFilter, error) {
+	var filter irma.IssuanceRecordFilter
+	filter.Key, _ = flags.GetString("key")
+
+	var err error
+	if after, _ := flags.GetString("issued-after"); after != "" {
+		if filter.IssuedFrom, err = time.Parse(time.RFC3339, after); err != nil {
+			return filter, errors.WrapPrefix(err, "invalid --issued-after", 0)
+		}
+	}
+	if before, _ := flags.GetString("issued-before"); before != "" {
+		if filter.IssuedUntil, err = time.Parse(time.RFC3339, before); err != nil {
+			return filter, errors.WrapPrefix(err, "invalid --issued-before", 0)
+		}
+	}
+
+	revoked, _ := flags.GetBool("revoked")
+	notRevoked, _ := flags.GetBool("not-revoked")
+	if revoked && notRevoked {
+		return filter, errors.New("--revoked and --not-revoked are mutually exclusive")
+	}
+	if revoked || notRevoked {
+		filter.Revoked = &revoked
+	}
+	return filter, nil
+}
+
+func formatNanoTime(t int64) string {
+	if t == 0 {
+		return "-"
+	}
+	return time.Unix(0, t).Format(time.RFC3339)
+}
+
+func formatPKCounter(counter *uint) string {
+	if counter == nil {
+		return "-"
+	}
+	return strconv.FormatUint(uint64(*counter), 10)
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{revocationRecordsListCmd, revocationRecordsStateCmd, revocationRecordsExportCmd} {
+		flags := cmd.Flags()
+		flags.String("db-type", "postgres", "database type of the revocation database (supported: mysql, postgres, sqlite)")
+		flags.String("db-str", "", "connection string of the revocation database")
+		flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+		flags.CountP("verbose", "v", "verbose (repeatable)")
+		revocationRecordsCmd.AddCommand(cmd)
+	}
+
+	for _, cmd := range []*cobra.Command{revocationRecordsListCmd, revocationRecordsExportCmd} {
+		flags := cmd.Flags()
+		flags.String("key", "", "only records with this revocation key")
+		flags.String("issued-after", "", "only records issued at or after this time (RFC 3339)")
+		flags.String("issued-before", "", "only records issued before this time (RFC 3339)")
+		flags.Bool("revoked", false, "only revoked records")
+		flags.Bool("not-revoked", false, "only records that have not been revoked")
+	}
+
+	flags := revocationRecordsExportCmd.Flags()
+	flags.StringP("format", "f", "json", "export format (json or csv)")
+	flags.StringP("output", "o", "", "file to write the export to (default stdout)")
+
+	revocationCmd.AddCommand(revocationRecordsCmd)
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"github.com/privacybydesign/gabi/gabikeys"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/spf13/cobra"
+)
+
+var revocationRotateCmd = &cobra.Command{
+	Use:   "rotate <credentialtype>",
+	Short: "Create the accumulator of a new issuer key, and optionally freeze those of older keys",
+	Long: `Create the accumulator of a new issuer key, and optionally freeze those of older keys.
+
+When the private key of an issuer is rotated, the revocation authority needs an accumulator for the
+new key before credentials can be issued with it. The rotate command creates it for the latest private
+key of the issuer, or for the key specified with --counter. The accumulators of older keys remain in
+use as long as credentials issued with those keys are still valid. With --freeze, the accumulators
+of older keys with which no valid credentials remain are frozen: they are no longer updated, and
+can be used neither for issuance nor for revocation.
+
+Afterwards the state of the accumulators of the credential type is shown, including how many
+credentials issued with each key are still valid.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		counter, _ := cmd.Flags().GetInt("counter")
+		freeze, _ := cmd.Flags().GetBool("freeze")
+
+		id := irma.NewCredentialTypeIdentifier(args[0])
+		conf := openRevocationDB(cmd, id)
+		defer closeRevocationDB(conf)
+
+		var (
+			sk  *gabikeys.PrivateKey
+			err error
+		)
+		if counter < 0 {
+			sk, err = conf.Revocation.Keys.PrivateKeyLatest(id.IssuerIdentifier())
+		} else {
+			sk, err = conf.Revocation.Keys.PrivateKey(id.IssuerIdentifier(), uint(counter))
+		}
+		if err != nil {
+			die("failed to read private key", err)
+		}
+
+		states, err := conf.Revocation.RotateAccumulator(id, sk, freeze)
+		if err != nil {
+			die("failed to rotate accumulator", err)
+		}
+		printAccumulatorStates(states)
+	},
+}
+
+func init() {
+	flags := revocationRotateCmd.Flags()
+	flags.String("db-type", "postgres", "database type of the revocation database (supported: mysql, postgres, sqlite)")
+	flags.String("db-str", "", "connection string of the revocation database")
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.String("privkeys", "", "path to IRMA private keys")
+	flags.Int("counter", -1, "counter of the private key for which to create the accumulator (default latest)")
+	flags.Bool("freeze", false, "freeze the accumulators of older keys with which no valid credentials remain")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+
+	revocationCmd.AddCommand(revocationRotateCmd)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/spf13/cobra"
+)
+
+var revocationSnapshotCmd = &cobra.Command{
+	Use:   "snapshot <credentialtype> [<file>]",
+	Short: "Export the latest accumulators of a credential type for offline verification",
+	Long: `Export the latest accumulators of a credential type for offline verification.
+
+The snapshot contains the latest signed accumulator and revocation events for each public key of the
+issuer. It is read from the revocation database if one is specified with --db-str, and otherwise
+fetched from the revocation server of the credential type (or from --url). It is written to the
+specified file, or to standard output if no file is specified.
+
+Verifiers that cannot reach the revocation server can verify nonrevocation proofs against the
+snapshot, using the "snapshot" revocation setting of the IRMA server or the --revocation-snapshot
+flag of "irma session".`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := irma.NewCredentialTypeIdentifier(args[0])
+		flags := cmd.Flags()
+		dbstr, _ := flags.GetString("db-str")
+
+		var conf *irma.Configuration
+		if dbstr != "" {
+			conf = openRevocationDB(cmd, id)
+			defer closeRevocationDB(conf)
+		} else {
+			conf = openRevocationServer(cmd, id)
+		}
+
+		snapshot, err := conf.Revocation.AccumulatorSnapshot(id)
+		if err != nil {
+			die("failed to export accumulators", err)
+		}
+		bts, err := json.Marshal(snapshot)
+		if err != nil {
+			die("failed to marshal accumulator snapshot", err)
+		}
+		if len(args) == 1 {
+			fmt.Println(string(bts))
+			return
+		}
+		if err = ioutil.WriteFile(args[1], bts, 0644); err != nil {
+			die("failed to write accumulator snapshot", err)
+		}
+	},
+}
+
+// openRevocationServer parses the schemes, configuring the revocation server specified in the
+// flags, if any, for the credential type.
+func openRevocationServer(cmd *cobra.Command, id irma.CredentialTypeIdentifier) *irma.Configuration {
+	flags := cmd.Flags()
+	schemespath, _ := flags.GetString("schemes-path")
+	url, _ := flags.GetString("url")
+	verbosity, _ := flags.GetCount("verbose")
+
+	logger.Level = server.Verbosity(verbosity)
+	irma.SetLogger(logger)
+
+	settings := irma.RevocationSettings{}
+	if url != "" {
+		settings[id] = &irma.RevocationSetting{RevocationServerURL: url}
+	}
+	conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{
+		ReadOnly:           true,
+		RevocationSettings: settings,
+	})
+	if err != nil {
+		die("failed to open irma_configuration", err)
+	}
+	if err = conf.ParseFolder(); err != nil {
+		die("failed to parse irma_configuration", err)
+	}
+	return conf
+}
+
+func init() {
+	flags := revocationSnapshotCmd.Flags()
+	flags.String("url", "", "URL of the revocation server (default: the revocation server of the credential type)")
+	flags.String("db-type", "postgres", "database type of the revocation database (supported: mysql, postgres, sqlite)")
+	flags.String("db-str", "", "connection string of the revocation database")
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+	revocationCmd.AddCommand(revocationSnapshotCmd)
+}
@@ -0,0 +1,12 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+var revocationCmd = &cobra.Command{
+	Use:   "revocation",
+	Short: "Manage the revocation database of an IRMA server",
+}
+
+func init() {
+	RootCmd.AddCommand(revocationCmd)
+}
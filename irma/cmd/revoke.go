@@ -1,36 +1,102 @@
 package cmd
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-errors/errors"
+
 	irma "github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/server"
 	"github.com/spf13/cobra"
 )
 
 var revokeCmd = &cobra.Command{
-	Use:   "revoke <credentialtype> <key> <url>",
+	Use:   "revoke <credentialtype> [<key>] <url>",
 	Short: "Revoke a previously issued credential identified by a given key",
-	Args:  cobra.ExactArgs(3),
+	Long: `Revoke a previously issued credential identified by a given key.
+
+With --from-file, the credentials identified by each of the keys in the file are revoked at once,
+resulting in a single update of the revocation accumulator. The file contains one key per line,
+optionally followed by whitespace and the issuance time of the credential to revoke in Unix nanoseconds.
+Empty lines and lines starting with # are ignored. Pass - to read the keys from standard input.`,
+	Args: cobra.RangeArgs(2, 3),
 	Run: func(cmd *cobra.Command, args []string) {
 		flags := cmd.Flags()
 		schemespath, _ := flags.GetString("schemes-path")
 		authmethod, _ := flags.GetString("auth-method")
 		key, _ := flags.GetString("key")
 		name, _ := flags.GetString("name")
+		file, _ := flags.GetString("from-file")
 		verbosity, _ := cmd.Flags().GetCount("verbose")
-		url := args[2]
+		url := args[len(args)-1]
 
 		request := &irma.RevocationRequest{
 			LDContext:      irma.LDContextRevocationRequest,
 			CredentialType: irma.NewCredentialTypeIdentifier(args[0]),
-			Key:            args[1],
+		}
+		switch {
+		case file == "" && len(args) == 3:
+			request.Key = args[1]
+		case file != "" && len(args) == 2:
+			keys, err := readRevocationKeys(file)
+			if err != nil {
+				die("failed to read revocation keys", err)
+			}
+			request.Keys = keys
+		default:
+			die("specify either a key or --from-file", nil)
 		}
 
 		postRevocation(request, url, schemespath, authmethod, key, name, verbosity)
 	},
 }
 
+func readRevocationKeys(path string) ([]irma.RevocationKey, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+
+	var keys []irma.RevocationKey
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, errors.Errorf("line %d: expected key and optional issuance time", line)
+		}
+		key := irma.RevocationKey{Key: fields[0]}
+		if len(fields) == 2 {
+			issued, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, errors.WrapPrefix(err, fmt.Sprintf("line %d: invalid issuance time", line), 0)
+			}
+			key.Issued = issued
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no revocation keys found")
+	}
+	return keys, nil
+}
+
 func postRevocation(request *irma.RevocationRequest, url, schemespath, authmethod, key, name string, verbosity int) {
 	logger.Level = server.Verbosity(verbosity)
 	irma.SetLogger(logger)
@@ -93,6 +159,7 @@ func init() {
 	flags.StringP("auth-method", "a", "none", "Authentication method to server (none, token, rsa, hmac)")
 	flags.String("key", "", "Key to sign request with")
 	flags.String("name", "", "Requestor name")
+	flags.String("from-file", "", "revoke the keys listed in this file (- for stdin) in a single accumulator update")
 	flags.CountP("verbose", "v", "verbose (repeatable)")
 
 	issuerCmd.AddCommand(revokeCmd)
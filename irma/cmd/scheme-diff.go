@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/spf13/cobra"
+)
+
+// schemeDiffCmd represents the scheme diff command
+var schemeDiffCmd = &cobra.Command{
+	Use:   "diff <old> <new>",
+	Short: "Show the changes between two versions of a scheme",
+	Long: `Show the changes between two versions of a scheme
+
+The diff command parses and verifies the schemes in the "old" and "new" directories, and shows the
+changes between them that matter to wallets: added and removed issuers, public keys, credential
+types and issue wizards, and changes to credential types and their attributes. Changes that break
+existing credentials, keyshare enrollments or issue wizards, such as removed credential types or
+reordered attributes, are marked as breaking.
+
+The command exits with a nonzero status if there are breaking changes, or with --strict if there
+are any changes, so that it can be used to check scheme updates before publishing them.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		asJSON, _ := flags.GetBool("json")
+		strict, _ := flags.GetBool("strict")
+
+		from, err := parseSchemeForDiff(args[0])
+		if err != nil {
+			die("Failed to parse old scheme", err)
+		}
+		to, err := parseSchemeForDiff(args[1])
+		if err != nil {
+			die("Failed to parse new scheme", err)
+		}
+		diff, err := irma.DiffSchemes(from, to)
+		if err != nil {
+			die("Failed to compare schemes", err)
+		}
+
+		if asJSON {
+			if diff == nil {
+				diff = irma.SchemeDiff{}
+			}
+			bts, _ := json.MarshalIndent(diff, "", "  ")
+			fmt.Println(string(bts))
+		} else {
+			for _, change := range diff {
+				prefix := "         "
+				if change.Breaking {
+					prefix = "BREAKING "
+				}
+				fmt.Println(prefix + change.String())
+			}
+		}
+
+		if diff.Breaking() {
+			die("", errors.New("new scheme contains breaking changes"))
+		}
+		if strict && len(diff) > 0 {
+			die("", errors.New("schemes differ"))
+		}
+	},
+}
+
+func parseSchemeForDiff(path string) (*irma.Configuration, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := irma.NewConfiguration(filepath.Dir(path), irma.ConfigurationOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conf.ParseSchemeFolder(path); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func init() {
+	schemeCmd.AddCommand(schemeDiffCmd)
+
+	schemeDiffCmd.Flags().Bool("json", false, "output the changes as JSON")
+	schemeDiffCmd.Flags().Bool("strict", false, "exit with a nonzero status if there are any changes, also if not breaking")
+}
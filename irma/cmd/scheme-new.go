@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// schemeDescription is the part of irma.SchemeManager that is written to the description.xml of
+// new schemes, also used as the YAML or JSON spec from which that file is generated.
+type schemeDescription struct {
+	XMLName         xml.Name              `xml:"SchemeManager" mapstructure:"-"`
+	XMLVersion      int                   `xml:"version,attr" mapstructure:"-"`
+	ID              string                `xml:"Id" mapstructure:"-"`
+	URL             string                `xml:"Url" mapstructure:"url"`
+	Demo            bool                  `xml:"Demo,omitempty" mapstructure:"demo"`
+	Name            irma.TranslatedString `xml:"Name" mapstructure:"name"`
+	Description     irma.TranslatedString `xml:"Description" mapstructure:"description"`
+	TimestampServer string                `xml:"TimestampServer,omitempty" mapstructure:"timestamp_server"`
+	Contact         string                `xml:"contact,omitempty" mapstructure:"contact"`
+}
+
+// schemeNewCmd represents the scheme new command
+var schemeNewCmd = &cobra.Command{
+	Use:   "new <path>",
+	Short: "Create the description of a new IRMA scheme",
+	Long: `Create the description of a new IRMA scheme
+
+The new command writes the description.xml of a new IRMA scheme in the directory specified by the
+"path" parameter, whose name is used as the scheme identifier. The contents of the description are
+taken from the flags, or from the YAML or JSON file specified with --spec, in which case the flags
+override the values from that file. For example:
+
+    url: https://example.com/schemes/example
+    name:
+      en: Example scheme
+      nl: Voorbeeldschema
+    description:
+      en: Credentials of the example organization
+      nl: Credentials van de voorbeeldorganisatie
+    contact: https://example.com
+
+The description is validated after it has been written. Next, issuers can be added to the scheme
+using "irma scheme issuer new", after which the scheme must be provided with a keypair using
+"irma scheme keygen" and signed using "irma scheme sign" before it can be used.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		descpath := filepath.Join(path, "description.xml")
+		if err = common.AssertPathNotExists(descpath); err != nil {
+			return errors.Errorf("File %s already exists, not overwriting", descpath)
+		}
+
+		description := &schemeDescription{}
+		if err = readSpec(cmd, description); err != nil {
+			return err
+		}
+		flags := cmd.Flags()
+		if flags.Changed("demo") {
+			description.Demo, _ = flags.GetBool("demo")
+		}
+		flagString(cmd, "url", &description.URL)
+		flagString(cmd, "timestamp-server", &description.TimestampServer)
+		flagString(cmd, "contact", &description.Contact)
+		flagTranslations(cmd, "name", &description.Name)
+		flagTranslations(cmd, "description", &description.Description)
+		if description.URL == "" {
+			return errors.New("scheme URL is required")
+		}
+		description.XMLVersion = 7
+		description.ID = filepath.Base(path)
+
+		created, err := createDirectory(path)
+		if err != nil {
+			return err
+		}
+		if err = writeDescription(path, descpath, description); err != nil && created != "" {
+			_ = os.RemoveAll(created)
+		}
+		return err
+	},
+}
+
+// createDirectory creates the directory at path along with any missing parents, returning the
+// topmost directory that it created, or "" if path already existed.
+func createDirectory(path string) (string, error) {
+	created := ""
+	for dir := path; ; dir = filepath.Dir(dir) {
+		exists, err := common.PathExists(dir)
+		if err != nil {
+			return "", err
+		}
+		if exists || dir == filepath.Dir(dir) {
+			break
+		}
+		created = dir
+	}
+	return created, common.EnsureDirectoryExists(path)
+}
+
+// readSpec unmarshals the YAML or JSON file specified with the --spec flag, if any, into dest.
+func readSpec(cmd *cobra.Command, dest interface{}) error {
+	path, _ := cmd.Flags().GetString("spec")
+	if path == "" {
+		return nil
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return errors.WrapPrefix(err, "Failed to read spec "+path, 0)
+	}
+	if err := v.Unmarshal(dest); err != nil {
+		return errors.WrapPrefix(err, "Failed to unmarshal spec "+path, 0)
+	}
+	return nil
+}
+
+func flagString(cmd *cobra.Command, name string, dest *string) {
+	if cmd.Flags().Changed(name) {
+		*dest, _ = cmd.Flags().GetString(name)
+	}
+}
+
+// flagTranslations adds the translations given in the specified flag, if any, to dest.
+func flagTranslations(cmd *cobra.Command, name string, dest *irma.TranslatedString) {
+	if !cmd.Flags().Changed(name) {
+		return
+	}
+	translations, _ := cmd.Flags().GetStringToString(name)
+	if *dest == nil {
+		*dest = irma.TranslatedString{}
+	}
+	for lang, text := range translations {
+		(*dest)[lang] = text
+	}
+}
+
+// readSchemeDescription parses the description.xml of the scheme in dir, without verifying its
+// signature.
+func readSchemeDescription(dir string) (*irma.SchemeManager, error) {
+	bts, err := ioutil.ReadFile(filepath.Join(dir, "description.xml"))
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to read scheme description", 0)
+	}
+	scheme := &irma.SchemeManager{}
+	if err = xml.Unmarshal(bts, scheme); err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to parse scheme description", 0)
+	}
+	return scheme, nil
+}
+
+// writeDescription writes the description to path and validates the scheme in schemedir containing
+// it, removing the description again if that makes the scheme invalid.
+func writeDescription(schemedir, path string, description interface{}) error {
+	bts, err := xml.MarshalIndent(description, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path, append(bts, '\n'), 0644); err != nil {
+		return err
+	}
+
+	conf, err := irma.NewConfiguration(filepath.Dir(schemedir), irma.ConfigurationOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	if err = conf.ValidateSchemeDescriptions(schemedir); err != nil {
+		_ = os.Remove(path)
+		return errors.WrapPrefix(err, "Scheme invalid with new description, not writing it", 0)
+	}
+	fmt.Println("Description written at", path)
+	for _, warning := range conf.Warnings {
+		fmt.Println("Warning: " + warning)
+	}
+	return nil
+}
+
+func addTranslationFlag(cmd *cobra.Command, name, usage string) {
+	cmd.Flags().StringToString(name, nil, usage+` per language, e.g. --`+name+` "en=...,nl=..."`)
+}
+
+func init() {
+	schemeCmd.AddCommand(schemeNewCmd)
+
+	flags := schemeNewCmd.Flags()
+	flags.SortFlags = false
+	flags.String("spec", "", "YAML or JSON file containing the scheme description")
+	flags.String("url", "", "URL at which the scheme will be hosted")
+	flags.Bool("demo", false, "whether the scheme is a demo scheme")
+	addTranslationFlag(schemeNewCmd, "name", "scheme name")
+	addTranslationFlag(schemeNewCmd, "description", "scheme description")
+	flags.String("contact", "", "contact URL of the scheme maintainer")
+	flags.String("timestamp-server", "", "URL of the timestamp server for attribute-based signatures")
+}
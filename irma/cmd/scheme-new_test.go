@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/privacybydesign/irmago/internal/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateDirectory(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	path := filepath.Join(storage, "schemes", "example")
+	created, err := createDirectory(path)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(storage, "schemes"), created)
+	exists, err := common.PathExists(path)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	created, err = createDirectory(path)
+	require.NoError(t, err)
+	require.Empty(t, created)
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// schemeServeCmd represents the scheme serve command
+var schemeServeCmd = &cobra.Command{
+	Use:   "serve <path>...",
+	Short: "Serve signed schemes over HTTP",
+	Long: `Serve signed schemes over HTTP
+
+The serve command serves the schemes in the specified paths, each of which must be a scheme or a
+directory containing schemes, for when the scheme URLs cannot be reached. The files of each scheme
+are served at /<scheme>/ in the same layout as at the scheme URL, so that IRMA apps and servers can
+install and update the scheme from there: the index, its signature, the timestamp, the scheme
+public key and all files in the index. The signatures of the schemes are verified before they are
+served, and ETag and If-Modified-Since are supported.
+
+In mirror mode, the schemes are periodically updated from their scheme URLs. Updates are verified
+in the same way as when IRMA apps and servers update their schemes, and served only after that
+succeeded. The schemes must then be writable, and contain the public key (pk.pem) of the scheme.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		mirror, _ := flags.GetBool("mirror")
+		interval, _ := flags.GetInt("update-interval")
+		port, _ := flags.GetInt("port")
+		listenAddr, _ := flags.GetString("listen-addr")
+		certfile, _ := flags.GetString("tls-cert-file")
+		keyfile, _ := flags.GetString("tls-privkey-file")
+		verbosity, _ := flags.GetCount("verbose")
+
+		logger.Level = server.Verbosity(verbosity)
+		irma.SetLogger(logger)
+
+		if (certfile == "") != (keyfile == "") {
+			die("", errors.New("specify both --tls-cert-file and --tls-privkey-file, or neither"))
+		}
+		if mirror && interval <= 0 {
+			die("", errors.New("--update-interval must be positive"))
+		}
+
+		s := &schemeFileServer{}
+		for _, p := range args {
+			conf, err := parseServedSchemes(p, mirror)
+			if err != nil {
+				die("Failed to parse schemes at "+p, err)
+			}
+			s.confs = append(s.confs, conf)
+		}
+		if err := s.reload(); err != nil {
+			die("Failed to read schemes", err)
+		}
+		if mirror {
+			go s.mirror(time.Duration(interval) * time.Minute)
+		}
+
+		addr := fmt.Sprintf("%s:%d", listenAddr, port)
+		logger.Info("Serving schemes at ", addr)
+		var err error
+		if certfile != "" {
+			err = http.ListenAndServeTLS(addr, certfile, keyfile, s)
+		} else {
+			err = http.ListenAndServe(addr, s)
+		}
+		die("Failed to serve schemes", err)
+	},
+}
+
+type (
+	// schemeFileServer serves the signed files of the schemes in its configurations.
+	schemeFileServer struct {
+		confs []*irma.Configuration
+
+		lock  sync.RWMutex
+		files map[string]*schemeFile // keyed by URL path
+	}
+
+	schemeFile struct {
+		bts      []byte
+		etag     string
+		modified time.Time
+	}
+)
+
+// parseServedSchemes parses the scheme in path, or the schemes in it if it is not a scheme itself.
+func parseServedSchemes(p string, mirror bool) (*irma.Configuration, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return nil, err
+	}
+	isScheme, err := common.IsScheme(p, true)
+	if err != nil {
+		return nil, err
+	}
+	opts := irma.ConfigurationOptions{ReadOnly: !mirror}
+	if !isScheme {
+		conf, err := irma.NewConfiguration(p, opts)
+		if err != nil {
+			return nil, err
+		}
+		return conf, conf.ParseFolder()
+	}
+	conf, err := irma.NewConfiguration(filepath.Dir(p), opts)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conf.ParseSchemeFolder(p)
+	return conf, err
+}
+
+// reload reads and verifies the files of all schemes, and serves them from then on.
+func (s *schemeFileServer) reload() error {
+	files := map[string]*schemeFile{}
+	for _, conf := range s.confs {
+		schemes, err := conf.SignedSchemeFiles()
+		if err != nil {
+			return err
+		}
+		for _, scheme := range schemes {
+			if _, ok := files["/"+scheme.ID+"/index"]; ok {
+				return errors.Errorf("scheme %s found more than once", scheme.ID)
+			}
+			for name, bts := range scheme.Files {
+				hash := sha256.Sum256(bts)
+				files[path.Join("/", scheme.ID, name)] = &schemeFile{
+					bts:      bts,
+					etag:     `"` + hex.EncodeToString(hash[:]) + `"`,
+					modified: time.Time(scheme.Timestamp),
+				}
+			}
+			logger.WithFields(logrus.Fields{"scheme": scheme.ID, "timestamp": scheme.Timestamp.String()}).Info("Serving scheme")
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.files = files
+	return nil
+}
+
+// mirror periodically updates the schemes from their scheme URLs, and serves them once verified.
+func (s *schemeFileServer) mirror(interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, conf := range s.confs {
+			if err := conf.UpdateSchemes(); err != nil {
+				logger.Error("Failed to update schemes: ", err)
+			}
+		}
+		if err := s.reload(); err != nil {
+			logger.Error("Failed to read updated schemes, serving previous versions: ", err)
+		}
+	}
+}
+
+func (s *schemeFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.lock.RLock()
+	file := s.files[path.Clean(r.URL.Path)]
+	s.lock.RUnlock()
+	if file == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("ETag", file.etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, path.Base(r.URL.Path), file.modified, bytes.NewReader(file.bts))
+}
+
+func init() {
+	schemeCmd.AddCommand(schemeServeCmd)
+
+	flags := schemeServeCmd.Flags()
+	flags.SortFlags = false
+	flags.IntP("port", "p", 8080, "port at which to listen")
+	flags.StringP("listen-addr", "l", "", "address at which to listen (default 0.0.0.0)")
+	flags.String("tls-cert-file", "", "path to TLS certificate (chain)")
+	flags.String("tls-privkey-file", "", "path to TLS private key")
+	flags.Bool("mirror", false, "periodically update the schemes from their scheme URLs")
+	flags.Int("update-interval", 60, "interval in minutes at which to update the schemes in mirror mode")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+}
@@ -3,8 +3,11 @@ package cmd
 import (
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync"
 	"syscall"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/go-errors/errors"
 	irma "github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/server"
@@ -33,6 +36,21 @@ var serverCmd = &cobra.Command{
 		stopped := make(chan struct{})
 		interrupt := make(chan os.Signal, 1)
 		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		// Reloads are triggered by SIGHUP and by the configuration watcher, but are all performed
+		// by the loop below, as viper may not be used concurrently
+		reload := make(chan string, 1)
+		hangup := make(chan os.Signal, 1)
+		signal.Notify(hangup, syscall.SIGHUP)
+		go func() {
+			for range hangup {
+				triggerReload(reload, "Caught SIGHUP")
+			}
+		}()
+		var watcher *configWatcher
+		if viper.GetBool("watch_config") {
+			watcher = watchConfig(reload)
+			watcher.watch(configFiles())
+		}
 
 		go func() {
 			if err := serv.Start(conf); err != nil {
@@ -48,10 +66,21 @@ var serverCmd = &cobra.Command{
 				conf.Logger.Debug("Caught interrupt")
 				serv.Stop() // causes serv.Start() above to return
 				conf.Logger.Debug("Sent stop signal to server")
+			case reason := <-reload:
+				conf.Logger.Info(reason, ", reloading requestors")
+				_ = reloadRequestors(serv)
+				if watcher != nil {
+					watcher.watch(configFiles())
+				}
 			case <-stopped:
 				conf.Logger.Info("Exiting")
 				close(stopped)
 				close(interrupt)
+				signal.Stop(hangup)
+				close(hangup)
+				if watcher != nil {
+					watcher.close()
+				}
 				return
 			}
 		}
@@ -91,9 +120,15 @@ func setFlags(cmd *cobra.Command, production bool) error {
 	flags.String("static-path", "", "Host files under this path as static files (leave empty to disable)")
 	flags.String("static-prefix", "/", "Host static files under this URL prefix")
 	flags.StringP("url", "u", defaulturl, "external URL to server to which the IRMA client connects, \":port\" being replaced by --port value")
-	flags.String("revocation-db-type", "", "database type for revocation database (supported: mysql, postgres)")
+	flags.String("revocation-db-type", "", "database type for revocation database (supported: mysql, postgres, sqlite)")
 	flags.String("revocation-db-str", "", "connection string for revocation database")
 	flags.Bool("sse", false, "Enable server sent for status updates (experimental)")
+	flags.Bool("metrics", false, "Expose Prometheus metrics at /metrics")
+	flags.String("audit-log-file", "", "file to which an audit log of requestor activity is appended")
+	flags.String("audit-log-db-type", "", "database type for audit log database (supported: mysql, postgres)")
+	flags.String("audit-log-db-str", "", "connection string for audit log database")
+	flags.String("audit-log-key", "", "key with which audit log events are authenticated (required when using an audit log)")
+	flags.String("audit-log-key-file", "", "path to key with which audit log events are authenticated")
 
 	headers["port"] = "Server address and port to listen on"
 	flags.IntP("port", "p", 8088, "port at which to listen")
@@ -114,13 +149,18 @@ func setFlags(cmd *cobra.Command, production bool) error {
 	flags.StringSlice("issue-perms", nil, issHelp)
 	flags.StringSlice("revoke-perms", nil, "list of credentials that all requestors may revoke")
 	flags.Bool("skip-private-keys-check", false, "whether or not to skip checking whether the private keys that requestors have permission for using are present in the configuration")
+	flags.Bool("watch-config", false, "reload requestors and permissions when the configuration file, a requestor key_file or the oauth2_jwks_file changes (they are always reloaded on SIGHUP; TLS certificates and mtls_client_ca_file require a restart)")
 	flags.String("static-sessions", "", "preconfigured static sessions (in JSON)")
+	flags.String("admin-token", "", "token that grants access to the admin API at /admin in the Authorization header (leave empty to disable)")
 	flags.Int("max-session-lifetime", 5, "maximum duration of a session once a client connects in minutes")
 
 	flags.String("revocation-settings", "", "revocation settings (in JSON)")
+	flags.Uint64("revocation-max-age", 0, "maximum age in seconds of accumulators before /health reports unhealthy (default: tolerance of the credential type)")
+	flags.Int("self-revocation-max-concurrent-sessions", 100, "maximum amount of live self-revocation sessions (-1 for no limit)")
+	flags.Int("self-revocation-max-sessions-per-ip", 10, "maximum amount of self-revocation sessions per minute per client IP address (-1 for no limit)")
 
 	headers["store-type"] = "Session store configuration"
-	flags.String("store-type", "", "specifies how session state will be saved on the server (default \"memory\")")
+	flags.String("store-type", "", "specifies how session state will be saved on the server (supported: memory, redis, postgres, mysql) (default \"memory\")")
 	flags.String("redis-addr", "", "Redis address, to be specified as host:port")
 	flags.String("redis-pw", "", "Redis server password")
 	flags.Bool("redis-allow-empty-password", false, "explicitly allow an empty string as Redis password")
@@ -128,15 +168,27 @@ func setFlags(cmd *cobra.Command, production bool) error {
 	flags.String("redis-tls-cert", "", "use Redis TLS with specific certificate or certificate authority")
 	flags.String("redis-tls-cert-file", "", "use Redis TLS path to specific certificate or certificate authority")
 	flags.Bool("redis-no-tls", false, "disable Redis TLS (by default, Redis TLS is enabled with the system certificate pool)")
+	flags.String("store-db-str", "", "connection string for the session database (when --store-type is postgres or mysql)")
 
 	headers["jwt-issuer"] = "JWT configuration"
 	flags.StringP("jwt-issuer", "j", "irmaserver", "JWT issuer")
 	flags.String("jwt-privkey", "", "JWT private key")
 	flags.String("jwt-privkey-file", "", "path to JWT private key")
 	flags.Int("max-request-age", 300, "max age in seconds of a session request JWT")
+	flags.String("oauth2-jwks-file", "", "path to JWKS containing the keys of OAuth2 access tokens of requestors using the oauth2 authentication method")
+	flags.String("oauth2-jwks-url", "", "URL of JWKS containing the keys of OAuth2 access tokens of requestors using the oauth2 authentication method")
+	flags.String("oauth2-issuer", "", "required issuer (iss) of OAuth2 access tokens")
+	flags.String("oauth2-audience", "", "required audience (aud) of OAuth2 access tokens")
+	flags.String("oauth2-requestor-claim", "sub", "claim of OAuth2 access tokens containing the requestor name")
 	flags.Bool("allow-unsigned-callbacks", false, "Allow callbackUrl in session requests when no JWT privatekey is installed (potentially unsafe)")
 	flags.Bool("augment-client-return-url", false, "Augment the client return url with the server session token if present")
 
+	headers["callback-hmac-key"] = "Session result callbacks"
+	flags.String("callback-hmac-key", "", "key with which session results POSTed to callback URLs are signed in the X-IRMA-Signature header")
+	flags.String("callback-hmac-key-file", "", "path to key with which session results POSTed to callback URLs are signed")
+	flags.Int("callback-max-attempts", 10, "maximum number of attempts to POST a session result to its callback URL")
+	flags.Int("callback-retention", 168, "hours after which callback deliveries, which contain disclosed attribute values, are deleted even if they failed")
+
 	headers["tls-cert"] = "TLS configuration (leave empty to disable TLS)"
 	flags.String("tls-cert", "", "TLS certificate (chain)")
 	flags.String("tls-cert-file", "", "path to TLS certificate (chain)")
@@ -147,6 +199,9 @@ func setFlags(cmd *cobra.Command, production bool) error {
 	flags.String("client-tls-privkey", "", "TLS private key for IRMA app server")
 	flags.String("client-tls-privkey-file", "", "path to TLS private key for IRMA app server")
 	flags.Bool("no-tls", false, "disable TLS")
+	flags.String("mtls-client-ca-file", "", "path to certificate authorities against which TLS client certificates of requestors using the mtls authentication method are verified")
+	flags.String("mtls-proxy-header", "", "header in which a TLS-terminating proxy passes the URL-encoded PEM client certificate of requestors")
+	flags.StringSlice("mtls-trusted-proxies", nil, "IP addresses or CIDR ranges of proxies from which mtls-proxy-header is accepted")
 
 	headers["email"] = "Email address (see README for more info)"
 	flags.StringP("email", "e", "", "Email address of server admin, for incidental notifications such as breaking API changes")
@@ -176,13 +231,8 @@ func configureServer(cmd *cobra.Command) (*requestorserver.Configuration, error)
 
 	// Read configuration from flags and/or environmental variables
 	conf := &requestorserver.Configuration{
-		Configuration: configureIRMAServer(),
-		Permissions: requestorserver.Permissions{
-			Disclosing: handlePermission("disclose_perms"),
-			Signing:    handlePermission("sign_perms"),
-			Issuing:    handlePermission("issue_perms"),
-			Revoking:   handlePermission("revoke_perms"),
-		},
+		Configuration:                  configureIRMAServer(),
+		Permissions:                    configurePermissions(),
 		SkipPrivateKeysCheck:           viper.GetBool("skip_private_keys_check"),
 		ListenAddress:                  viper.GetString("listen_addr"),
 		Port:                           viper.GetInt("port"),
@@ -192,8 +242,17 @@ func configureServer(cmd *cobra.Command) (*requestorserver.Configuration, error)
 		DisableRequestorAuthentication: viper.GetBool("no_auth"),
 		Requestors:                     make(map[string]requestorserver.Requestor),
 		MaxRequestAge:                  viper.GetInt("max_request_age"),
+		OAuth2JwksFile:                 viper.GetString("oauth2_jwks_file"),
+		OAuth2JwksURL:                  viper.GetString("oauth2_jwks_url"),
+		OAuth2Issuer:                   viper.GetString("oauth2_issuer"),
+		OAuth2Audience:                 viper.GetString("oauth2_audience"),
+		OAuth2RequestorClaim:           viper.GetString("oauth2_requestor_claim"),
+		MTLSClientCAFile:               viper.GetString("mtls_client_ca_file"),
+		MTLSProxyHeader:                viper.GetString("mtls_proxy_header"),
+		MTLSTrustedProxies:             viper.GetStringSlice("mtls_trusted_proxies"),
 		StaticPath:                     viper.GetString("static_path"),
 		StaticPrefix:                   viper.GetString("static_prefix"),
+		AdminToken:                     viper.GetString("admin_token"),
 
 		TlsCertificate:           viper.GetString("tls_cert"),
 		TlsCertificateFile:       viper.GetString("tls_cert_file"),
@@ -248,7 +307,158 @@ func configureServer(cmd *cobra.Command) (*requestorserver.Configuration, error)
 		conf.RedisSettings.DisableTLS = viper.GetBool("redis_no_tls")
 	}
 
+	// Parse SQL store configuration
+	if (conf.StoreType == "postgres" || conf.StoreType == "mysql") && conf.StoreDBConnStr == "" {
+		return nil, errors.New("When a SQL database is used as session data store, a connection string must be specified with the --store-db-str flag.")
+	}
+
 	logger.Debug("Done configuring")
 
 	return conf, nil
 }
+
+func configurePermissions() requestorserver.Permissions {
+	return requestorserver.Permissions{
+		Disclosing: handlePermission("disclose_perms"),
+		Signing:    handlePermission("sign_perms"),
+		Issuing:    handlePermission("issue_perms"),
+		Revoking:   handlePermission("revoke_perms"),
+	}
+}
+
+// reloadRequestors reads the requestors and global permissions from the configuration and puts
+// them into use in the server, along with the authentication keys of the requestors. The
+// configuration file (if any) is reread first. If the new configuration is invalid, the server
+// keeps using its current configuration.
+func reloadRequestors(serv *requestorserver.Server) error {
+	if viper.ConfigFileUsed() != "" {
+		if err := viper.ReadInConfig(); err != nil {
+			return server.LogError(errors.WrapPrefix(err, "Failed to reread configuration file", 0))
+		}
+	}
+	requestors := make(map[string]requestorserver.Requestor)
+	if err := handleMapOrString("requestors", &requestors); err != nil {
+		return server.LogError(err)
+	}
+	return serv.ReloadRequestors(requestors, configurePermissions())
+}
+
+// triggerReload asks for the requestors to be reloaded, unless a reload is already pending.
+func triggerReload(reload chan<- string, reason string) {
+	select {
+	case reload <- reason:
+	default:
+	}
+}
+
+// configFiles returns the files from which the requestors are loaded: the configuration file,
+// the key files of the requestors, and the OAuth2 JWKS file.
+func configFiles() []string {
+	files := []string{viper.ConfigFileUsed(), viper.GetString("oauth2_jwks_file")}
+	requestors := make(map[string]requestorserver.Requestor)
+	if err := handleMapOrString("requestors", &requestors); err == nil {
+		for _, r := range requestors {
+			files = append(files, r.AuthenticationKeyFile)
+		}
+	}
+	return files
+}
+
+// configWatcher triggers a reload when one of the watched files changes. The directories
+// containing the files are watched instead of the files themselves, so that files that are
+// replaced (e.g. by renaming a new file over them) remain watched.
+type configWatcher struct {
+	sync.Mutex
+	watcher *fsnotify.Watcher
+	files   map[string]struct{}
+	dirs    map[string]struct{}
+}
+
+// watchConfig returns a configWatcher that sends to the specified channel when one of its files
+// changes, or nil if no configuration file is used.
+func watchConfig(reload chan<- string) *configWatcher {
+	if viper.ConfigFileUsed() == "" {
+		logger.Warn("--watch-config specified but no configuration file is used, ignoring")
+		return nil
+	}
+	w, err := newConfigWatcher(reload)
+	if err != nil {
+		_ = server.LogError(errors.WrapPrefix(err, "Failed to watch configuration file", 0))
+		return nil
+	}
+	logger.Info("Watching configuration file ", viper.ConfigFileUsed(), " for changes")
+	return w
+}
+
+func newConfigWatcher(reload chan<- string) (*configWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &configWatcher{
+		watcher: watcher,
+		files:   map[string]struct{}{},
+		dirs:    map[string]struct{}{},
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op != fsnotify.Chmod && w.watching(event.Name) {
+					triggerReload(reload, "File "+event.Name+" changed")
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				_ = server.LogWarning(errors.WrapPrefix(err, "Error watching configuration files", 0))
+			}
+		}
+	}()
+	return w, nil
+}
+
+// watch replaces the set of watched files with the specified files. Empty paths are ignored.
+func (w *configWatcher) watch(files []string) {
+	w.Lock()
+	defer w.Unlock()
+	w.files = map[string]struct{}{}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		path, err := filepath.Abs(file)
+		if err != nil {
+			_ = server.LogWarning(errors.WrapPrefix(err, "Cannot watch "+file, 0))
+			continue
+		}
+		w.files[path] = struct{}{}
+		dir := filepath.Dir(path)
+		if _, ok := w.dirs[dir]; ok {
+			continue
+		}
+		if err = w.watcher.Add(dir); err != nil {
+			_ = server.LogWarning(errors.WrapPrefix(err, "Cannot watch "+file, 0))
+			continue
+		}
+		w.dirs[dir] = struct{}{}
+	}
+}
+
+func (w *configWatcher) watching(file string) bool {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	w.Lock()
+	defer w.Unlock()
+	_, ok := w.files[path]
+	return ok
+}
+
+func (w *configWatcher) close() {
+	_ = w.watcher.Close()
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/privacybydesign/irmago/internal/test"
+	"github.com/stretchr/testify/require"
+)
+
+func requireReload(t *testing.T, reload <-chan string, expected bool) {
+	select {
+	case <-reload:
+		require.True(t, expected, "unexpected reload")
+		// a single change may cause several events; drain the reloads these trigger
+		for {
+			select {
+			case <-reload:
+			case <-time.After(100 * time.Millisecond):
+				return
+			}
+		}
+	case <-time.After(500 * time.Millisecond):
+		require.False(t, expected, "no reload")
+	}
+}
+
+func TestConfigWatcher(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	confFile := filepath.Join(storage, "irmaserver.yml")
+	keyFile := filepath.Join(storage, "keys", "requestor.pem")
+	other := filepath.Join(storage, "other")
+	require.NoError(t, os.MkdirAll(filepath.Dir(keyFile), 0700))
+	for _, file := range []string{confFile, keyFile, other} {
+		require.NoError(t, ioutil.WriteFile(file, []byte("old"), 0600))
+	}
+
+	reload := make(chan string, 1)
+	w, err := newConfigWatcher(reload)
+	require.NoError(t, err)
+	defer w.close()
+	w.watch([]string{confFile, keyFile, ""})
+
+	require.NoError(t, ioutil.WriteFile(other, []byte("new"), 0600))
+	requireReload(t, reload, false)
+
+	require.NoError(t, ioutil.WriteFile(confFile, []byte("new"), 0600))
+	requireReload(t, reload, true)
+
+	// Files replaced by renaming a new file over them remain watched
+	tmp := filepath.Join(filepath.Dir(keyFile), "tmp")
+	require.NoError(t, ioutil.WriteFile(tmp, []byte("new"), 0600))
+	requireReload(t, reload, false)
+	require.NoError(t, os.Rename(tmp, keyFile))
+	requireReload(t, reload, true)
+
+	// Files no longer referenced by the configuration are not watched anymore
+	w.watch([]string{confFile})
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("newer"), 0600))
+	requireReload(t, reload, false)
+}
@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"regexp"
@@ -68,6 +70,17 @@ irma session --server http://localhost:8088 --authmethod token --key mytoken --d
 			die("Failed to read configuration", errors.New("--url can't be combined with --server"))
 		}
 
+		snapshots, _ := flags.GetStringArray("revocation-snapshot")
+		if len(snapshots) > 0 && serverurl != "" {
+			die("Failed to read configuration", errors.New("--revocation-snapshot can't be combined with --server"))
+		}
+		maxAge, _ := flags.GetUint64("revocation-snapshot-max-age")
+		for _, path := range snapshots {
+			if err = loadAccumulatorSnapshot(irmaconfig, path, maxAge); err != nil {
+				die("Failed to load accumulator snapshot", err)
+			}
+		}
+
 		if serverurl == "" {
 			port, _ := flags.GetInt("port")
 			privatekeysPath, _ := flags.GetString("privkeys")
@@ -341,6 +354,20 @@ func configureSessionServer(url string, port int, privatekeysPath string, irmaco
 	return err
 }
 
+// loadAccumulatorSnapshot reads an accumulator snapshot written by "irma revocation snapshot", to
+// verify nonrevocation proofs against it instead of fetching updates from the revocation server.
+func loadAccumulatorSnapshot(irmaconfig *irma.Configuration, path string, maxAge uint64) error {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var snapshot irma.AccumulatorSnapshot
+	if err = json.Unmarshal(bts, &snapshot); err != nil {
+		return err
+	}
+	return irmaconfig.Revocation.LoadAccumulatorSnapshot(&snapshot, maxAge)
+}
+
 func configureSession(cmd *cobra.Command) (irma.RequestorRequest, *irma.Configuration, error) {
 	verbosity, _ := cmd.Flags().GetCount("verbose")
 	logger.Level = server.Verbosity(verbosity)
@@ -372,6 +399,8 @@ func init() {
 	flags.StringP("request", "r", "", "JSON session request")
 	flags.StringP("privkeys", "k", "", "path to private keys")
 	flags.Bool("disable-schemes-update", false, "disable scheme updates")
+	flags.StringArray("revocation-snapshot", nil, "accumulator snapshot to verify nonrevocation proofs against, instead of fetching revocation updates (repeatable)")
+	flags.Uint64("revocation-snapshot-max-age", 0, "maximum age in seconds of accumulator snapshots (default: unlimited)")
 
 	addRequestFlags(flags)
 
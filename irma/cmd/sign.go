@@ -3,9 +3,14 @@ package cmd
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/asn1"
+	"io"
 	"io/ioutil"
+	"math/big"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -25,20 +30,43 @@ var signCmd = &cobra.Command{
 	Short: "Sign a scheme directory",
 	Long: `Sign a scheme directory, using the specified ECDSA key. Both arguments are optional; "sk.pem" and the working directory are the defaults. Outputs an index file, signature over the index file, and the public key in the specified directory.
 
+Instead of from a PEM file, the private key can be used from a PKCS#11 token such as an HSM, by
+specifying the PKCS#11 module with --pkcs11-module and the token and key labels with --pkcs11-token
+and --pkcs11-key. The PIN of the token is read from the IRMA_PKCS11_PIN environment variable.
+Alternatively, the index can be signed by an external command specified with --sign-command, which
+receives the SHA256 hash of the index on its standard input, and must write the ASN.1 DER encoded
+ECDSA signature over it to its standard output; for example "openssl pkeyutl -sign -inkey sk.pem".
+The public key of the command must then be specified with --public-key. In both cases the only
+(optional) argument is the path to the scheme directory.
+
+The signature is verified against the public key before it is written.
+
 Careful: this command could fail and invalidate or destroy your scheme directory! Use this only if you can restore it from git or backups.`,
 	Args: cobra.MaximumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		module, _ := flags.GetString("pkcs11-module")
+		command, _ := flags.GetString("sign-command")
+		if module != "" && command != "" {
+			return errors.New("specify either --pkcs11-module or --sign-command, not both")
+		}
+		external := module != "" || command != ""
+
 		// Validate arguments
 		var err error
 		var sk, confpath string
-		switch len(args) {
-		case 0:
+		switch {
+		case external && len(args) > 1:
+			return errors.New("only a path may be specified when using --pkcs11-module or --sign-command")
+		case external && len(args) == 1:
+			confpath, err = filepath.Abs(args[0])
+		case len(args) == 0:
 			sk = "sk.pem"
 			confpath, err = os.Getwd()
-		case 1:
+		case len(args) == 1:
 			sk = args[0]
 			confpath, err = os.Getwd()
-		case 2:
+		case len(args) == 2:
 			sk = args[0]
 			confpath, err = filepath.Abs(args[1])
 		}
@@ -46,20 +74,34 @@ Careful: this command could fail and invalidate or destroy your scheme directory
 			return errors.WrapPrefix(err, "Invalid path", 0)
 		}
 
-		privatekey, err := readPrivateKey(sk)
-		if err != nil {
-			return errors.WrapPrefix(err, "Failed to read private key:", 0)
-		}
-
 		if err = common.AssertPathExists(confpath); err != nil {
 			return err
 		}
 
-		skipverification, err := cmd.Flags().GetBool("noverification")
+		var signer schemeSigner
+		switch {
+		case module != "":
+			token, _ := flags.GetString("pkcs11-token")
+			key, _ := flags.GetString("pkcs11-key")
+			signer, err = newPKCS11Signer(module, token, key, os.Getenv("IRMA_PKCS11_PIN"))
+		case command != "":
+			pk, _ := flags.GetString("public-key")
+			signer, err = newCommandSigner(command, pk)
+		default:
+			signer, err = newPemSigner(sk)
+		}
+		if err != nil {
+			return errors.WrapPrefix(err, "Failed to load private key", 0)
+		}
+		if closer, ok := signer.(io.Closer); ok {
+			defer common.Close(closer)
+		}
+
+		skipverification, err := flags.GetBool("noverification")
 		if err != nil {
 			return err
 		}
-		if err := signScheme(privatekey, confpath, skipverification); err != nil {
+		if err := signScheme(signer, confpath, skipverification); err != nil {
 			die("Failed to sign scheme", err)
 		}
 		return nil
@@ -69,10 +111,21 @@ Careful: this command could fail and invalidate or destroy your scheme directory
 func init() {
 	schemeCmd.AddCommand(signCmd)
 
-	signCmd.Flags().BoolP("noverification", "n", false, "Skip verification of the scheme after signing it")
+	flags := signCmd.Flags()
+	flags.BoolP("noverification", "n", false, "Skip verification of the scheme after signing it")
+	flags.String("pkcs11-module", "", "path to PKCS#11 module with which to sign, e.g. /usr/lib/softhsm/libsofthsm2.so")
+	flags.String("pkcs11-token", "", "label of the PKCS#11 token containing the private key")
+	flags.String("pkcs11-key", "", "label of the private key (and its public key) in the PKCS#11 token")
+	flags.String("sign-command", "", "command that signs the SHA256 hash on its stdin, writing a DER signature to its stdout")
+	flags.String("public-key", "", "PEM file containing the public key of --sign-command")
 }
 
-func signScheme(privatekey *ecdsa.PrivateKey, path string, skipverification bool) error {
+func signScheme(signer schemeSigner, path string, skipverification bool) error {
+	pk, err := signer.PublicKey()
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to get public key", 0)
+	}
+
 	filename, err := common.SchemeFilename(path)
 	if err != nil {
 		return err
@@ -86,13 +139,7 @@ func signScheme(privatekey *ecdsa.PrivateKey, path string, skipverification bool
 		return err
 	}
 
-	// Write timestamp
-	bts = []byte(strconv.FormatInt(time.Now().Unix(), 10) + "\n")
-	if err := ioutil.WriteFile(filepath.Join(path, "timestamp"), bts, 0644); err != nil {
-		return errors.WrapPrefix(err, "Failed to write timestamp", 0)
-	}
-
-	// Traverse dir and add file hashes to index
+	// Traverse dir and add file hashes to index, including that of the new timestamp
 	var index irma.SchemeManagerIndex = make(map[string]irma.SchemeFileHash)
 	err = common.WalkDir(path, func(p string, info os.FileInfo) error {
 		return calculateFileHash(id, path, p, info, index, irma.SchemeType(typ))
@@ -100,24 +147,35 @@ func signScheme(privatekey *ecdsa.PrivateKey, path string, skipverification bool
 	if err != nil {
 		return errors.WrapPrefix(err, "Failed to calculate file index", 0)
 	}
+	timestamp := []byte(strconv.FormatInt(time.Now().Unix(), 10) + "\n")
+	timestampHash := sha256.Sum256(timestamp)
+	index[id+"/timestamp"] = timestampHash[:]
 
-	// Write index
+	// Create and check signature before writing anything, so that the scheme is left untouched
+	// if signing fails
 	bts = []byte(index.String())
-	if err := ioutil.WriteFile(filepath.Join(path, "index"), bts, 0644); err != nil {
-		return errors.WrapPrefix(err, "Failed to write index", 0)
+	hash := sha256.Sum256(bts)
+	sigbytes, err := signer.Sign(hash[:])
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to sign index", 0)
+	}
+	if err = signed.Verify(pk, bts, sigbytes); err != nil {
+		return errors.WrapPrefix(err, "Signature does not verify against public key", 0)
 	}
 
-	// Create and write signature
-	sigbytes, err := signed.Sign(privatekey, bts)
-	if err != nil {
-		return errors.WrapPrefix(err, "Failed to serialize signature:", 0)
+	// Write timestamp, index and signature
+	if err = ioutil.WriteFile(filepath.Join(path, "timestamp"), timestamp, 0644); err != nil {
+		return errors.WrapPrefix(err, "Failed to write timestamp", 0)
+	}
+	if err = ioutil.WriteFile(filepath.Join(path, "index"), bts, 0644); err != nil {
+		return errors.WrapPrefix(err, "Failed to write index", 0)
 	}
 	if err = ioutil.WriteFile(filepath.Join(path, "index.sig"), sigbytes, 0644); err != nil {
 		return errors.WrapPrefix(err, "Failed to write index.sig", 0)
 	}
 
 	// Write public key
-	pemEncodedPub, err := signed.MarshalPemPublicKey(&privatekey.PublicKey)
+	pemEncodedPub, err := signed.MarshalPemPublicKey(pk)
 	if err != nil {
 		return errors.WrapPrefix(err, "Failed to serialize public key", 0)
 	}
@@ -136,6 +194,80 @@ func signScheme(privatekey *ecdsa.PrivateKey, path string, skipverification bool
 	return nil
 }
 
+// schemeSigner signs the index of a scheme using an ECDSA private key.
+type schemeSigner interface {
+	// PublicKey returns the public key corresponding to the private key.
+	PublicKey() (*ecdsa.PublicKey, error)
+	// Sign returns the ASN.1 DER encoded ECDSA signature over the specified SHA256 hash.
+	Sign(hash []byte) ([]byte, error)
+}
+
+// pemSigner signs using a private key from a PEM file.
+type pemSigner struct {
+	sk *ecdsa.PrivateKey
+}
+
+// commandSigner signs by running an external command, which receives the hash on stdin and writes
+// the signature to stdout.
+type commandSigner struct {
+	command []string
+	pk      *ecdsa.PublicKey
+}
+
+func newPemSigner(path string) (*pemSigner, error) {
+	sk, err := readPrivateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return &pemSigner{sk: sk}, nil
+}
+
+func (s *pemSigner) PublicKey() (*ecdsa.PublicKey, error) {
+	return &s.sk.PublicKey, nil
+}
+
+func (s *pemSigner) Sign(hash []byte) ([]byte, error) {
+	r, ss, err := ecdsa.Sign(rand.Reader, s.sk, hash)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal([]*big.Int{r, ss})
+}
+
+func newCommandSigner(command, pkpath string) (*commandSigner, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("empty signing command")
+	}
+	if pkpath == "" {
+		return nil, errors.New("--public-key is required with --sign-command")
+	}
+	bts, err := ioutil.ReadFile(pkpath)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := signed.UnmarshalPemPublicKey(bts)
+	if err != nil {
+		return nil, err
+	}
+	return &commandSigner{command: args, pk: pk}, nil
+}
+
+func (s *commandSigner) PublicKey() (*ecdsa.PublicKey, error) {
+	return s.pk, nil
+}
+
+func (s *commandSigner) Sign(hash []byte) ([]byte, error) {
+	cmd := exec.Command(s.command[0], s.command[1:]...)
+	cmd.Stdin = bytes.NewReader(hash)
+	cmd.Stderr = os.Stderr
+	sig, err := cmd.Output()
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "Signing command failed", 0)
+	}
+	return sig, nil
+}
+
 func readPrivateKey(path string) (*ecdsa.PrivateKey, error) {
 	bts, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -0,0 +1,174 @@
+//go:build cgo
+// +build cgo
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/miekg/pkcs11"
+)
+
+// pkcs11Signer signs using an ECDSA private key in a PKCS#11 token, such as an HSM.
+type pkcs11Signer struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	pk      *ecdsa.PublicKey
+}
+
+var pkcs11Curves = map[string]elliptic.Curve{
+	"1.2.840.10045.3.1.7": elliptic.P256(),
+	"1.3.132.0.34":        elliptic.P384(),
+	"1.3.132.0.35":        elliptic.P521(),
+}
+
+// newPKCS11Signer loads the PKCS#11 module, logs in to the token with the specified label, and
+// looks up the private and public key with the specified label. The signer must be closed after use.
+func newPKCS11Signer(module, token, label, pin string) (schemeSigner, error) {
+	if token == "" || label == "" {
+		return nil, errors.New("--pkcs11-token and --pkcs11-key are required with --pkcs11-module")
+	}
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, errors.Errorf("failed to load PKCS#11 module %s", module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, errors.WrapPrefix(err, "failed to initialize PKCS#11 module", 0)
+	}
+
+	s := &pkcs11Signer{ctx: ctx}
+	if err := s.open(token, label, pin); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *pkcs11Signer) open(token, label, pin string) error {
+	slots, err := s.ctx.GetSlotList(true)
+	if err != nil {
+		return err
+	}
+	slot, found := uint(0), false
+	for _, id := range slots {
+		info, err := s.ctx.GetTokenInfo(id)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(info.Label) == token {
+			slot, found = id, true
+			break
+		}
+	}
+	if !found {
+		return errors.Errorf("PKCS#11 token %s not found", token)
+	}
+
+	if s.session, err = s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION); err != nil {
+		return err
+	}
+	if err = s.ctx.Login(s.session, pkcs11.CKU_USER, pin); err != nil {
+		return errors.WrapPrefix(err, "failed to log in to PKCS#11 token", 0)
+	}
+	if s.key, err = s.findObject(pkcs11.CKO_PRIVATE_KEY, label); err != nil {
+		return err
+	}
+	pubkey, err := s.findObject(pkcs11.CKO_PUBLIC_KEY, label)
+	if err != nil {
+		return err
+	}
+	s.pk, err = s.readPublicKey(pubkey)
+	return err
+}
+
+func (s *pkcs11Signer) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, err
+	}
+	objects, _, err := s.ctx.FindObjects(s.session, 2)
+	if ferr := s.ctx.FindObjectsFinal(s.session); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(objects) != 1 {
+		return 0, errors.Errorf("found %d EC keys with label %s in PKCS#11 token, expected 1", len(objects), label)
+	}
+	return objects[0], nil
+}
+
+func (s *pkcs11Signer) readPublicKey(object pkcs11.ObjectHandle) (*ecdsa.PublicKey, error) {
+	attrs, err := s.ctx.GetAttributeValue(s.session, object, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var oid asn1.ObjectIdentifier
+	if _, err = asn1.Unmarshal(attrs[0].Value, &oid); err != nil {
+		return nil, errors.WrapPrefix(err, "failed to parse curve of public key", 0)
+	}
+	curve, ok := pkcs11Curves[oid.String()]
+	if !ok {
+		return nil, errors.Errorf("unsupported curve %s", oid.String())
+	}
+	// The point should be DER encoded as an octet string, but some modules return it as is
+	point := attrs[1].Value
+	var unwrapped []byte
+	if rest, err := asn1.Unmarshal(point, &unwrapped); err == nil && len(rest) == 0 {
+		point = unwrapped
+	}
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		return nil, errors.New("failed to parse public key")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func (s *pkcs11Signer) PublicKey() (*ecdsa.PublicKey, error) {
+	return s.pk, nil
+}
+
+func (s *pkcs11Signer) Sign(hash []byte) ([]byte, error) {
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	if err := s.ctx.SignInit(s.session, mechanism, s.key); err != nil {
+		return nil, err
+	}
+	sig, err := s.ctx.Sign(s.session, hash)
+	if err != nil {
+		return nil, err
+	}
+	// PKCS#11 returns r and s concatenated, while the scheme signature must be DER encoded
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, errors.New("PKCS#11 token returned invalid signature")
+	}
+	r := new(big.Int).SetBytes(sig[:len(sig)/2])
+	ss := new(big.Int).SetBytes(sig[len(sig)/2:])
+	return asn1.Marshal([]*big.Int{r, ss})
+}
+
+// Close logs out of the token and unloads the PKCS#11 module.
+func (s *pkcs11Signer) Close() error {
+	if s.session != 0 {
+		_ = s.ctx.Logout(s.session)
+		_ = s.ctx.CloseSession(s.session)
+	}
+	err := s.ctx.Finalize()
+	s.ctx.Destroy()
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !cgo
+// +build !cgo
+
+package cmd
+
+import (
+	"github.com/go-errors/errors"
+)
+
+// newPKCS11Signer is unavailable as loading PKCS#11 modules requires cgo.
+func newPKCS11Signer(string, string, string, string) (schemeSigner, error) {
+	return nil, errors.New("PKCS#11 is not supported by this build, which was built without cgo")
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/privacybydesign/gabi/signed"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/privacybydesign/irmago/internal/test"
+	"github.com/stretchr/testify/require"
+)
+
+// signHelperKeyEnv is the environment variable that, when set to the path of a private key,
+// makes the test binary act as a signing command for commandSigner.
+const signHelperKeyEnv = "IRMA_TEST_SIGN_HELPER_KEY"
+
+func TestMain(m *testing.M) {
+	if sk := os.Getenv(signHelperKeyEnv); sk != "" {
+		os.Exit(signHelper(sk))
+	}
+	os.Exit(m.Run())
+}
+
+// signHelper signs the hash on stdin using the private key at sk, writing the signature to stdout.
+func signHelper(sk string) int {
+	signer, err := newPemSigner(sk)
+	if err != nil {
+		return 1
+	}
+	hash, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return 1
+	}
+	sig, err := signer.Sign(hash)
+	if err != nil {
+		return 1
+	}
+	if _, err = os.Stdout.Write(sig); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// setupSignTest copies the test-requestors scheme to test storage, returning the storage
+// directory, the path to the copied scheme, and the paths to its private and public key.
+func setupSignTest(t *testing.T) (storage, scheme, sk, pk string) {
+	storage = test.CreateTestStorage(t)
+	scheme = filepath.Join(storage, "irma_configuration", "test-requestors")
+	require.NoError(t, common.CopyDirectory(
+		filepath.Join(test.FindTestdataFolder(t), "irma_configuration", "test-requestors"),
+		scheme,
+	))
+	return storage, scheme, filepath.Join(scheme, "sk.pem"), filepath.Join(scheme, "pk.pem")
+}
+
+func readFile(t *testing.T, path string) []byte {
+	bts, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return bts
+}
+
+func testSigner(t *testing.T, signer schemeSigner, pkfile string) {
+	pk, err := signed.UnmarshalPemPublicKey(readFile(t, pkfile))
+	require.NoError(t, err)
+	signerPk, err := signer.PublicKey()
+	require.NoError(t, err)
+	require.True(t, pk.Equal(signerPk))
+
+	msg := []byte("message")
+	hash := sha256.Sum256(msg)
+	sig, err := signer.Sign(hash[:])
+	require.NoError(t, err)
+	require.NoError(t, signed.Verify(pk, msg, sig))
+}
+
+func TestPemSigner(t *testing.T) {
+	storage, _, sk, pk := setupSignTest(t)
+	defer test.ClearTestStorage(t, storage)
+
+	signer, err := newPemSigner(sk)
+	require.NoError(t, err)
+	testSigner(t, signer, pk)
+
+	_, err = newPemSigner(filepath.Join(storage, "nonexisting.pem"))
+	require.Error(t, err)
+}
+
+func TestCommandSigner(t *testing.T) {
+	storage, scheme, sk, pk := setupSignTest(t)
+	defer test.ClearTestStorage(t, storage)
+	require.NoError(t, os.Setenv(signHelperKeyEnv, sk))
+	defer os.Unsetenv(signHelperKeyEnv)
+
+	signer, err := newCommandSigner(os.Args[0], pk)
+	require.NoError(t, err)
+	testSigner(t, signer, pk)
+
+	require.NoError(t, signScheme(signer, scheme, true))
+	require.NoError(t, VerifyScheme(scheme, false))
+
+	_, err = newCommandSigner(os.Args[0], "")
+	require.Error(t, err)
+	_, err = newCommandSigner("", pk)
+	require.Error(t, err)
+}
+
+func TestCommandSignerWrongPublicKey(t *testing.T) {
+	storage, scheme, sk, _ := setupSignTest(t)
+	defer test.ClearTestStorage(t, storage)
+	require.NoError(t, os.Setenv(signHelperKeyEnv, sk))
+	defer os.Unsetenv(signHelperKeyEnv)
+
+	other, err := signed.GenerateKey()
+	require.NoError(t, err)
+	bts, err := signed.MarshalPemPublicKey(&other.PublicKey)
+	require.NoError(t, err)
+	otherPk := filepath.Join(storage, "other.pem")
+	require.NoError(t, ioutil.WriteFile(otherPk, bts, 0600))
+
+	files := []string{"index", "index.sig", "pk.pem", "timestamp"}
+	before := map[string][]byte{}
+	for _, file := range files {
+		before[file] = readFile(t, filepath.Join(scheme, file))
+	}
+
+	signer, err := newCommandSigner(os.Args[0], otherPk)
+	require.NoError(t, err)
+	err = signScheme(signer, scheme, true)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Signature does not verify against public key")
+
+	// The scheme must be left untouched
+	for _, file := range files {
+		require.Equal(t, before[file], readFile(t, filepath.Join(scheme, file)), file)
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -15,8 +16,15 @@ import (
 var verifyCmd = &cobra.Command{
 	Use:   "verify [<path>]",
 	Short: "Verify irma_configuration folder correctness and authenticity",
-	Long:  `The verify command parses the specified irma_configuration directory, or the current directory if not specified, and checks the signatures of the contained scheme managers.`,
-	Args:  cobra.MaximumNArgs(1),
+	Long: `The verify command parses the specified irma_configuration directory, or the current directory if not specified, and checks the signatures of the contained scheme managers.
+
+With --lint, the specified scheme or the schemes in the specified directory are checked for all
+problems instead of stopping at the first one, and each problem is reported with the file (and if
+possible, the line) in which it occurs: invalid signatures, unsigned or modified files, invalid
+descriptions and missing translations, demo prefix violations, public keys that expired or expire
+soon, invalid issue wizards, and missing, invalid or oversized logos. Schemes need not have been
+signed to be linted. The command exits with a nonzero status if any errors were found.`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var err error
 		var path string
@@ -28,6 +36,20 @@ var verifyCmd = &cobra.Command{
 				return err
 			}
 		}
+		if lint, _ := cmd.Flags().GetBool("lint"); lint {
+			asJSON, _ := cmd.Flags().GetBool("json")
+			issues, err := RunLint(path)
+			if err != nil {
+				die("Linting failed", err)
+			}
+			printLintIssues(issues, asJSON)
+			for _, issue := range issues {
+				if issue.Severity == irma.SchemeLintError {
+					die("", errors.New("scheme contains errors"))
+				}
+			}
+			return nil
+		}
 		if err = RunVerify(path, true); err == nil {
 			fmt.Println()
 			fmt.Println("Verification was successful.")
@@ -111,6 +133,90 @@ func VerifyIrmaConfiguration(path string, verbose bool) error {
 	return nil
 }
 
+// RunLint lints the scheme in path, or the schemes in its subdirectories. The files of the
+// returned issues are relative to path. Other schemes in the same directory are parsed first, so
+// that issue wizards referring to their credential types can be validated.
+func RunLint(path string) ([]*irma.SchemeLintIssue, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var schemes []string
+	confpath := filepath.Dir(path)
+	isScheme, err := common.IsScheme(path, false)
+	if err != nil {
+		return nil, err
+	}
+	if isScheme {
+		schemes = []string{path}
+	} else {
+		confpath = path
+		err = common.IterateSubfolders(path, func(dir string, _ os.FileInfo) error {
+			isScheme, err := common.IsScheme(dir, false)
+			if isScheme {
+				schemes = append(schemes, dir)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(schemes) == 0 {
+			return nil, errors.New("path must contain a scheme, or multiple schemes in subdirectories")
+		}
+	}
+
+	conf, err := irma.NewConfiguration(confpath, irma.ConfigurationOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	_ = common.IterateSubfolders(confpath, func(dir string, _ os.FileInfo) error {
+		if isScheme, _ := common.IsScheme(dir, true); isScheme {
+			_, _ = conf.ParseSchemeFolder(dir) // problems in the scheme are reported by linting it
+		}
+		return nil
+	})
+
+	issues := []*irma.SchemeLintIssue{}
+	for _, scheme := range schemes {
+		schemeIssues, err := conf.LintScheme(scheme)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "failed to lint scheme "+scheme, 0)
+		}
+		rel, err := filepath.Rel(path, scheme)
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range schemeIssues {
+			issue.File = filepath.ToSlash(filepath.Join(rel, issue.File))
+		}
+		issues = append(issues, schemeIssues...)
+	}
+	return issues, nil
+}
+
+func printLintIssues(issues []*irma.SchemeLintIssue, asJSON bool) {
+	if asJSON {
+		bts, _ := json.MarshalIndent(issues, "", "  ")
+		fmt.Println(string(bts))
+		return
+	}
+	var errs, warnings int
+	for _, issue := range issues {
+		fmt.Println(issue.String())
+		if issue.Severity == irma.SchemeLintError {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+	fmt.Printf("%d error(s), %d warning(s)\n", errs, warnings)
+}
+
 func init() {
 	schemeCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().Bool("lint", false, "report all problems in the scheme(s) instead of stopping at the first one")
+	verifyCmd.Flags().Bool("json", false, "output the problems found with --lint as JSON")
 }
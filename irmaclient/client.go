@@ -884,8 +884,14 @@ func (client *Client) ProofBuilders(choice *irma.DisclosureChoice, request irma.
 		if cred.attrs.Revoked {
 			return nil, nil, nil, revocation.ErrorRevoked
 		}
-		nonrev := request.Base().RequestsRevocation(cred.CredentialType().Identifier())
-		builder, err = cred.CreateDisclosureProofBuilder(grp.attrs, nil, nonrev)
+		credtype := cred.CredentialType()
+		attrs := grp.attrs
+		if r, ok := request.(*irma.DisclosureRequest); ok && r.SelfRevocation != nil && *r.SelfRevocation == credtype.Identifier() {
+			// Also disclose the revocation attribute, by which the revocation server finds the credential to revoke
+			attrs = append(attrs, credtype.RevocationIndex+2)
+		}
+		nonrev := request.Base().RequestsRevocation(credtype.Identifier())
+		builder, err = cred.CreateDisclosureProofBuilder(attrs, nil, nonrev)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -1119,7 +1125,7 @@ func (client *Client) keyshareEnrollWorker(managerID irma.SchemeManagerIdentifie
 		client: client,
 		pin:    pin,
 		kss:    kss,
-	})
+	}, nil)
 
 	return nil
 }
@@ -118,13 +118,12 @@ func (entry *LogEntry) GetDisclosedCredentials(conf *irma.Configuration) ([][]*i
 		return nil, err
 	}
 	var disclosure *irma.Disclosure
-	disjunctions := request.Disclosure()
 	if entry.Type == irma.ActionIssuing {
 		disclosure = entry.IssueCommitment.Disclosure()
 	} else {
 		disclosure = entry.Disclosure
 	}
-	_, attrs, err := disclosure.DisclosedAttributes(conf, disjunctions.Disclose, nil)
+	_, attrs, err := disclosure.DisclosedRequestAttributes(conf, request.Disclosure(), nil)
 	return attrs, err
 }
 
@@ -222,6 +222,60 @@ func (client *Client) NonrevUpdateFromServer(id irma.CredentialTypeIdentifier) e
 	return client.nonrevUpdate(id, nil)
 }
 
+// RevokeCredential asks the revocation server of the specified credential to revoke it, for
+// example when the user suspects that it has been compromised. This starts a disclosure session
+// with the revocation server, passing feedback to the specified handler, in which the credential
+// is disclosed along with its revocation attribute. If the session succeeds the credential is
+// revoked at the revocation server, and marked as revoked in the client.
+// The revocation server must have self-revocation enabled for the credential type.
+func (client *Client) RevokeCredential(id *irma.CredentialIdentifier, handler Handler) SessionDismisser {
+	credtype := client.Configuration.CredentialTypes[id.Type]
+	if credtype == nil || !credtype.RevocationSupported() {
+		handler.Failure(&irma.SessionError{ErrorType: irma.ErrorInvalidRequest, Info: "credential type does not support revocation"})
+		return nil
+	}
+	if attrs, _ := client.attributesByHash(id.Hash); attrs == nil || attrs.Revoked {
+		handler.Failure(&irma.SessionError{ErrorType: irma.ErrorInvalidRequest, Info: "unknown or already revoked credential"})
+		return nil
+	}
+
+	qr := &irma.Qr{}
+	transport := irma.NewHTTPTransport(credtype.RevocationServers[0], !client.Preferences.DeveloperMode)
+	if err := transport.Post(fmt.Sprintf("revocation/%s/selfrevoke", id.Type), qr, struct{}{}); err != nil {
+		handler.Failure(&irma.SessionError{ErrorType: irma.ErrorTransport, Err: err})
+		return nil
+	}
+	if err := qr.Validate(); err != nil {
+		handler.Failure(&irma.SessionError{ErrorType: irma.ErrorInvalidRequest, Err: err})
+		return nil
+	}
+	return client.newQrSession(qr, handler, id)
+}
+
+// markRevoked marks the specified credential as revoked, after it has been revoked at our request.
+func (client *Client) markRevoked(id irma.CredentialIdentifier) error {
+	client.credMutex.Lock()
+	defer client.credMutex.Unlock()
+
+	cred, _, err := client.credentialByHash(id.Hash)
+	if err != nil {
+		return err
+	}
+	if cred == nil {
+		return nil
+	}
+	cred.attrs.Revoked = true
+	if attrs, _ := client.attributesByHash(id.Hash); attrs != nil {
+		attrs.Revoked = true
+	}
+	if err = client.storage.StoreAttributes(id.Type, client.attributes[id.Type]); err != nil {
+		return err
+	}
+	client.handler.Revoked(&id)
+	client.handler.UpdateAttributes()
+	return nil
+}
+
 func (client *Client) nonrevPrepareCache(id irma.CredentialTypeIdentifier, index int) error {
 	logger := irma.Logger.WithFields(logrus.Fields{"credtype": id, "index": index})
 	logger.Debug("preparing cache")
@@ -91,6 +91,9 @@ type session struct {
 	// State for signature sessions
 	timestamp *atum.Timestamp
 
+	// State for self-revocation sessions: the credential to be revoked
+	selfRevocation *irma.CredentialIdentifier
+
 	// These are empty on manual sessions
 	Hostname  string
 	ServerURL string
@@ -129,7 +132,7 @@ func (client *Client) NewSession(sessionrequest string, handler Handler) Session
 			handler.Failure(&irma.SessionError{ErrorType: irma.ErrorInvalidRequest, Err: err})
 			return nil
 		}
-		return client.newQrSession(qr, handler)
+		return client.newQrSession(qr, handler, nil)
 	}
 
 	sigRequest := &irma.SignatureRequest{}
@@ -177,8 +180,9 @@ func (client *Client) newManualSession(request irma.SessionRequest, handler Hand
 	return session
 }
 
-// newQrSession creates and starts a new interactive IRMA session
-func (client *Client) newQrSession(qr *irma.Qr, handler Handler) *session {
+// newQrSession creates and starts a new interactive IRMA session. If selfRevocation is not nil,
+// the session must be a self-revocation session of the specified credential.
+func (client *Client) newQrSession(qr *irma.Qr, handler Handler, selfRevocation *irma.CredentialIdentifier) *session {
 	if qr.Type == irma.ActionRedirect {
 		newqr := &irma.Qr{}
 		transport := irma.NewHTTPTransport("", !client.Preferences.DeveloperMode)
@@ -190,7 +194,7 @@ func (client *Client) newQrSession(qr *irma.Qr, handler Handler) *session {
 			handler.Failure(&irma.SessionError{ErrorType: irma.ErrorInvalidRequest, Err: errors.New("infinite static QR recursion")})
 			return nil
 		}
-		return client.newQrSession(newqr, handler)
+		return client.newQrSession(newqr, handler, selfRevocation)
 	}
 
 	client.PauseJobs()
@@ -209,6 +213,7 @@ func (client *Client) newQrSession(qr *irma.Qr, handler Handler) *session {
 		client:         client,
 		done:           doneChannel,
 		prepRevocation: make(chan error),
+		selfRevocation: selfRevocation,
 	}
 	client.sessions.add(session)
 
@@ -346,6 +351,11 @@ func (session *session) processSessionInfo() {
 		return
 	}
 
+	if err := session.checkSelfRevocation(); err != nil {
+		session.fail(&irma.SessionError{ErrorType: irma.ErrorInvalidRequest, Err: err})
+		return
+	}
+
 	baserequest := session.request.Base()
 	if baserequest.DevelopmentMode && !session.client.Preferences.DeveloperMode {
 		session.fail(&irma.SessionError{
@@ -469,6 +479,10 @@ func (session *session) doSession(proceed bool, choice *irma.DisclosureChoice) {
 		session.fail(&irma.SessionError{ErrorType: irma.ErrorRequiredAttributeMissing, Err: err})
 		return
 	}
+	if err := session.checkSelfRevocationChoice(); err != nil {
+		session.fail(&irma.SessionError{ErrorType: irma.ErrorRequiredAttributeMissing, Err: err})
+		return
+	}
 	session.Handler.StatusUpdate(session.Action, irma.ClientStatusCommunicating)
 
 	// wait for revocation preparation to finish
@@ -571,10 +585,16 @@ func (session *session) sendResponse(message interface{}) {
 	if session.Action == irma.ActionIssuing {
 		session.client.handler.UpdateAttributes()
 	}
+	if session.selfRevocation != nil {
+		if err = session.client.markRevoked(*session.selfRevocation); err != nil {
+			irma.Logger.Warn(errors.WrapPrefix(err, "Failed to mark revoked credential", 0).ErrorStack())
+			session.client.reportError(err)
+		}
+	}
 	session.finish(false)
 
 	if serverResponse != nil && serverResponse.NextSession != nil {
-		session.next = session.client.newQrSession(serverResponse.NextSession, session.Handler)
+		session.next = session.client.newQrSession(serverResponse.NextSession, session.Handler, nil)
 		session.next.implicitDisclosure = session.choice.Attributes
 	} else {
 		session.Handler.Success(string(messageJson))
@@ -634,6 +654,46 @@ func (session *session) checkKeyshareEnrollment() bool {
 	return true
 }
 
+// checkSelfRevocation checks that the session request asks for the revocation attribute of a
+// credential only if we started this session to revoke that credential, and vice versa.
+func (session *session) checkSelfRevocation() error {
+	requested := session.request.Disclosure().SelfRevocation
+	if session.selfRevocation == nil {
+		if requested != nil {
+			return errors.New("unexpected self-revocation request")
+		}
+		return nil
+	}
+	if session.Action != irma.ActionDisclosing || requested == nil || *requested != session.selfRevocation.Type {
+		return errors.Errorf("session is not a self-revocation session of %s", session.selfRevocation.Type)
+	}
+	return nil
+}
+
+// checkSelfRevocationChoice checks that in a self-revocation session, the chosen attributes of
+// the credential type to be revoked belong to the credential that we want to revoke.
+func (session *session) checkSelfRevocationChoice() error {
+	if session.selfRevocation == nil {
+		return nil
+	}
+	found := false
+	for _, attrs := range session.choice.Attributes {
+		for _, attr := range attrs {
+			if attr.Type.CredentialTypeIdentifier() != session.selfRevocation.Type {
+				continue
+			}
+			if attr.CredentialHash != session.selfRevocation.Hash {
+				return errors.New("cannot disclose another credential than the one to be revoked")
+			}
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("credential to be revoked not disclosed")
+	}
+	return nil
+}
+
 func (session *session) checkAndUpdateConfiguration() error {
 	// Download missing credential types/issuers/public keys from the scheme manager
 	downloaded, err := session.client.Configuration.Download(session.request)
@@ -60,6 +60,9 @@ type Configuration struct {
 	Scheduler   *gocron.Scheduler
 	Warnings    []string `json:"-"`
 
+	// warnings are the same as Warnings, along with the tags that they are about
+	warnings []schemeWarning
+
 	options     ConfigurationOptions
 	initialized bool
 	assets      string
@@ -350,9 +353,11 @@ func (conf *Configuration) PublicKeyIndices(issuerid IssuerIdentifier) (i []uint
 	return matchKeyPattern(filepath.Join(scheme.path(), issuerid.Name(), "PublicKeys", "*"))
 }
 
-func (conf *Configuration) ValidateKeys() error {
-	const expiryBoundary = int64(time.Hour/time.Second) * 24 * 31 // 1 month, TODO make configurable
+// expiryBoundary is the time in seconds before the expiry of the latest public key of an issuer
+// from which on a warning is given.
+const expiryBoundary = int64(time.Hour/time.Second) * 24 * 31 // 1 month, TODO make configurable
 
+func (conf *Configuration) ValidateKeys() error {
 	for issuerid, issuer := range conf.Issuers {
 		if err := conf.parseKeysFolder(issuerid); err != nil {
 			return err
@@ -373,11 +378,11 @@ func (conf *Configuration) ValidateKeys() error {
 		now := time.Now()
 		if issuer.DeprecatedSince.IsZero() || issuer.DeprecatedSince.After(Timestamp(now)) {
 			if latest == nil || latest.ExpiryDate < now.Unix() {
-				conf.Warnings = append(conf.Warnings, fmt.Sprintf("Issuer %s has no nonexpired public keys", issuerid.String()))
+				conf.warnf("Issuer %s has no nonexpired public keys", issuerid.String())
 			}
 			if latest != nil && latest.ExpiryDate > now.Unix() && latest.ExpiryDate < now.Unix()+expiryBoundary {
-				conf.Warnings = append(conf.Warnings, fmt.Sprintf("Latest public key of issuer %s expires soon (at %s)",
-					issuerid.String(), time.Unix(latest.ExpiryDate, 0).String()))
+				conf.warnf("Latest public key of issuer %s expires soon (at %s)",
+					issuerid.String(), time.Unix(latest.ExpiryDate, 0).String())
 			}
 		}
 
@@ -652,7 +657,7 @@ func (conf *Configuration) checkIssuers(set *IrmaIdentifierSet, missing *IrmaIde
 
 func (conf *Configuration) validateIssuer(scheme *SchemeManager, issuer *Issuer, dir string) error {
 	issuerid := issuer.Identifier()
-	conf.validateTranslations(fmt.Sprintf("Issuer %s", issuerid.String()), issuer)
+	conf.warn(validateTranslations(fmt.Sprintf("Issuer %s", issuerid.String()), issuer)...)
 	// Check that the issuer has public keys
 	pkpath := filepath.Join(scheme.path(), issuer.ID, "PublicKeys", "*")
 	files, err := filepath.Glob(pkpath)
@@ -660,7 +665,7 @@ func (conf *Configuration) validateIssuer(scheme *SchemeManager, issuer *Issuer,
 		return err
 	}
 	if len(files) == 0 {
-		conf.Warnings = append(conf.Warnings, fmt.Sprintf("Issuer %s has no public keys", issuerid.String()))
+		conf.warnf("Issuer %s has no public keys", issuerid.String())
 	}
 
 	if filepath.Base(dir) != issuer.ID {
@@ -670,17 +675,17 @@ func (conf *Configuration) validateIssuer(scheme *SchemeManager, issuer *Issuer,
 		return errors.Errorf("Issuer %s has wrong SchemeManager %s", issuerid.String(), issuer.SchemeManagerID)
 	}
 	if err = validateDemoPrefix(issuer.Name); scheme.Demo && err != nil {
-		return errors.Errorf("Name of demo issuer %s invalid: %s", issuer.ID, err.Error())
+		return &schemeTagError{tag: "Name", err: errors.Errorf("Name of demo issuer %s invalid: %s", issuer.ID, err.Error())}
 	}
 	if err = common.AssertPathExists(filepath.Join(dir, "logo.png")); err != nil {
-		conf.Warnings = append(conf.Warnings, fmt.Sprintf("Issuer %s has no logo.png", issuerid.String()))
+		conf.warnf("Issuer %s has no logo.png", issuerid.String())
 	}
 	return nil
 }
 
 func (conf *Configuration) validateCredentialType(manager *SchemeManager, issuer *Issuer, cred *CredentialType, dir string) error {
 	credid := cred.Identifier()
-	conf.validateTranslations(fmt.Sprintf("Credential type %s", credid.String()), cred)
+	conf.warn(validateTranslations(fmt.Sprintf("Credential type %s", credid.String()), cred)...)
 	if cred.XMLVersion < 4 {
 		return errors.New("Unsupported credential type description")
 	}
@@ -694,19 +699,19 @@ func (conf *Configuration) validateCredentialType(manager *SchemeManager, issuer
 		return errors.Errorf("Credential type %s has wrong SchemeManager %s", credid.String(), cred.SchemeManagerID)
 	}
 	if err := validateDemoPrefix(cred.Name); manager.Demo && err != nil {
-		return errors.Errorf("Name of demo credential %s invalid: %s", credid.String(), err.Error())
+		return &schemeTagError{tag: "Name", err: errors.Errorf("Name of demo credential %s invalid: %s", credid.String(), err.Error())}
 	}
 
 	for _, url := range cred.RevocationServers {
 		if !manager.Demo && !strings.HasPrefix(url, "https://") {
-			return errors.Errorf("Revocation server of %s does not use https://", credid.String())
+			return &schemeTagError{tag: "RevocationServer", err: errors.Errorf("Revocation server of %s does not use https://", credid.String())}
 		}
 		if strings.HasSuffix(url, "/") {
-			return errors.Errorf("Revocation server of %s should have no trailing /", credid.String())
+			return &schemeTagError{tag: "RevocationServer", err: errors.Errorf("Revocation server of %s should have no trailing /", credid.String())}
 		}
 	}
 	if err := common.AssertPathExists(filepath.Join(dir, "logo.png")); err != nil {
-		conf.Warnings = append(conf.Warnings, fmt.Sprintf("Credential type %s has no logo.png", credid.String()))
+		conf.warnf("Credential type %s has no logo.png", credid.String())
 	}
 	return conf.validateAttributes(cred)
 }
@@ -721,14 +726,18 @@ func (conf *Configuration) validateAttributes(cred *CredentialType) error {
 	}
 	for i, attr := range cred.AttributeTypes {
 		if !attr.RevocationAttribute {
-			conf.validateTranslations(fmt.Sprintf("Attribute %s of credential type %s", attr.ID, cred.Identifier().String()), attr)
+			warnings := validateTranslations(fmt.Sprintf("Attribute %s of credential type %s", attr.ID, cred.Identifier().String()), attr)
+			for i := range warnings {
+				warnings[i].attribute = attr.ID
+			}
+			conf.warn(warnings...)
 		}
 		index := i
 		if attr.DisplayIndex != nil {
 			index = *attr.DisplayIndex
 		}
 		if index >= count {
-			conf.Warnings = append(conf.Warnings, fmt.Sprintf("Credential type %s has invalid attribute displayIndex at attribute %d", name, i))
+			conf.warnf("Credential type %s has invalid attribute displayIndex at attribute %d", name, i)
 		}
 		indices[index] = struct{}{}
 		if attr.RevocationAttribute {
@@ -740,7 +749,7 @@ func (conf *Configuration) validateAttributes(cred *CredentialType) error {
 		}
 	}
 	if len(indices) != count {
-		conf.Warnings = append(conf.Warnings, fmt.Sprintf("Credential type %s has invalid attribute ordering, check the displayIndex tags", name))
+		conf.warnf("Credential type %s has invalid attribute ordering, check the displayIndex tags", name)
 	}
 	if revocation && !cred.RevocationSupported() {
 		return errors.New("revocation attribute found but no RevocationServers configured")
@@ -751,9 +760,40 @@ func (conf *Configuration) validateAttributes(cred *CredentialType) error {
 	return nil
 }
 
+// schemeWarning is a problem in a scheme that does not invalidate it. If it is about a specific
+// tag of a description, or of one of the attribute types in it, tag and attribute are set.
+type schemeWarning struct {
+	message   string
+	tag       string
+	attribute string // ID of the attribute type
+}
+
+// schemeTagError is an error in a scheme description about the specified tag.
+type schemeTagError struct {
+	tag string
+	err error
+}
+
+func (e *schemeTagError) Error() string {
+	return e.err.Error()
+}
+
+// warn appends the warnings to conf.Warnings.
+func (conf *Configuration) warn(warnings ...schemeWarning) {
+	for _, warning := range warnings {
+		conf.Warnings = append(conf.Warnings, warning.message)
+		conf.warnings = append(conf.warnings, warning)
+	}
+}
+
+func (conf *Configuration) warnf(format string, args ...interface{}) {
+	conf.warn(schemeWarning{message: fmt.Sprintf(format, args...)})
+}
+
 // validateTranslations checks for each member of the interface o that is of type TranslatedString
-// that it contains all necessary translations.
-func (conf *Configuration) validateTranslations(file string, o interface{}) {
+// that it contains all necessary translations, returning a warning for each missing translation.
+func validateTranslations(file string, o interface{}) []schemeWarning {
+	var warnings []schemeWarning
 	v := reflect.ValueOf(o)
 
 	// Dereference in case of pointer or interface
@@ -778,7 +818,7 @@ func (conf *Configuration) validateTranslations(file string, o interface{}) {
 		if field.Type() == reflect.TypeOf(&translatedString) {
 			tmp := field.Interface().(*TranslatedString)
 			if tmp == nil {
-				return
+				return warnings
 			}
 			val = *tmp
 		} else {
@@ -788,10 +828,14 @@ func (conf *Configuration) validateTranslations(file string, o interface{}) {
 		// assuming that translations also never should be empty
 		if l := val.validate(); len(l) > 0 {
 			for _, invalidLang := range l {
-				conf.Warnings = append(conf.Warnings, fmt.Sprintf("%s misses %s translation in <%s> tag", file, invalidLang, name))
+				warnings = append(warnings, schemeWarning{
+					message: fmt.Sprintf("%s misses %s translation in <%s> tag", file, invalidLang, name),
+					tag:     name,
+				})
 			}
 		}
 	}
+	return warnings
 }
 
 func (conf *Configuration) join(other *Configuration) {
@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -116,6 +118,162 @@ func TestParseInvalidIrmaConfiguration(t *testing.T) {
 	require.Equal(t, SchemeManagerStatusInvalidSignature, conf.SchemeManagers[id].Status)
 }
 
+func TestValidateSchemeDescriptions(t *testing.T) {
+	conf, err := NewConfiguration(filepath.Join("testdata", "irma_configuration"), ConfigurationOptions{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, conf.ValidateSchemeDescriptions(filepath.Join("testdata", "irma_configuration", "irma-demo")))
+
+	// Remove the revocation server of a credential type having a revocation attribute
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	scheme := filepath.Join(storage, "irma-demo")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration", "irma-demo"), scheme))
+	path := filepath.Join(scheme, "MijnOverheid", "Issues", "root", "description.xml")
+	bts, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	bts = regexp.MustCompile(`(?s)<RevocationServers>.*</RevocationServers>`).ReplaceAll(bts, nil)
+	require.NoError(t, ioutil.WriteFile(path, bts, 0600))
+
+	require.EqualError(t, conf.ValidateSchemeDescriptions(scheme), "revocation attribute found but no RevocationServers configured")
+}
+
+func TestDiffSchemes(t *testing.T) {
+	parse := func(path string) *Configuration {
+		conf, err := NewConfiguration(filepath.Dir(path), ConfigurationOptions{ReadOnly: true})
+		require.NoError(t, err)
+		_, err = conf.ParseSchemeFolder(path)
+		require.NoError(t, err)
+		return conf
+	}
+	from := parse(filepath.Join("testdata", "irma_configuration", "irma-demo"))
+	to := parse(filepath.Join("testdata", "irma_configuration_updated", "irma-demo"))
+
+	diff, err := DiffSchemes(from, from)
+	require.NoError(t, err)
+	require.Empty(t, diff)
+
+	diff, err = DiffSchemes(from, to)
+	require.NoError(t, err)
+	require.Equal(t, SchemeDiff{
+		{Type: SchemeChangeChanged, Subject: "irma-demo.RU.studentCard.level", Message: "optional changed from false to true"},
+		{Type: SchemeChangeAdded, Subject: "irma-demo.RU.studentCard.newAttribute", Message: "attribute added at index 4"},
+		{Type: SchemeChangeAdded, Subject: "irma-demo.stemmen.stempas.votingnumber2", Message: "attribute added at index 2", Breaking: true},
+	}, diff)
+	require.True(t, diff.Breaking())
+
+	// Swap two attributes and disable revocation of a credential type
+	credtype := *to.CredentialTypes[credid("irma-demo.MijnOverheid.fullName")]
+	credtype.AttributeTypes = append([]*AttributeType{credtype.AttributeTypes[1], credtype.AttributeTypes[0]}, credtype.AttributeTypes[2:]...)
+	to.CredentialTypes[credtype.Identifier()] = &credtype
+	root := *to.CredentialTypes[credid("irma-demo.MijnOverheid.root")]
+	root.RevocationServers = nil
+	to.CredentialTypes[root.Identifier()] = &root
+
+	diff, err = DiffSchemes(from, to)
+	require.NoError(t, err)
+	require.Contains(t, diff, &SchemeChange{Type: SchemeChangeChanged, Subject: "irma-demo.MijnOverheid.fullName.firstnames", Message: "attribute moved from index 0 to 1", Breaking: true})
+	require.Contains(t, diff, &SchemeChange{Type: SchemeChangeChanged, Subject: "irma-demo.MijnOverheid.fullName.firstname", Message: "attribute moved from index 1 to 0", Breaking: true})
+	require.Contains(t, diff, &SchemeChange{Type: SchemeChangeChanged, Subject: "irma-demo.MijnOverheid.root", Message: "revocation support removed", Breaking: true})
+}
+
+func TestSignedSchemeFiles(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	path := filepath.Join(storage, "irma_configuration")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration"), path))
+	conf, err := NewConfiguration(path, ConfigurationOptions{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+
+	schemes, err := conf.SignedSchemeFiles()
+	require.NoError(t, err)
+	require.Len(t, schemes, 3)
+	require.Equal(t, "irma-demo", schemes[0].ID)
+	require.Equal(t, "test-requestors", schemes[2].ID)
+	for _, file := range []string{"index", "index.sig", "pk.pem", "timestamp", "description.xml",
+		"MijnOverheid/description.xml", "MijnOverheid/PublicKeys/2.xml", "sk.pem", "MijnOverheid/PrivateKeys/2.xml"} {
+		require.Contains(t, schemes[0].Files, file)
+	}
+	bts, err := ioutil.ReadFile(filepath.Join(path, "irma-demo", "MijnOverheid", "Issues", "root", "description.xml"))
+	require.NoError(t, err)
+	require.Equal(t, bts, schemes[0].Files["MijnOverheid/Issues/root/description.xml"])
+	require.Contains(t, schemes[2].Files, "assets/61a1fc7f161e43f8fc5b0c6ac2997cfe6bc0da7d27009b9914a04dca79ec6718.png")
+
+	// Files that do not match the index are not served
+	require.NoError(t, ioutil.WriteFile(filepath.Join(path, "irma-demo", "MijnOverheid", "description.xml"), []byte("<Issuer/>"), 0600))
+	_, err = conf.SignedSchemeFiles()
+	require.Error(t, err)
+}
+
+func TestLintScheme(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	path := filepath.Join(storage, "irma_configuration")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration"), path))
+	conf, err := NewConfiguration(path, ConfigurationOptions{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	warnings := len(conf.Warnings)
+
+	errorsIn := func(issues []*SchemeLintIssue) []*SchemeLintIssue {
+		var errs []*SchemeLintIssue
+		for _, issue := range issues {
+			if issue.Severity == SchemeLintError {
+				errs = append(errs, issue)
+			}
+		}
+		return errs
+	}
+	for _, scheme := range []string{"irma-demo", "test", "test-requestors"} {
+		issues, err := conf.LintScheme(filepath.Join(path, scheme))
+		require.NoError(t, err)
+		require.Empty(t, errorsIn(issues), scheme)
+	}
+	require.Len(t, conf.Warnings, warnings)
+
+	// Warnings appended directly to conf.Warnings are kept
+	conf.Warnings = append(conf.Warnings, "custom warning")
+	_, err = conf.LintScheme(filepath.Join(path, "irma-demo"))
+	require.NoError(t, err)
+	require.Len(t, conf.Warnings, warnings+1)
+	require.Equal(t, "custom warning", conf.Warnings[warnings])
+	conf.Warnings = conf.Warnings[:warnings]
+
+	// Remove a translation, break the demo prefix of an issuer name, and add an unsigned file
+	scheme := filepath.Join(path, "irma-demo")
+	credpath := filepath.Join(scheme, "MijnOverheid", "Issues", "fullName", "description.xml")
+	bts, err := ioutil.ReadFile(credpath)
+	require.NoError(t, err)
+	bts = regexp.MustCompile(`(?s)(<Attribute id="firstname">.*?<Description>\s*)<en>[^<]*</en>`).ReplaceAll(bts, []byte("$1"))
+	require.NoError(t, ioutil.WriteFile(credpath, bts, 0600))
+	issuerpath := filepath.Join(scheme, "RU", "description.xml")
+	bts, err = ioutil.ReadFile(issuerpath)
+	require.NoError(t, err)
+	bts = regexp.MustCompile(`<en>Demo `).ReplaceAll(bts, []byte("<en>"))
+	require.NoError(t, ioutil.WriteFile(issuerpath, bts, 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(scheme, "unsigned.txt"), []byte("foo"), 0600))
+
+	issues, err := conf.LintScheme(scheme)
+	require.NoError(t, err)
+	find := func(file, message string) *SchemeLintIssue {
+		for _, issue := range issues {
+			if issue.File == file && strings.Contains(issue.Message, message) {
+				return issue
+			}
+		}
+		require.Fail(t, "issue not found", "%s: %s", file, message)
+		return nil
+	}
+	issue := find("MijnOverheid/Issues/fullName/description.xml", "misses en translation in <Description> tag")
+	require.Equal(t, SchemeLintWarning, issue.Severity)
+	require.Equal(t, 39, issue.Line)
+	issue = find("RU/description.xml", "Name of demo issuer RU invalid")
+	require.Equal(t, SchemeLintError, issue.Severity)
+	require.NotZero(t, issue.Line)
+	require.Equal(t, SchemeLintError, find("RU/description.xml", "modified after the scheme was signed").Severity)
+	require.Equal(t, SchemeLintError, find("unsigned.txt", "not signed").Severity)
+}
+
 func TestRetryHTTPRequest(t *testing.T) {
 	test.StartBadHttpServer(2, 1*time.Second, "42")
 	defer test.StopBadHttpServer()
@@ -444,7 +602,7 @@ func TestSessionRequests(t *testing.T) {
 
 		{
 			expected: &SignatureRequest{
-				DisclosureRequest{BaseRequest{LDContext: LDContextSignatureRequest}, base.Disclose, base.Labels},
+				DisclosureRequest{BaseRequest: BaseRequest{LDContext: LDContextSignatureRequest}, Disclose: base.Disclose, Labels: base.Labels},
 				sigMessage,
 			},
 			old: &SignatureRequest{},
@@ -499,7 +657,7 @@ func TestSessionRequests(t *testing.T) {
 
 		{
 			expected: &IssuanceRequest{
-				DisclosureRequest: DisclosureRequest{BaseRequest{LDContext: LDContextIssuanceRequest}, base.Disclose, base.Labels},
+				DisclosureRequest: DisclosureRequest{BaseRequest: BaseRequest{LDContext: LDContextIssuanceRequest}, Disclose: base.Disclose, Labels: base.Labels},
 				Credentials: []*CredentialRequest{
 					{
 						CredentialTypeID: NewCredentialTypeIdentifier("irma-demo.MijnOverheid.root"),
@@ -235,9 +235,9 @@ func (sr *SignatureRequest) UnmarshalJSON(bts []byte) (err error) {
 		}
 		*sr = SignatureRequest{
 			DisclosureRequest{
-				req.BaseRequest,
-				req.Disclose,
-				req.Labels,
+				BaseRequest: req.BaseRequest,
+				Disclose:    req.Disclose,
+				Labels:      req.Labels,
 			},
 			req.Message,
 		}
@@ -291,7 +291,7 @@ func (ir *IssuanceRequest) UnmarshalJSON(bts []byte) (err error) {
 			return err
 		}
 		*ir = IssuanceRequest{
-			DisclosureRequest: DisclosureRequest{req.BaseRequest, req.Disclose, req.Labels},
+			DisclosureRequest: DisclosureRequest{BaseRequest: req.BaseRequest, Disclose: req.Disclose, Labels: req.Labels},
 			Credentials:       req.Credentials,
 		}
 		return nil
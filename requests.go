@@ -69,6 +69,11 @@ type DisclosureRequest struct {
 
 	Disclose AttributeConDisCon       `json:"disclose,omitempty"`
 	Labels   map[int]TranslatedString `json:"labels,omitempty"`
+
+	// SelfRevocation is set in sessions in which the holder revokes a credential of this type
+	// at its revocation server. Along with the requested attributes the holder then discloses
+	// the revocation attribute of the credential, by which the revocation server finds it.
+	SelfRevocation *CredentialTypeIdentifier `json:"selfRevocation,omitempty"`
 }
 
 // A SignatureRequest is a a request to sign a message with certain attributes. Construct new
@@ -244,6 +249,16 @@ type RevocationRequest struct {
 	CredentialType CredentialTypeIdentifier `json:"type"`
 	Key            string                   `json:"revocationKey,omitempty"`
 	Issued         int64                    `json:"issued,omitempty"`
+	// Keys specifies further credentials of the same type, to be revoked along with Key
+	// in a single accumulator update.
+	Keys []RevocationKey `json:"revocationKeys,omitempty"`
+}
+
+// RevocationKey specifies the credentials issued with the revocation key, or if Issued is nonzero
+// only the credential issued at that time (in Unix nanoseconds).
+type RevocationKey struct {
+	Key    string `json:"revocationKey"`
+	Issued int64  `json:"issued,omitempty"`
 }
 
 type NonRevocationRequest struct {
@@ -303,9 +318,23 @@ func (r *RevocationRequest) Validate() error {
 	if r.LDContext != LDContextRevocationRequest {
 		return errors.New("not a revocation request")
 	}
+	for _, key := range r.Keys {
+		if key.Key == "" {
+			return errors.New("revocationKeys contains empty revocationKey")
+		}
+	}
 	return nil
 }
 
+// RevocationKeys returns all credentials to be revoked by the request.
+func (r *RevocationRequest) RevocationKeys() []RevocationKey {
+	keys := make([]RevocationKey, 0, len(r.Keys)+1)
+	if r.Key != "" {
+		keys = append(keys, RevocationKey{Key: r.Key, Issued: r.Issued})
+	}
+	return append(keys, r.Keys...)
+}
+
 var (
 	bigZero = big.NewInt(0)
 	bigOne  = big.NewInt(1)
@@ -612,6 +641,14 @@ func (dr *DisclosureRequest) Validate() error {
 			return err
 		}
 	}
+	if id := dr.SelfRevocation; id != nil {
+		if _, ok := dr.Identifiers().CredentialTypes[*id]; !ok {
+			return errors.New("Self-revocation request does not disclose the credential to be revoked")
+		}
+		if dr.RequestsRevocation(*id) {
+			return errors.New("Self-revocation request cannot request a nonrevocation proof of the credential to be revoked")
+		}
+	}
 	return nil
 }
 
@@ -22,6 +22,7 @@ import (
 	"github.com/privacybydesign/gabi/revocation"
 	"github.com/privacybydesign/gabi/signed"
 	sseclient "github.com/sietseringers/go-sse"
+	"github.com/sirupsen/logrus"
 
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
@@ -43,8 +44,20 @@ type (
 
 		ServerSentEvents *sse.Server
 
+		// SyncListener, if set, is invoked after each attempt to fetch revocation updates from
+		// the revocation server, with the duration of the request and its error, if any.
+		SyncListener func(id CredentialTypeIdentifier, duration time.Duration, err error)
+
+		// PruneListener, if set, is invoked when the periodic job deleting issuance records of
+		// expired credentials deleted records, with the amount of records of revoked and of
+		// unrevoked credentials that it deleted.
+		PruneListener func(id CredentialTypeIdentifier, revoked bool, count int64)
+
 		close  chan struct{}
 		events chan *sseclient.Event
+
+		freshnessLock sync.Mutex
+		freshness     map[CredentialTypeIdentifier]map[uint]*RevocationFreshness
 	}
 
 	// RevocationClient offers an HTTP client to the revocation server endpoints.
@@ -68,6 +81,30 @@ type (
 		Tolerance           uint64 `json:"tolerance,omitempty" mapstructure:"tolerance"` // in seconds, min 30
 		SSE                 bool   `json:"sse,omitempty" mapstructure:"sse"`
 
+		// In mirror mode, the server listens for the updates of the revocation server of the credential
+		// type, stores them after verifying that their events extend the chain of events it already
+		// has, and serves them to other servers and clients. Implies server mode and SSE.
+		Mirror bool `json:"mirror,omitempty" mapstructure:"mirror"`
+
+		// If PruneGracePeriod is set, the issuance records of credentials that have not been revoked
+		// are periodically deleted once that many seconds have passed after they expired.
+		PruneGracePeriod uint64 `json:"prune_grace_period,omitempty" mapstructure:"prune_grace_period"`
+
+		// If SelfRevocation is set, holders can revoke their own credentials by disclosing them in a
+		// session started at /revocation/{credtype}/selfrevoke of the IRMA server. Requires authority mode.
+		SelfRevocation bool `json:"self_revocation,omitempty" mapstructure:"self_revocation"`
+
+		// Snapshot is the path to an accumulator snapshot, as written by "irma revocation snapshot",
+		// to verify nonrevocation proofs against without contacting the revocation server. If
+		// SnapshotMaxAge is set, nonrevocation proofs are rejected once the snapshot is older than
+		// that many seconds.
+		Snapshot       string `json:"snapshot,omitempty" mapstructure:"snapshot"`
+		SnapshotMaxAge uint64 `json:"snapshot_max_age,omitempty" mapstructure:"snapshot_max_age"`
+
+		// accumulators and updates of the accumulator snapshot, per public key counter
+		snapshot        map[uint]*revocation.Accumulator
+		snapshotUpdates map[uint]*revocation.Update
+
 		// set to now whenever a new update is received, or when the RA indicates
 		// there are no new updates. Thus it specifies up to what time our nonrevocation
 		// guarantees lasts.
@@ -90,6 +127,8 @@ type (
 		CredType  CredentialTypeIdentifier `gorm:"primary_key"`
 		Data      signedMessage
 		PKCounter *uint `gorm:"primary_key;auto_increment:false"`
+		// Frozen accumulators are no longer updated, and can be used neither for issuance nor for revocation
+		Frozen bool `gorm:"not null;default:false"`
 	}
 
 	EventRecord struct {
@@ -116,6 +155,8 @@ var (
 	ErrRevocationStateNotFound = errors.New("revocation state not found")
 	ErrUnknownRevocationKey    = errors.New("unknown revocationKey")
 	ErrorUnknownCredentialType = errors.New("unknown credential type")
+	ErrAccumulatorFrozen       = errors.New("accumulator is frozen")
+	ErrAccumulatorInUse        = errors.New("accumulator is in use by valid credentials")
 )
 
 // RevocationParameters contains global revocation constants and default values.
@@ -137,7 +178,9 @@ var RevocationParameters = struct {
 	// the timestamp in each accumulator is updated to now.
 	AccumulatorUpdateInterval uint64
 
-	// DELETE issuance records of expired credential every so many minutes
+	// DELETE issuance records of expired revoked credentials every so many minutes, as well as
+	// those of expired unrevoked credentials of credential types for which
+	// RevocationSetting.PruneGracePeriod is set
 	DeleteIssuanceRecordsInterval uint64
 
 	// ClientUpdateInterval is the time interval with which the irmaclient periodically
@@ -309,6 +352,9 @@ func (*RevocationStorage) newUpdates(records []*AccumulatorRecord, events []*Eve
 }
 
 func (rs *RevocationStorage) AddUpdate(id CredentialTypeIdentifier, record *revocation.Update) error {
+	if rs.sqlMode && rs.settings.Get(id).Mirror {
+		return rs.addMirrorUpdate(id, record)
+	}
 	if rs.sqlMode {
 		return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
 			return rs.addUpdate(tx, id, record, false)
@@ -323,7 +369,8 @@ func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdenti
 	if err != nil {
 		return err
 	}
-	if _, err = update.Verify(pk); err != nil {
+	acc, err := update.Verify(pk)
+	if err != nil {
 		return err
 	}
 
@@ -347,25 +394,144 @@ func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdenti
 
 	s := rs.settings.Get(id)
 	s.updated = time.Now()
+	rs.trackAccumulator(id, update.SignedAccumulator.PKCounter, acc.Time)
 	// POST record to listeners, if any, asynchroniously
 	rs.PostUpdate(id, update)
 
 	return nil
 }
 
+// addMirrorUpdate stores an update from the revocation server in mirror mode. The events of the
+// update must extend the chain of events that we already have for its accumulator; if there is a
+// gap between them, the missing events are first fetched from the revocation server. Thus the stored
+// events always form a complete and verified chain from the first event up to the stored accumulator.
+func (rs *RevocationStorage) addMirrorUpdate(id CredentialTypeIdentifier, update *revocation.Update) error {
+	pkcounter := update.SignedAccumulator.PKCounter
+	pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), pkcounter)
+	if err != nil {
+		return err
+	}
+	acc, err := update.Verify(pk)
+	if err != nil {
+		return err
+	}
+
+	// Fetch the events between our last event and those in the update, if any
+	last, err := rs.lastEvent(rs.sqldb, id, pkcounter)
+	if err != nil {
+		return err
+	}
+	var next uint64
+	if last != nil {
+		next = last.Index + 1
+	}
+	if acc.Index >= next && (len(update.Events) == 0 || update.Events[0].Index > next) {
+		if update, err = rs.client.FetchUpdateFrom(id, pkcounter, next); err != nil {
+			return err
+		}
+		if acc, err = update.Verify(pk); err != nil {
+			return err
+		}
+	}
+
+	var events []*revocation.Event
+	stale := false
+	err = rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+		// Our events may have changed in the meantime, so we check them again
+		last, err := rs.lastEvent(tx, id, pkcounter)
+		if err != nil {
+			return err
+		}
+		events = update.Events
+		for len(events) > 0 && last != nil && events[0].Index <= last.Index {
+			events = events[1:]
+		}
+		if len(events) == 0 {
+			if last == nil || acc.Index > last.Index {
+				return errors.New("update does not contain the events of its accumulator")
+			}
+			current, err := rs.accumulator(tx, id, pkcounter)
+			if err != nil {
+				return err
+			}
+			if current.Accumulator.Index > acc.Index || current.Accumulator.Time >= acc.Time {
+				stale = true
+				return nil
+			}
+		}
+
+		// Verify that the new events extend our chain up to the new accumulator
+		chain := events
+		if last != nil {
+			chain = append([]*revocation.Event{last}, events...)
+		} else if events[0].Index != 0 {
+			return errors.New("update does not connect to the first event")
+		}
+		if err = revocation.NewEventList(chain...).Verify(acc); err != nil {
+			return errors.WrapPrefix(err, "update does not connect to stored events", 0)
+		}
+
+		if err = tx.Save(new(AccumulatorRecord).Convert(id, update.SignedAccumulator)); err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err = tx.Insert(new(EventRecord).Convert(id, pkcounter, event)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || stale {
+		return err
+	}
+
+	rs.settings.Get(id).updated = time.Now()
+	rs.trackAccumulator(id, pkcounter, acc.Time)
+	// POST the new events to listeners, if any, asynchroniously
+	rs.PostUpdate(id, &revocation.Update{SignedAccumulator: update.SignedAccumulator, Events: events})
+	return nil
+}
+
+// lastEvent returns the stored event with the highest index of the given accumulator, if any.
+func (rs *RevocationStorage) lastEvent(tx sqlRevStorage, id CredentialTypeIdentifier, pkcounter uint) (*revocation.Event, error) {
+	var records []*EventRecord
+	if err := tx.Latest(&records, 1, map[string]interface{}{"cred_type": id, "pk_counter": pkcounter}); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return records[0].Event(), nil
+}
+
 // Issuance records
 
 func (rs *RevocationStorage) AddIssuanceRecord(r *IssuanceRecord) error {
+	if r.PKCounter != nil {
+		frozen, err := rs.sqldb.Exists((*AccumulatorRecord)(nil),
+			map[string]interface{}{"cred_type": r.CredType, "pk_counter": *r.PKCounter, "frozen": true},
+		)
+		if err != nil {
+			return err
+		}
+		if frozen {
+			return ErrAccumulatorFrozen
+		}
+	}
 	return rs.sqldb.Insert(r)
 }
 
 func (rs *RevocationStorage) IssuanceRecords(id CredentialTypeIdentifier, key string, issued time.Time) ([]*IssuanceRecord, error) {
+	return rs.issuanceRecords(rs.sqldb, id, key, issued)
+}
+
+func (rs *RevocationStorage) issuanceRecords(tx sqlRevStorage, id CredentialTypeIdentifier, key string, issued time.Time) ([]*IssuanceRecord, error) {
 	where := map[string]interface{}{"cred_type": id, "revocationkey": key, "revoked_at": 0}
 	if !issued.IsZero() {
 		where["issued"] = issued.UnixNano()
 	}
 	var r []*IssuanceRecord
-	err := rs.sqldb.Find(&r, where)
+	err := tx.Find(&r, where)
 	if err != nil {
 		return nil, err
 	}
@@ -375,6 +541,206 @@ func (rs *RevocationStorage) IssuanceRecords(id CredentialTypeIdentifier, key st
 	return r, nil
 }
 
+// IssuanceRecordFilter selects issuance records in FindIssuanceRecords. Fields with their zero value
+// match all records.
+type IssuanceRecordFilter struct {
+	// Revocation key of the records
+	Key string
+	// Issuance time of the records is at or after IssuedFrom, and before IssuedUntil
+	IssuedFrom, IssuedUntil time.Time
+	// If set, only revoked records (if true) or only unrevoked records (if false) match
+	Revoked *bool
+}
+
+// FindIssuanceRecords returns the issuance records of the given credential type that match the filter,
+// ordered by their revocation key and issuance time.
+func (rs *RevocationStorage) FindIssuanceRecords(id CredentialTypeIdentifier, filter IssuanceRecordFilter) ([]*IssuanceRecord, error) {
+	if !rs.sqlMode {
+		return nil, errors.New("issuance records are only stored in a SQL database")
+	}
+	query, args := "cred_type = ?", []interface{}{id}
+	if filter.Key != "" {
+		query += " and revocationkey = ?"
+		args = append(args, filter.Key)
+	}
+	if !filter.IssuedFrom.IsZero() {
+		query += " and issued >= ?"
+		args = append(args, filter.IssuedFrom.UnixNano())
+	}
+	if !filter.IssuedUntil.IsZero() {
+		query += " and issued < ?"
+		args = append(args, filter.IssuedUntil.UnixNano())
+	}
+	if filter.Revoked != nil {
+		if *filter.Revoked {
+			query += " and revoked_at <> 0"
+		} else {
+			query += " and revoked_at = 0"
+		}
+	}
+
+	var records []*IssuanceRecord
+	if err := rs.sqldb.Find(&records, query, args...); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// AccumulatorState summarizes the revocation state of a credential type for one public key of its issuer.
+type AccumulatorState struct {
+	CredType  CredentialTypeIdentifier `json:"type"`
+	PKCounter uint                     `json:"pkCounter"`
+	// Index and time of the current accumulator
+	Index uint64    `json:"index"`
+	Time  time.Time `json:"time"`
+	// Number of revocation events, including the initial event of the accumulator
+	Events int `json:"events"`
+	// Number of issuance records, and how many of them are revoked
+	IssuanceRecords int `json:"issuanceRecords"`
+	Revoked         int `json:"revoked"`
+	// Number of credentials that have neither expired nor been revoked, and when the last of them expires
+	Valid      int       `json:"valid"`
+	ValidUntil time.Time `json:"validUntil"`
+	Frozen     bool      `json:"frozen"`
+}
+
+// AccumulatorStates returns the state of the accumulators of the given credential type, one for
+// each public key of its issuer that has an accumulator, in order of their counters.
+func (rs *RevocationStorage) AccumulatorStates(id CredentialTypeIdentifier) ([]*AccumulatorState, error) {
+	if !rs.sqlMode {
+		return nil, errors.New("accumulator states are only available from a SQL database")
+	}
+	var states []*AccumulatorState
+	err := rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+		var records []*AccumulatorRecord
+		if err := tx.Find(&records, "cred_type = ?", id); err != nil {
+			return err
+		}
+		for _, r := range records {
+			pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), *r.PKCounter)
+			if err != nil {
+				return err
+			}
+			acc, err := r.SignedAccumulator().UnmarshalVerify(pk)
+			if err != nil {
+				return err
+			}
+			state := &AccumulatorState{
+				CredType:  id,
+				PKCounter: *r.PKCounter,
+				Index:     acc.Index,
+				Time:      time.Unix(acc.Time, 0),
+				Frozen:    r.Frozen,
+			}
+			where := map[string]interface{}{"cred_type": id, "pk_counter": *r.PKCounter}
+			if state.Events, err = tx.Count((*EventRecord)(nil), where); err != nil {
+				return err
+			}
+			if state.IssuanceRecords, err = tx.Count((*IssuanceRecord)(nil), where); err != nil {
+				return err
+			}
+			if state.Revoked, err = tx.Count((*IssuanceRecord)(nil),
+				"cred_type = ? and pk_counter = ? and revoked_at <> 0", id, *r.PKCounter,
+			); err != nil {
+				return err
+			}
+			valid := "cred_type = ? and pk_counter = ? and revoked_at = 0 and valid_until > ?"
+			now := time.Now().UnixNano()
+			if state.Valid, err = tx.Count((*IssuanceRecord)(nil), valid, id, *r.PKCounter, now); err != nil {
+				return err
+			}
+			if state.Valid > 0 {
+				validUntil, err := tx.Max((*IssuanceRecord)(nil), "valid_until", valid, id, *r.PKCounter, now)
+				if err != nil {
+					return err
+				}
+				state.ValidUntil = time.Unix(0, validUntil)
+			}
+			states = append(states, state)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].PKCounter < states[j].PKCounter })
+	return states, nil
+}
+
+// RotateAccumulator creates the accumulator of the given private key if it does not exist yet, for
+// use in issuance from now on. If freeze is true, the accumulators of the other keys of the issuer
+// that are not used by valid credentials anymore are frozen. It returns the resulting accumulator states.
+func (rs *RevocationStorage) RotateAccumulator(id CredentialTypeIdentifier, sk *gabikeys.PrivateKey, freeze bool) ([]*AccumulatorState, error) {
+	if !rs.sqlMode {
+		return nil, errors.New("accumulators can only be rotated in a SQL database")
+	}
+	exists, err := rs.Exists(id, sk.Counter)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		Logger.WithField("credtype", id).Infof("Creating accumulator for public key %d", sk.Counter)
+		if err = rs.EnableRevocation(id, sk); err != nil {
+			return nil, err
+		}
+	}
+
+	states, err := rs.AccumulatorStates(id)
+	if err != nil || !freeze {
+		return states, err
+	}
+	for _, state := range states {
+		if state.PKCounter == sk.Counter || state.Frozen || state.Valid > 0 {
+			continue
+		}
+		if err = rs.FreezeAccumulator(id, state.PKCounter); err != nil {
+			return nil, err
+		}
+		state.Frozen = true
+	}
+	return states, nil
+}
+
+// FreezeAccumulator freezes the accumulator of the given public key, after which it is no longer
+// updated and can be used neither for issuance nor for revocation. This is only allowed if no
+// valid credentials have been issued with it, i.e., all of them have expired or have been revoked.
+func (rs *RevocationStorage) FreezeAccumulator(id CredentialTypeIdentifier, pkcounter uint) error {
+	if !rs.sqlMode {
+		return errors.New("accumulators can only be frozen in a SQL database")
+	}
+	err := rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+		var record AccumulatorRecord
+		err := tx.Last(&record, map[string]interface{}{"cred_type": id, "pk_counter": pkcounter})
+		if gorm.IsRecordNotFoundError(err) {
+			return ErrRevocationStateNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if record.Frozen {
+			return nil
+		}
+		inUse, err := tx.Exists((*IssuanceRecord)(nil),
+			"cred_type = ? and pk_counter = ? and revoked_at = 0 and valid_until > ?",
+			id, pkcounter, time.Now().UnixNano(),
+		)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			return ErrAccumulatorInUse
+		}
+		Logger.WithField("credtype", id).Infof("Freezing accumulator of public key %d", pkcounter)
+		record.Frozen = true
+		return tx.Save(&record)
+	})
+	if err != nil {
+		return err
+	}
+	rs.untrackAccumulator(id, pkcounter)
+	return nil
+}
+
 // Revocation methods
 
 // Revoke revokes the credential(s) specified by key and issued, if found within the current database,
@@ -386,13 +752,89 @@ func (rs *RevocationStorage) Revoke(id CredentialTypeIdentifier, key string, iss
 		return errors.Errorf("cannot revoke %s", id)
 	}
 	return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
-		return rs.revoke(tx, id, key, issued)
+		issrecords, err := rs.issuanceRecords(tx, id, key, issued)
+		if err != nil {
+			return err
+		}
+		return rs.revoke(tx, id, issrecords)
 	})
 }
 
-func (rs *RevocationStorage) revoke(tx sqlRevStorage, id CredentialTypeIdentifier, key string, issued time.Time) error {
-	var err error
-	issrecords, err := rs.IssuanceRecords(id, key, issued)
+// RevokeBatch revokes the credentials specified by each of the keys in a single transaction, like
+// Revoke. Per issuer public key a single accumulator update is made, containing an event for each
+// revoked credential. If any of the keys is not found, nothing is revoked and an error prefixed with
+// that key and wrapping ErrUnknownRevocationKey is returned.
+func (rs *RevocationStorage) RevokeBatch(id CredentialTypeIdentifier, keys []RevocationKey) error {
+	if !rs.settings.Get(id).Authority {
+		return errors.Errorf("cannot revoke %s", id)
+	}
+	if len(keys) == 0 {
+		return errors.New("no revocation keys specified")
+	}
+	return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+		type recordID struct {
+			key    string
+			issued int64
+		}
+		seen := map[recordID]bool{}
+		var issrecords []*IssuanceRecord
+		for _, k := range keys {
+			var issued time.Time
+			if k.Issued != 0 {
+				issued = time.Unix(0, k.Issued)
+			}
+			records, err := rs.issuanceRecords(tx, id, k.Key, issued)
+			if err == ErrUnknownRevocationKey {
+				return errors.WrapPrefix(err, k.Key, 0)
+			}
+			if err != nil {
+				return err
+			}
+			// The same credential may be specified more than once, but can be revoked only once
+			for _, r := range records {
+				if rid := (recordID{r.Key, r.Issued}); !seen[rid] {
+					seen[rid] = true
+					issrecords = append(issrecords, r)
+				}
+			}
+		}
+		return rs.revoke(tx, id, issrecords)
+	})
+}
+
+// RevokeAttribute revokes the credential having the specified revocation attribute, as disclosed
+// by its holder in a self-revocation session. If the credential has already been revoked, nothing
+// happens.
+func (rs *RevocationStorage) RevokeAttribute(id CredentialTypeIdentifier, attr *big.Int) error {
+	if !rs.settings.Get(id).Authority {
+		return errors.Errorf("cannot revoke %s", id)
+	}
+	return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+		var records []*IssuanceRecord
+		if err := tx.Find(&records, "cred_type = ? and attr = ?", id, (*RevocationAttribute)(attr)); err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return ErrUnknownRevocationKey
+		}
+		var issrecords []*IssuanceRecord
+		for _, r := range records {
+			if r.RevokedAt == 0 {
+				issrecords = append(issrecords, r)
+			}
+		}
+		if len(issrecords) == 0 {
+			return nil
+		}
+		return rs.revoke(tx, id, issrecords)
+	})
+}
+
+func (rs *RevocationStorage) revoke(tx sqlRevStorage, id CredentialTypeIdentifier, issrecords []*IssuanceRecord) error {
+	// Accumulators are only frozen once all credentials issued against them have expired or have
+	// been revoked, so those credentials need not and cannot be revoked. Skip them, so that other
+	// credentials with the same revocation key are still revoked.
+	issrecords, err := rs.skipFrozen(tx, id, issrecords)
 	if err != nil {
 		return err
 	}
@@ -434,6 +876,34 @@ func (rs *RevocationStorage) revoke(tx sqlRevStorage, id CredentialTypeIdentifie
 	return nil
 }
 
+// skipFrozen returns the issuance records whose accumulator is not frozen. If there are none,
+// ErrAccumulatorFrozen is returned.
+func (rs *RevocationStorage) skipFrozen(tx sqlRevStorage, id CredentialTypeIdentifier, issrecords []*IssuanceRecord) ([]*IssuanceRecord, error) {
+	var frozen []AccumulatorRecord
+	if err := tx.Find(&frozen, map[string]interface{}{"cred_type": id, "frozen": true}); err != nil {
+		return nil, err
+	}
+	if len(frozen) == 0 {
+		return issrecords, nil
+	}
+	counters := map[uint]bool{}
+	for _, r := range frozen {
+		counters[*r.PKCounter] = true
+	}
+	var result []*IssuanceRecord
+	for _, r := range issrecords {
+		if counters[*r.PKCounter] {
+			Logger.WithField("credtype", id).Infof("Not revoking credential %s issued against frozen accumulator of public key %d", r.Key, *r.PKCounter)
+			continue
+		}
+		result = append(result, r)
+	}
+	if len(result) == 0 {
+		return nil, ErrAccumulatorFrozen
+	}
+	return result, nil
+}
+
 func (rs *RevocationStorage) revokeReadRecords(
 	tx sqlRevStorage,
 	id CredentialTypeIdentifier,
@@ -464,6 +934,9 @@ func (rs *RevocationStorage) revokeReadRecords(
 	accs := map[uint]*revocation.Accumulator{}
 	events := map[uint][]*revocation.Event{}
 	for _, r := range records {
+		if r.Frozen {
+			return nil, nil, errors.WrapPrefix(ErrAccumulatorFrozen, fmt.Sprintf("public key %d", *r.PKCounter), 0)
+		}
 		sacc := r.SignedAccumulator()
 		pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), sacc.PKCounter)
 		if err != nil {
@@ -549,11 +1022,12 @@ func (rs *RevocationStorage) updateAccumulatorTimes() error {
 			types = append(types, id)
 		}
 	}
-	return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+	var updated []*RevocationFreshness
+	err := rs.sqldb.Transaction(func(tx sqlRevStorage) error {
 		var err error
 		var records []AccumulatorRecord
 		Logger.Tracef("updating accumulator times")
-		if err = tx.Find(&records, "cred_type in (?)", types); err != nil {
+		if err = tx.Find(&records, "cred_type in (?) and frozen = ?", types, false); err != nil {
 			return err
 		}
 		for _, r := range records {
@@ -583,9 +1057,67 @@ func (rs *RevocationStorage) updateAccumulatorTimes() error {
 			s.updated = time.Now()
 			// POST record to listeners, if any, asynchroniously
 			rs.PostUpdate(r.CredType, &revocation.Update{SignedAccumulator: sacc})
+			updated = append(updated, &RevocationFreshness{
+				CredType: r.CredType, PKCounter: *r.PKCounter, AccumulatorTime: time.Unix(acc.Time, 0),
+			})
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	for _, f := range updated {
+		rs.trackAccumulator(f.CredType, f.PKCounter, f.AccumulatorTime.Unix())
+	}
+	return nil
+}
+
+// pruneIssuanceRecords deletes the issuance records of expired credentials that have been
+// revoked, and those of expired credentials that have not been revoked once the grace period of
+// their credential type has passed, if that is set in the revocation settings. It returns the
+// number of deleted records.
+func (rs *RevocationStorage) pruneIssuanceRecords() (int64, error) {
+	if !rs.sqlMode {
+		return 0, nil
+	}
+	now := time.Now()
+	var total int64
+	deleted := func(id CredentialTypeIdentifier, revoked bool, count int64) {
+		if count == 0 {
+			return
+		}
+		total += count
+		Logger.WithFields(logrus.Fields{"credtype": id, "revoked": revoked}).
+			Infof("Deleted %d expired issuance records", count)
+		if rs.PruneListener != nil {
+			rs.PruneListener(id, revoked, count)
+		}
+	}
+
+	for id, ct := range rs.conf.CredentialTypes {
+		if !ct.RevocationSupported() {
+			continue
+		}
+		count, err := rs.sqldb.Delete(IssuanceRecord{}, "cred_type = ? and revoked_at <> 0 and valid_until < ?",
+			id.String(), now.UnixNano())
+		if err != nil {
+			return total, err
+		}
+		deleted(id, true, count)
+
+		settings, ok := rs.settings[id]
+		if !ok || settings.PruneGracePeriod == 0 {
+			continue
+		}
+		before := now.Add(-time.Duration(settings.PruneGracePeriod) * time.Second)
+		count, err = rs.sqldb.Delete(IssuanceRecord{}, "cred_type = ? and revoked_at = 0 and valid_until < ?",
+			id.String(), before.UnixNano())
+		if err != nil {
+			return total, err
+		}
+		deleted(id, false, count)
+	}
+	return total, nil
 }
 
 // Methods to update from remote revocation server
@@ -595,22 +1127,29 @@ func (rs *RevocationStorage) SyncDB(id CredentialTypeIdentifier) error {
 	if ct == nil {
 		return ErrorUnknownCredentialType
 	}
-	if settings, ok := rs.settings[id]; ok && settings.Authority {
+	if settings, ok := rs.settings[id]; ok && (settings.Authority || settings.snapshot != nil) {
 		return nil
 	}
 
 	Logger.WithField("credtype", id).Tracef("fetching revocation updates")
+	start := time.Now()
 	updates, err := rs.client.FetchUpdatesLatest(id, ct.RevocationUpdateCount)
+	if rs.SyncListener != nil {
+		rs.SyncListener(id, time.Since(start), err)
+	}
 	if err != nil {
 		return err
 	}
-	for _, u := range updates {
+	counters := make([]uint, 0, len(updates))
+	for counter, u := range updates {
 		if err = rs.AddUpdate(id, u); err != nil {
 			return err
 		}
+		counters = append(counters, counter)
 	}
 	// bump updated even if no new records were added
 	rs.settings.Get(id).updated = time.Now()
+	rs.trackSync(id, counters, time.Now())
 	return nil
 }
 
@@ -720,8 +1259,15 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 	settings.fixSlash()
 	var t *CredentialTypeIdentifier
 	for id, s := range settings {
+		if s.Mirror {
+			if s.Authority {
+				return errors.Errorf("revocation mirror mode for %s cannot be combined with authority mode", id.String())
+			}
+			s.Server = true
+			s.SSE = true
+		}
 		if !s.Authority {
-			if s.Server && s.RevocationServerURL == "" {
+			if s.Server && !s.Mirror && s.RevocationServerURL == "" {
 				return errors.Errorf("revocation server mode for %s requires URL to be configured", id.String())
 			}
 		} else {
@@ -733,6 +1279,12 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 		if s.Server {
 			t = &id
 		}
+		if s.SelfRevocation && !s.Authority {
+			return errors.Errorf("self-revocation of %s requires revocation authority mode", id.String())
+		}
+		if s.Snapshot != "" && s.Server {
+			return errors.Errorf("accumulator snapshot of %s cannot be combined with revocation server mode", id.String())
+		}
 		if s.SSE {
 			urls, err := updateURL(id, rs.conf, settings)
 			if err != nil {
@@ -759,10 +1311,7 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 	})
 
 	rs.conf.Scheduler.Every(RevocationParameters.DeleteIssuanceRecordsInterval).Minutes().Do(func() {
-		if !rs.sqlMode {
-			return
-		}
-		if err := rs.sqldb.Delete(IssuanceRecord{}, "valid_until < ?", time.Now().UnixNano()); err != nil {
+		if _, err := rs.pruneIssuanceRecords(); err != nil {
 			err = errors.WrapPrefix(err, "failed to delete expired issuance records", 0)
 			raven.CaptureError(err, nil)
 		}
@@ -794,6 +1343,19 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 	}
 	rs.client = RevocationClient{Conf: rs.conf, Settings: rs.settings}
 	rs.Keys = RevocationKeys{Conf: rs.conf}
+	if rs.sqlMode {
+		if err := rs.loadFreshness(); err != nil {
+			Logger.Warn("failed to load accumulator times from revocation database: ", err)
+		}
+	}
+	for id, s := range rs.settings {
+		if s.Snapshot == "" {
+			continue
+		}
+		if err := rs.loadAccumulatorSnapshotFile(id, s); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -821,6 +1383,17 @@ func (rs *RevocationStorage) SetRevocationUpdates(b *BaseRequest) error {
 			return errors.Errorf("cannot request nonrevocation proof for %s: revocation not enabled in scheme", credid)
 		}
 		settings := rs.settings.Get(credid)
+		if settings.snapshot != nil {
+			// Offline: include the updates of the accumulator snapshot, as long as it is not too old
+			params.Updates = map[uint]*revocation.Update{}
+			for counter, update := range settings.snapshotUpdates {
+				if _, err = settings.snapshotAccumulator(counter, time.Now()); err != nil {
+					return err
+				}
+				params.Updates[counter] = update
+			}
+			continue
+		}
 		tolerance := settings.Tolerance
 		if params.Tolerance != 0 {
 			tolerance = params.Tolerance
@@ -886,9 +1459,17 @@ func (client RevocationClient) FetchUpdateFrom(id CredentialTypeIdentifier, pkco
 	}
 
 	to := acc.Index - uint64(len(update.Events))
-	if from >= to {
+	if from > to {
 		return update, err
 	}
+	// Mirrors fetch the events from the revocation server that they mirror, which need not be
+	// listed in the scheme; everyone else fetches them from the revocation servers in the scheme.
+	urls := ct.RevocationServers
+	if settings := client.Settings[id]; settings != nil && settings.Mirror {
+		if urls, err = updateURL(id, client.Conf, client.Settings); err != nil {
+			return nil, err
+		}
+	}
 
 	// Fetch events not included in the response above
 	indices := binaryPartition(from, to)
@@ -900,7 +1481,7 @@ func (client RevocationClient) FetchUpdateFrom(id CredentialTypeIdentifier, pkco
 		go func(i [2]uint64) {
 			events := &revocation.EventList{ComputeProduct: true}
 			if e := client.getMultiple(
-				client.Conf.CredentialTypes[id].RevocationServers,
+				urls,
 				fmt.Sprintf("/revocation/%s/events/%d/%d/%d", id, pkcounter, i[0], i[1]),
 				events,
 			); e != nil {
@@ -0,0 +1,186 @@
+package irma
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/revocation"
+	"github.com/privacybydesign/gabi/signed"
+)
+
+// RevocationArchiveVersion is the version of the revocation archives created by DumpRevocationState.
+const RevocationArchiveVersion = 1
+
+type (
+	// RevocationArchive contains the complete revocation state of a credential type as stored in the
+	// revocation database of its revocation authority, for backups and for migrating to another database.
+	RevocationArchive struct {
+		Version         int
+		CredType        CredentialTypeIdentifier
+		Created         int64
+		Accumulators    []*AccumulatorRecord
+		Events          []*EventRecord
+		IssuanceRecords []*IssuanceRecord
+	}
+
+	// SignedRevocationArchive is a RevocationArchive signed with the revocation key of the issuer
+	// of the credential type, along with the counter of the public key with which to verify it.
+	SignedRevocationArchive struct {
+		Version   int                      `json:"version"`
+		CredType  CredentialTypeIdentifier `json:"credType"`
+		PKCounter uint                     `json:"pk"`
+		Data      signed.Message           `json:"data"`
+	}
+)
+
+// DumpRevocationState returns all accumulators, events and issuance records of the given credential
+// type in a revocation archive, signed with the latest private key of the issuer.
+func (rs *RevocationStorage) DumpRevocationState(id CredentialTypeIdentifier) (*SignedRevocationArchive, error) {
+	if !rs.sqlMode {
+		return nil, errors.New("revocation state can only be dumped from a SQL database")
+	}
+	sk, err := rs.Keys.PrivateKeyLatest(id.IssuerIdentifier())
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &RevocationArchive{
+		Version:  RevocationArchiveVersion,
+		CredType: id,
+		Created:  time.Now().Unix(),
+	}
+	err = rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+		if err := tx.Find(&archive.Accumulators, "cred_type = ?", id); err != nil {
+			return err
+		}
+		if err := tx.Find(&archive.Events, "cred_type = ?", id); err != nil {
+			return err
+		}
+		return tx.Find(&archive.IssuanceRecords, "cred_type = ?", id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(archive.Accumulators) == 0 {
+		return nil, ErrRevocationStateNotFound
+	}
+
+	data, err := signed.MarshalSign(sk.ECDSA, archive)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedRevocationArchive{
+		Version:   RevocationArchiveVersion,
+		CredType:  id,
+		PKCounter: sk.Counter,
+		Data:      data,
+	}, nil
+}
+
+// RestoreRevocationState verifies the signature of the revocation archive, the signatures of the
+// accumulators in it and the hash chains of their events, and then writes its contents to the
+// database. The database must not yet contain accumulators for the credential type of the archive.
+func (rs *RevocationStorage) RestoreRevocationState(signedArchive *SignedRevocationArchive) error {
+	if !rs.sqlMode {
+		return errors.New("revocation state can only be restored to a SQL database")
+	}
+	archive, err := rs.verifyRevocationArchive(signedArchive)
+	if err != nil {
+		return errors.WrapPrefix(err, "invalid revocation archive", 0)
+	}
+	id := archive.CredType
+
+	return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+		exists, err := tx.Exists((*AccumulatorRecord)(nil), map[string]interface{}{"cred_type": id})
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errors.Errorf("revocation database already contains revocation state for %s", id)
+		}
+		for _, r := range archive.Accumulators {
+			if err = tx.Insert(r); err != nil {
+				return err
+			}
+		}
+		for _, r := range archive.Events {
+			if err = tx.Insert(r); err != nil {
+				return err
+			}
+		}
+		for _, r := range archive.IssuanceRecords {
+			if err = tx.Insert(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (rs *RevocationStorage) verifyRevocationArchive(signedArchive *SignedRevocationArchive) (*RevocationArchive, error) {
+	if signedArchive.Version != RevocationArchiveVersion {
+		return nil, errors.Errorf("unsupported version %d", signedArchive.Version)
+	}
+	id := signedArchive.CredType
+	pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), signedArchive.PKCounter)
+	if err != nil {
+		return nil, err
+	}
+	var archive RevocationArchive
+	if err = signed.UnmarshalVerify(pk.ECDSA, signedArchive.Data, &archive); err != nil {
+		return nil, err
+	}
+	if archive.Version != signedArchive.Version || archive.CredType != id {
+		return nil, errors.New("archive does not match its header")
+	}
+
+	// Verify each accumulator along with the hash chain of its events
+	events := map[uint][]*revocation.Event{}
+	for _, r := range archive.Events {
+		if r.CredType != id || r.PKCounter == nil || r.Index == nil || r.E == nil {
+			return nil, errors.New("invalid event record")
+		}
+		events[*r.PKCounter] = append(events[*r.PKCounter], r.Event())
+	}
+	accumulators := map[uint]bool{}
+	for _, r := range archive.Accumulators {
+		if r.CredType != id || r.PKCounter == nil {
+			return nil, errors.New("invalid accumulator record")
+		}
+		counter := *r.PKCounter
+		pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), counter)
+		if err != nil {
+			return nil, err
+		}
+		acc, err := r.SignedAccumulator().UnmarshalVerify(pk)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "accumulator signature invalid", 0)
+		}
+		list := events[counter]
+		sort.Slice(list, func(i, j int) bool { return list[i].Index < list[j].Index })
+		if len(list) == 0 || list[0].Index != 0 || list[len(list)-1].Index != acc.Index {
+			return nil, errors.Errorf("events of accumulator of public key %d incomplete", counter)
+		}
+		if err = revocation.NewEventList(list...).Verify(acc); err != nil {
+			return nil, errors.WrapPrefix(err, "event hash chain invalid", 0)
+		}
+		accumulators[counter] = true
+	}
+	if len(accumulators) == 0 {
+		return nil, errors.New("archive contains no accumulators")
+	}
+	for counter := range events {
+		if !accumulators[counter] {
+			return nil, errors.Errorf("events of public key %d have no accumulator", counter)
+		}
+	}
+
+	for _, r := range archive.IssuanceRecords {
+		if r.CredType != id || r.PKCounter == nil || r.Attr == nil {
+			return nil, errors.New("invalid issuance record")
+		}
+	}
+
+	return &archive, nil
+}
@@ -1,6 +1,7 @@
 package irma
 
 import (
+	"database/sql"
 	"log"
 	"sync"
 
@@ -30,16 +31,27 @@ type (
 )
 
 func newSqlStorage(debug bool, dbtype, connstr string) (sqlRevStorage, error) {
+	dialect := dbtype
 	switch dbtype {
 	case "postgres", "mysql":
+	case "sqlite":
+		if !SQLiteSupported {
+			return sqlRevStorage{}, errors.New("sqlite is not supported by this build, which was built without cgo")
+		}
+		dialect = "sqlite3"
 	default:
 		return sqlRevStorage{}, errors.New("unsupported database type")
 	}
 
-	g, err := gorm.Open(dbtype, connstr)
+	g, err := gorm.Open(dialect, connstr)
 	if err != nil {
 		return sqlRevStorage{}, err
 	}
+	if dbtype == "sqlite" {
+		// SQLite supports only one writer at a time, and a transaction that cannot acquire the write
+		// lock fails instead of waiting for it; so we use a single connection to serialize all access.
+		g.DB().SetMaxOpenConns(1)
+	}
 
 	if debug {
 		g.LogMode(true)
@@ -110,8 +122,26 @@ func (s sqlRevStorage) Exists(id interface{}, query interface{}, args ...interfa
 	return c > 0, db.Error
 }
 
-func (s sqlRevStorage) Delete(id interface{}, query interface{}, args ...interface{}) error {
-	return s.gorm.Delete(id, query, args).Error
+func (s sqlRevStorage) Max(model interface{}, column string, query interface{}, args ...interface{}) (int64, error) {
+	var max sql.NullInt64
+	err := s.gorm.Model(model).Where(query, args...).Select("max(" + column + ")").Row().Scan(&max)
+	return max.Int64, err
+}
+
+func (s sqlRevStorage) Count(model interface{}, query interface{}, args ...interface{}) (int, error) {
+	var c int
+	db := s.gorm.Model(model)
+	if query != nil {
+		db = db.Where(query, args...)
+	}
+	db = db.Count(&c)
+	return c, db.Error
+}
+
+// Delete deletes the matching records and returns how many were deleted.
+func (s sqlRevStorage) Delete(id interface{}, query interface{}, args ...interface{}) (int64, error) {
+	db := s.gorm.Where(query, args...).Delete(id)
+	return db.RowsAffected, db.Error
 }
 
 func (s sqlRevStorage) Find(dest interface{}, query interface{}, args ...interface{}) error {
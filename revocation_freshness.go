@@ -0,0 +1,113 @@
+package irma
+
+import (
+	"sort"
+	"time"
+)
+
+// RevocationFreshness describes how recent the revocation state is that we have of a credential
+// type, for one of the public keys of its issuer.
+type RevocationFreshness struct {
+	CredType  CredentialTypeIdentifier `json:"credType"`
+	PKCounter uint                     `json:"pk"`
+
+	// AccumulatorTime is the time at which the latest accumulator that we have was signed.
+	// Verifiers reject nonrevocation proofs against accumulators older than their tolerance.
+	AccumulatorTime time.Time `json:"accumulatorTime"`
+
+	// LastSync is the last time that we successfully fetched updates from the revocation server.
+	// It is zero if we never did, for example because we are the revocation authority.
+	LastSync time.Time `json:"lastSync"`
+}
+
+// Freshness returns, per credential type and public key counter, the time of the latest accumulator
+// that we have and the time at which we last successfully synced with the revocation server.
+func (rs *RevocationStorage) Freshness() []*RevocationFreshness {
+	rs.freshnessLock.Lock()
+	defer rs.freshnessLock.Unlock()
+
+	var result []*RevocationFreshness
+	for _, counters := range rs.freshness {
+		for _, f := range counters {
+			copied := *f
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CredType != result[j].CredType {
+			return result[i].CredType.String() < result[j].CredType.String()
+		}
+		return result[i].PKCounter < result[j].PKCounter
+	})
+	return result
+}
+
+// trackAccumulator records the time of an accumulator that we stored, if it is newer than
+// the one that we already know of.
+func (rs *RevocationStorage) trackAccumulator(id CredentialTypeIdentifier, pkcounter uint, t int64) {
+	rs.freshnessLock.Lock()
+	defer rs.freshnessLock.Unlock()
+
+	f := rs.trackedFreshness(id, pkcounter)
+	if accTime := time.Unix(t, 0); accTime.After(f.AccumulatorTime) {
+		f.AccumulatorTime = accTime
+	}
+}
+
+// trackSync records a successful sync with the revocation server for the given public keys.
+func (rs *RevocationStorage) trackSync(id CredentialTypeIdentifier, pkcounters []uint, t time.Time) {
+	rs.freshnessLock.Lock()
+	defer rs.freshnessLock.Unlock()
+
+	for _, pkcounter := range pkcounters {
+		rs.trackedFreshness(id, pkcounter).LastSync = t
+	}
+}
+
+// untrackAccumulator stops tracking the freshness of an accumulator, for when it is frozen and
+// thus no longer updated.
+func (rs *RevocationStorage) untrackAccumulator(id CredentialTypeIdentifier, pkcounter uint) {
+	rs.freshnessLock.Lock()
+	defer rs.freshnessLock.Unlock()
+
+	delete(rs.freshness[id], pkcounter)
+}
+
+func (rs *RevocationStorage) trackedFreshness(id CredentialTypeIdentifier, pkcounter uint) *RevocationFreshness {
+	if rs.freshness == nil {
+		rs.freshness = map[CredentialTypeIdentifier]map[uint]*RevocationFreshness{}
+	}
+	if rs.freshness[id] == nil {
+		rs.freshness[id] = map[uint]*RevocationFreshness{}
+	}
+	f := rs.freshness[id][pkcounter]
+	if f == nil {
+		f = &RevocationFreshness{CredType: id, PKCounter: pkcounter}
+		rs.freshness[id][pkcounter] = f
+	}
+	return f
+}
+
+// loadFreshness initializes the freshness of the unfrozen accumulators already present in the
+// database, so that it is known before the first update is stored or fetched.
+func (rs *RevocationStorage) loadFreshness() error {
+	var records []*AccumulatorRecord
+	if err := rs.sqldb.Find(&records, "frozen = ?", false); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if rs.conf.CredentialTypes[r.CredType] == nil {
+			continue
+		}
+		pk, err := rs.Keys.PublicKey(r.CredType.IssuerIdentifier(), *r.PKCounter)
+		if err != nil {
+			return err
+		}
+		acc, err := r.SignedAccumulator().UnmarshalVerify(pk)
+		if err != nil {
+			return err
+		}
+		rs.trackAccumulator(r.CredType, *r.PKCounter, acc.Time)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package irma
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/revocation"
+)
+
+// AccumulatorSnapshot contains, for each public key of the issuer of a credential type, the latest
+// signed accumulator along with the latest revocation events, as exported from a revocation server.
+// Verifiers that cannot reach the revocation server use it as the reference accumulator when
+// verifying nonrevocation proofs.
+type AccumulatorSnapshot struct {
+	CredType CredentialTypeIdentifier    `json:"credType"`
+	Created  int64                       `json:"created"`
+	Updates  map[uint]*revocation.Update `json:"updates"`
+}
+
+// AccumulatorSnapshot returns a snapshot of the latest accumulators of the credential type, from the
+// revocation database if we have one, and otherwise fetched from the revocation server.
+func (rs *RevocationStorage) AccumulatorSnapshot(id CredentialTypeIdentifier) (*AccumulatorSnapshot, error) {
+	ct := rs.conf.CredentialTypes[id]
+	if ct == nil {
+		return nil, ErrorUnknownCredentialType
+	}
+	if !ct.RevocationSupported() {
+		return nil, errors.New("credential type does not support revocation")
+	}
+
+	var (
+		updates map[uint]*revocation.Update
+		err     error
+	)
+	if rs.sqlMode {
+		updates, err = rs.UpdateLatest(id, ct.RevocationUpdateCount, nil)
+	} else {
+		updates, err = rs.client.FetchUpdatesLatest(id, ct.RevocationUpdateCount)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(updates) == 0 {
+		return nil, ErrRevocationStateNotFound
+	}
+	return &AccumulatorSnapshot{CredType: id, Created: time.Now().Unix(), Updates: updates}, nil
+}
+
+// LoadAccumulatorSnapshot verifies the accumulators in the snapshot against the revocation public
+// keys of the issuer, and uses them as the reference accumulators of the credential type from then
+// on: nonrevocation proofs against older accumulators are rejected, and the snapshot is included in
+// session requests instead of fetching updates from the revocation server. If maxAge is not zero,
+// nonrevocation proofs are rejected once the snapshot accumulator is older than maxAge seconds.
+func (rs *RevocationStorage) LoadAccumulatorSnapshot(snapshot *AccumulatorSnapshot, maxAge uint64) error {
+	id := snapshot.CredType
+	ct := rs.conf.CredentialTypes[id]
+	if ct == nil {
+		return ErrorUnknownCredentialType
+	}
+	if !ct.RevocationSupported() {
+		return errors.New("credential type does not support revocation")
+	}
+	if len(snapshot.Updates) == 0 {
+		return errors.New("accumulator snapshot contains no accumulators")
+	}
+
+	accs := map[uint]*revocation.Accumulator{}
+	for counter, update := range snapshot.Updates {
+		if update == nil || update.SignedAccumulator == nil || update.SignedAccumulator.PKCounter != counter {
+			return errors.Errorf("invalid accumulator of public key %d in accumulator snapshot", counter)
+		}
+		pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), counter)
+		if err != nil {
+			return err
+		}
+		acc, err := update.Verify(pk)
+		if err != nil {
+			return errors.WrapPrefix(err, "invalid accumulator snapshot", 0)
+		}
+		accs[counter] = acc
+	}
+
+	if rs.settings == nil {
+		rs.settings = RevocationSettings{}
+	}
+	s := rs.settings.Get(id)
+	if s.Server {
+		return errors.Errorf("accumulator snapshot of %s cannot be combined with revocation server mode", id)
+	}
+	s.SnapshotMaxAge = maxAge
+	s.snapshot = accs
+	s.snapshotUpdates = snapshot.Updates
+	for counter, acc := range accs {
+		rs.trackAccumulator(id, counter, acc.Time)
+		if _, err := s.snapshotAccumulator(counter, time.Now()); err != nil {
+			Logger.Warn(err)
+		}
+	}
+	return nil
+}
+
+// loadAccumulatorSnapshotFile reads the accumulator snapshot configured in the revocation settings
+// of the credential type.
+func (rs *RevocationStorage) loadAccumulatorSnapshotFile(id CredentialTypeIdentifier, s *RevocationSetting) error {
+	bts, err := ioutil.ReadFile(s.Snapshot)
+	if err != nil {
+		return errors.WrapPrefix(err, "failed to read accumulator snapshot", 0)
+	}
+	var snapshot AccumulatorSnapshot
+	if err = json.Unmarshal(bts, &snapshot); err != nil {
+		return errors.WrapPrefix(err, "failed to parse accumulator snapshot", 0)
+	}
+	if snapshot.CredType != id {
+		return errors.Errorf("accumulator snapshot %s is not of %s", s.Snapshot, id)
+	}
+	return rs.LoadAccumulatorSnapshot(&snapshot, s.SnapshotMaxAge)
+}
+
+// snapshotAccumulator returns the accumulator of the specified public key in the accumulator
+// snapshot, if any, or an error if the snapshot was older than its maximum age at the given time.
+func (s *RevocationSetting) snapshotAccumulator(pkcounter uint, t time.Time) (*revocation.Accumulator, error) {
+	acc := s.snapshot[pkcounter]
+	if acc == nil {
+		return nil, nil
+	}
+	if s.SnapshotMaxAge != 0 && t.Sub(time.Unix(acc.Time, 0)) > time.Duration(s.SnapshotMaxAge)*time.Second {
+		return nil, errors.Errorf("accumulator snapshot of public key %d is older than %d seconds", pkcounter, s.SnapshotMaxAge)
+	}
+	return acc, nil
+}
@@ -0,0 +1,12 @@
+//go:build cgo
+// +build cgo
+
+package irma
+
+import (
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// SQLiteSupported indicates whether SQLite can be used as revocation database, which requires
+// this build to have been built with cgo.
+const SQLiteSupported = true
@@ -0,0 +1,8 @@
+//go:build !cgo
+// +build !cgo
+
+package irma
+
+// SQLiteSupported indicates whether SQLite can be used as revocation database, which is not
+// the case as this build was built without cgo.
+const SQLiteSupported = false
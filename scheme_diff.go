@@ -0,0 +1,320 @@
+package irma
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// SchemeChangeType is the type of a SchemeChange.
+type SchemeChangeType string
+
+const (
+	SchemeChangeAdded   SchemeChangeType = "added"
+	SchemeChangeRemoved SchemeChangeType = "removed"
+	SchemeChangeChanged SchemeChangeType = "changed"
+)
+
+// SchemeChange is a semantic difference between two versions of a scheme. A change is breaking if
+// existing credentials, keyshare enrollments or issue wizards stop working after the update.
+type SchemeChange struct {
+	Type SchemeChangeType `json:"type"`
+	// Subject is the identifier of the scheme, issuer, public key, credential type or issue wizard
+	// that changed.
+	Subject  string `json:"subject"`
+	Message  string `json:"message"`
+	Breaking bool   `json:"breaking"`
+}
+
+// SchemeDiff contains the changes between two versions of a set of schemes, sorted by subject.
+type SchemeDiff []*SchemeChange
+
+func (c *SchemeChange) String() string {
+	return fmt.Sprintf("%s: %s", c.Subject, c.Message)
+}
+
+// Breaking returns whether any of the changes is breaking.
+func (diff SchemeDiff) Breaking() bool {
+	for _, c := range diff {
+		if c.Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffSchemes returns the semantic changes between the schemes in the from and to configurations,
+// as seen by wallets: added and removed schemes, issuers, public keys, credential types and issue
+// wizards, and changes to credential types and their attributes. Typically both configurations
+// contain one version of the same scheme, parsed using ParseSchemeFolder.
+func DiffSchemes(from, to *Configuration) (SchemeDiff, error) {
+	var diff SchemeDiff
+	diff.diffSchemeManagers(from, to)
+	diff.diffRequestorSchemes(from, to)
+	diff.diffIssuers(from, to)
+	if err := diff.diffPublicKeys(from, to); err != nil {
+		return nil, err
+	}
+	diff.diffCredentialTypes(from, to)
+	if err := diff.diffIssueWizards(from, to); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(diff, func(i, j int) bool { return diff[i].Subject < diff[j].Subject })
+	return diff, nil
+}
+
+func (diff *SchemeDiff) add(typ SchemeChangeType, subject fmt.Stringer, breaking bool, format string, args ...interface{}) {
+	*diff = append(*diff, &SchemeChange{
+		Type:     typ,
+		Subject:  subject.String(),
+		Message:  fmt.Sprintf(format, args...),
+		Breaking: breaking,
+	})
+}
+
+func (diff *SchemeDiff) diffSchemeManagers(from, to *Configuration) {
+	for id, old := range from.SchemeManagers {
+		scheme, ok := to.SchemeManagers[id]
+		if !ok {
+			diff.add(SchemeChangeRemoved, id, true, "scheme removed")
+			continue
+		}
+		if old.URL != scheme.URL {
+			diff.add(SchemeChangeChanged, id, false, "URL changed from %s to %s", old.URL, scheme.URL)
+		}
+		if old.KeyshareServer != scheme.KeyshareServer {
+			diff.add(SchemeChangeChanged, id, true, "keyshare server changed from %s to %s", old.KeyshareServer, scheme.KeyshareServer)
+		}
+		if old.TimestampServer != scheme.TimestampServer {
+			diff.add(SchemeChangeChanged, id, false, "timestamp server changed from %s to %s", old.TimestampServer, scheme.TimestampServer)
+		}
+		if old.Demo != scheme.Demo {
+			diff.add(SchemeChangeChanged, id, false, "demo changed from %t to %t", old.Demo, scheme.Demo)
+		}
+		if old.MinimumAppVersion != scheme.MinimumAppVersion {
+			diff.add(SchemeChangeChanged, id, false, "minimum app version changed from %+v to %+v", old.MinimumAppVersion, scheme.MinimumAppVersion)
+		}
+	}
+	for id := range to.SchemeManagers {
+		if _, ok := from.SchemeManagers[id]; !ok {
+			diff.add(SchemeChangeAdded, id, false, "scheme added")
+		}
+	}
+}
+
+func (diff *SchemeDiff) diffRequestorSchemes(from, to *Configuration) {
+	for id := range from.RequestorSchemes {
+		if _, ok := to.RequestorSchemes[id]; !ok {
+			diff.add(SchemeChangeRemoved, id, true, "requestor scheme removed")
+		}
+	}
+	for id := range to.RequestorSchemes {
+		if _, ok := from.RequestorSchemes[id]; !ok {
+			diff.add(SchemeChangeAdded, id, false, "requestor scheme added")
+		}
+	}
+}
+
+func (diff *SchemeDiff) diffIssuers(from, to *Configuration) {
+	for id, old := range from.Issuers {
+		issuer, ok := to.Issuers[id]
+		if !ok {
+			diff.add(SchemeChangeRemoved, id, true, "issuer removed")
+			continue
+		}
+		if !timestampEqual(old.DeprecatedSince, issuer.DeprecatedSince) {
+			diff.add(SchemeChangeChanged, id, false, "deprecated since %s", issuer.DeprecatedSince.String())
+		}
+	}
+	for id := range to.Issuers {
+		if _, ok := from.Issuers[id]; !ok {
+			diff.add(SchemeChangeAdded, id, false, "issuer added")
+		}
+	}
+}
+
+func (diff *SchemeDiff) diffPublicKeys(from, to *Configuration) error {
+	for id := range from.Issuers {
+		if _, ok := to.Issuers[id]; !ok {
+			continue
+		}
+		oldCounters, err := from.PublicKeyIndices(id)
+		if err != nil {
+			return err
+		}
+		counters, err := to.PublicKeyIndices(id)
+		if err != nil {
+			return err
+		}
+		newCounters := map[uint]struct{}{}
+		for _, counter := range counters {
+			newCounters[counter] = struct{}{}
+		}
+		for _, counter := range oldCounters {
+			keyid := PublicKeyIdentifier{Issuer: id, Counter: counter}
+			if _, ok := newCounters[counter]; !ok {
+				diff.add(SchemeChangeRemoved, keyid, true, "public key removed")
+				continue
+			}
+			delete(newCounters, counter)
+			oldPk, err := from.PublicKey(id, counter)
+			if err != nil {
+				return err
+			}
+			pk, err := to.PublicKey(id, counter)
+			if err != nil {
+				return err
+			}
+			if oldPk == nil || pk == nil {
+				return errors.Errorf("public key %s could not be parsed", keyid)
+			}
+			if oldPk.N.Cmp(pk.N) != 0 {
+				diff.add(SchemeChangeChanged, keyid, true, "public key replaced")
+			} else if oldPk.ExpiryDate != pk.ExpiryDate {
+				diff.add(SchemeChangeChanged, keyid, false, "expiry date changed from %s to %s",
+					time.Unix(oldPk.ExpiryDate, 0).UTC().Format(time.RFC3339), time.Unix(pk.ExpiryDate, 0).UTC().Format(time.RFC3339))
+			}
+		}
+		for counter := range newCounters {
+			diff.add(SchemeChangeAdded, PublicKeyIdentifier{Issuer: id, Counter: counter}, false, "public key added")
+		}
+	}
+	for id := range to.Issuers {
+		if _, ok := from.Issuers[id]; ok {
+			continue
+		}
+		counters, err := to.PublicKeyIndices(id)
+		if err != nil {
+			return err
+		}
+		for _, counter := range counters {
+			diff.add(SchemeChangeAdded, PublicKeyIdentifier{Issuer: id, Counter: counter}, false, "public key added")
+		}
+	}
+	return nil
+}
+
+func (diff *SchemeDiff) diffCredentialTypes(from, to *Configuration) {
+	for id, old := range from.CredentialTypes {
+		credtype, ok := to.CredentialTypes[id]
+		if !ok {
+			diff.add(SchemeChangeRemoved, id, true, "credential type removed")
+			continue
+		}
+		if old.RevocationSupported() != credtype.RevocationSupported() {
+			if credtype.RevocationSupported() {
+				diff.add(SchemeChangeChanged, id, true, "revocation support added")
+			} else {
+				diff.add(SchemeChangeChanged, id, true, "revocation support removed")
+			}
+		} else if !reflect.DeepEqual(old.RevocationServers, credtype.RevocationServers) {
+			diff.add(SchemeChangeChanged, id, false, "revocation servers changed from %v to %v", old.RevocationServers, credtype.RevocationServers)
+		}
+		if old.IsSingleton != credtype.IsSingleton {
+			diff.add(SchemeChangeChanged, id, false, "singleton changed from %t to %t", old.IsSingleton, credtype.IsSingleton)
+		}
+		if !timestampEqual(old.DeprecatedSince, credtype.DeprecatedSince) {
+			diff.add(SchemeChangeChanged, id, false, "deprecated since %s", credtype.DeprecatedSince.String())
+		}
+		if !reflect.DeepEqual(old.Dependencies, credtype.Dependencies) {
+			diff.add(SchemeChangeChanged, id, false, "dependencies changed")
+		}
+		diff.diffAttributes(old, credtype)
+	}
+	for id := range to.CredentialTypes {
+		if _, ok := from.CredentialTypes[id]; !ok {
+			diff.add(SchemeChangeAdded, id, false, "credential type added")
+		}
+	}
+}
+
+// diffAttributes reports the changes between the attributes of two versions of a credential type.
+// Existing credentials break if attributes are removed or moved to another index, if optional
+// attributes become required, if attributes are added that are required or not at the end, and
+// if random blind attributes change. Revocation attributes are covered by the revocation support
+// of the credential type.
+func (diff *SchemeDiff) diffAttributes(from, to *CredentialType) {
+	indices := map[string]int{}
+	for i, attr := range to.AttributeTypes {
+		if !attr.RevocationAttribute {
+			indices[attr.ID] = i
+		}
+	}
+	oldIndices := map[string]int{}
+	for i, old := range from.AttributeTypes {
+		if old.RevocationAttribute {
+			continue
+		}
+		oldIndices[old.ID] = i
+		id := old.GetAttributeTypeIdentifier()
+		j, ok := indices[old.ID]
+		if !ok {
+			diff.add(SchemeChangeRemoved, id, true, "attribute removed")
+			continue
+		}
+		attr := to.AttributeTypes[j]
+		if i != j {
+			diff.add(SchemeChangeChanged, id, true, "attribute moved from index %d to %d", i, j)
+		}
+		if old.IsOptional() != attr.IsOptional() {
+			diff.add(SchemeChangeChanged, id, !attr.IsOptional(), "optional changed from %t to %t", old.IsOptional(), attr.IsOptional())
+		}
+		if old.RandomBlind != attr.RandomBlind {
+			diff.add(SchemeChangeChanged, id, true, "random blind changed from %t to %t", old.RandomBlind, attr.RandomBlind)
+		}
+	}
+	for j, attr := range to.AttributeTypes {
+		if _, ok := oldIndices[attr.ID]; ok || attr.RevocationAttribute {
+			continue
+		}
+		appended := j >= len(from.AttributeTypes)
+		diff.add(SchemeChangeAdded, attr.GetAttributeTypeIdentifier(), !appended || !attr.IsOptional(),
+			"attribute added at index %d", j)
+	}
+}
+
+func (diff *SchemeDiff) diffIssueWizards(from, to *Configuration) error {
+	for id, old := range from.IssueWizards {
+		wizard, ok := to.IssueWizards[id]
+		if !ok {
+			diff.add(SchemeChangeRemoved, id, true, "issue wizard removed")
+			continue
+		}
+		equal, err := issueWizardsEqual(old, wizard)
+		if err != nil {
+			return err
+		}
+		if !equal {
+			diff.add(SchemeChangeChanged, id, false, "issue wizard changed")
+		}
+	}
+	for id := range to.IssueWizards {
+		if _, ok := from.IssueWizards[id]; !ok {
+			diff.add(SchemeChangeAdded, id, false, "issue wizard added")
+		}
+	}
+	return nil
+}
+
+// issueWizardsEqual compares the issue wizards, except for the paths on disk of their logos.
+func issueWizardsEqual(a, b *IssueWizard) (bool, error) {
+	copyA, copyB := *a, *b
+	copyA.LogoPath, copyB.LogoPath = nil, nil
+	btsA, err := json.Marshal(copyA)
+	if err != nil {
+		return false, err
+	}
+	btsB, err := json.Marshal(copyB)
+	if err != nil {
+		return false, err
+	}
+	return string(btsA) == string(btsB), nil
+}
+
+func timestampEqual(a, b Timestamp) bool {
+	return time.Time(a).Equal(time.Time(b))
+}
@@ -0,0 +1,140 @@
+package irma
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// SchemeFiles contains the files of a scheme that are downloaded from its URL when it is installed
+// or updated, keyed by their path relative to the scheme directory (using forward slashes).
+type SchemeFiles struct {
+	ID        string
+	Timestamp Timestamp
+	Files     map[string][]byte
+}
+
+// SignedSchemeFiles reads the files that are downloaded from the URL of each valid scheme in the
+// configuration, for serving them from another location: the index, its signature, the public key
+// of the scheme, all files present in the index, and requestor logos. The signature of the index is
+// verified, and the files are verified against the index. Private keys are included only for demo
+// schemes, which publish them.
+func (conf *Configuration) SignedSchemeFiles() ([]*SchemeFiles, error) {
+	var schemes []Scheme
+	for _, scheme := range conf.SchemeManagers {
+		if scheme.Status == SchemeManagerStatusValid {
+			schemes = append(schemes, scheme)
+		}
+	}
+	for _, scheme := range conf.RequestorSchemes {
+		if scheme.Status == SchemeManagerStatusValid {
+			schemes = append(schemes, scheme)
+		}
+	}
+
+	result := make([]*SchemeFiles, 0, len(schemes))
+	for _, scheme := range schemes {
+		files, err := conf.signedSchemeFiles(scheme)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "failed to read files of scheme "+scheme.id(), 0)
+		}
+		result = append(result, files)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (conf *Configuration) signedSchemeFiles(scheme Scheme) (*SchemeFiles, error) {
+	dir := scheme.path()
+	if err := conf.verifySignature(dir); err != nil {
+		return nil, err
+	}
+
+	result := &SchemeFiles{ID: scheme.id(), Timestamp: scheme.timestamp(), Files: map[string][]byte{}}
+	for _, filename := range []string{"index", "index.sig", "pk.pem"} {
+		bts, err := ioutil.ReadFile(filepath.Join(dir, filename))
+		if err != nil {
+			return nil, err
+		}
+		result.Files[filename] = bts
+	}
+	index := SchemeManagerIndex(make(map[string]SchemeFileHash))
+	if err := index.FromString(string(result.Files["index"])); err != nil {
+		return nil, err
+	}
+	if index.Scheme() != result.ID {
+		return nil, errors.Errorf("index of scheme %s is of scheme %s", result.ID, index.Scheme())
+	}
+
+	for path, hash := range index {
+		path = path[len(result.ID)+1:] // strip scheme name
+		exists, err := common.PathExists(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		bts, err := conf.readHashedFile(filepath.Join(dir, filepath.FromSlash(path)), hash)
+		if err != nil {
+			return nil, err
+		}
+		result.Files[path] = bts
+	}
+
+	// Requestor logos are not in the index, but are named after their SHA256 hash
+	logos, err := filepath.Glob(filepath.Join(dir, "assets", "*.png"))
+	if err != nil {
+		return nil, err
+	}
+	for _, logo := range logos {
+		bts, err := ioutil.ReadFile(logo)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(bts)
+		if strings.TrimSuffix(filepath.Base(logo), ".png") == hex.EncodeToString(hash[:]) {
+			result.Files["assets/"+filepath.Base(logo)] = bts
+		}
+	}
+
+	if sm, ok := scheme.(*SchemeManager); ok && sm.Demo {
+		if err = readDemoPrivateKeys(dir, result.Files); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// readDemoPrivateKeys adds the scheme and issuer private keys in the scheme directory to files,
+// as far as they are present.
+func readDemoPrivateKeys(dir string, files map[string][]byte) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "PrivateKeys", "*.xml"))
+	if err != nil {
+		return err
+	}
+	if exists, err := common.PathExists(filepath.Join(dir, "sk.pem")); err != nil {
+		return err
+	} else if exists {
+		paths = append(paths, filepath.Join(dir, "sk.pem"))
+	}
+	for _, path := range paths {
+		bts, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = bts
+	}
+	return nil
+}
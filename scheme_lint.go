@@ -0,0 +1,464 @@
+package irma
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// SchemeLintSeverity is the severity of a SchemeLintIssue.
+type SchemeLintSeverity string
+
+const (
+	// SchemeLintError is the severity of problems that make the scheme invalid, or that keep
+	// (parts of) it from being installed or used.
+	SchemeLintError SchemeLintSeverity = "error"
+	// SchemeLintWarning is the severity of problems that do not invalidate the scheme.
+	SchemeLintWarning SchemeLintSeverity = "warning"
+)
+
+const (
+	// maxLogoSize is the file size in bytes above which logos are reported as oversized.
+	maxLogoSize = 256 * 1024
+	// maxLogoDimension is the width and height in pixels above which logos are reported as oversized.
+	maxLogoDimension = 512
+)
+
+// SchemeLintIssue is a problem in a scheme found by LintScheme.
+type SchemeLintIssue struct {
+	Severity SchemeLintSeverity `json:"severity"`
+	// File is the path of the file containing the problem, relative to the scheme directory and
+	// using forward slashes.
+	File string `json:"file"`
+	// Line is the line in File at which the problem occurs, or 0 if it is not known.
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (issue *SchemeLintIssue) String() string {
+	pos := issue.File
+	if issue.Line > 0 {
+		pos += ":" + strconv.Itoa(issue.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s", pos, issue.Severity, issue.Message)
+}
+
+type schemeLinter struct {
+	conf   *Configuration
+	dir    string
+	issues []*SchemeLintIssue
+	files  map[string][]byte // contents of files, for finding line numbers
+}
+
+// LintScheme checks the (issuer or requestor) scheme in dir for problems, returning all of them
+// instead of stopping at the first one like ParseSchemeFolder does. Apart from the errors and
+// warnings of ParseSchemeFolder and ValidateKeys, it reports files that are not signed or that
+// were modified after signing, logos that are missing, invalid or oversized, and public keys that
+// expired or expire soon. The scheme need not have been signed. Issue wizards are validated
+// against the credential types in conf, so the schemes containing those must be parsed into conf
+// beforehand. An error is returned only if the scheme could not be checked at all; conf is not
+// modified.
+func (conf *Configuration) LintScheme(dir string) ([]*SchemeLintIssue, error) {
+	// conf.Warnings is exported, so it need not have the same length as conf.warnings
+	nWarnings, nwarnings := len(conf.Warnings), len(conf.warnings)
+	defer func() {
+		conf.Warnings = conf.Warnings[:nWarnings]
+		conf.warnings = conf.warnings[:nwarnings]
+	}()
+
+	filename, err := common.SchemeFilename(dir)
+	if err != nil {
+		return nil, err
+	}
+	bts, err := ioutil.ReadFile(filepath.Join(dir, filename))
+	if err != nil {
+		return nil, err
+	}
+	l := &schemeLinter{conf: conf, dir: dir, files: map[string][]byte{}}
+	_, typ, err := common.SchemeInfo(filename, bts)
+	if err != nil {
+		l.addError(filepath.Join(dir, filename), err)
+		return l.issues, nil
+	}
+
+	if err = l.lintSignature(); err != nil {
+		return nil, err
+	}
+	switch SchemeType(typ) {
+	case SchemeTypeIssuer:
+		err = l.lintIssuerScheme()
+	case SchemeTypeRequestor:
+		err = l.lintRequestorScheme()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = l.lintLogos(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(l.issues, func(i, j int) bool {
+		a, b := l.issues[i], l.issues[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Message < b.Message
+	})
+	return l.issues, nil
+}
+
+// lintSignature checks the signature and timestamp of the scheme, and that all files in it are
+// signed and unmodified.
+func (l *schemeLinter) lintSignature() error {
+	exists, err := common.PathExists(filepath.Join(l.dir, "index"))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		l.add(SchemeLintError, "index", 0, "scheme is not signed: index not found")
+		return nil
+	}
+	if err = l.conf.verifySignature(l.dir); err != nil {
+		l.add(SchemeLintError, "index.sig", 0, "%s", err.Error())
+	}
+	if _, exists, err = readTimestamp(filepath.Join(l.dir, "timestamp")); err != nil {
+		l.add(SchemeLintError, "timestamp", 0, "failed to parse timestamp: %s", err.Error())
+	} else if !exists {
+		l.add(SchemeLintError, "timestamp", 0, "scheme has no timestamp")
+	}
+
+	bts, err := ioutil.ReadFile(filepath.Join(l.dir, "index"))
+	if err != nil {
+		return err
+	}
+	index := SchemeManagerIndex(make(map[string]SchemeFileHash))
+	if err = index.FromString(string(bts)); err != nil {
+		l.add(SchemeLintError, "index", 0, "failed to parse index: %s", err.Error())
+		return nil
+	}
+	for path, hash := range index {
+		path = strings.TrimPrefix(path, index.Scheme()+"/")
+		exists, err = common.PathExists(filepath.Join(l.dir, filepath.FromSlash(path)))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			l.add(SchemeLintWarning, path, 0, "file is in the index but does not exist")
+			continue
+		}
+		if _, err = l.conf.readHashedFile(filepath.Join(l.dir, filepath.FromSlash(path)), hash); err != nil {
+			l.add(SchemeLintError, path, 0, "file was modified after the scheme was signed")
+		}
+	}
+
+	return walkUnsignedFiles(l.dir, index, func(schemepath string, info os.FileInfo) {
+		if !info.IsDir() {
+			path := strings.TrimPrefix(filepath.ToSlash(schemepath), index.Scheme()+"/")
+			l.add(SchemeLintError, path, 0, "file is not signed: it is not in the index")
+		}
+	})
+}
+
+// lintIssuerScheme checks the descriptions of the issuer scheme, its issuers and their credential
+// types, and the public keys of the issuers.
+func (l *schemeLinter) lintIssuerScheme() error {
+	err := l.conf.walkSchemeDescriptions(l.dir, func(path string, warnings []schemeWarning, err error) error {
+		l.addWarnings(path, "", warnings)
+		if err != nil {
+			l.addError(path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return common.IterateSubfolders(l.dir, func(issuerdir string, _ os.FileInfo) error {
+		issuer := &Issuer{}
+		if exists, err := readDescription(filepath.Join(issuerdir, "description.xml"), issuer); err != nil || !exists {
+			return nil // already reported above
+		}
+		return l.lintPublicKeys(issuer, issuerdir)
+	})
+}
+
+// lintPublicKeys checks that the public keys of the issuer can be parsed and are correctly named,
+// and that the latest one has not expired and does not expire soon unless the issuer is deprecated.
+func (l *schemeLinter) lintPublicKeys(issuer *Issuer, issuerdir string) error {
+	files, err := filepath.Glob(filepath.Join(issuerdir, "PublicKeys", "*"))
+	if err != nil {
+		return err
+	}
+	var (
+		latest     *gabikeys.PublicKey
+		latestFile string
+	)
+	for _, file := range files {
+		counter, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file), ".xml"), 10, 32)
+		if err != nil || filepath.Ext(file) != ".xml" {
+			l.add(SchemeLintError, file, 0, "public key file name must be of the form <counter>.xml")
+			continue
+		}
+		bts, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		pk, err := gabikeys.NewPublicKeyFromBytes(bts)
+		if err != nil {
+			l.addError(file, errors.WrapPrefix(err, "failed to parse public key", 0))
+			continue
+		}
+		if pk.Counter != uint(counter) {
+			l.add(SchemeLintError, file, l.line(file, "", "<Counter>"), "public key has wrong <Counter> %d", pk.Counter)
+			continue
+		}
+		if latest == nil || pk.Counter > latest.Counter {
+			latest, latestFile = pk, file
+		}
+	}
+
+	now := time.Now()
+	if latest == nil || !(issuer.DeprecatedSince.IsZero() || issuer.DeprecatedSince.After(Timestamp(now))) {
+		return nil
+	}
+	line := l.line(latestFile, "", "<ExpiryDate>")
+	expiry := time.Unix(latest.ExpiryDate, 0)
+	if latest.ExpiryDate < now.Unix() {
+		l.add(SchemeLintWarning, latestFile, line, "Issuer %s has no nonexpired public keys: latest public key expired at %s",
+			issuer.Identifier().String(), expiry.String())
+	} else if latest.ExpiryDate < now.Unix()+expiryBoundary {
+		l.add(SchemeLintWarning, latestFile, line, "Latest public key of issuer %s expires soon (at %s)",
+			issuer.Identifier().String(), expiry.String())
+	}
+	return nil
+}
+
+// lintRequestorScheme checks the description of the requestor scheme, and the requestors and
+// issue wizards in the other JSON files of the scheme.
+func (l *schemeLinter) lintRequestorScheme() error {
+	scheme := &RequestorScheme{storagepath: l.dir}
+	if _, err := readDescription(filepath.Join(l.dir, "description.json"), scheme); err != nil {
+		l.addError(filepath.Join(l.dir, "description.json"), err)
+		return nil
+	}
+
+	return common.WalkDir(l.dir, func(path string, info os.FileInfo) error {
+		if info.IsDir() || filepath.Ext(path) != ".json" || path == filepath.Join(l.dir, "description.json") {
+			return nil
+		}
+		var chunk RequestorChunk
+		if _, err := readDescription(path, &chunk); err != nil {
+			l.addError(path, err)
+			return nil
+		}
+		for _, requestor := range chunk {
+			l.lintRequestor(scheme, path, requestor)
+		}
+		return nil
+	})
+}
+
+func (l *schemeLinter) lintRequestor(scheme *RequestorScheme, file string, requestor *RequestorInfo) {
+	anchor := `"` + requestor.ID.String() + `"`
+	line := l.line(file, "", anchor)
+
+	l.addWarnings(file, anchor, validateTranslations(fmt.Sprintf("Requestor %s", requestor.ID), requestor))
+	if requestor.Scheme != scheme.ID {
+		l.add(SchemeLintError, file, line, "Requestor %s has incorrect scheme %s", requestor.ID, requestor.Scheme)
+	}
+	if requestor.ID.RequestorSchemeIdentifier() != scheme.ID {
+		l.add(SchemeLintError, file, line, "requestor %s has incorrect ID", requestor.ID)
+	}
+	if scheme.Demo && len(requestor.Hostnames) > 0 {
+		l.add(SchemeLintError, file, line, "Demo requestor %s has hostnames: only allowed for non-demo schemes", requestor.ID)
+	}
+	if requestor.Logo != nil {
+		l.lintRequestorLogo(file, line, *requestor.Logo)
+	}
+
+	for id, wizard := range requestor.Wizards {
+		anchor := `"` + id.String() + `"`
+		line := l.line(file, "", anchor)
+		if id != wizard.ID || id.RequestorIdentifier() != requestor.ID {
+			l.add(SchemeLintError, file, line, "issue wizard %s has incorrect ID", id)
+		}
+		n := len(l.conf.warnings)
+		err := wizard.Validate(l.conf)
+		warnings := append([]schemeWarning(nil), l.conf.warnings[n:]...)
+		for i := range warnings {
+			if !strings.Contains(warnings[i].message, id.String()) {
+				warnings[i].message = fmt.Sprintf("issue wizard %s: %s", id, warnings[i].message)
+			}
+		}
+		l.addWarnings(file, anchor, warnings)
+		if err != nil {
+			l.add(SchemeLintError, file, line, "issue wizard %s: %s", id, err.Error())
+		}
+		if wizard.Logo != nil {
+			l.lintRequestorLogo(file, line, *wizard.Logo)
+		}
+	}
+}
+
+// lintRequestorLogo checks that the logo of a requestor or issue wizard exists in the assets
+// directory, and is named after its SHA256 hash.
+func (l *schemeLinter) lintRequestorLogo(file string, line int, logo string) {
+	hash, err := hex.DecodeString(logo)
+	if err != nil || len(hash) != sha256.Size {
+		l.add(SchemeLintError, file, line, "logo %s is not a SHA256 hash", logo)
+		return
+	}
+	path := filepath.Join(l.dir, "assets", logo+".png")
+	if exists, err := common.PathExists(path); err != nil || !exists {
+		l.add(SchemeLintError, file, line, "logo %s not found in assets", logo)
+		return
+	}
+	if _, err = l.conf.readHashedFile(path, hash); err != nil {
+		l.add(SchemeLintError, path, 0, "logo does not match the SHA256 hash in its file name")
+	}
+}
+
+// lintLogos checks that all PNG files in the scheme are valid and not oversized.
+func (l *schemeLinter) lintLogos() error {
+	return common.WalkDir(l.dir, func(path string, info os.FileInfo) error {
+		if info.IsDir() || filepath.Ext(path) != ".png" {
+			return nil
+		}
+		if info.Size() > maxLogoSize {
+			l.add(SchemeLintWarning, path, 0, "logo is %d KiB, should be at most %d KiB", info.Size()/1024, maxLogoSize/1024)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer common.Close(f)
+		config, err := png.DecodeConfig(f)
+		if err != nil {
+			l.add(SchemeLintError, path, 0, "logo is not a valid PNG image: %s", err.Error())
+			return nil
+		}
+		if config.Width > maxLogoDimension || config.Height > maxLogoDimension {
+			l.add(SchemeLintWarning, path, 0, "logo is %dx%d pixels, should be at most %dx%d",
+				config.Width, config.Height, maxLogoDimension, maxLogoDimension)
+		}
+		return nil
+	})
+}
+
+func (l *schemeLinter) add(severity SchemeLintSeverity, file string, line int, format string, args ...interface{}) {
+	if filepath.IsAbs(file) {
+		if rel, err := filepath.Rel(l.dir, file); err == nil {
+			file = rel
+		}
+	}
+	l.issues = append(l.issues, &SchemeLintIssue{
+		Severity: severity,
+		File:     filepath.ToSlash(file),
+		Line:     line,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// addError adds an error for the file, determining the line from syntax errors or the tag that
+// the error is about.
+func (l *schemeLinter) addError(file string, err error) {
+	line := 0
+	cause := err
+	if e, ok := cause.(*errors.Error); ok {
+		cause = e.Err
+	}
+	switch e := cause.(type) {
+	case *xml.SyntaxError:
+		line = e.Line
+	case *json.SyntaxError:
+		line = l.offsetLine(file, e.Offset)
+	case *json.UnmarshalTypeError:
+		line = l.offsetLine(file, e.Offset)
+	case *schemeTagError:
+		line = l.tagLine(file, "", e.tag)
+	}
+	l.add(SchemeLintError, file, line, "%s", err.Error())
+}
+
+// addWarnings adds warnings for the file, finding the lines of the tags they are about after the
+// first occurrence of anchor, or of the attribute type they are about.
+func (l *schemeLinter) addWarnings(file, anchor string, warnings []schemeWarning) {
+	for _, warning := range warnings {
+		line := 0
+		if warning.tag != "" {
+			a := anchor
+			if warning.attribute != "" {
+				a = `id="` + warning.attribute + `"`
+			}
+			line = l.tagLine(file, a, warning.tag)
+		}
+		if line == 0 && anchor != "" {
+			line = l.line(file, "", anchor)
+		}
+		l.add(SchemeLintWarning, file, line, "%s", warning.message)
+	}
+}
+
+// tagLine returns the line of the XML tag in the file after the first occurrence of anchor,
+// or 0 if not found.
+func (l *schemeLinter) tagLine(file, anchor, tag string) int {
+	if line := l.line(file, anchor, "<"+tag+">"); line > 0 {
+		return line
+	}
+	return l.line(file, anchor, "<"+tag+" ")
+}
+
+// line returns the line of the first occurrence of needle in the file after the first occurrence
+// of anchor, or 0 if not found.
+func (l *schemeLinter) line(file, anchor, needle string) int {
+	bts := l.read(file)
+	start := 0
+	if anchor != "" {
+		if start = bytes.Index(bts, []byte(anchor)); start < 0 {
+			return 0
+		}
+	}
+	i := bytes.Index(bts[start:], []byte(needle))
+	if i < 0 {
+		return 0
+	}
+	return bytes.Count(bts[:start+i], []byte("\n")) + 1
+}
+
+func (l *schemeLinter) offsetLine(file string, offset int64) int {
+	bts := l.read(file)
+	if offset > int64(len(bts)) {
+		return 0
+	}
+	return bytes.Count(bts[:offset], []byte("\n")) + 1
+}
+
+func (l *schemeLinter) read(file string) []byte {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(l.dir, file)
+	}
+	bts, ok := l.files[file]
+	if !ok {
+		bts, _ = ioutil.ReadFile(file)
+		l.files[file] = bts
+	}
+	return bts
+}
@@ -280,6 +280,89 @@ func (conf *Configuration) ParseSchemeFolder(dir string) (scheme Scheme, serr er
 	return
 }
 
+// ValidateSchemeDescriptions parses the description.xml files of the issuer scheme in dir and of
+// its issuers and credential types, and performs the same consistency checks on them as
+// ParseSchemeFolder. Unlike ParseSchemeFolder it does not require an index, signature or timestamp,
+// so that it can be used on schemes that are being authored and have not yet been signed.
+// Problems that do not invalidate the scheme are appended to conf.Warnings.
+func (conf *Configuration) ValidateSchemeDescriptions(dir string) error {
+	return conf.walkSchemeDescriptions(dir, func(_ string, _ []schemeWarning, err error) error {
+		return err
+	})
+}
+
+// walkSchemeDescriptions parses and validates the description.xml files of the issuer scheme in
+// dir and of its issuers and credential types. For each file, handle is called with the path of
+// the file, the warnings that were appended to conf.Warnings while validating it, and the error
+// that invalidates it if any. Walking stops if handle returns an error. Credential types of issuers
+// that could not be parsed are skipped, as well as the issuers if the scheme could not be parsed.
+func (conf *Configuration) walkSchemeDescriptions(dir string, handle func(path string, warnings []schemeWarning, err error) error) error {
+	check := func(path string, validate func() error) error {
+		n := len(conf.warnings)
+		err := validate()
+		return handle(path, conf.warnings[n:], err)
+	}
+
+	scheme := &SchemeManager{storagepath: dir}
+	path := filepath.Join(dir, "description.xml")
+	var parsed bool
+	err := check(path, func() error {
+		exists, err := readDescription(path, scheme)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errors.Errorf("%s contains no scheme description.xml", dir)
+		}
+		parsed = true
+		return scheme.validateDescription(conf)
+	})
+	if err != nil || !parsed {
+		return err
+	}
+
+	return common.IterateSubfolders(dir, func(issuerdir string, _ os.FileInfo) error {
+		issuer := &Issuer{}
+		path := filepath.Join(issuerdir, "description.xml")
+		var parsed bool
+		err := check(path, func() error {
+			exists, err := readDescription(path, issuer)
+			if err != nil || !exists {
+				return err
+			}
+			parsed = true
+			if issuer.XMLVersion < 4 {
+				return errors.New("Unsupported issuer description")
+			}
+			return conf.validateIssuer(scheme, issuer, issuerdir)
+		})
+		if err != nil || !parsed {
+			return err
+		}
+
+		var foundcred bool
+		err = common.IterateSubfolders(filepath.Join(issuerdir, "Issues"), func(creddir string, _ os.FileInfo) error {
+			cred := &CredentialType{}
+			path := filepath.Join(creddir, "description.xml")
+			return check(path, func() error {
+				exists, err := readDescription(path, cred)
+				if err != nil || !exists {
+					return err
+				}
+				foundcred = true
+				return conf.validateCredentialType(scheme, issuer, cred, creddir)
+			})
+		})
+		if err != nil || foundcred {
+			return err
+		}
+		return check(path, func() error {
+			conf.warnf("Issuer %s has no credential types", issuer.Identifier().String())
+			return nil
+		})
+	})
+}
+
 // Unexported scheme helpers that work for all scheme types (issuer or requestor) follow.
 // These deal with what schemes have in common: verifying the signature; authenticating
 // contained files against the (signed) index; and downloading, (re)installing
@@ -685,6 +768,22 @@ func (conf *Configuration) readHashedFile(path string, hash SchemeFileHash) ([]b
 	return bts, nil
 }
 
+// readDescription unmarshals the (unsigned) description file at path into description.
+func readDescription(path string, description interface{}) (bool, error) {
+	exists, err := common.PathExists(path)
+	if err != nil || !exists {
+		return false, err
+	}
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return true, err
+	}
+	if err = common.Unmarshal(filepath.Base(path), bts, description); err != nil {
+		return true, errors.WrapPrefix(err, "failed to parse "+path, 0)
+	}
+	return true, nil
+}
+
 // parseIndex parses the index file of the specified manager.
 func (conf *Configuration) parseIndex(dir string) (SchemeManagerIndex, error, SchemeManagerStatus) {
 	if err := conf.verifySignature(dir); err != nil {
@@ -709,6 +808,18 @@ func (conf *Configuration) parseIndex(dir string) (SchemeManagerIndex, error, Sc
 }
 
 func (conf *Configuration) checkUnsignedFiles(dir string, index SchemeManagerIndex) error {
+	return walkUnsignedFiles(dir, index, func(schemepath string, info os.FileInfo) {
+		if info.IsDir() {
+			conf.warnf("Ignored dir: %s", schemepath)
+		} else {
+			conf.warnf("Ignored file: %s", schemepath)
+		}
+	})
+}
+
+// walkUnsignedFiles calls handle for each file and directory in the scheme in dir that is not in
+// its index, and not excepted from it by sigExceptions.
+func walkUnsignedFiles(dir string, index SchemeManagerIndex, handle func(schemepath string, info os.FileInfo)) error {
 	return common.WalkDir(dir, func(path string, info os.FileInfo) error {
 		relpath, err := filepath.Rel(dir, path)
 		if err != nil {
@@ -723,11 +834,11 @@ func (conf *Configuration) checkUnsignedFiles(dir string, index SchemeManagerInd
 
 		if info.IsDir() {
 			if !dirInScheme(index, schemepath) {
-				conf.Warnings = append(conf.Warnings, "Ignored dir: "+schemepath)
+				handle(schemepath, info)
 			}
 		} else {
 			if _, ok := index[schemepath]; !ok {
-				conf.Warnings = append(conf.Warnings, "Ignored file: "+schemepath)
+				handle(schemepath, info)
 			}
 		}
 
@@ -1009,15 +1120,9 @@ func (d DependencyChain) String() string {
 }
 
 func (scheme *SchemeManager) validate(conf *Configuration) (error, SchemeManagerStatus) {
-	if scheme.XMLVersion < 7 {
-		return errors.New("Unsupported scheme manager description"), SchemeManagerStatusParsingError
-	}
-	if scheme.KeyshareServer != "" {
-		if err := common.AssertPathExists(filepath.Join(scheme.path(), "kss-0.pem")); err != nil {
-			return errors.Errorf("Scheme %s has keyshare URL but no keyshare public key kss-0.pem", scheme.ID), SchemeManagerStatusParsingError
-		}
+	if err := scheme.validateDescription(conf); err != nil {
+		return err, SchemeManagerStatusParsingError
 	}
-	conf.validateTranslations(fmt.Sprintf("Scheme %s", scheme.ID), scheme)
 
 	// Verify that all other files are validly signed
 	if err := scheme.verifyFiles(conf); err != nil {
@@ -1027,6 +1132,19 @@ func (scheme *SchemeManager) validate(conf *Configuration) (error, SchemeManager
 	return nil, SchemeManagerStatusValid
 }
 
+func (scheme *SchemeManager) validateDescription(conf *Configuration) error {
+	if scheme.XMLVersion < 7 {
+		return errors.New("Unsupported scheme manager description")
+	}
+	if scheme.KeyshareServer != "" {
+		if err := common.AssertPathExists(filepath.Join(scheme.path(), "kss-0.pem")); err != nil {
+			return errors.Errorf("Scheme %s has keyshare URL but no keyshare public key kss-0.pem", scheme.ID)
+		}
+	}
+	conf.warn(validateTranslations(fmt.Sprintf("Scheme %s", scheme.ID), scheme)...)
+	return nil
+}
+
 func (scheme *SchemeManager) update() error {
 	return scheme.downloadDemoPrivateKeys()
 }
@@ -1212,7 +1330,7 @@ func (scheme *SchemeManager) parseCredentialsFolder(conf *Configuration, issuer
 		return nil
 	})
 	if !foundcred {
-		conf.Warnings = append(conf.Warnings, fmt.Sprintf("Issuer %s has no credential types", issuer.Identifier().String()))
+		conf.warnf("Issuer %s has no credential types", issuer.Identifier().String())
 	}
 	return err
 }
@@ -2,7 +2,9 @@ package server
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -12,6 +14,7 @@ import (
 	"reflect"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
@@ -347,30 +350,106 @@ func ResultJwt(sessionresult *SessionResult, issuer string, validity int, privat
 	return token.SignedString(privatekey)
 }
 
+// CallbackSignatureHeader is the HTTP header containing the HMAC-SHA256 signature over session
+// results POSTed to callback URLs, if a callback HMAC key is configured. It has the form
+// "t=<unix timestamp>,v1=<hex-encoded HMAC over the timestamp, a dot, and the request body>".
+const CallbackSignatureHeader = "X-IRMA-Signature"
+
+// CallbackSignature computes the value of the CallbackSignatureHeader for the specified body.
+func CallbackSignature(key []byte, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(callbackMAC(key, ts, body)))
+}
+
+// VerifyCallbackSignature verifies the value of the CallbackSignatureHeader of a session result
+// POSTed to a callback URL, rejecting signatures that are older than maxAge.
+func VerifyCallbackSignature(key []byte, header string, body []byte, maxAge time.Duration) error {
+	var ts, mac string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return errors.New("malformed callback signature")
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			mac = kv[1]
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("malformed callback signature timestamp")
+	}
+	if time.Since(time.Unix(unix, 0)) > maxAge {
+		return errors.New("callback signature expired")
+	}
+	macbts, err := hex.DecodeString(mac)
+	if err != nil {
+		return errors.New("malformed callback signature")
+	}
+	if !hmac.Equal(macbts, callbackMAC(key, ts, body)) {
+		return errors.New("invalid callback signature")
+	}
+	return nil
+}
+
+func callbackMAC(key []byte, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(timestamp + "."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// DoResultCallback POSTs the session result to the callback URL once, as a JWT if a private key
+// is specified, logging any error.
 func DoResultCallback(callbackUrl string, result *SessionResult, issuer string, validity int, privatekey *rsa.PrivateKey) {
-	logger := Logger.WithFields(logrus.Fields{"session": result.Token, "callbackUrl": callbackUrl})
+	conf := &Configuration{JwtIssuer: issuer, JwtRSAPrivateKey: privatekey, Logger: Logger}
+	if err := PostResultCallback(conf, callbackUrl, result, validity, nil); err != nil {
+		// not our problem, log it and go on
+		Logger.WithFields(logrus.Fields{"session": result.Token, "callbackUrl": callbackUrl}).
+			Warn(errors.WrapPrefix(err, "Failed to POST session result to callback URL", 0))
+	}
+}
+
+// PostResultCallback POSTs the session result to the callback URL once, as a JWT if the
+// configuration contains a JWT private key, and signed with the callback HMAC key if configured.
+// The specified headers are included in the request.
+func PostResultCallback(conf *Configuration, callbackUrl string, result *SessionResult, validity int, headers map[string]string) error {
+	logger := conf.Logger.WithFields(logrus.Fields{"session": result.Token, "callbackUrl": callbackUrl})
 	if !strings.HasPrefix(callbackUrl, "https") {
 		logger.Warn("POSTing session result to callback URL without TLS: attributes are unencrypted in traffic")
 	} else {
 		logger.Debug("POSTing session result")
 	}
 
-	var res interface{}
-	if privatekey != nil {
-		var err error
-		res, err = ResultJwt(result, issuer, validity, privatekey)
-		if err != nil {
-			_ = LogError(errors.WrapPrefix(err, "Failed to create JWT for result callback", 0))
-			return
+	// The body is signed as it is sent, so we marshal it ourselves
+	var (
+		body interface{}
+		bts  []byte
+		err  error
+	)
+	if conf.JwtRSAPrivateKey != nil {
+		var j string
+		if j, err = ResultJwt(result, conf.JwtIssuer, validity, conf.JwtRSAPrivateKey); err != nil {
+			return errors.WrapPrefix(err, "Failed to create JWT for result callback", 0)
 		}
+		body, bts = j, []byte(j)
 	} else {
-		res = result
+		if bts, err = json.Marshal(result); err != nil {
+			return err
+		}
+		body = json.RawMessage(bts)
 	}
 
-	if err := irma.NewHTTPTransport(callbackUrl, false).Post("", nil, res); err != nil {
-		// not our problem, log it and go on
-		logger.Warn(errors.WrapPrefix(err, "Failed to POST session result to callback URL", 0))
+	transport := irma.NewHTTPTransport(callbackUrl, false)
+	for name, value := range headers {
+		transport.SetHeader(name, value)
+	}
+	if len(conf.CallbackHMACKeyBytes) > 0 {
+		transport.SetHeader(CallbackSignatureHeader, CallbackSignature(conf.CallbackHMACKeyBytes, time.Now(), bts))
 	}
+	return transport.Post("", nil, body)
 }
 
 func log(level logrus.Level, err error) error {
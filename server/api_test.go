@@ -168,3 +168,18 @@ func stopServer(t *testing.T, server *http.Server) {
 	require.NoError(t, server.Shutdown(ctx))
 	cancel()
 }
+
+func TestCallbackSignature(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	body := []byte(`{"token":"abc","status":"DONE"}`)
+
+	header := CallbackSignature(key, time.Now(), body)
+	require.NoError(t, VerifyCallbackSignature(key, header, body, time.Minute))
+
+	require.EqualError(t, VerifyCallbackSignature(key, header, []byte(`{"token":"abc","status":"CANCELLED"}`), time.Minute), "invalid callback signature")
+	require.EqualError(t, VerifyCallbackSignature([]byte("another key"), header, body, time.Minute), "invalid callback signature")
+	require.EqualError(t, VerifyCallbackSignature(key, "garbage", body, time.Minute), "malformed callback signature")
+
+	old := CallbackSignature(key, time.Now().Add(-time.Hour), body)
+	require.EqualError(t, VerifyCallbackSignature(key, old, body, time.Minute), "callback signature expired")
+}
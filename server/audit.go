@@ -0,0 +1,512 @@
+package server
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+// AuditLog is an append-only log of requestor activity: which requestor requested which attributes,
+// when, and with what outcome. Attribute values are never included. Each event contains an
+// HMAC-SHA256 over the event and the MAC of the previous event, so that without the key, removing,
+// reordering or modifying events afterwards can be detected using VerifyAuditLog.
+type AuditLog interface {
+	// Record assigns the next sequence number and the MACs to the event, and appends it to the log.
+	Record(event *AuditEvent) error
+	// Events returns all events in the log, in order.
+	Events() ([]*AuditEvent, error)
+	Close() error
+}
+
+type AuditEventType string
+
+// AuditEvent is an entry of the AuditLog.
+type AuditEvent struct {
+	Sequence    uint64              `json:"seq"`
+	Time        string              `json:"time"` // RFC 3339 in UTC, so that it survives storage unchanged
+	Type        AuditEventType      `json:"type"`
+	Requestor   string              `json:"requestor,omitempty"`
+	Session     irma.RequestorToken `json:"session,omitempty"`
+	Action      irma.Action         `json:"action,omitempty"`
+	Attributes  []string            `json:"attributes,omitempty"`  // attribute types requested for disclosure
+	Credentials []string            `json:"credentials,omitempty"` // credential types to be issued, or revoked
+	ProofStatus irma.ProofStatus    `json:"proofStatus,omitempty"`
+	Dropped     uint64              `json:"dropped,omitempty"` // amount of events dropped, for AuditEventDropped
+	PrevMAC     string              `json:"prevMac"`
+	MAC         string              `json:"mac,omitempty"`
+}
+
+const (
+	AuditEventCreated   AuditEventType = "created"
+	AuditEventConnected AuditEventType = "connected"
+	AuditEventDone      AuditEventType = "done"
+	AuditEventCancelled AuditEventType = "cancelled"
+	AuditEventTimeout   AuditEventType = "timeout"
+	AuditEventRevoked   AuditEventType = "revoked"
+	// AuditEventDropped records that events could not be written to the audit log, because
+	// too many events were waiting to be written.
+	AuditEventDropped AuditEventType = "dropped"
+
+	// auditGenesisMAC is the PrevMAC of the first event in an audit log.
+	auditGenesisMAC = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	// auditBufferSize is the amount of events that may wait to be written to the audit log.
+	auditBufferSize = 1024
+	// auditRecordTimeout is how long recording an event waits for room in the buffer, after
+	// which the event is dropped.
+	auditRecordTimeout = time.Second
+
+	sqlAuditMaxAttempts = 10
+)
+
+// AuditError is returned by VerifyAuditLog when the MAC chain of the audit log is broken.
+type AuditError struct {
+	Sequence uint64
+	Reason   string
+}
+
+func (err *AuditError) Error() string {
+	return fmt.Sprintf("audit log broken at event %d: %s", err.Sequence, err.Reason)
+}
+
+// NewAuditEvent returns an audit event of the specified type for the specified session request,
+// including the attribute types and credential types that it requests but no attribute values.
+func NewAuditEvent(typ AuditEventType, requestor string, token irma.RequestorToken, request irma.SessionRequest) *AuditEvent {
+	event := &AuditEvent{Type: typ, Requestor: requestor, Session: token}
+	if request == nil {
+		return event
+	}
+	event.Action = request.Action()
+
+	attrs := map[string]struct{}{}
+	_ = request.Disclosure().Disclose.Iterate(func(attr *irma.AttributeRequest) error {
+		attrs[attr.Type.String()] = struct{}{}
+		return nil
+	})
+	for attr := range attrs {
+		event.Attributes = append(event.Attributes, attr)
+	}
+	sort.Strings(event.Attributes)
+
+	if isreq, ok := request.(*irma.IssuanceRequest); ok {
+		for _, cred := range isreq.Credentials {
+			event.Credentials = append(event.Credentials, cred.CredentialTypeID.String())
+		}
+	}
+	return event
+}
+
+// Audit records the event in the configured audit log, if any. Events are written to the log in
+// the background; failures are logged but otherwise ignored, so that they don't affect the
+// sessions being audited.
+func (conf *Configuration) Audit(event *AuditEvent) {
+	if conf.AuditLog == nil {
+		return
+	}
+	if err := conf.AuditLog.Record(event); err != nil {
+		conf.Logger.WithFields(logrus.Fields{"session": event.Session, "type": event.Type}).
+			Error("Failed to record audit event: ", err.Error())
+	}
+}
+
+// computeMAC returns the hex-encoded HMAC-SHA256 with the specified key over the JSON
+// serialization of the event without its MAC, which includes the MAC of the previous event.
+func (event *AuditEvent) computeMAC(key []byte) (string, error) {
+	cpy := *event
+	cpy.MAC = ""
+	bts, err := json.Marshal(cpy)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(bts)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// chain links the event to the previous event, having the specified sequence number and MAC.
+func (event *AuditEvent) chain(key []byte, prevSequence uint64, prevMAC string) error {
+	if event.Time == "" {
+		event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	event.Sequence = prevSequence + 1
+	event.PrevMAC = prevMAC
+	var err error
+	event.MAC, err = event.computeMAC(key)
+	return err
+}
+
+// VerifyAuditLog checks, using the key with which the log was written, that the events form an
+// unbroken MAC chain starting at the first event of the log. If not, an *AuditError is returned.
+// Events that the server failed to write are not part of the chain, but are counted by events
+// of type AuditEventDropped (see DroppedAuditEvents).
+func VerifyAuditLog(events []*AuditEvent, key []byte) error {
+	if len(key) == 0 {
+		return errors.New("no audit log key specified")
+	}
+	prevMAC := auditGenesisMAC
+	for i, event := range events {
+		if event.Sequence != uint64(i)+1 {
+			return &AuditError{Sequence: uint64(i) + 1, Reason: "event missing or out of order"}
+		}
+		if event.PrevMAC != prevMAC {
+			return &AuditError{Sequence: event.Sequence, Reason: "MAC of previous event does not match"}
+		}
+		mac, err := event.computeMAC(key)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal([]byte(event.MAC), []byte(mac)) {
+			return &AuditError{Sequence: event.Sequence, Reason: "event was modified"}
+		}
+		prevMAC = event.MAC
+	}
+	return nil
+}
+
+// DroppedAuditEvents returns the events of type AuditEventDropped among the events, and the total
+// amount of events that they report were dropped.
+func DroppedAuditEvents(events []*AuditEvent) ([]*AuditEvent, uint64) {
+	var (
+		dropped []*AuditEvent
+		count   uint64
+	)
+	for _, event := range events {
+		if event.Type == AuditEventDropped {
+			dropped = append(dropped, event)
+			count += event.Dropped
+		}
+	}
+	return dropped, count
+}
+
+// asyncAuditLog records events in another AuditLog from a separate goroutine, so that writing the
+// log (which may involve syncing files or retrying database transactions) does not block the
+// sessions being audited. Events that are waiting to be written are written before it is closed.
+// If events have to be dropped because too many are waiting, an AuditEventDropped event counting
+// them is written in their place, so that the loss shows when verifying the log.
+type asyncAuditLog struct {
+	AuditLog
+	logger  *logrus.Logger
+	events  chan *AuditEvent
+	done    chan struct{}
+	timeout time.Duration
+	dropped uint64 // accessed atomically
+
+	lock   sync.RWMutex
+	closed bool
+}
+
+func newAsyncAuditLog(log AuditLog, logger *logrus.Logger) *asyncAuditLog {
+	l := &asyncAuditLog{
+		AuditLog: log,
+		logger:   logger,
+		events:   make(chan *AuditEvent, auditBufferSize),
+		done:     make(chan struct{}),
+		timeout:  auditRecordTimeout,
+	}
+	go l.write()
+	return l
+}
+
+func (l *asyncAuditLog) write() {
+	defer close(l.done)
+	for event := range l.events {
+		l.record(event)
+		l.recordDropped()
+	}
+	l.recordDropped()
+}
+
+func (l *asyncAuditLog) record(event *AuditEvent) {
+	if err := l.AuditLog.Record(event); err != nil {
+		l.logger.WithFields(logrus.Fields{"session": event.Session, "type": event.Type}).
+			Error("Failed to record audit event: ", err.Error())
+	}
+}
+
+// recordDropped writes an AuditEventDropped event if events were dropped since the last one.
+func (l *asyncAuditLog) recordDropped() {
+	if n := atomic.SwapUint64(&l.dropped, 0); n > 0 {
+		l.record(&AuditEvent{
+			Type:    AuditEventDropped,
+			Time:    time.Now().UTC().Format(time.RFC3339Nano),
+			Dropped: n,
+		})
+	}
+}
+
+// Record queues the event for being written to the log, waiting for a bounded time if too many
+// events are waiting to be written. The sequence number and MACs of the event are assigned when
+// it is written.
+func (l *asyncAuditLog) Record(event *AuditEvent) error {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.closed {
+		return errors.New("audit log is closed")
+	}
+	if event.Time == "" {
+		event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	select {
+	case l.events <- event:
+		return nil
+	case <-time.After(l.timeout):
+		atomic.AddUint64(&l.dropped, 1)
+		return errors.New("too many events waiting to be written to audit log, event dropped")
+	}
+}
+
+// Close writes the events that are waiting to be written, and closes the log.
+func (l *asyncAuditLog) Close() error {
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.events)
+	l.lock.Unlock()
+
+	<-l.done
+	return l.AuditLog.Close()
+}
+
+// FileAuditLog is an AuditLog that appends events as JSON lines to a file. The file must not be
+// written to by more than one IRMA server at the same time.
+type FileAuditLog struct {
+	sync.Mutex
+	path     string
+	key      []byte
+	file     *os.File
+	sequence uint64
+	mac      string
+}
+
+// NewFileAuditLog opens the audit log at the specified path, creating it if necessary. Events
+// are authenticated with the specified key.
+func NewFileAuditLog(path string, key []byte) (*FileAuditLog, error) {
+	if len(key) == 0 {
+		return nil, errors.New("no audit log key specified")
+	}
+	l := &FileAuditLog{path: path, key: key, mac: auditGenesisMAC}
+	if _, err := os.Stat(path); err == nil {
+		events, err := l.Events()
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > 0 {
+			last := events[len(events)-1]
+			l.sequence, l.mac = last.Sequence, last.MAC
+		}
+	}
+	var err error
+	l.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "failed to open audit log", 0)
+	}
+	return l, nil
+}
+
+func (l *FileAuditLog) Record(event *AuditEvent) error {
+	l.Lock()
+	defer l.Unlock()
+
+	if err := event.chain(l.key, l.sequence, l.mac); err != nil {
+		return err
+	}
+	bts, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err = l.file.Write(append(bts, '\n')); err != nil {
+		return errors.WrapPrefix(err, "failed to write audit log", 0)
+	}
+	if err = l.file.Sync(); err != nil {
+		return errors.WrapPrefix(err, "failed to write audit log", 0)
+	}
+	l.sequence, l.mac = event.Sequence, event.MAC
+	return nil
+}
+
+func (l *FileAuditLog) Events() ([]*AuditEvent, error) {
+	return ReadAuditLogFile(l.path)
+}
+
+// ReadAuditLogFile reads the events from the audit log at the specified path.
+func ReadAuditLogFile(path string) ([]*AuditEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "failed to open audit log", 0)
+	}
+	defer func() { _ = f.Close() }()
+
+	var events []*AuditEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		event := &AuditEvent{}
+		if err = json.Unmarshal(scanner.Bytes(), event); err != nil {
+			return nil, errors.WrapPrefix(err, fmt.Sprintf("failed to parse line %d of audit log", line), 0)
+		}
+		events = append(events, event)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.WrapPrefix(err, "failed to read audit log", 0)
+	}
+	return events, nil
+}
+
+func (l *FileAuditLog) Close() error {
+	return l.file.Close()
+}
+
+// SQLAuditLog is an AuditLog that stores events in the irma_audit_log table of a Postgres or MySQL
+// database. Multiple IRMA servers may share the same table.
+type SQLAuditLog struct {
+	db  *gorm.DB
+	key []byte
+}
+
+// sqlAuditRecord is an audit event as stored in the database. The event is stored as JSON, so that
+// its MAC can be recomputed from exactly the same data.
+type sqlAuditRecord struct {
+	Sequence uint64 `gorm:"column:seq;primary_key;auto_increment:false"`
+	MAC      string
+	Data     []byte
+}
+
+func (sqlAuditRecord) TableName() string {
+	return "irma_audit_log"
+}
+
+// NewSQLAuditLog connects to the audit log in the specified database, creating its table if necessary.
+// Events are authenticated with the specified key.
+func NewSQLAuditLog(dbtype, connstr string, key []byte) (*SQLAuditLog, error) {
+	if len(key) == 0 {
+		return nil, errors.New("no audit log key specified")
+	}
+	db, err := gorm.Open(dbtype, connstr)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "failed to connect to audit log database", 0)
+	}
+	if err = db.AutoMigrate(&sqlAuditRecord{}).Error; err != nil {
+		_ = db.Close()
+		return nil, errors.WrapPrefix(err, "failed to migrate audit log database", 0)
+	}
+	return &SQLAuditLog{db: db, key: key}, nil
+}
+
+func (l *SQLAuditLog) Record(event *AuditEvent) error {
+	// Other servers may append events concurrently, in which case inserting our event at the same
+	// sequence number fails and we retry after the new last event.
+	var err error
+	for i := 0; i < sqlAuditMaxAttempts; i++ {
+		prev := sqlAuditRecord{MAC: auditGenesisMAC}
+		err = l.db.Select("seq, mac").Order("seq desc").Limit(1).Find(&prev).Error
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			return errors.WrapPrefix(err, "failed to read audit log", 0)
+		}
+		if err = event.chain(l.key, prev.Sequence, prev.MAC); err != nil {
+			return err
+		}
+		var bts []byte
+		if bts, err = json.Marshal(event); err != nil {
+			return err
+		}
+		err = l.db.Create(&sqlAuditRecord{Sequence: event.Sequence, MAC: event.MAC, Data: bts}).Error
+		if err == nil {
+			return nil
+		}
+	}
+	return errors.WrapPrefix(err, "failed to write audit log", 0)
+}
+
+func (l *SQLAuditLog) Events() ([]*AuditEvent, error) {
+	var records []sqlAuditRecord
+	if err := l.db.Order("seq asc").Find(&records).Error; err != nil {
+		return nil, errors.WrapPrefix(err, "failed to read audit log", 0)
+	}
+	events := make([]*AuditEvent, 0, len(records))
+	for _, record := range records {
+		event := &AuditEvent{}
+		if err := json.Unmarshal(record.Data, event); err != nil {
+			return nil, errors.WrapPrefix(err, fmt.Sprintf("failed to parse audit event %d", record.Sequence), 0)
+		}
+		if event.Sequence != record.Sequence {
+			return nil, &AuditError{Sequence: record.Sequence, Reason: "sequence number was modified"}
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (l *SQLAuditLog) Close() error {
+	return l.db.Close()
+}
+
+// OpenAuditLog opens the audit log from a file if path is specified, or otherwise from a database.
+// Events are authenticated with the specified key.
+func OpenAuditLog(path, dbtype, connstr string, key []byte) (AuditLog, error) {
+	if path != "" {
+		return NewFileAuditLog(path, key)
+	}
+	switch dbtype {
+	case "postgres", "mysql":
+		return NewSQLAuditLog(dbtype, connstr, key)
+	default:
+		return nil, errors.New("audit log database type must be postgres or mysql")
+	}
+}
+
+func (conf *Configuration) verifyAuditLog() error {
+	if conf.AuditLog != nil {
+		if _, ok := conf.AuditLog.(*asyncAuditLog); !ok {
+			conf.AuditLog = newAsyncAuditLog(conf.AuditLog, conf.Logger)
+		}
+		return nil
+	}
+	if conf.AuditLogFile != "" && conf.AuditLogDBConnStr != "" {
+		return errors.New("audit_log_file and audit_log_db_str must not both be specified")
+	}
+	if conf.AuditLogFile == "" && conf.AuditLogDBConnStr == "" {
+		return nil
+	}
+
+	if conf.AuditLogKey == "" && conf.AuditLogKeyFile == "" {
+		return errors.New("audit_log_key or audit_log_key_file must be specified when using an audit log")
+	}
+	key, err := common.ReadKey(conf.AuditLogKey, conf.AuditLogKeyFile)
+	if err != nil {
+		return errors.WrapPrefix(err, "failed to read audit log key", 0)
+	}
+	if len(key) < 32 {
+		return errors.New("audit log key must be at least 32 bytes long")
+	}
+
+	log, err := OpenAuditLog(conf.AuditLogFile, conf.AuditLogDBType, conf.AuditLogDBConnStr, key)
+	if err != nil {
+		return err
+	}
+	conf.AuditLog = newAsyncAuditLog(log, conf.Logger)
+	conf.Logger.Info("Recording requestor activity in audit log")
+	return nil
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/privacybydesign/irmago"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+var testAuditKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestFileAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "irmaaudit")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "audit.log")
+
+	request := irma.NewDisclosureRequest(
+		irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"),
+		irma.NewAttributeTypeIdentifier("irma-demo.MijnOverheid.root.BSN"),
+	)
+	request.Disclose[0][0][0].Value = new(string) // values are never included
+
+	log, err := NewFileAuditLog(path, testAuditKey)
+	require.NoError(t, err)
+	require.NoError(t, log.Record(NewAuditEvent(AuditEventCreated, "myapp", "token", request)))
+	done := NewAuditEvent(AuditEventDone, "myapp", "token", request)
+	done.ProofStatus = irma.ProofStatusValid
+	require.NoError(t, log.Record(done))
+	require.NoError(t, log.Close())
+
+	// Reopening the log continues the chain
+	log, err = NewFileAuditLog(path, testAuditKey)
+	require.NoError(t, err)
+	require.NoError(t, log.Record(&AuditEvent{Type: AuditEventRevoked, Requestor: "myapp", Credentials: []string{"irma-demo.RU.studentCard"}}))
+	require.NoError(t, log.Close())
+
+	events, err := ReadAuditLogFile(path)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	require.NoError(t, VerifyAuditLog(events, testAuditKey))
+	require.Equal(t, []string{"irma-demo.MijnOverheid.root.BSN", "irma-demo.RU.studentCard.studentID"}, events[0].Attributes)
+	require.Equal(t, irma.ActionDisclosing, events[0].Action)
+	require.Equal(t, irma.ProofStatusValid, events[1].ProofStatus)
+	require.Equal(t, uint64(3), events[2].Sequence)
+	require.Equal(t, events[1].MAC, events[2].PrevMAC)
+
+	bts, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := bytes.Split(bytes.TrimSpace(bts), []byte("\n"))
+	require.Len(t, lines, 3)
+
+	t.Run("wrong key", func(t *testing.T) {
+		err := VerifyAuditLog(events, []byte("fedcba9876543210fedcba9876543210"))
+		require.IsType(t, &AuditError{}, err)
+		require.Equal(t, uint64(1), err.(*AuditError).Sequence)
+	})
+
+	t.Run("modified event with recomputed unkeyed hashes", func(t *testing.T) {
+		// Without the key, an attacker cannot recompute the chain after modifying an event
+		modified := *events[0]
+		modified.Requestor = "other"
+		bts, err := json.Marshal(modified)
+		require.NoError(t, err)
+		hash := sha256.Sum256(bts)
+		modified.MAC = hex.EncodeToString(hash[:])
+		err = VerifyAuditLog([]*AuditEvent{&modified}, testAuditKey)
+		require.IsType(t, &AuditError{}, err)
+	})
+
+	t.Run("modified event", func(t *testing.T) {
+		modified := bytes.Replace(bts, []byte(`"requestor":"myapp"`), []byte(`"requestor":"other"`), 1)
+		require.NoError(t, ioutil.WriteFile(path, modified, 0600))
+		events, err := ReadAuditLogFile(path)
+		require.NoError(t, err)
+		err = VerifyAuditLog(events, testAuditKey)
+		require.IsType(t, &AuditError{}, err)
+		require.Equal(t, uint64(1), err.(*AuditError).Sequence)
+	})
+
+	t.Run("removed event", func(t *testing.T) {
+		removed := bytes.Join([][]byte{lines[0], lines[2]}, []byte("\n"))
+		require.NoError(t, ioutil.WriteFile(path, removed, 0600))
+		events, err := ReadAuditLogFile(path)
+		require.NoError(t, err)
+		err = VerifyAuditLog(events, testAuditKey)
+		require.IsType(t, &AuditError{}, err)
+		require.Equal(t, uint64(2), err.(*AuditError).Sequence)
+	})
+
+	t.Run("truncated log", func(t *testing.T) {
+		require.NoError(t, ioutil.WriteFile(path, bytes.Join(lines[1:], []byte("\n")), 0600))
+		events, err := ReadAuditLogFile(path)
+		require.NoError(t, err)
+		require.Error(t, VerifyAuditLog(events, testAuditKey))
+	})
+}
+
+func TestAuditEventIssuance(t *testing.T) {
+	request := irma.NewIssuanceRequest([]*irma.CredentialRequest{{
+		CredentialTypeID: irma.NewCredentialTypeIdentifier("irma-demo.RU.studentCard"),
+		Attributes:       map[string]string{"studentID": "456"},
+	}})
+	event := NewAuditEvent(AuditEventCreated, "myapp", "token", request)
+	require.Equal(t, irma.ActionIssuing, event.Action)
+	require.Equal(t, []string{"irma-demo.RU.studentCard"}, event.Credentials)
+	require.Empty(t, event.Attributes)
+}
+
+// blockingAuditLog is an AuditLog of which Record blocks until it is released.
+type blockingAuditLog struct {
+	release chan struct{}
+	events  []*AuditEvent
+	closed  bool
+}
+
+func (l *blockingAuditLog) Record(event *AuditEvent) error {
+	<-l.release
+	l.events = append(l.events, event)
+	return nil
+}
+
+func (l *blockingAuditLog) Events() ([]*AuditEvent, error) {
+	return l.events, nil
+}
+
+func (l *blockingAuditLog) Close() error {
+	l.closed = true
+	return nil
+}
+
+func TestAsyncAuditLog(t *testing.T) {
+	blocking := &blockingAuditLog{release: make(chan struct{})}
+	log := newAsyncAuditLog(blocking, logrus.New())
+
+	// Recording does not wait for the underlying log
+	recorded := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			require.NoError(t, log.Record(&AuditEvent{Type: AuditEventCreated}))
+		}
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recording audit event blocked")
+	}
+
+	// Closing writes the waiting events before closing the underlying log
+	close(blocking.release)
+	require.NoError(t, log.Close())
+	require.Len(t, blocking.events, 3)
+	require.NotEmpty(t, blocking.events[0].Time)
+	require.True(t, blocking.closed)
+	require.Error(t, log.Record(&AuditEvent{Type: AuditEventCreated}))
+}
+
+func TestAsyncAuditLogDropped(t *testing.T) {
+	blocking := &blockingAuditLog{release: make(chan struct{})}
+	log := newAsyncAuditLog(blocking, logrus.New())
+	log.timeout = 10 * time.Millisecond
+
+	// Fill the buffer while the underlying log blocks, so that further events are dropped
+	var recorded, dropped int
+	for i := 0; i < auditBufferSize+3; i++ {
+		if err := log.Record(&AuditEvent{Type: AuditEventCreated}); err != nil {
+			dropped++
+		} else {
+			recorded++
+		}
+	}
+	require.NotZero(t, dropped)
+
+	// The dropped events are counted by an event written in their place
+	close(blocking.release)
+	require.NoError(t, log.Close())
+	require.Len(t, blocking.events, recorded+1)
+	events, count := DroppedAuditEvents(blocking.events)
+	require.Len(t, events, 1)
+	require.Equal(t, uint64(dropped), count)
+}
@@ -44,11 +44,15 @@ type Configuration struct {
 	Email string `json:"email" mapstructure:"email"`
 	// Enable server sent events for status updates (experimental; tends to hang when a reverse proxy is used)
 	EnableSSE bool `json:"enable_sse" mapstructure:"enable_sse"`
-	// StoreType in which session data will be stored.
+	// Expose metrics about sessions and revocation in the Prometheus format at /metrics
+	EnableMetrics bool `json:"enable_metrics" mapstructure:"enable_metrics"`
+	// StoreType in which session data will be stored, supported: memory, redis, postgres, mysql.
 	// If left empty, session data will be stored in memory by default.
 	StoreType string `json:"store_type" mapstructure:"store_type"`
 	// RedisSettings that need to be specified when Redis is used as session data store.
 	RedisSettings *RedisSettings `json:"redis_settings" mapstructure:"redis_settings"`
+	// Connection string for the session database, when postgres or mysql is used as session data store.
+	StoreDBConnStr string `json:"store_db_str" mapstructure:"store_db_str"`
 
 	// Static session requests that can be created by POST /session/{name}
 	StaticSessions map[string]interface{} `json:"static_sessions"`
@@ -68,6 +72,19 @@ type Configuration struct {
 	// Whether to allow callbackUrl to be set in session requests when no JWT privatekey is installed
 	// (which is potentially unsafe depending on the setup)
 	AllowUnsignedCallbacks bool `json:"allow_unsigned_callbacks" mapstructure:"allow_unsigned_callbacks"`
+	// Key with which session result callbacks are signed using HMAC-SHA256 in the X-IRMA-Signature header.
+	// Can be used instead of or in addition to the JWT private key.
+	CallbackHMACKey     string `json:"callback_hmac_key" mapstructure:"callback_hmac_key"`
+	CallbackHMACKeyFile string `json:"callback_hmac_key_file" mapstructure:"callback_hmac_key_file"`
+	// Parsed callback HMAC key
+	CallbackHMACKeyBytes []byte `json:"-"`
+	// Maximum amount of attempts to POST a session result to its callback URL, after which the
+	// delivery is marked as failed (default value 0 means 10)
+	CallbackMaxAttempts int `json:"callback_max_attempts" mapstructure:"callback_max_attempts"`
+	// Amount of hours that callback deliveries, including the session results with disclosed
+	// attribute values that they contain, are kept after they were created, also when they failed
+	// (default value 0 means 168, i.e. a week)
+	CallbackRetention int `json:"callback_retention" mapstructure:"callback_retention"`
 	// Whether to augment the clientreturnurl with the server token of the request (this allows for stateless
 	// requestor servers more easily)
 	AugmentClientReturnURL bool `json:"augment_client_return_url" mapstructure:"augment_client_return_url"`
@@ -83,10 +100,36 @@ type Configuration struct {
 
 	// Connection string for revocation database
 	RevocationDBConnStr string `json:"revocation_db_str" mapstructure:"revocation_db_str"`
-	// Database type for revocation database, supported: postgres, mysql
+	// Database type for revocation database, supported: postgres, mysql, sqlite (with a file path as connection string)
 	RevocationDBType string `json:"revocation_db_type" mapstructure:"revocation_db_type"`
 	// Credentials types for which revocation database should be hosted
 	RevocationSettings irma.RevocationSettings `json:"revocation_settings" mapstructure:"revocation_settings"`
+	// Maximum age in seconds of the accumulators of revocable credential types, after which the
+	// revocation state is reported as unhealthy at /health (default value 0 means the tolerance
+	// of the credential type)
+	RevocationMaxAge uint64 `json:"revocation_max_age" mapstructure:"revocation_max_age"`
+	// Maximum amount of self-revocation sessions (see irma.RevocationSetting.SelfRevocation) that
+	// may be live at the same time, and that may be started per minute from the same IP address
+	// (default 100 and 10; negative values mean no limit)
+	SelfRevocationMaxConcurrentSessions int `json:"self_revocation_max_concurrent_sessions" mapstructure:"self_revocation_max_concurrent_sessions"`
+	SelfRevocationMaxSessionsPerIP      int `json:"self_revocation_max_sessions_per_ip" mapstructure:"self_revocation_max_sessions_per_ip"`
+
+	// File to which an audit log of requestor activity is appended
+	AuditLogFile string `json:"audit_log_file" mapstructure:"audit_log_file"`
+	// Connection string for an audit log database, as alternative to AuditLogFile
+	AuditLogDBConnStr string `json:"audit_log_db_str" mapstructure:"audit_log_db_str"`
+	// Database type for the audit log database, supported: postgres, mysql
+	AuditLogDBType string `json:"audit_log_db_type" mapstructure:"audit_log_db_type"`
+	// Key with which the events in the audit log are authenticated using HMAC-SHA256, and with
+	// which the log can be verified. Required when AuditLogFile or AuditLogDBConnStr is specified.
+	AuditLogKey     string `json:"audit_log_key" mapstructure:"audit_log_key"`
+	AuditLogKeyFile string `json:"audit_log_key_file" mapstructure:"audit_log_key_file"`
+	// Audit log of requestor activity. If not given, it is opened using the settings above.
+	AuditLog AuditLog `json:"-"`
+	// RequestorLimits returns the maximum amount of sessions per minute and of concurrent live
+	// sessions of the specified requestor (0 means no limit). If set, these limits are enforced
+	// when sessions of requestors are started.
+	RequestorLimits func(requestor string) (perMinute, concurrent int) `json:"-"`
 
 	// Production mode: enables safer and stricter defaults and config checking
 	Production bool `json:"production" mapstructure:"production"`
@@ -114,6 +157,12 @@ func (conf *Configuration) Check() error {
 	if conf.MaxSessionLifetime == 0 {
 		conf.MaxSessionLifetime = 5
 	}
+	if conf.SelfRevocationMaxConcurrentSessions == 0 {
+		conf.SelfRevocationMaxConcurrentSessions = 100
+	}
+	if conf.SelfRevocationMaxSessionsPerIP == 0 {
+		conf.SelfRevocationMaxSessionsPerIP = 10
+	}
 
 	// loop to avoid repetetive err != nil line triplets
 	for _, f := range []func() error{
@@ -124,6 +173,8 @@ func (conf *Configuration) Check() error {
 		conf.verifyRevocation,
 		conf.verifyJwtPrivateKey,
 		conf.verifyStaticSessions,
+		conf.verifyCallbacks,
+		conf.verifyAuditLog,
 	} {
 		if err := f(); err != nil {
 			_ = LogError(err)
@@ -139,6 +190,9 @@ func (conf *Configuration) Check() error {
 	if conf.EnableSSE && conf.StoreType == "redis" {
 		return errors.New("Currently server-sent events (SSE) cannot be used simultaneously with the Redis session store.")
 	}
+	if conf.EnableSSE && (conf.StoreType == "postgres" || conf.StoreType == "mysql") {
+		return errors.New("Currently server-sent events (SSE) cannot be used simultaneously with a SQL session store.")
+	}
 
 	return nil
 }
@@ -189,6 +243,9 @@ func (conf *Configuration) verifyStaticSessions() error {
 }
 
 func (conf *Configuration) verifyIrmaConf() error {
+	if conf.RevocationDBType == "sqlite" && !irma.SQLiteSupported {
+		return errors.New("revocation_db_type sqlite is not supported by this build, which was built without cgo")
+	}
 	if conf.IrmaConfiguration == nil {
 		var (
 			err    error
@@ -297,6 +354,8 @@ func (conf *Configuration) verifyRevocation() error {
 			if err := conf.prepareRevocation(credid); err != nil {
 				return err
 			}
+		} else if settings.Mirror {
+			conf.Logger.Info("revocation mirror mode enabled for " + credid.String())
 		} else if settings.Server {
 			conf.Logger.Info("revocation server mode enabled for " + credid.String())
 		}
@@ -393,6 +452,40 @@ func (conf *Configuration) verifyJwtPrivateKey() error {
 	return err
 }
 
+func (conf *Configuration) verifyCallbacks() error {
+	if conf.CallbackMaxAttempts == 0 {
+		conf.CallbackMaxAttempts = 10
+	}
+	if conf.CallbackMaxAttempts < 0 {
+		return errors.New("callback_max_attempts must be positive")
+	}
+	if conf.CallbackRetention == 0 {
+		conf.CallbackRetention = 168
+	}
+	if conf.CallbackRetention < 0 {
+		return errors.New("callback_retention must be positive")
+	}
+	if conf.CallbackHMACKey == "" && conf.CallbackHMACKeyFile == "" {
+		return nil
+	}
+
+	var err error
+	conf.CallbackHMACKeyBytes, err = common.ReadKey(conf.CallbackHMACKey, conf.CallbackHMACKeyFile)
+	if err != nil {
+		return errors.WrapPrefix(err, "failed to read callback HMAC key", 0)
+	}
+	if len(conf.CallbackHMACKeyBytes) < 32 {
+		return errors.New("callback HMAC key must be at least 32 bytes long")
+	}
+	return nil
+}
+
+// CanSignCallbacks returns whether session results POSTed to callback URLs can be signed,
+// either as JWT or using an HMAC.
+func (conf *Configuration) CanSignCallbacks() bool {
+	return conf.JwtRSAPrivateKey != nil || len(conf.CallbackHMACKeyBytes) > 0
+}
+
 // ReplacePortString is a helper that returns a copy of the specified url of the form
 // "http(s)://...:port" with "port" replaced by the specified port.
 func ReplacePortString(url string, port int) string {
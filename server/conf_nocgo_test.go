@@ -0,0 +1,17 @@
+//go:build !cgo
+// +build !cgo
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteRevocationDBWithoutCgo(t *testing.T) {
+	conf := &Configuration{RevocationDBType: "sqlite", RevocationDBConnStr: "revocation.db"}
+	err := conf.verifyIrmaConf()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "built without cgo")
+}
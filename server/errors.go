@@ -35,6 +35,9 @@ var (
 	ErrorNextSession          Error = Error{Type: "NEXT_SESSION", Status: 500, Description: "Error starting next session"}
 	ErrorRevocation           Error = Error{Type: "REVOCATION", Status: 500, Description: "Revocation error"}
 	ErrorUnknownRevocationKey Error = Error{Type: "UNKNOWN_REVOCATION_KEY", Status: 404, Description: "No issuance records correspond to the given revocationKey"}
+	ErrorUnknownCallback      Error = Error{Type: "UNKNOWN_CALLBACK", Status: 404, Description: "Unknown callback delivery"}
+	ErrorAdminUnauthorized    Error = Error{Type: "UNAUTHORIZED", Status: 403, Description: "You are not authorized to access the admin API"}
+	ErrorTooManyRequests      Error = Error{Type: "TOO_MANY_REQUESTS", Status: 429, Description: "Rate limit or quota exceeded"}
 
 	ErrorUnsupported     Error = Error{Type: "UNSUPPORTED", Status: 501, Description: "Unsupported by this server"}
 	ErrorInvalidRequest  Error = Error{Type: "INVALID_REQUEST", Status: 400, Description: "Invalid HTTP request"}
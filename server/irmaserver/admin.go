@@ -0,0 +1,185 @@
+package irmaserver
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-redis/redis/v8"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sirupsen/logrus"
+)
+
+// SessionInfo describes a session in the session store, for administrative purposes.
+type SessionInfo struct {
+	Token      irma.RequestorToken   `json:"token"`
+	Action     irma.Action           `json:"action"`
+	Requestor  string                `json:"requestor,omitempty"`
+	Status     irma.ServerStatus     `json:"status"`
+	Version    *irma.ProtocolVersion `json:"version,omitempty"`
+	Started    time.Time             `json:"started"`
+	LastActive time.Time             `json:"lastActive"`
+
+	// Request is the session request purged of attribute values; only included by Session().
+	Request irma.RequestorRequest `json:"request,omitempty"`
+}
+
+// sessionLister is implemented by the session stores to list the sessions they contain.
+type sessionLister interface {
+	list() ([]*SessionInfo, error)
+}
+
+// Sessions returns the sessions that are currently in the session store, ordered by the moment
+// at which they were started. Finished sessions are only included if includeFinished is true.
+func Sessions(includeFinished bool) ([]*SessionInfo, error) {
+	return s.Sessions(includeFinished)
+}
+func (s *Server) Sessions(includeFinished bool) ([]*SessionInfo, error) {
+	lister, ok := s.sessions.(sessionLister)
+	if !ok {
+		return nil, errors.New("session store does not support listing sessions")
+	}
+	all, err := lister.list()
+	if err != nil {
+		return nil, err
+	}
+	lifetime := time.Duration(s.conf.MaxSessionLifetime) * time.Minute
+	infos := make([]*SessionInfo, 0, len(all))
+	for _, info := range all {
+		// The Redis and SQL session stores only mark expired sessions as timed out when they are next accessed
+		if !info.Status.Finished() && info.LastActive.Add(lifetime).Before(time.Now()) {
+			info.Status = irma.ServerStatusTimeout
+		}
+		if includeFinished || !info.Status.Finished() {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Started.Before(infos[j].Started) })
+	return infos, nil
+}
+
+// Session returns information about the specified session, including its request purged of
+// any attribute values.
+func Session(requestorToken irma.RequestorToken) (*SessionInfo, error) {
+	return s.Session(requestorToken)
+}
+func (s *Server) Session(requestorToken irma.RequestorToken) (info *SessionInfo, err error) {
+	session, err := s.sessions.get(requestorToken)
+	defer func() { err = updateAndUnlock(session, err) }()
+	if err != nil {
+		return
+	}
+
+	info = session.info()
+	info.Request = purgeRequest(session.Rrequest)
+	return
+}
+
+// CancelSessions cancels the specified sessions, and returns the sessions that were cancelled.
+// Sessions that are unknown or already finished are skipped.
+func CancelSessions(requestorTokens []irma.RequestorToken) ([]irma.RequestorToken, error) {
+	return s.CancelSessions(requestorTokens)
+}
+func (s *Server) CancelSessions(requestorTokens []irma.RequestorToken) ([]irma.RequestorToken, error) {
+	cancelled := make([]irma.RequestorToken, 0, len(requestorTokens))
+	for _, token := range requestorTokens {
+		ok, err := s.cancelSession(token)
+		if _, unknown := err.(*UnknownSessionError); unknown {
+			continue
+		}
+		if err != nil {
+			return cancelled, err
+		}
+		if ok {
+			cancelled = append(cancelled, token)
+		}
+	}
+	s.conf.Logger.WithFields(logrus.Fields{"count": len(cancelled)}).Info("Cancelled sessions")
+	return cancelled, nil
+}
+
+func (s *Server) cancelSession(requestorToken irma.RequestorToken) (cancelled bool, err error) {
+	session, err := s.sessions.get(requestorToken)
+	defer func() { err = updateAndUnlock(session, err) }()
+	if err != nil {
+		return
+	}
+
+	if session.Status.Finished() {
+		return
+	}
+	session.handleDelete()
+	cancelled = true
+	return
+}
+
+func (sd *sessionData) info() *SessionInfo {
+	return &SessionInfo{
+		Token:      sd.RequestorToken,
+		Action:     sd.Action,
+		Requestor:  sd.Requestor,
+		Status:     sd.Status,
+		Version:    sd.Version,
+		Started:    sd.Started,
+		LastActive: sd.LastActive,
+	}
+}
+
+func (s *memorySessionStore) list() ([]*SessionInfo, error) {
+	s.RLock()
+	sessions := make([]*session, 0, len(s.requestor))
+	for _, session := range s.requestor {
+		sessions = append(sessions, session)
+	}
+	s.RUnlock()
+
+	infos := make([]*SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		session.Lock()
+		infos = append(infos, session.info())
+		session.Unlock()
+	}
+	return infos, nil
+}
+
+func (s *redisSessionStore) list() ([]*SessionInfo, error) {
+	ctx := context.Background()
+	var infos []*SessionInfo
+	iter := s.client.Scan(ctx, 0, clientTokenLookupPrefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		val, err := s.client.Get(ctx, iter.Val()).Result()
+		if err == redis.Nil {
+			continue // expired in the meantime
+		} else if err != nil {
+			return nil, logAsRedisError(err)
+		}
+		var sd sessionData
+		if err = json.Unmarshal([]byte(val), &sd); err != nil {
+			return nil, logAsRedisError(err)
+		}
+		infos = append(infos, sd.info())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, logAsRedisError(err)
+	}
+	return infos, nil
+}
+
+func (s *sqlSessionStore) list() ([]*SessionInfo, error) {
+	var records []sqlSessionRecord
+	err := s.db.Select("data").Where("expires >= ?", time.Now().UnixNano()).Find(&records).Error
+	if err != nil {
+		return nil, logAsSQLError(err)
+	}
+	infos := make([]*SessionInfo, 0, len(records))
+	for _, record := range records {
+		var sd sessionData
+		if err = json.Unmarshal(record.Data, &sd); err != nil {
+			return nil, logAsSQLError(err)
+		}
+		infos = append(infos, sd.info())
+	}
+	return infos, nil
+}
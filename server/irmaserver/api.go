@@ -13,6 +13,7 @@ import (
 
 	"github.com/bsm/redislock"
 	"github.com/go-redis/redis/v8"
+	"github.com/jinzhu/gorm"
 	"github.com/privacybydesign/irmago/internal/common"
 
 	"github.com/alexandrevicenzi/go-sse"
@@ -31,6 +32,8 @@ type Server struct {
 	scheduler        *gocron.Scheduler
 	stopScheduler    chan bool
 	serverSentEvents *sse.Server
+
+	retryingCallbacks int32 // accessed atomically
 }
 
 // Default server instance
@@ -65,6 +68,9 @@ func New(conf *server.Configuration) (*Server, error) {
 		s.sessions = &memorySessionStore{
 			requestor: make(map[irma.RequestorToken]*session),
 			client:    make(map[irma.ClientToken]*session),
+			callbacks: make(map[string]*CallbackDelivery),
+			rates:     make(map[string]*rateCounter),
+			live:      make(map[string]map[irma.RequestorToken]time.Time),
 			conf:      conf,
 		}
 
@@ -94,10 +100,32 @@ func New(conf *server.Configuration) (*Server, error) {
 			conf:   conf,
 			locker: redislock.New(cl),
 		}
+	case "postgres", "mysql":
+		if conf.StoreDBConnStr == "" {
+			return nil, errors.Errorf("When %s is used as session data store, a connection string must be specified.", conf.StoreType)
+		}
+		db, err := gorm.Open(conf.StoreType, conf.StoreDBConnStr)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "failed to connect to session database", 0)
+		}
+		if err = db.AutoMigrate((*sqlSessionRecord)(nil), (*sqlCallbackRecord)(nil), (*sqlRateCounter)(nil), (*sqlLiveSession)(nil)).Error; err != nil {
+			return nil, errors.WrapPrefix(err, "failed to migrate session database", 0)
+		}
+
+		s.sessions = &sqlSessionStore{
+			db:   db,
+			conf: conf,
+		}
+
+		s.scheduler.Every(10).Seconds().Do(func() {
+			s.sessions.(*sqlSessionStore).deleteExpired()
+		})
 	default:
 		return nil, errors.New("storeType not known")
 	}
 
+	s.scheduler.Every(1).Seconds().Do(s.retryCallbacks)
+
 	s.scheduler.Every(irma.RevocationParameters.RequestorUpdateInterval).Seconds().Do(func() {
 		for credid, settings := range s.conf.RevocationSettings {
 			if settings.Authority {
@@ -110,6 +138,10 @@ func New(conf *server.Configuration) (*Server, error) {
 		}
 	})
 
+	if conf.EnableMetrics {
+		s.registerMetrics()
+	}
+
 	s.stopScheduler = s.scheduler.Start()
 
 	return s, nil
@@ -210,6 +242,7 @@ func (s *Server) HandlerFunc() http.HandlerFunc {
 		r.Get("/update/{count:\\d+}", s.handleRevocationGetUpdateLatest)
 		r.Get("/update/{count:\\d+}/{counter:\\d+}", s.handleRevocationGetUpdateLatest)
 		r.Post("/issuancerecord/{counter:\\d+}", s.handleRevocationPostIssuanceRecord)
+		r.Post("/selfrevoke", s.handleRevocationSelfRevoke)
 	})
 
 	return s.router.ServeHTTP
@@ -220,11 +253,19 @@ func Stop() {
 	s.Stop()
 }
 func (s *Server) Stop() {
+	if s.conf.EnableMetrics {
+		s.unregisterMetrics()
+	}
 	if err := s.conf.IrmaConfiguration.Revocation.Close(); err != nil {
 		_ = server.LogWarning(err)
 	}
 	s.stopScheduler <- true
 	s.sessions.stop()
+	if s.conf.AuditLog != nil {
+		if err := s.conf.AuditLog.Close(); err != nil {
+			_ = server.LogWarning(err)
+		}
+	}
 }
 
 // StartSession starts an IRMA session, running the handler on completion, if specified.
@@ -239,14 +280,29 @@ func StartSession(request interface{}, handler server.SessionHandler,
 }
 func (s *Server) StartSession(req interface{}, handler server.SessionHandler,
 ) (*irma.Qr, irma.RequestorToken, *irma.FrontendSessionRequest, error) {
-	return s.startNextSession(req, handler, nil, "")
+	return s.startNextSession(req, handler, nil, "", "")
+}
+
+// StartRequestorSession starts an IRMA session like StartSession, on behalf of the specified
+// requestor. The requestor name is used in logging and metrics.
+func StartRequestorSession(requestor string, request interface{}, handler server.SessionHandler,
+) (*irma.Qr, irma.RequestorToken, *irma.FrontendSessionRequest, error) {
+	return s.StartRequestorSession(requestor, request, handler)
+}
+func (s *Server) StartRequestorSession(requestor string, req interface{}, handler server.SessionHandler,
+) (*irma.Qr, irma.RequestorToken, *irma.FrontendSessionRequest, error) {
+	return s.startNextSession(req, handler, nil, "", requestor)
 }
+
 func (s *Server) startNextSession(
-	req interface{}, handler server.SessionHandler, disclosed irma.AttributeConDisCon, FrontendAuth irma.FrontendAuthorization,
+	req interface{}, handler server.SessionHandler, disclosed irma.AttributeConDisCon, FrontendAuth irma.FrontendAuthorization, requestor string,
 ) (*irma.Qr, irma.RequestorToken, *irma.FrontendSessionRequest, error) {
 	if s.conf.StoreType == "redis" && handler != nil {
 		return nil, "", nil, errors.New("Handlers cannot be used in combination with Redis.")
 	}
+	if _, ok := s.sessions.(*sqlSessionStore); ok && handler != nil {
+		return nil, "", nil, errors.New("Handlers cannot be used in combination with a SQL session store.")
+	}
 	rrequest, err := server.ParseSessionRequest(req)
 	if err != nil {
 		return nil, "", nil, err
@@ -290,10 +346,11 @@ func (s *Server) startNextSession(
 	}
 
 	request.Base().DevelopmentMode = !s.conf.Production
-	session, err := s.newSession(action, rrequest, disclosed, FrontendAuth)
+	session, err := s.newSession(action, rrequest, disclosed, FrontendAuth, requestor)
 	if err != nil {
 		return nil, "", nil, err
 	}
+	metricSessionsStarted.WithLabelValues(string(action), requestor).Inc()
 	s.conf.Logger.WithFields(logrus.Fields{"action": action, "session": session.RequestorToken}).Infof("Session started")
 	if s.conf.Logger.IsLevelEnabled(logrus.DebugLevel) {
 		s.conf.Logger.
@@ -408,6 +465,15 @@ func (s *Server) Revoke(credid irma.CredentialTypeIdentifier, key string, issued
 	return s.conf.IrmaConfiguration.Revocation.Revoke(credid, key, issued)
 }
 
+// RevokeBatch revokes the earlier issued credentials specified by the keys, in a single accumulator
+// update. (The same restrictions apply as for Revoke.)
+func RevokeBatch(credid irma.CredentialTypeIdentifier, keys []irma.RevocationKey) error {
+	return s.RevokeBatch(credid, keys)
+}
+func (s *Server) RevokeBatch(credid irma.CredentialTypeIdentifier, keys []irma.RevocationKey) error {
+	return s.conf.IrmaConfiguration.Revocation.RevokeBatch(credid, keys)
+}
+
 // SubscribeServerSentEvents subscribes the HTTP client to server sent events on status updates
 // of the specified IRMA session.
 func (s *Server) SubscribeServerSentEvents(w http.ResponseWriter, r *http.Request, token irma.RequestorToken) (err error) {
@@ -423,15 +489,16 @@ func (s *Server) SubscribeServerSentEvents(w http.ResponseWriter, r *http.Reques
 	session, err := s.sessions.get(token)
 	err = updateAndUnlock(session, err)
 	if err != nil {
-		if _, ok := err.(*RedisError); ok {
+		switch err.(type) {
+		case *RedisError, *SQLError:
 			// In no flow, you should end up with an storeError. If you do, be alarmed!
-			// Only the Redis session store implementation actively uses these errors. As the Redis session store
+			// Only the Redis and SQL session store implementations actively use these errors. As these session stores
 			// currently cannot be used in combination with SSE, there should be no storeError here.
 			// Furthermore, the specific storeError is already logged in `session.go` and does not have
 			// to be logged again.
 			err = server.LogError(errors.Errorf("unexpectedly triggered error when trying to receive session %s", token))
 			return
-		} else {
+		default:
 			return
 		}
 	}
@@ -486,6 +553,9 @@ func (s *Server) SessionStatus(requestorToken irma.RequestorToken) (statusChan c
 	if s.conf.StoreType == "redis" {
 		return nil, errors.New("SessionStatus cannot be used in combination with Redis.")
 	}
+	if _, ok := s.sessions.(*sqlSessionStore); ok {
+		return nil, errors.New("SessionStatus cannot be used in combination with a SQL session store.")
+	}
 
 	session, err := s.sessions.get(requestorToken)
 	err = updateAndUnlock(session, err)
@@ -0,0 +1,555 @@
+package irmaserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/jinzhu/gorm"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sirupsen/logrus"
+)
+
+// CallbackDelivery is a session result that is to be POSTed to the callback URL of its session.
+// Deliveries are persisted in the session store and retried with exponential backoff, until they
+// succeed or the maximum amount of attempts is reached, after which they are marked as failed.
+// As session results contain disclosed attribute values, deliveries are deleted once the
+// callback retention period of the configuration has passed after they were created, also if
+// they failed.
+type CallbackDelivery struct {
+	ID          string                `json:"id"`
+	Token       irma.RequestorToken   `json:"token"`
+	URL         string                `json:"url"`
+	Result      *server.SessionResult `json:"result,omitempty"`
+	JwtValidity int                   `json:"jwtValidity,omitempty"`
+	Attempts    int                   `json:"attempts"`
+	NextAttempt time.Time             `json:"nextAttempt"`
+	LastError   string                `json:"lastError,omitempty"`
+	Created     time.Time             `json:"created"`
+	Failed      bool                  `json:"failed"`
+}
+
+type UnknownCallbackError struct {
+	id string
+}
+
+func (err *UnknownCallbackError) Error() string {
+	return fmt.Sprintf("unknown callback delivery %s", err.id)
+}
+
+type callbackStore interface {
+	addCallback(d *CallbackDelivery) error
+	// claimCallbacks returns the deliveries of which the next attempt is due, and postpones
+	// their next attempt until the lease expires so that other servers don't pick them up meanwhile.
+	claimCallbacks(now, lease time.Time) ([]*CallbackDelivery, error)
+	updateCallback(d *CallbackDelivery) error
+	removeCallback(id string) error
+	getCallback(id string) (*CallbackDelivery, error)
+	failedCallbacks() ([]*CallbackDelivery, error)
+}
+
+const (
+	// CallbackDeliveryHeader contains the ID of a callback delivery, which is the same for all
+	// attempts of the delivery so that the receiver can recognize duplicates.
+	CallbackDeliveryHeader = "X-IRMA-Delivery"
+
+	callbackLease      = time.Minute // larger than the maximum duration of one delivery attempt
+	callbackMaxDelay   = time.Hour
+	callbackPrefix     = "callback:"
+	callbacksDueKey    = "callbacks:due"
+	callbacksFailedKey = "callbacks:failed"
+)
+
+// callbackRetryDelay is the delay after the first failed attempt of a callback delivery;
+// after each subsequent failed attempt the delay doubles.
+var callbackRetryDelay = 5 * time.Second
+
+// redisClaimCallbacks atomically fetches the IDs of the due callback deliveries from the sorted set
+// in KEYS[1] (scored by their next attempt), and moves their next attempt forward to ARGV[2].
+var redisClaimCallbacks = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for _, id in ipairs(due) do
+	redis.call('ZADD', KEYS[1], ARGV[2], id)
+end
+return due`)
+
+// FailedCallbacks returns the callback deliveries that failed permanently, i.e., of which all
+// attempts failed. The session results themselves are not included.
+func FailedCallbacks() ([]*CallbackDelivery, error) {
+	return s.FailedCallbacks()
+}
+func (s *Server) FailedCallbacks() ([]*CallbackDelivery, error) {
+	store, err := s.callbackStore()
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := store.failedCallbacks()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deliveries {
+		d.Result = nil
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Created.Before(deliveries[j].Created) })
+	return deliveries, nil
+}
+
+// ReplayCallback schedules a failed callback delivery for immediate redelivery,
+// allowing it the maximum amount of attempts again.
+func ReplayCallback(id string) error {
+	return s.ReplayCallback(id)
+}
+func (s *Server) ReplayCallback(id string) error {
+	store, err := s.callbackStore()
+	if err != nil {
+		return err
+	}
+	d, err := store.getCallback(id)
+	if err != nil {
+		return err
+	}
+	if !d.Failed {
+		return errors.Errorf("callback delivery %s has not failed", id)
+	}
+	s.conf.Logger.WithFields(logrus.Fields{"session": d.Token, "delivery": id}).Info("Replaying callback delivery")
+	d.Failed = false
+	d.Attempts = 0
+	d.NextAttempt = time.Now()
+	return store.updateCallback(d)
+}
+
+// DeleteCallback removes a callback delivery, e.g. after it has failed permanently.
+func DeleteCallback(id string) error {
+	return s.DeleteCallback(id)
+}
+func (s *Server) DeleteCallback(id string) error {
+	store, err := s.callbackStore()
+	if err != nil {
+		return err
+	}
+	if _, err := store.getCallback(id); err != nil {
+		return err
+	}
+	return store.removeCallback(id)
+}
+
+func (s *Server) callbackStore() (callbackStore, error) {
+	store, ok := s.sessions.(callbackStore)
+	if !ok {
+		return nil, errors.New("session store does not support callback deliveries")
+	}
+	return store, nil
+}
+
+func (session *session) doResultCallback() {
+	url := session.Rrequest.Base().CallbackURL
+	if url == "" {
+		return
+	}
+
+	result := *session.Result
+	now := time.Now()
+	d := &CallbackDelivery{
+		ID:          common.NewSessionToken(),
+		Token:       session.RequestorToken,
+		URL:         url,
+		Result:      &result,
+		JwtValidity: session.Rrequest.Base().ResultJwtValidity,
+		Created:     now,
+		NextAttempt: now.Add(callbackLease),
+	}
+	// If the delivery cannot be stored, we can still try to deliver it once
+	store, ok := session.sessions.(callbackStore)
+	if ok {
+		if err := store.addCallback(d); err != nil {
+			_ = server.LogError(errors.WrapPrefix(err, "Failed to store callback delivery", 0))
+			store = nil
+		}
+	}
+
+	go deliverCallback(session.conf, store, d)
+}
+
+// retryCallbacks starts delivering all callbacks that are due, unless a previous run is still busy.
+func (s *Server) retryCallbacks() {
+	store, ok := s.sessions.(callbackStore)
+	if !ok || !atomic.CompareAndSwapInt32(&s.retryingCallbacks, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&s.retryingCallbacks, 0)
+		now := time.Now()
+		deliveries, err := store.claimCallbacks(now, now.Add(callbackLease))
+		if err != nil {
+			return // already logged
+		}
+		for _, d := range deliveries {
+			deliverCallback(s.conf, store, d)
+		}
+	}()
+}
+
+// deliverCallback makes an attempt to deliver the callback, and updates it in the store, if any.
+func deliverCallback(conf *server.Configuration, store callbackStore, d *CallbackDelivery) {
+	logger := conf.Logger.WithFields(logrus.Fields{"session": d.Token, "callbackUrl": d.URL, "delivery": d.ID})
+	d.Attempts++
+	err := postCallback(conf, d)
+	if err == nil {
+		logger.Debug("Session result POSTed to callback URL")
+		if store != nil {
+			_ = store.removeCallback(d.ID)
+		}
+		return
+	}
+	if store == nil {
+		logger.Warn(errors.WrapPrefix(err, "Failed to POST session result to callback URL", 0))
+		return
+	}
+
+	d.LastError = err.Error()
+	if d.Attempts >= conf.CallbackMaxAttempts {
+		d.Failed = true
+		logger.Warn(errors.WrapPrefix(err, fmt.Sprintf("Failed to POST session result to callback URL after %d attempts, giving up", d.Attempts), 0))
+	} else {
+		d.NextAttempt = time.Now().Add(callbackBackoff(d.Attempts))
+		logger.Warn(errors.WrapPrefix(err, "Failed to POST session result to callback URL, retrying at "+d.NextAttempt.Format(time.RFC3339), 0))
+	}
+	_ = store.updateCallback(d)
+}
+
+// callbackExpiry returns the moment after which the delivery is deleted from the store.
+func callbackExpiry(conf *server.Configuration, d *CallbackDelivery) time.Time {
+	return d.Created.Add(time.Duration(conf.CallbackRetention) * time.Hour)
+}
+
+func postCallback(conf *server.Configuration, d *CallbackDelivery) error {
+	return server.PostResultCallback(conf, d.URL, d.Result, d.JwtValidity, map[string]string{CallbackDeliveryHeader: d.ID})
+}
+
+func callbackBackoff(attempts int) time.Duration {
+	if attempts > 16 {
+		return callbackMaxDelay
+	}
+	delay := callbackRetryDelay << uint(attempts-1)
+	if delay > callbackMaxDelay {
+		return callbackMaxDelay
+	}
+	return delay
+}
+
+func (s *memorySessionStore) addCallback(d *CallbackDelivery) error {
+	s.callbackLock.Lock()
+	defer s.callbackLock.Unlock()
+	c := *d
+	s.callbacks[d.ID] = &c
+	return nil
+}
+
+func (s *memorySessionStore) claimCallbacks(now, lease time.Time) ([]*CallbackDelivery, error) {
+	s.callbackLock.Lock()
+	defer s.callbackLock.Unlock()
+	var due []*CallbackDelivery
+	for _, d := range s.callbacks {
+		if d.Failed || d.NextAttempt.After(now) {
+			continue
+		}
+		d.NextAttempt = lease
+		c := *d
+		due = append(due, &c)
+	}
+	return due, nil
+}
+
+func (s *memorySessionStore) updateCallback(d *CallbackDelivery) error {
+	s.callbackLock.Lock()
+	defer s.callbackLock.Unlock()
+	if _, ok := s.callbacks[d.ID]; !ok {
+		// removed in the meantime
+		return nil
+	}
+	c := *d
+	s.callbacks[d.ID] = &c
+	return nil
+}
+
+func (s *memorySessionStore) removeCallback(id string) error {
+	s.callbackLock.Lock()
+	defer s.callbackLock.Unlock()
+	delete(s.callbacks, id)
+	return nil
+}
+
+func (s *memorySessionStore) getCallback(id string) (*CallbackDelivery, error) {
+	s.callbackLock.Lock()
+	defer s.callbackLock.Unlock()
+	d, ok := s.callbacks[id]
+	if !ok {
+		return nil, &UnknownCallbackError{id}
+	}
+	c := *d
+	return &c, nil
+}
+
+func (s *memorySessionStore) deleteExpiredCallbacks() {
+	s.callbackLock.Lock()
+	defer s.callbackLock.Unlock()
+	now := time.Now()
+	for id, d := range s.callbacks {
+		if callbackExpiry(s.conf, d).Before(now) {
+			delete(s.callbacks, id)
+		}
+	}
+}
+
+func (s *memorySessionStore) failedCallbacks() ([]*CallbackDelivery, error) {
+	s.callbackLock.Lock()
+	defer s.callbackLock.Unlock()
+	failed := []*CallbackDelivery{}
+	for _, d := range s.callbacks {
+		if d.Failed {
+			c := *d
+			failed = append(failed, &c)
+		}
+	}
+	return failed, nil
+}
+
+func (s *redisSessionStore) addCallback(d *CallbackDelivery) error {
+	return s.updateCallback(d)
+}
+
+func (s *redisSessionStore) claimCallbacks(now, lease time.Time) ([]*CallbackDelivery, error) {
+	res, err := redisClaimCallbacks.Run(context.Background(), s.client,
+		[]string{callbacksDueKey}, unixMilli(now), unixMilli(lease),
+	).Result()
+	if err != nil && err != redis.Nil {
+		return nil, logAsRedisError(err)
+	}
+	ids, _ := res.([]interface{})
+
+	var due []*CallbackDelivery
+	for _, id := range ids {
+		d, err := s.getCallback(fmt.Sprint(id))
+		if _, ok := err.(*UnknownCallbackError); ok {
+			_ = s.client.ZRem(context.Background(), callbacksDueKey, id).Err()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		d.NextAttempt = lease
+		due = append(due, d)
+	}
+	return due, nil
+}
+
+func (s *redisSessionStore) updateCallback(d *CallbackDelivery) error {
+	bts, err := json.Marshal(d)
+	if err != nil {
+		return server.LogError(err)
+	}
+	ttl := time.Until(callbackExpiry(s.conf, d))
+	if ttl <= 0 {
+		return s.removeCallback(d.ID)
+	}
+	ctx := context.Background()
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, callbackPrefix+d.ID, bts, ttl)
+		if d.Failed {
+			pipe.ZRem(ctx, callbacksDueKey, d.ID)
+			pipe.SAdd(ctx, callbacksFailedKey, d.ID)
+		} else {
+			pipe.ZAdd(ctx, callbacksDueKey, &redis.Z{Score: float64(unixMilli(d.NextAttempt)), Member: d.ID})
+			pipe.SRem(ctx, callbacksFailedKey, d.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return logAsRedisError(err)
+	}
+	return nil
+}
+
+func (s *redisSessionStore) removeCallback(id string) error {
+	ctx := context.Background()
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, callbackPrefix+id)
+		pipe.ZRem(ctx, callbacksDueKey, id)
+		pipe.SRem(ctx, callbacksFailedKey, id)
+		return nil
+	})
+	if err != nil {
+		return logAsRedisError(err)
+	}
+	return nil
+}
+
+func (s *redisSessionStore) getCallback(id string) (*CallbackDelivery, error) {
+	val, err := s.client.Get(context.Background(), callbackPrefix+id).Result()
+	if err == redis.Nil {
+		return nil, &UnknownCallbackError{id}
+	} else if err != nil {
+		return nil, logAsRedisError(err)
+	}
+	var d CallbackDelivery
+	if err = json.Unmarshal([]byte(val), &d); err != nil {
+		return nil, logAsRedisError(err)
+	}
+	return &d, nil
+}
+
+func (s *redisSessionStore) failedCallbacks() ([]*CallbackDelivery, error) {
+	ids, err := s.client.SMembers(context.Background(), callbacksFailedKey).Result()
+	if err != nil {
+		return nil, logAsRedisError(err)
+	}
+	failed := []*CallbackDelivery{}
+	for _, id := range ids {
+		d, err := s.getCallback(id)
+		if _, ok := err.(*UnknownCallbackError); ok {
+			// expired
+			_ = s.client.SRem(context.Background(), callbacksFailedKey, id).Err()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		failed = append(failed, d)
+	}
+	return failed, nil
+}
+
+// sqlCallbackRecord is a callback delivery as stored in the SQL session store.
+type sqlCallbackRecord struct {
+	ID          string `gorm:"primary_key"`
+	Data        []byte
+	NextAttempt int64 `gorm:"index"`
+	Failed      bool  `gorm:"index"`
+	Expires     int64 `gorm:"index"`
+}
+
+func (sqlCallbackRecord) TableName() string {
+	return "irma_callbacks"
+}
+
+func (s *sqlSessionStore) addCallback(d *CallbackDelivery) error {
+	bts, err := json.Marshal(d)
+	if err != nil {
+		return server.LogError(err)
+	}
+	err = s.db.Create(&sqlCallbackRecord{
+		ID:          d.ID,
+		Data:        bts,
+		NextAttempt: d.NextAttempt.UnixNano(),
+		Expires:     callbackExpiry(s.conf, d).UnixNano(),
+	}).Error
+	if err != nil {
+		return logAsSQLError(err)
+	}
+	return nil
+}
+
+func (s *sqlSessionStore) claimCallbacks(now, lease time.Time) ([]*CallbackDelivery, error) {
+	var records []sqlCallbackRecord
+	err := s.db.Where("failed = ? AND next_attempt <= ?", false, now.UnixNano()).Find(&records).Error
+	if err != nil {
+		return nil, logAsSQLError(err)
+	}
+
+	var due []*CallbackDelivery
+	for _, record := range records {
+		// Only one server succeeds in moving the next attempt of the record forward
+		res := s.db.Model(&sqlCallbackRecord{}).
+			Where("id = ? AND next_attempt = ?", record.ID, record.NextAttempt).
+			Update("next_attempt", lease.UnixNano())
+		if res.Error != nil {
+			return nil, logAsSQLError(res.Error)
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		var d CallbackDelivery
+		if err = json.Unmarshal(record.Data, &d); err != nil {
+			return nil, logAsSQLError(err)
+		}
+		d.NextAttempt = lease
+		due = append(due, &d)
+	}
+	return due, nil
+}
+
+func (s *sqlSessionStore) updateCallback(d *CallbackDelivery) error {
+	bts, err := json.Marshal(d)
+	if err != nil {
+		return server.LogError(err)
+	}
+	err = s.db.Model(&sqlCallbackRecord{}).
+		Where("id = ?", d.ID).
+		Updates(map[string]interface{}{
+			"data":         bts,
+			"next_attempt": d.NextAttempt.UnixNano(),
+			"failed":       d.Failed,
+		}).Error
+	if err != nil {
+		return logAsSQLError(err)
+	}
+	return nil
+}
+
+func (s *sqlSessionStore) removeCallback(id string) error {
+	if err := s.db.Where("id = ?", id).Delete(&sqlCallbackRecord{}).Error; err != nil {
+		return logAsSQLError(err)
+	}
+	return nil
+}
+
+func (s *sqlSessionStore) getCallback(id string) (*CallbackDelivery, error) {
+	var record sqlCallbackRecord
+	err := s.db.Where("id = ?", id).First(&record).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, &UnknownCallbackError{id}
+	} else if err != nil {
+		return nil, logAsSQLError(err)
+	}
+	var d CallbackDelivery
+	if err = json.Unmarshal(record.Data, &d); err != nil {
+		return nil, logAsSQLError(err)
+	}
+	return &d, nil
+}
+
+func (s *sqlSessionStore) deleteExpiredCallbacks() {
+	res := s.db.Where("expires < ?", time.Now().UnixNano()).Delete(&sqlCallbackRecord{})
+	if res.Error != nil {
+		_ = logAsSQLError(res.Error)
+		return
+	}
+	if res.RowsAffected > 0 {
+		s.conf.Logger.WithFields(logrus.Fields{"count": res.RowsAffected}).Info("Deleted expired callback deliveries")
+	}
+}
+
+func (s *sqlSessionStore) failedCallbacks() ([]*CallbackDelivery, error) {
+	var records []sqlCallbackRecord
+	if err := s.db.Where("failed = ?", true).Find(&records).Error; err != nil {
+		return nil, logAsSQLError(err)
+	}
+	failed := make([]*CallbackDelivery, 0, len(records))
+	for _, record := range records {
+		var d CallbackDelivery
+		if err := json.Unmarshal(record.Data, &d); err != nil {
+			return nil, logAsSQLError(err)
+		}
+		failed = append(failed, &d)
+	}
+	return failed, nil
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,193 @@
+package irmaserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/stretchr/testify/require"
+)
+
+var callbackHMACKey = []byte("0123456789abcdef0123456789abcdef")
+
+// callbackReceiver is an HTTP server receiving session results, of which the first requests fail.
+type callbackReceiver struct {
+	sync.Mutex
+	*httptest.Server
+	failures   int
+	attempts   int
+	deliveries map[string]int
+	results    []*server.SessionResult
+}
+
+func startCallbackReceiver(t *testing.T, failures int) *callbackReceiver {
+	r := &callbackReceiver{failures: failures, deliveries: map[string]int{}}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.Lock()
+		defer r.Unlock()
+
+		bts, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		require.NoError(t, server.VerifyCallbackSignature(
+			callbackHMACKey, req.Header.Get(server.CallbackSignatureHeader), bts, time.Minute),
+		)
+		r.attempts++
+		r.deliveries[req.Header.Get(CallbackDeliveryHeader)]++
+		if r.attempts <= r.failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var result server.SessionResult
+		require.NoError(t, json.Unmarshal(bts, &result))
+		r.results = append(r.results, &result)
+	}))
+	return r
+}
+
+func (r *callbackReceiver) received() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.results)
+}
+
+func startCallbackSession(t *testing.T, s *Server, url string) irma.RequestorToken {
+	request := &irma.ServiceProviderRequest{
+		RequestorBaseRequest: irma.RequestorBaseRequest{CallbackURL: url},
+		Request:              irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")),
+	}
+	_, token, _, err := s.StartSession(request, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.CancelSession(token))
+	return token
+}
+
+// retryCallbacksUntil retries callback deliveries until the condition is met or a timeout occurs.
+func retryCallbacksUntil(t *testing.T, s *Server, condition func() bool) {
+	for i := 0; i < 100 && !condition(); i++ {
+		time.Sleep(20 * time.Millisecond)
+		s.retryCallbacks()
+	}
+	require.True(t, condition())
+}
+
+func callbacksConf(t *testing.T, maxAttempts int) *server.Configuration {
+	conf := sessionsConf(t)
+	conf.CallbackHMACKey = string(callbackHMACKey)
+	conf.CallbackMaxAttempts = maxAttempts
+	return conf
+}
+
+func TestCallbackRetry(t *testing.T) {
+	defer func(delay time.Duration) { callbackRetryDelay = delay }(callbackRetryDelay)
+	callbackRetryDelay = 10 * time.Millisecond
+
+	s, err := New(callbacksConf(t, 5))
+	require.NoError(t, err)
+	defer s.Stop()
+
+	receiver := startCallbackReceiver(t, 2)
+	defer receiver.Close()
+
+	token := startCallbackSession(t, s, receiver.URL)
+	retryCallbacksUntil(t, s, func() bool { return receiver.received() == 1 })
+
+	receiver.Lock()
+	defer receiver.Unlock()
+	require.Equal(t, 3, receiver.attempts)
+	require.Len(t, receiver.deliveries, 1) // all attempts concern the same delivery
+	require.Equal(t, token, receiver.results[0].Token)
+	require.Equal(t, irma.ServerStatusCancelled, receiver.results[0].Status)
+
+	failed, err := s.FailedCallbacks()
+	require.NoError(t, err)
+	require.Empty(t, failed)
+}
+
+func TestCallbackDeadLetter(t *testing.T) {
+	defer func(delay time.Duration) { callbackRetryDelay = delay }(callbackRetryDelay)
+	callbackRetryDelay = 10 * time.Millisecond
+
+	s, err := New(callbacksConf(t, 2))
+	require.NoError(t, err)
+	defer s.Stop()
+
+	receiver := startCallbackReceiver(t, 2)
+	defer receiver.Close()
+
+	token := startCallbackSession(t, s, receiver.URL)
+
+	var failed []*CallbackDelivery
+	retryCallbacksUntil(t, s, func() bool {
+		failed, err = s.FailedCallbacks()
+		require.NoError(t, err)
+		return len(failed) == 1
+	})
+	require.Equal(t, token, failed[0].Token)
+	require.Equal(t, 2, failed[0].Attempts)
+	require.True(t, failed[0].Failed)
+	require.NotEmpty(t, failed[0].LastError)
+	require.Nil(t, failed[0].Result)
+	require.Equal(t, 0, receiver.received())
+
+	require.IsType(t, &UnknownCallbackError{}, s.ReplayCallback("nonexisting"))
+
+	// After replaying, the receiver no longer fails so the delivery succeeds and is removed
+	id := failed[0].ID
+	require.NoError(t, s.ReplayCallback(id))
+	retryCallbacksUntil(t, s, func() bool { return receiver.received() == 1 })
+
+	failed, err = s.FailedCallbacks()
+	require.NoError(t, err)
+	require.Empty(t, failed)
+	require.IsType(t, &UnknownCallbackError{}, s.DeleteCallback(id))
+}
+
+func TestCallbackRetention(t *testing.T) {
+	conf := callbacksConf(t, 2)
+	require.NoError(t, conf.Check())
+	store := &memorySessionStore{conf: conf, callbacks: map[string]*CallbackDelivery{}}
+
+	retention := time.Duration(conf.CallbackRetention) * time.Hour
+	require.Equal(t, 7*24*time.Hour, retention)
+	old := &CallbackDelivery{ID: "old", Failed: true, Created: time.Now().Add(-retention - time.Minute)}
+	recent := &CallbackDelivery{ID: "recent", Failed: true, Created: time.Now().Add(-retention + time.Minute)}
+	require.NoError(t, store.addCallback(old))
+	require.NoError(t, store.addCallback(recent))
+
+	store.deleteExpiredCallbacks()
+	failed, err := store.failedCallbacks()
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	require.Equal(t, "recent", failed[0].ID)
+}
+
+func TestRedisCallbackRetention(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	conf := callbacksConf(t, 2)
+	require.NoError(t, conf.Check())
+	store := &redisSessionStore{conf: conf, client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	defer store.client.Close()
+
+	d := &CallbackDelivery{ID: "delivery", Failed: true, Created: time.Now().Add(-time.Hour)}
+	require.NoError(t, store.addCallback(d))
+	ttl := mr.TTL(callbackPrefix + d.ID)
+	require.True(t, ttl > 166*time.Hour && ttl <= 167*time.Hour, ttl)
+
+	// Deliveries stay only until the retention period has passed
+	mr.FastForward(167 * time.Hour)
+	failed, err := store.failedCallbacks()
+	require.NoError(t, err)
+	require.Empty(t, failed)
+	require.False(t, mr.Exists(callbacksFailedKey))
+}
@@ -144,6 +144,8 @@ func (session *session) handlePostDisclosure(disclosure *irma.Disclosure) (*irma
 		rerr = session.fail(server.ErrorUnknownPublicKey, err.Error())
 	} else if err != nil {
 		rerr = session.fail(server.ErrorUnknown, err.Error())
+	} else if request.SelfRevocation != nil && session.Result.ProofStatus == irma.ProofStatusValid {
+		rerr = session.selfRevoke(disclosure, *request.SelfRevocation)
 	}
 
 	return &irma.ServerSessionResponse{
@@ -153,6 +155,27 @@ func (session *session) handlePostDisclosure(disclosure *irma.Disclosure) (*irma
 	}, rerr
 }
 
+// selfRevoke revokes the credential that the holder disclosed along with its revocation attribute.
+func (session *session) selfRevoke(disclosure *irma.Disclosure, id irma.CredentialTypeIdentifier) *irma.RemoteError {
+	conf := session.conf.IrmaConfiguration
+	attr, err := disclosure.RevocationAttribute(conf, id)
+	if err != nil {
+		return session.fail(server.ErrorRevocation, err.Error())
+	}
+	if err = conf.Revocation.RevokeAttribute(id, attr); err != nil {
+		return session.fail(server.ErrorRevocation, err.Error())
+	}
+	session.conf.Logger.WithFields(logrus.Fields{"session": session.RequestorToken, "credtype": id}).
+		Info("Credential revoked by its holder")
+	session.conf.Audit(&server.AuditEvent{
+		Type:        server.AuditEventRevoked,
+		Requestor:   session.Requestor,
+		Session:     session.RequestorToken,
+		Credentials: []string{id.String()},
+	})
+	return nil
+}
+
 func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentMessage) (*irma.ServerSessionResponse, *irma.RemoteError) {
 	session.markAlive()
 	request := session.request.(*irma.IssuanceRequest)
@@ -311,7 +334,7 @@ func (s *Server) startNext(session *session, res *irma.ServerSessionResponse) er
 	// All attributes that were disclosed in the previous session, as well as any attributes
 	// from sessions before that, need to be disclosed in the new session as well.
 	// Therefore pass them as parameters to startNextSession
-	qr, token, _, err := s.startNextSession(next, nil, disclosed, session.FrontendAuth)
+	qr, token, _, err := s.startNextSession(next, nil, disclosed, session.FrontendAuth, session.Requestor)
 	if err != nil {
 		return err
 	}
@@ -509,6 +532,37 @@ func (s *Server) handleStaticMessage(w http.ResponseWriter, r *http.Request) {
 	server.WriteResponse(w, qr, nil)
 }
 
+// POST revocation/{credtype}/selfrevoke
+func (s *Server) handleRevocationSelfRevoke(w http.ResponseWriter, r *http.Request) {
+	cred := irma.NewCredentialTypeIdentifier(chi.URLParam(r, "id"))
+	if settings := s.conf.RevocationSettings[cred]; settings == nil || !settings.SelfRevocation {
+		server.WriteResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, "not supported by this server"))
+		return
+	}
+	// Anyone may start self-revocation sessions, so they are limited per client IP address and
+	// as sessions of a requestor of their own
+	if err := s.checkSelfRevocationLimit(r.RemoteAddr); err != nil {
+		if _, ok := err.(*LimitExceededError); ok {
+			server.WriteResponse(w, nil, server.RemoteError(server.ErrorTooManyRequests, err.Error()))
+		} else {
+			server.WriteResponse(w, nil, server.RemoteError(server.ErrorInternal, ""))
+		}
+		return
+	}
+	request := irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier(cred.String()))
+	request.SelfRevocation = &cred
+	qr, _, _, err := s.StartRequestorSession(selfRevocationRequestor, request, nil)
+	if err != nil {
+		if _, ok := err.(*LimitExceededError); ok {
+			server.WriteResponse(w, nil, server.RemoteError(server.ErrorTooManyRequests, err.Error()))
+		} else {
+			server.WriteResponse(w, nil, server.RemoteError(server.ErrorRevocation, err.Error()))
+		}
+		return
+	}
+	server.WriteResponse(w, qr, nil)
+}
+
 // GET revocation/events/{credtype}/{pkcounter}/{min}/{max}
 func (s *Server) handleRevocationGetEvents(w http.ResponseWriter, r *http.Request) {
 	cred := irma.NewCredentialTypeIdentifier(chi.URLParam(r, "id"))
@@ -0,0 +1,88 @@
+package irmaserver
+
+import (
+	"sort"
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+)
+
+// RevocationHealth describes the freshness of the revocation state of the server, per credential
+// type and public key counter.
+type RevocationHealth struct {
+	Healthy    bool                         `json:"healthy"`
+	Revocation []*RevocationFreshnessStatus `json:"revocation,omitempty"`
+}
+
+// RevocationFreshnessStatus is the freshness of the accumulator of a credential type for one of
+// the public keys of its issuer, along with the maximum age that it is allowed to have. If the
+// server has no accumulator at all of a credential type in its revocation settings, its status
+// is Unknown and unhealthy, and only CredType and MaxAge are set.
+type RevocationFreshnessStatus struct {
+	*irma.RevocationFreshness
+
+	// Age and MaxAge are in seconds.
+	Age     uint64 `json:"age"`
+	MaxAge  uint64 `json:"maxAge"`
+	Healthy bool   `json:"healthy"`
+	Unknown bool   `json:"unknown,omitempty"`
+}
+
+// GetRevocationHealth reports whether the accumulators of all credential types whose revocation
+// state the server keeps are at most as old as allowed by RevocationMaxAge or, if that is not
+// set, by the tolerance of the credential type. Verifiers reject nonrevocation proofs against
+// accumulators older than that, so sessions requesting them fail if the state is unhealthy.
+// Revocable credential types in the revocation settings of which the server has not yet stored
+// or fetched any accumulator are reported as unhealthy.
+func GetRevocationHealth() *RevocationHealth {
+	return s.GetRevocationHealth()
+}
+func (s *Server) GetRevocationHealth() *RevocationHealth {
+	health := &RevocationHealth{Healthy: true}
+	known := map[irma.CredentialTypeIdentifier]struct{}{}
+	for _, f := range s.conf.IrmaConfiguration.Revocation.Freshness() {
+		known[f.CredType] = struct{}{}
+		maxAge := s.revocationMaxAge(f.CredType)
+		var age uint64
+		if d := time.Since(f.AccumulatorTime); d > 0 {
+			age = uint64(d / time.Second)
+		}
+		status := &RevocationFreshnessStatus{
+			RevocationFreshness: f,
+			Age:                 age,
+			MaxAge:              maxAge,
+			Healthy:             age <= maxAge,
+		}
+		health.Healthy = health.Healthy && status.Healthy
+		health.Revocation = append(health.Revocation, status)
+	}
+
+	for id := range s.conf.RevocationSettings {
+		if _, ok := known[id]; ok {
+			continue
+		}
+		if credtype := s.conf.IrmaConfiguration.CredentialTypes[id]; credtype == nil || !credtype.RevocationSupported() {
+			continue
+		}
+		health.Healthy = false
+		health.Revocation = append(health.Revocation, &RevocationFreshnessStatus{
+			RevocationFreshness: &irma.RevocationFreshness{CredType: id},
+			MaxAge:              s.revocationMaxAge(id),
+			Unknown:             true,
+		})
+	}
+	sort.SliceStable(health.Revocation, func(i, j int) bool {
+		return health.Revocation[i].CredType.String() < health.Revocation[j].CredType.String()
+	})
+	return health
+}
+
+func (s *Server) revocationMaxAge(id irma.CredentialTypeIdentifier) uint64 {
+	if s.conf.RevocationMaxAge != 0 {
+		return s.conf.RevocationMaxAge
+	}
+	if settings := s.conf.RevocationSettings[id]; settings != nil && settings.Tolerance != 0 {
+		return settings.Tolerance
+	}
+	return irma.RevocationParameters.DefaultTolerance
+}
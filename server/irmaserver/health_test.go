@@ -0,0 +1,30 @@
+package irmaserver
+
+import (
+	"testing"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocationHealthWithoutAccumulator(t *testing.T) {
+	revocable := irma.NewCredentialTypeIdentifier("irma-demo.MijnOverheid.root")
+	conf := sessionsConf(t)
+	conf.RevocationSettings = irma.RevocationSettings{
+		revocable: {RevocationServerURL: "http://localhost:48683"},
+		irma.NewCredentialTypeIdentifier("irma-demo.RU.studentCard"): {Tolerance: 60},
+	}
+	s, err := New(conf)
+	require.NoError(t, err)
+	defer s.Stop()
+
+	// Nothing has been stored or fetched yet for the revocable credential type
+	health := s.GetRevocationHealth()
+	require.False(t, health.Healthy)
+	require.Len(t, health.Revocation, 1)
+	status := health.Revocation[0]
+	require.Equal(t, revocable, status.CredType)
+	require.True(t, status.Unknown)
+	require.False(t, status.Healthy)
+	require.Equal(t, irma.RevocationParameters.DefaultTolerance, status.MaxAge)
+}
@@ -42,9 +42,35 @@ func (session *session) setStatus(status irma.ServerStatus) {
 		Info("Session status updated")
 	session.Status = status
 	session.Result.Status = status
+	session.updateMetrics()
+	session.removeLiveSession()
+	session.audit()
 	session.onStatusChange()
 }
 
+// auditEventTypes maps session statuses to the type of the audit event that is recorded when a
+// session reaches that status.
+var auditEventTypes = map[irma.ServerStatus]server.AuditEventType{
+	irma.ServerStatusInitialized: server.AuditEventCreated,
+	irma.ServerStatusConnected:   server.AuditEventConnected,
+	irma.ServerStatusDone:        server.AuditEventDone,
+	irma.ServerStatusCancelled:   server.AuditEventCancelled,
+	irma.ServerStatusTimeout:     server.AuditEventTimeout,
+}
+
+// audit records the current status of the session in the audit log, if any.
+func (session *session) audit() {
+	typ, ok := auditEventTypes[session.Status]
+	if !ok || session.conf.AuditLog == nil {
+		return
+	}
+	event := server.NewAuditEvent(typ, session.Requestor, session.RequestorToken, session.request)
+	if session.Status == irma.ServerStatusDone && session.Result != nil {
+		event.ProofStatus = session.Result.ProofStatus
+	}
+	session.conf.Audit(event)
+}
+
 func (session *session) onStatusChange() {
 	// Send status update to all listener channels
 	for _, statusChan := range session.statusChannels {
@@ -81,19 +107,6 @@ func (session *session) onStatusChange() {
 	)
 }
 
-func (session *session) doResultCallback() {
-	url := session.Rrequest.Base().CallbackURL
-	if url == "" {
-		return
-	}
-	server.DoResultCallback(url,
-		session.Result,
-		session.conf.JwtIssuer,
-		session.Rrequest.Base().ResultJwtValidity,
-		session.conf.JwtRSAPrivateKey,
-	)
-}
-
 // Checks whether requested options are valid in the current session context.
 func (session *session) updateFrontendOptions(request *irma.FrontendOptionsRequest) (*irma.SessionOptions, error) {
 	if session.Status != irma.ServerStatusInitialized {
@@ -440,6 +453,14 @@ func (s *Server) validateRequest(request irma.SessionRequest) error {
 			return errors.New("cannot augment empty client return url")
 		}
 	}
+	if id := request.Disclosure().SelfRevocation; id != nil {
+		if request.Action() != irma.ActionDisclosing {
+			return errors.New("self-revocation is only possible in disclosure sessions")
+		}
+		if settings := s.conf.RevocationSettings[*id]; settings == nil || !settings.SelfRevocation {
+			return errors.Errorf("self-revocation of %s not enabled in server configuration", id)
+		}
+	}
 	return request.Disclosure().Disclose.Validate(s.conf.IrmaConfiguration)
 }
 
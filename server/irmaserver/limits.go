@@ -0,0 +1,401 @@
+package irmaserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/jinzhu/gorm"
+	irma "github.com/privacybydesign/irmago"
+)
+
+// LimitExceededError is returned when a requestor exceeds one of its rate limits or quotas.
+type LimitExceededError struct {
+	Requestor string
+	Limit     string
+	Max       int
+}
+
+func (err *LimitExceededError) Error() string {
+	return fmt.Sprintf("requestor %s exceeded its limit of %d %s", err.Requestor, err.Max, err.Limit)
+}
+
+// limitStore keeps track of the amount of requests that requestors make within a time window,
+// and of their live sessions, such that rate limits and quotas apply across all servers that
+// share the session store.
+type limitStore interface {
+	// incrementRequests increments by n (which may be negative) and returns the amount of
+	// requests made for the key within the current window.
+	incrementRequests(key string, n int, window time.Duration) (int, error)
+	// addLiveSession registers a live session of the requestor until it is removed or expires,
+	// if the requestor has less than max live sessions. Counting and registering are atomic, so
+	// that concurrently started sessions cannot exceed the maximum. Returns whether it was added.
+	addLiveSession(requestor string, token irma.RequestorToken, expires time.Time, max int) (bool, error)
+	removeLiveSession(requestor string, token irma.RequestorToken) error
+}
+
+const (
+	rateLimitPrefix    = "ratelimit:"
+	liveSessionsPrefix = "livesessions:"
+
+	// selfRevocationRequestor is the requestor of self-revocation sessions, so that the limits
+	// on live sessions apply to them although no requestor starts them.
+	selfRevocationRequestor = "selfrevoke"
+)
+
+type rateCounter struct {
+	requests int
+	expires  time.Time
+}
+
+// checkSessionLimits checks whether the requestor of the new session may start it without
+// exceeding the limits returned by requestorLimits, and if so,
+// counts the session towards the sessions per minute, and registers it as a live session of the
+// requestor if its concurrent sessions are limited.
+// If a limit would be exceeded, a *LimitExceededError is returned.
+func (s *Server) checkSessionLimits(session *session) error {
+	if session.Requestor == "" {
+		return nil
+	}
+	perMinute, concurrent := s.requestorLimits(session.Requestor)
+	if perMinute <= 0 && concurrent <= 0 {
+		return nil
+	}
+	store, err := s.limitStore()
+	if err != nil {
+		return err
+	}
+	if concurrent > 0 {
+		added, err := store.addLiveSession(session.Requestor, session.RequestorToken, session.liveUntil(), concurrent)
+		if err != nil {
+			return err
+		}
+		if !added {
+			return &LimitExceededError{Requestor: session.Requestor, Limit: "concurrent sessions", Max: concurrent}
+		}
+	}
+	if err := s.checkRate(session.Requestor, "sessions", "sessions per minute", perMinute, 1, time.Minute); err != nil {
+		if concurrent > 0 {
+			_ = store.removeLiveSession(session.Requestor, session.RequestorToken)
+		}
+		return err
+	}
+	return nil
+}
+
+// requestorLimits returns the maximum amount of sessions per minute and of concurrent live
+// sessions of the requestor, as returned by the RequestorLimits function of the configuration
+// or, for self-revocation sessions, as configured for those.
+func (s *Server) requestorLimits(requestor string) (perMinute, concurrent int) {
+	if requestor == selfRevocationRequestor {
+		return 0, s.conf.SelfRevocationMaxConcurrentSessions
+	}
+	if s.conf.RequestorLimits == nil {
+		return 0, 0
+	}
+	return s.conf.RequestorLimits(requestor)
+}
+
+// checkSelfRevocationLimit checks whether the client at the specified address may start another
+// self-revocation session, and if so, counts it.
+func (s *Server) checkSelfRevocationLimit(remoteAddr string) error {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return s.checkRate(host, "selfrevoke", "self-revocation sessions per minute",
+		s.conf.SelfRevocationMaxSessionsPerIP, 1, time.Minute)
+}
+
+// CheckRevocationLimit checks whether the requestor may revoke the specified amount of credentials
+// without exceeding the specified maximum amount of revocations per hour, and if so, counts the
+// revocations. A maximum of 0 means no limit.
+// If the limit would be exceeded, a *LimitExceededError is returned.
+func CheckRevocationLimit(requestor string, perHour, count int) error {
+	return s.CheckRevocationLimit(requestor, perHour, count)
+}
+func (s *Server) CheckRevocationLimit(requestor string, perHour, count int) error {
+	return s.checkRate(requestor, "revocations", "revocations per hour", perHour, count, time.Hour)
+}
+
+// RefundRevocationLimit no longer counts the specified amount of revocations, previously counted
+// by CheckRevocationLimit, towards the limit of the requestor, for when revoking them failed.
+func RefundRevocationLimit(requestor string, perHour, count int) error {
+	return s.RefundRevocationLimit(requestor, perHour, count)
+}
+func (s *Server) RefundRevocationLimit(requestor string, perHour, count int) error {
+	if perHour <= 0 {
+		return nil
+	}
+	store, err := s.limitStore()
+	if err != nil {
+		return err
+	}
+	_, err = store.incrementRequests(rateKey("revocations", requestor, time.Hour), -count, time.Hour)
+	return err
+}
+
+func rateKey(kind, requestor string, window time.Duration) string {
+	return fmt.Sprintf("%s:%s:%d", kind, requestor, time.Now().UnixNano()/int64(window))
+}
+
+func (s *Server) checkRate(requestor, kind, limit string, max, n int, window time.Duration) error {
+	if max <= 0 {
+		return nil
+	}
+	store, err := s.limitStore()
+	if err != nil {
+		return err
+	}
+	key := rateKey(kind, requestor, window)
+	requests, err := store.incrementRequests(key, n, window)
+	if err != nil {
+		return err
+	}
+	if requests > max {
+		// Rejected requests do not count towards the limit, so retrying does not extend the lockout
+		if _, err = store.incrementRequests(key, -n, window); err != nil {
+			return err
+		}
+		return &LimitExceededError{Requestor: requestor, Limit: limit, Max: max}
+	}
+	return nil
+}
+
+func (s *Server) limitStore() (limitStore, error) {
+	store, ok := s.sessions.(limitStore)
+	if !ok {
+		return nil, errors.New("session store does not support requestor limits")
+	}
+	return store, nil
+}
+
+// liveUntil returns the moment after which the session is certainly no longer live, in case
+// its removal from the live sessions of its requestor is missed.
+func (session *session) liveUntil() time.Time {
+	lifetime := time.Duration(session.conf.MaxSessionLifetime) * time.Minute
+	return session.Started.Add(session.storeTimeout() + lifetime)
+}
+
+func (session *session) removeLiveSession() {
+	if session.Requestor == "" || !session.Status.Finished() {
+		return
+	}
+	if store, ok := session.sessions.(limitStore); ok {
+		_ = store.removeLiveSession(session.Requestor, session.RequestorToken)
+	}
+}
+
+func (s *memorySessionStore) incrementRequests(key string, n int, window time.Duration) (int, error) {
+	s.limitLock.Lock()
+	defer s.limitLock.Unlock()
+	counter, ok := s.rates[key]
+	if !ok {
+		counter = &rateCounter{expires: time.Now().Add(window)}
+		s.rates[key] = counter
+	}
+	counter.requests += n
+	return counter.requests, nil
+}
+
+func (s *memorySessionStore) addLiveSession(requestor string, token irma.RequestorToken, expires time.Time, max int) (bool, error) {
+	s.limitLock.Lock()
+	defer s.limitLock.Unlock()
+	count := 0
+	now := time.Now()
+	for _, e := range s.live[requestor] {
+		if e.After(now) {
+			count++
+		}
+	}
+	if count >= max {
+		return false, nil
+	}
+	if s.live[requestor] == nil {
+		s.live[requestor] = map[irma.RequestorToken]time.Time{}
+	}
+	s.live[requestor][token] = expires
+	return true, nil
+}
+
+func (s *memorySessionStore) removeLiveSession(requestor string, token irma.RequestorToken) error {
+	s.limitLock.Lock()
+	defer s.limitLock.Unlock()
+	delete(s.live[requestor], token)
+	if len(s.live[requestor]) == 0 {
+		delete(s.live, requestor)
+	}
+	return nil
+}
+
+func (s *memorySessionStore) deleteExpiredLimits() {
+	s.limitLock.Lock()
+	defer s.limitLock.Unlock()
+	now := time.Now()
+	for key, counter := range s.rates {
+		if counter.expires.Before(now) {
+			delete(s.rates, key)
+		}
+	}
+	for requestor, sessions := range s.live {
+		for token, expires := range sessions {
+			if expires.Before(now) {
+				delete(sessions, token)
+			}
+		}
+		if len(sessions) == 0 {
+			delete(s.live, requestor)
+		}
+	}
+}
+
+func (s *redisSessionStore) incrementRequests(key string, n int, window time.Duration) (int, error) {
+	ctx := context.Background()
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.IncrBy(ctx, rateLimitPrefix+key, int64(n))
+		pipe.PExpire(ctx, rateLimitPrefix+key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, logAsRedisError(err)
+	}
+	return int(incr.Val()), nil
+}
+
+// redisAddLiveSession atomically removes the expired sessions from the sorted set in KEYS[1]
+// (scored by their expiry), and adds the session ARGV[3] expiring at ARGV[2] if less than
+// ARGV[4] sessions remain. Returns 1 if the session was added and 0 otherwise.
+var redisAddLiveSession = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[4]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+return 1`)
+
+func (s *redisSessionStore) addLiveSession(requestor string, token irma.RequestorToken, expires time.Time, max int) (bool, error) {
+	added, err := redisAddLiveSession.Run(context.Background(), s.client,
+		[]string{liveSessionsPrefix + requestor}, unixMilli(time.Now()), unixMilli(expires), string(token), max,
+	).Int()
+	if err != nil {
+		return false, logAsRedisError(err)
+	}
+	return added == 1, nil
+}
+
+func (s *redisSessionStore) removeLiveSession(requestor string, token irma.RequestorToken) error {
+	if err := s.client.ZRem(context.Background(), liveSessionsPrefix+requestor, string(token)).Err(); err != nil {
+		return logAsRedisError(err)
+	}
+	return nil
+}
+
+// sqlRateCounter is the amount of requests for a key within a time window, as stored in the SQL session store.
+type sqlRateCounter struct {
+	ID       string `gorm:"primary_key"`
+	Requests int
+	Expires  int64 `gorm:"index"`
+}
+
+func (sqlRateCounter) TableName() string {
+	return "irma_rate_counters"
+}
+
+// sqlLiveSession is a live session of a requestor, as stored in the SQL session store.
+type sqlLiveSession struct {
+	Token     string `gorm:"primary_key"`
+	Requestor string `gorm:"index"`
+	Expires   int64  `gorm:"index"`
+}
+
+func (sqlLiveSession) TableName() string {
+	return "irma_live_sessions"
+}
+
+func (s *sqlSessionStore) incrementRequests(key string, n int, window time.Duration) (int, error) {
+	increment := func() (int64, error) {
+		res := s.db.Model(&sqlRateCounter{}).Where("id = ?", key).
+			UpdateColumn("requests", gorm.Expr("requests + ?", n))
+		return res.RowsAffected, res.Error
+	}
+
+	updated, err := increment()
+	if err != nil {
+		return 0, logAsSQLError(err)
+	}
+	if updated == 0 {
+		err = s.db.Create(&sqlRateCounter{ID: key, Requests: n, Expires: time.Now().Add(window).UnixNano()}).Error
+		if err != nil {
+			// Another server may have created the counter in the meantime
+			if _, err = increment(); err != nil {
+				return 0, logAsSQLError(err)
+			}
+		}
+	}
+
+	var counter sqlRateCounter
+	if err = s.db.Where("id = ?", key).First(&counter).Error; err != nil {
+		return 0, logAsSQLError(err)
+	}
+	return counter.Requests, nil
+}
+
+func (s *sqlSessionStore) addLiveSession(requestor string, token irma.RequestorToken, expires time.Time, max int) (bool, error) {
+	// Concurrent transactions adding live sessions of the requestor are serialized by locking
+	// a row of the requestor in the rate counter table, as locking the live sessions themselves
+	// would not prevent others from being inserted in the meantime.
+	lock := liveSessionsPrefix + requestor
+	_ = s.db.Create(&sqlRateCounter{ID: lock, Expires: expires.UnixNano()}).Error // fails if it already exists
+
+	added := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&sqlRateCounter{}).Where("id = ?", lock).UpdateColumns(map[string]interface{}{
+			"requests": gorm.Expr("requests + 1"),
+			"expires":  expires.UnixNano(),
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errors.New("failed to lock live sessions of requestor")
+		}
+		var count int
+		err := tx.Model(&sqlLiveSession{}).
+			Where("requestor = ? AND expires > ?", requestor, time.Now().UnixNano()).
+			Count(&count).Error
+		if err != nil || count >= max {
+			return err
+		}
+		added = true
+		return tx.Create(&sqlLiveSession{
+			Token:     string(token),
+			Requestor: requestor,
+			Expires:   expires.UnixNano(),
+		}).Error
+	})
+	if err != nil {
+		return false, logAsSQLError(err)
+	}
+	return added, nil
+}
+
+func (s *sqlSessionStore) removeLiveSession(_ string, token irma.RequestorToken) error {
+	if err := s.db.Where("token = ?", string(token)).Delete(&sqlLiveSession{}).Error; err != nil {
+		return logAsSQLError(err)
+	}
+	return nil
+}
+
+func (s *sqlSessionStore) deleteExpiredLimits() {
+	now := time.Now().UnixNano()
+	if err := s.db.Where("expires < ?", now).Delete(&sqlRateCounter{}).Error; err != nil {
+		_ = logAsSQLError(err)
+	}
+	if err := s.db.Where("expires < ?", now).Delete(&sqlLiveSession{}).Error; err != nil {
+		_ = logAsSQLError(err)
+	}
+}
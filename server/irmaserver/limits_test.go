@@ -0,0 +1,79 @@
+package irmaserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocationLimitCountsBatch(t *testing.T) {
+	s, err := New(sessionsConf(t))
+	require.NoError(t, err)
+	defer s.Stop()
+
+	require.NoError(t, s.CheckRevocationLimit("requestor", 5, 3))
+	require.NoError(t, s.CheckRevocationLimit("requestor", 5, 2))
+	err = s.CheckRevocationLimit("requestor", 5, 1)
+	require.IsType(t, &LimitExceededError{}, err)
+
+	// A single batch exceeding the limit is refused as a whole
+	err = s.CheckRevocationLimit("other", 5, 6)
+	require.IsType(t, &LimitExceededError{}, err)
+
+	require.NoError(t, s.CheckRevocationLimit("unlimited", 0, 100))
+}
+
+func TestRateLimitIgnoresRejectedRequests(t *testing.T) {
+	s, err := New(sessionsConf(t))
+	require.NoError(t, err)
+	defer s.Stop()
+
+	require.NoError(t, s.CheckRevocationLimit("requestor", 5, 4))
+
+	// The first request over the limit is rejected, without counting towards the limit
+	err = s.CheckRevocationLimit("requestor", 5, 2)
+	require.IsType(t, &LimitExceededError{}, err)
+	err = s.CheckRevocationLimit("requestor", 5, 2)
+	require.IsType(t, &LimitExceededError{}, err)
+
+	// so that a request within the limit is still accepted
+	require.NoError(t, s.CheckRevocationLimit("requestor", 5, 1))
+	err = s.CheckRevocationLimit("requestor", 5, 1)
+	require.IsType(t, &LimitExceededError{}, err)
+}
+
+func TestRefundRevocationLimit(t *testing.T) {
+	s, err := New(sessionsConf(t))
+	require.NoError(t, err)
+	defer s.Stop()
+
+	require.NoError(t, s.CheckRevocationLimit("requestor", 5, 5))
+	err = s.CheckRevocationLimit("requestor", 5, 1)
+	require.IsType(t, &LimitExceededError{}, err)
+
+	// Revocations that failed no longer count towards the limit
+	require.NoError(t, s.RefundRevocationLimit("requestor", 5, 3))
+	require.NoError(t, s.CheckRevocationLimit("requestor", 5, 3))
+	err = s.CheckRevocationLimit("requestor", 5, 1)
+	require.IsType(t, &LimitExceededError{}, err)
+}
+
+func TestSelfRevocationLimits(t *testing.T) {
+	conf := sessionsConf(t)
+	conf.SelfRevocationMaxConcurrentSessions = 3
+	conf.SelfRevocationMaxSessionsPerIP = 2
+	s, err := New(conf)
+	require.NoError(t, err)
+	defer s.Stop()
+
+	perMinute, concurrent := s.requestorLimits(selfRevocationRequestor)
+	require.Zero(t, perMinute)
+	require.Equal(t, 3, concurrent)
+
+	// Self-revocation sessions are limited per IP address, regardless of the port
+	require.NoError(t, s.checkSelfRevocationLimit("192.0.2.1:1234"))
+	require.NoError(t, s.checkSelfRevocationLimit("192.0.2.1:1235"))
+	err = s.checkSelfRevocationLimit("192.0.2.1:1236")
+	require.IsType(t, &LimitExceededError{}, err)
+	require.NoError(t, s.checkSelfRevocationLimit("192.0.2.2:1234"))
+}
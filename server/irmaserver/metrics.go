@@ -0,0 +1,163 @@
+package irmaserver
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricSessionsStarted = promauto.With(server.Metrics).NewCounterVec(prometheus.CounterOpts{
+		Name: "irma_sessions_started_total",
+		Help: "Number of IRMA sessions started, per session type and requestor",
+	}, []string{"action", "requestor"})
+	metricSessionsFinished = promauto.With(server.Metrics).NewCounterVec(prometheus.CounterOpts{
+		Name: "irma_sessions_finished_total",
+		Help: "Number of IRMA sessions that finished (DONE), were cancelled (CANCELLED) or timed out (TIMEOUT), per session type and requestor",
+	}, []string{"action", "requestor", "status"})
+	metricProofStatus = promauto.With(server.Metrics).NewCounterVec(prometheus.CounterOpts{
+		Name: "irma_sessions_proof_status_total",
+		Help: "Number of finished IRMA sessions per proof status, session type and requestor",
+	}, []string{"action", "requestor", "proof_status"})
+	metricSessionDuration = promauto.With(server.Metrics).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "irma_session_duration_seconds",
+		Help:    "Duration of IRMA sessions from their start until they finished, per session type and status",
+		Buckets: server.SessionDurationBuckets,
+	}, []string{"action", "status"})
+	metricRevocationUpdateDuration = promauto.With(server.Metrics).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "irma_revocation_update_fetch_duration_seconds",
+		Help:    "Latency of fetching revocation updates from the revocation server, per credential type and result",
+		Buckets: server.DurationBuckets,
+	}, []string{"credtype", "result"})
+	metricIssuanceRecordsDeleted = promauto.With(server.Metrics).NewCounterVec(prometheus.CounterOpts{
+		Name: "irma_revocation_issuance_records_deleted_total",
+		Help: "Number of issuance records of expired credentials deleted, per credential type and whether the credentials were revoked",
+	}, []string{"credtype", "revoked"})
+)
+
+// serverCollector collects the gauges that depend on the state of the servers having metrics
+// enabled, aggregated over all such servers in this process.
+type serverCollector struct {
+	sync.Mutex
+	servers map[*Server]struct{}
+}
+
+var (
+	servers = &serverCollector{servers: map[*Server]struct{}{}}
+
+	descSessionStoreSize = prometheus.NewDesc(
+		"irma_session_store_size",
+		"Number of sessions currently in the session stores of all servers",
+		nil, nil,
+	)
+	descAccumulatorAge = prometheus.NewDesc(
+		"irma_revocation_accumulator_age_seconds",
+		"Age of the latest accumulator of revocable credential types, per credential type and public key counter",
+		[]string{"credtype", "pk"}, nil,
+	)
+	descLastSyncAge = prometheus.NewDesc(
+		"irma_revocation_last_sync_age_seconds",
+		"Time since revocation updates were last fetched from the revocation server, per credential type and public key counter",
+		[]string{"credtype", "pk"}, nil,
+	)
+)
+
+func init() {
+	server.Metrics.MustRegister(servers)
+}
+
+// registerMetrics registers the server with the collector of its gauges, and observes the
+// revocation updates that it fetches and the issuance records that it deletes.
+func (s *Server) registerMetrics() {
+	servers.Lock()
+	servers.servers[s] = struct{}{}
+	servers.Unlock()
+
+	s.conf.IrmaConfiguration.Revocation.SyncListener = func(id irma.CredentialTypeIdentifier, duration time.Duration, err error) {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		metricRevocationUpdateDuration.WithLabelValues(id.String(), result).Observe(duration.Seconds())
+	}
+	s.conf.IrmaConfiguration.Revocation.PruneListener = func(id irma.CredentialTypeIdentifier, revoked bool, count int64) {
+		metricIssuanceRecordsDeleted.WithLabelValues(id.String(), strconv.FormatBool(revoked)).Add(float64(count))
+	}
+}
+
+func (s *Server) unregisterMetrics() {
+	servers.Lock()
+	defer servers.Unlock()
+	delete(servers.servers, s)
+}
+
+func (c *serverCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descSessionStoreSize
+	ch <- descAccumulatorAge
+	ch <- descLastSyncAge
+}
+
+// Collect sums the session store sizes of the servers. Servers may share their revocation
+// storage, so of the revocation freshness of each accumulator the oldest moment is reported.
+func (c *serverCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.servers) == 0 {
+		return
+	}
+
+	size := 0.
+	accumulatorAges := map[[2]string]float64{}
+	syncAges := map[[2]string]float64{}
+	for s := range c.servers {
+		if n, err := s.sessions.size(); err != nil {
+			size = math.NaN()
+		} else {
+			size += float64(n)
+		}
+		for _, f := range s.conf.IrmaConfiguration.Revocation.Freshness() {
+			labels := [2]string{f.CredType.String(), strconv.FormatUint(uint64(f.PKCounter), 10)}
+			maxAge(accumulatorAges, labels, f.AccumulatorTime)
+			maxAge(syncAges, labels, f.LastSync)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(descSessionStoreSize, prometheus.GaugeValue, size)
+	for labels, age := range accumulatorAges {
+		ch <- prometheus.MustNewConstMetric(descAccumulatorAge, prometheus.GaugeValue, age, labels[0], labels[1])
+	}
+	for labels, age := range syncAges {
+		ch <- prometheus.MustNewConstMetric(descLastSyncAge, prometheus.GaugeValue, age, labels[0], labels[1])
+	}
+}
+
+// maxAge records the time elapsed since the specified moment, if it is known and exceeds the
+// age already recorded for the labels.
+func maxAge(ages map[[2]string]float64, labels [2]string, moment time.Time) {
+	if moment.IsZero() {
+		return
+	}
+	if age, ok := ages[labels]; !ok || time.Since(moment).Seconds() > age {
+		ages[labels] = time.Since(moment).Seconds()
+	}
+}
+
+func (session *session) updateMetrics() {
+	if !session.Status.Finished() {
+		return
+	}
+	action, status := string(session.Action), string(session.Status)
+	metricSessionsFinished.WithLabelValues(action, session.Requestor, status).Inc()
+	if session.Result != nil && session.Result.ProofStatus != "" {
+		metricProofStatus.WithLabelValues(action, session.Requestor, string(session.Result.ProofStatus)).Inc()
+	}
+	if !session.Started.IsZero() {
+		metricSessionDuration.WithLabelValues(action, status).Observe(time.Since(session.Started).Seconds())
+	}
+}
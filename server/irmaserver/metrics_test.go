@@ -0,0 +1,43 @@
+package irmaserver
+
+import (
+	"strings"
+	"testing"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionStoreSizeMetric(t *testing.T) {
+	newServer := func() *Server {
+		conf := sessionsConf(t)
+		conf.EnableMetrics = true
+		s, err := New(conf)
+		require.NoError(t, err)
+		return s
+	}
+	requireSize := func(size string) {
+		expected := "# HELP irma_session_store_size Number of sessions currently in the session stores of all servers\n" +
+			"# TYPE irma_session_store_size gauge\n" +
+			"irma_session_store_size " + size + "\n"
+		require.NoError(t, testutil.CollectAndCompare(servers, strings.NewReader(expected), "irma_session_store_size"))
+	}
+	request := irma.NewDisclosureRequest(irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID"))
+
+	// The sessions of all servers are counted
+	s1, s2 := newServer(), newServer()
+	_, _, _, err := s1.StartSession(request, nil)
+	require.NoError(t, err)
+	_, _, _, err = s2.StartSession(request, nil)
+	require.NoError(t, err)
+	_, _, _, err = s2.StartSession(request, nil)
+	require.NoError(t, err)
+	requireSize("3")
+
+	// Stopped servers are no longer counted
+	s2.Stop()
+	requireSize("1")
+	s1.Stop()
+	require.Zero(t, testutil.CollectAndCount(servers))
+}
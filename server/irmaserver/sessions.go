@@ -10,6 +10,9 @@ import (
 	"time"
 
 	"github.com/bsm/redislock"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
 
 	"github.com/alexandrevicenzi/go-sse"
 	"github.com/go-redis/redis/v8"
@@ -27,6 +30,7 @@ type session struct {
 	sse            *sse.Server
 	locked         bool
 	lock           *redislock.Lock
+	lockToken      string
 	hashBefore     *[32]byte
 	sessions       sessionStore
 	conf           *server.Configuration
@@ -54,6 +58,8 @@ type sessionData struct {
 	ImplicitDisclosure irma.AttributeConDisCon
 	Options            irma.SessionOptions
 	ClientAuth         irma.ClientAuthorization
+	Requestor          string    `json:",omitempty"` // name of the requestor that started the session, if known
+	Started            time.Time // moment at which the session was started
 }
 
 type responseCache struct {
@@ -64,21 +70,46 @@ type responseCache struct {
 	SessionStatus irma.ServerStatus
 }
 
+// sessionStore stores sessions. Session stores may also implement callbackStore, limitStore
+// and sessionLister, which is checked using type assertions where these are needed.
 type sessionStore interface {
 	get(token irma.RequestorToken) (*session, error)
 	clientGet(token irma.ClientToken) (*session, error)
 	add(session *session) error
 	update(session *session) error
 	unlock(session *session)
+	size() (int, error)
 	stop()
 }
 
+var (
+	_ sessionStore  = (*memorySessionStore)(nil)
+	_ callbackStore = (*memorySessionStore)(nil)
+	_ limitStore    = (*memorySessionStore)(nil)
+	_ sessionLister = (*memorySessionStore)(nil)
+	_ sessionStore  = (*redisSessionStore)(nil)
+	_ callbackStore = (*redisSessionStore)(nil)
+	_ limitStore    = (*redisSessionStore)(nil)
+	_ sessionLister = (*redisSessionStore)(nil)
+	_ sessionStore  = (*sqlSessionStore)(nil)
+	_ callbackStore = (*sqlSessionStore)(nil)
+	_ limitStore    = (*sqlSessionStore)(nil)
+	_ sessionLister = (*sqlSessionStore)(nil)
+)
+
 type memorySessionStore struct {
 	sync.RWMutex
 	conf *server.Configuration
 
 	requestor map[irma.RequestorToken]*session
 	client    map[irma.ClientToken]*session
+
+	callbackLock sync.Mutex
+	callbacks    map[string]*CallbackDelivery
+
+	limitLock sync.Mutex
+	rates     map[string]*rateCounter
+	live      map[string]map[irma.RequestorToken]time.Time
 }
 
 type redisSessionStore struct {
@@ -87,6 +118,26 @@ type redisSessionStore struct {
 	conf   *server.Configuration
 }
 
+// sqlSessionStore stores sessions in a Postgres or MySQL database, such that multiple IRMA servers
+// can share their session state without needing Redis.
+type sqlSessionStore struct {
+	db   *gorm.DB
+	conf *server.Configuration
+}
+
+// sqlSessionRecord is a session as stored in the SQL session store. Timestamps are stored as
+// Unix timestamps in nanoseconds, as not all supported databases store times precisely enough
+// for the session locks.
+type sqlSessionRecord struct {
+	ClientToken    string `gorm:"primary_key"`
+	RequestorToken string `gorm:"unique_index"`
+	Data           []byte
+	LastActive     int64
+	Expires        int64 `gorm:"index"`
+	LockToken      string
+	LockExpires    int64
+}
+
 type RedisError struct {
 	err error
 }
@@ -95,6 +146,14 @@ func (err *RedisError) Error() string {
 	return fmt.Sprintf("redis error: %s", err.err)
 }
 
+type SQLError struct {
+	err error
+}
+
+func (err *SQLError) Error() string {
+	return fmt.Sprintf("sql error: %s", err.err)
+}
+
 type UnknownSessionError struct {
 	requestorToken irma.RequestorToken
 	clientToken    irma.ClientToken
@@ -109,7 +168,7 @@ func (err *UnknownSessionError) Error() string {
 }
 
 const (
-	maxLockLifetime            = 500 * time.Millisecond // After this the Redis lock self-deletes, preventing a deadlock
+	maxLockLifetime            = 500 * time.Millisecond // After this the Redis or SQL lock self-deletes, preventing a deadlock
 	minLockRetryTime           = 30 * time.Millisecond
 	maxLockRetryTime           = 2 * time.Second
 	requestorTokenLookupPrefix = "token:"
@@ -187,6 +246,12 @@ func (s *memorySessionStore) stop() {
 	}
 }
 
+func (s *memorySessionStore) size() (int, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.requestor), nil
+}
+
 func (s *memorySessionStore) deleteExpired() {
 	// First check which sessions have expired
 	// We don't need a write lock for this yet, so postpone that for actual deleting
@@ -232,6 +297,9 @@ func (s *memorySessionStore) deleteExpired() {
 		delete(s.requestor, token)
 	}
 	s.Unlock()
+
+	s.deleteExpiredLimits()
+	s.deleteExpiredCallbacks()
 }
 
 func (s *redisSessionStore) get(t irma.RequestorToken) (*session, error) {
@@ -300,17 +368,7 @@ func (s *redisSessionStore) clientGet(t irma.ClientToken) (*session, error) {
 }
 
 func (s *redisSessionStore) add(session *session) error {
-	lifetime := time.Duration(s.conf.MaxSessionLifetime) * time.Minute
-	// After the timeout, the session will automatically be removed. Therefore the timeout needs to
-	// be significantly longer than the session lifetime. Factor 2 was chosen since it matches the logic
-	// used in the memory store: After the session expired, the session will be marked as timed out
-	// and will exist for another session lifetime.
-	timeout := 2 * lifetime
-	if session.Status == irma.ServerStatusInitialized && session.Rrequest.Base().ClientTimeout != 0 {
-		timeout = time.Duration(session.Rrequest.Base().ClientTimeout) * time.Second
-	} else if session.Status.Finished() {
-		timeout = lifetime
-	}
+	timeout := session.storeTimeout()
 
 	sessionJSON, err := json.Marshal(session.sessionData)
 	if err != nil {
@@ -365,6 +423,18 @@ func (s *redisSessionStore) unlock(session *session) {
 	s.conf.Logger.WithFields(logrus.Fields{"session": session.RequestorToken}).Debug("session unlocked successfully")
 }
 
+func (s *redisSessionStore) size() (int, error) {
+	count := 0
+	iter := s.client.Scan(context.Background(), 0, requestorTokenLookupPrefix+"*", 0).Iterator()
+	for iter.Next(context.Background()) {
+		count++
+	}
+	if err := iter.Err(); err != nil {
+		return 0, logAsRedisError(err)
+	}
+	return count, nil
+}
+
 func (s *redisSessionStore) stop() {
 	err := s.client.Close()
 	if err != nil {
@@ -373,9 +443,244 @@ func (s *redisSessionStore) stop() {
 	s.conf.Logger.Info("Redis client closed successfully")
 }
 
+func (sqlSessionRecord) TableName() string {
+	return "irma_sessions"
+}
+
+func (s *sqlSessionStore) get(t irma.RequestorToken) (*session, error) {
+	var record sqlSessionRecord
+	err := s.db.Select("client_token").Where("requestor_token = ?", string(t)).First(&record).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, server.LogError(&UnknownSessionError{t, ""})
+	} else if err != nil {
+		return nil, logAsSQLError(err)
+	}
+
+	clientToken, err := irma.ParseClientToken(record.ClientToken)
+	if err != nil {
+		return nil, logAsSQLError(err)
+	}
+	s.conf.Logger.WithFields(logrus.Fields{"session": t, "clientToken": clientToken}).Debug("clientToken found in SQL datastore")
+
+	return s.clientGet(clientToken)
+}
+
+func (s *sqlSessionStore) clientGet(t irma.ClientToken) (*session, error) {
+	lockToken, err := s.obtainLock(t)
+	if err != nil {
+		return nil, err
+	}
+	session := &session{
+		sessions:  s,
+		conf:      s.conf,
+		locked:    true,
+		lockToken: lockToken,
+	}
+	// Set the clientToken already, such that the session can be unlocked even if the session data
+	// turns out to be unreadable.
+	session.ClientToken = t
+	s.conf.Logger.WithFields(logrus.Fields{"clientToken": t}).Debug("session locked successfully")
+
+	var record sqlSessionRecord
+	err = s.db.Where("client_token = ?", string(t)).First(&record).Error
+	if gorm.IsRecordNotFoundError(err) {
+		// As in the Redis session store, the locked session is returned along with the error
+		// such that it can be unlocked later.
+		return session, server.LogError(&UnknownSessionError{"", t})
+	} else if err != nil {
+		return session, logAsSQLError(err)
+	}
+
+	if err := json.Unmarshal(record.Data, &session.sessionData); err != nil {
+		return session, logAsSQLError(err)
+	}
+	session.request = session.Rrequest.SessionRequest()
+	s.conf.Logger.WithFields(logrus.Fields{"session": session.RequestorToken}).Debug("Session received from SQL datastore")
+
+	// hashing the current session data needs to take place before the timeout check to detect all changes!
+	hash := session.sessionData.hash()
+	session.hashBefore = &hash
+
+	// timeout check
+	lifetime := time.Duration(s.conf.MaxSessionLifetime) * time.Minute
+	if session.LastActive.Add(lifetime).Before(time.Now()) && !session.Status.Finished() {
+		s.conf.Logger.WithFields(logrus.Fields{"session": session.RequestorToken}).Info("Session expired")
+		session.markAlive()
+		session.setStatus(irma.ServerStatusTimeout)
+	}
+
+	return session, nil
+}
+
+// obtainLock locks the session having the specified clientToken, with the same semantics as the
+// locks of the Redis session store: the lock expires by itself after maxLockLifetime, and obtaining
+// the lock is retried with exponential backoff until the lock could be obtained or maxLockLifetime
+// has passed.
+func (s *sqlSessionStore) obtainLock(t irma.ClientToken) (string, error) {
+	lockToken := common.NewSessionToken()
+	retry := redislock.ExponentialBackoff(minLockRetryTime, maxLockRetryTime)
+	deadline := time.Now().Add(maxLockLifetime)
+
+	for {
+		now := time.Now()
+		res := s.db.Model(&sqlSessionRecord{}).
+			Where("client_token = ? AND lock_expires < ?", string(t), now.UnixNano()).
+			Updates(map[string]interface{}{
+				"lock_token":   lockToken,
+				"lock_expires": now.Add(maxLockLifetime).UnixNano(),
+			})
+		if res.Error != nil {
+			return "", logAsSQLError(res.Error)
+		}
+		if res.RowsAffected == 1 {
+			return lockToken, nil
+		}
+
+		// Either the session is locked by someone else, or it does not exist at all
+		var count int
+		if err := s.db.Model(&sqlSessionRecord{}).Where("client_token = ?", string(t)).Count(&count).Error; err != nil {
+			return "", logAsSQLError(err)
+		}
+		if count == 0 {
+			return "", server.LogError(&UnknownSessionError{"", t})
+		}
+
+		backoff := retry.NextBackoff()
+		if time.Now().Add(backoff).After(deadline) {
+			return "", logAsSQLError(errors.Errorf("session lock could not be obtained for session with clientToken %s", t))
+		}
+		time.Sleep(backoff)
+	}
+}
+
+func (s *sqlSessionStore) add(session *session) error {
+	sessionJSON, err := json.Marshal(session.sessionData)
+	if err != nil {
+		return server.LogError(err)
+	}
+
+	err = s.db.Create(&sqlSessionRecord{
+		ClientToken:    string(session.ClientToken),
+		RequestorToken: string(session.RequestorToken),
+		Data:           sessionJSON,
+		LastActive:     session.LastActive.UnixNano(),
+		Expires:        session.LastActive.Add(session.storeTimeout()).UnixNano(),
+	}).Error
+	if err != nil {
+		return logAsSQLError(err)
+	}
+
+	s.conf.Logger.WithFields(logrus.Fields{"session": session.RequestorToken}).Debug("session added in SQL datastore")
+	return nil
+}
+
+func (s *sqlSessionStore) update(session *session) error {
+	hash := session.hash()
+	if session.hashBefore == nil || *session.hashBefore == hash {
+		// if nothing changed, updating is not necessary
+		return nil
+	}
+
+	if session.lockToken == "" {
+		return logAsSQLError(errors.Errorf("lock is not set for session with requestorToken %s", session.RequestorToken))
+	}
+
+	sessionJSON, err := json.Marshal(session.sessionData)
+	if err != nil {
+		return server.LogError(err)
+	}
+
+	// Time passes between acquiring the lock and writing to the database, so we only update the session
+	// if our lock is still valid.
+	res := s.db.Model(&sqlSessionRecord{}).
+		Where("client_token = ? AND lock_token = ? AND lock_expires >= ?",
+			string(session.ClientToken), session.lockToken, time.Now().UnixNano()).
+		Updates(map[string]interface{}{
+			"data":        sessionJSON,
+			"last_active": session.LastActive.UnixNano(),
+			"expires":     session.LastActive.Add(session.storeTimeout()).UnixNano(),
+		})
+	if res.Error != nil {
+		return logAsSQLError(res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return logAsSQLError(errors.Errorf("no session lock available for session with requestorToken %s", session.RequestorToken))
+	}
+
+	s.conf.Logger.WithFields(logrus.Fields{"session": session.RequestorToken}).Debug("session updated in SQL datastore")
+	return nil
+}
+
+func (s *sqlSessionStore) unlock(session *session) {
+	if !session.locked {
+		return
+	}
+	err := s.db.Model(&sqlSessionRecord{}).
+		Where("client_token = ? AND lock_token = ?", string(session.ClientToken), session.lockToken).
+		Updates(map[string]interface{}{"lock_token": "", "lock_expires": 0}).
+		Error
+	if err != nil {
+		// The lock will be set free eventually after the `maxLockLifetime`. So it is safe to
+		// ignore this error.
+		_ = logAsSQLError(err)
+		return
+	}
+	session.locked = false
+	session.lockToken = ""
+	s.conf.Logger.WithFields(logrus.Fields{"session": session.RequestorToken}).Debug("session unlocked successfully")
+}
+
+func (s *sqlSessionStore) size() (int, error) {
+	var count int
+	if err := s.db.Model(&sqlSessionRecord{}).Count(&count).Error; err != nil {
+		return 0, logAsSQLError(err)
+	}
+	return count, nil
+}
+
+func (s *sqlSessionStore) stop() {
+	if err := s.db.Close(); err != nil {
+		_ = logAsSQLError(err)
+	}
+	s.conf.Logger.Info("SQL session store closed successfully")
+}
+
+func (s *sqlSessionStore) deleteExpired() {
+	s.deleteExpiredLimits()
+	s.deleteExpiredCallbacks()
+
+	res := s.db.Where("expires < ?", time.Now().UnixNano()).Delete(&sqlSessionRecord{})
+	if res.Error != nil {
+		_ = logAsSQLError(res.Error)
+		return
+	}
+	if res.RowsAffected > 0 {
+		s.conf.Logger.WithFields(logrus.Fields{"count": res.RowsAffected}).Info("Deleted expired sessions")
+	}
+}
+
+// storeTimeout returns the duration after which the session may be removed from the Redis or SQL
+// session store, counting from the moment it was last active.
+func (session *session) storeTimeout() time.Duration {
+	lifetime := time.Duration(session.conf.MaxSessionLifetime) * time.Minute
+	// After the timeout, the session will automatically be removed. Therefore the timeout needs to
+	// be significantly longer than the session lifetime. Factor 2 was chosen since it matches the logic
+	// used in the memory store: After the session expired, the session will be marked as timed out
+	// and will exist for another session lifetime.
+	timeout := 2 * lifetime
+	if session.Status == irma.ServerStatusInitialized && session.Rrequest.Base().ClientTimeout != 0 {
+		timeout = time.Duration(session.Rrequest.Base().ClientTimeout) * time.Second
+	} else if session.Status.Finished() {
+		timeout = lifetime
+	}
+	return timeout
+}
+
 var one *big.Int = big.NewInt(1)
 
-func (s *Server) newSession(action irma.Action, request irma.RequestorRequest, disclosed irma.AttributeConDisCon, FrontendAuth irma.FrontendAuthorization) (*session, error) {
+func (s *Server) newSession(
+	action irma.Action, request irma.RequestorRequest, disclosed irma.AttributeConDisCon, FrontendAuth irma.FrontendAuthorization, requestor string,
+) (*session, error) {
 	clientToken := irma.ClientToken(common.NewSessionToken())
 	requestorToken := irma.RequestorToken(common.NewSessionToken())
 	if len(FrontendAuth) == 0 {
@@ -395,6 +700,8 @@ func (s *Server) newSession(action irma.Action, request irma.RequestorRequest, d
 		Action:         action,
 		Rrequest:       request,
 		LastActive:     time.Now(),
+		Started:        time.Now(),
+		Requestor:      requestor,
 		RequestorToken: requestorToken,
 		ClientToken:    clientToken,
 		Status:         irma.ServerStatusInitialized,
@@ -424,10 +731,17 @@ func (s *Server) newSession(action irma.Action, request irma.RequestorRequest, d
 	base.Nonce = nonce
 	base.Context = one
 
+	if err := s.checkSessionLimits(ses); err != nil {
+		return nil, err
+	}
 	err := s.sessions.add(ses)
 	if err != nil {
+		if store, ok := s.sessions.(limitStore); ok && ses.Requestor != "" {
+			_ = store.removeLiveSession(ses.Requestor, ses.RequestorToken)
+		}
 		return nil, err
 	}
+	ses.audit()
 
 	return ses, nil
 }
@@ -435,3 +749,7 @@ func (s *Server) newSession(action irma.Action, request irma.RequestorRequest, d
 func logAsRedisError(err error) error {
 	return server.LogError(&RedisError{err})
 }
+
+func logAsSQLError(err error) error {
+	return server.LogError(&SQLError{err})
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/privacybydesign/gabi"
 	"github.com/privacybydesign/gabi/big"
 	irma "github.com/privacybydesign/irmago"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 
 	"github.com/privacybydesign/irmago/internal/common"
@@ -42,6 +44,11 @@ type Server struct {
 
 var errMissingCommitment = errors.New("missing previous call to getCommitments")
 
+var metricPinChecks = promauto.With(server.Metrics).NewCounterVec(prometheus.CounterOpts{
+	Name: "irma_keyshare_pin_checks_total",
+	Help: "Number of PIN verifications by the keyshare server, per outcome (success, failure, blocked, error)",
+}, []string{"result"})
+
 func New(conf *Configuration) (*Server, error) {
 	var err error
 	s := &Server{
@@ -125,6 +132,10 @@ func (s *Server) Handler() http.Handler {
 		})
 	})
 
+	if s.conf.EnableMetrics {
+		router.Get("/metrics", server.MetricsHandler().ServeHTTP)
+	}
+
 	// IRMA server for issuing myirma credential during registration
 	router.Mount("/irma/", s.irmaserv.HandlerFunc())
 	return router
@@ -301,10 +312,17 @@ func (s *Server) handleVerifyPin(w http.ResponseWriter, r *http.Request) {
 	result, err := s.verifyPin(user, msg.Pin)
 	if err != nil {
 		// already logged
+		metricPinChecks.WithLabelValues("error").Inc()
 		server.WriteError(w, server.ErrorInternal, err.Error())
 		return
 	}
 
+	if result.Status == "error" {
+		// The "error" status indicates that the user is (temporarily) blocked
+		metricPinChecks.WithLabelValues("blocked").Inc()
+	} else {
+		metricPinChecks.WithLabelValues(result.Status).Inc()
+	}
 	server.WriteJson(w, result)
 }
 
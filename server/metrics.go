@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics is the registry in which all IRMA server components register their metrics.
+// The metrics are served at /metrics by the requestor and keyshare servers when enabled
+// in their configuration.
+var Metrics = prometheus.NewRegistry()
+
+var (
+	// DurationBuckets are the default histogram buckets for durations of network requests, in seconds.
+	DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// SessionDurationBuckets are the default histogram buckets for durations of IRMA sessions, in seconds.
+	SessionDurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600}
+)
+
+// MetricsHandler returns a handler exposing the metrics in Metrics in the text-based
+// exposition format of Prometheus.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(Metrics, promhttp.HandlerOpts{ErrorLog: Logger})
+}
@@ -0,0 +1,174 @@
+package oidcprovider
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/go-multierror"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+)
+
+const (
+	TokenLifetimeDefault = 60 * 60 // seconds
+
+	// FrontendScriptDefault is the irma-frontend script with which the login page shows the IRMA QR code.
+	FrontendScriptDefault = "https://unpkg.com/@privacybydesign/irma-frontend@0.4.0/dist/irma.js"
+)
+
+// Configuration contains the configuration of the OpenID Connect provider.
+type Configuration struct {
+	// IRMA server configuration. The JWT private key is used to sign ID tokens.
+	*server.Configuration `mapstructure:",squash"`
+
+	// Issuer identifier of the provider: the URL at which it is reachable, without trailing slash.
+	// If URL is empty, the IRMA app connects to the provider at this URL too.
+	Issuer string `json:"issuer" mapstructure:"issuer"`
+	// Relying parties that may authenticate users at the provider, by client ID
+	Clients map[string]Client `json:"clients" mapstructure:"clients"`
+	// Scopes that relying parties may request, each mapped to the attributes that the user must
+	// disclose for it
+	Scopes map[string]irma.AttributeConDisCon `json:"scopes" mapstructure:"scopes"`
+	// Names of the claims in which disclosed attributes are included, by attribute type identifier.
+	// Attributes not mentioned here are included in a claim named after their identifier.
+	Claims map[string]string `json:"claims" mapstructure:"claims"`
+	// Attribute type from which the subject identifier is derived. If empty, it is derived from all
+	// disclosed attributes.
+	SubjectAttribute string `json:"subject_attribute" mapstructure:"subject_attribute"`
+	// Lifetime of ID tokens and access tokens in seconds
+	TokenLifetime int `json:"token_lifetime" mapstructure:"token_lifetime"`
+	// URL of the irma-frontend script used on the login page
+	FrontendScript string `json:"frontend_script" mapstructure:"frontend_script"`
+}
+
+// Client is a relying party of the provider.
+type Client struct {
+	// Secret with which the client authenticates at the token endpoint. Clients without a secret
+	// are public clients, which must use PKCE.
+	Secret string `json:"secret" mapstructure:"secret"`
+	// Redirect URIs to which the user may be sent back after authentication
+	RedirectURIs []string `json:"redirect_uris" mapstructure:"redirect_uris"`
+}
+
+// Process a passed configuration to ensure all field values are valid and initialized
+// as required by the rest of the provider.
+func processConfiguration(conf *Configuration) error {
+	if conf.JwtRSAPrivateKey == nil {
+		return server.LogError(errors.New("A JWT private key is required to sign ID tokens"))
+	}
+
+	conf.Issuer = strings.TrimSuffix(conf.Issuer, "/")
+	u, err := url.Parse(conf.Issuer)
+	if conf.Issuer == "" || err != nil || !strings.HasPrefix(u.Scheme, "http") || u.RawQuery != "" || u.Fragment != "" {
+		return server.LogError(errors.Errorf(`Invalid issuer "%s": must be an http(s) URL without query or fragment`, conf.Issuer))
+	}
+	if conf.Production && u.Scheme != "https" {
+		return server.LogError(errors.New("In production mode, the issuer must be an https URL"))
+	}
+
+	var multierr multierror.Error
+	if len(conf.Clients) == 0 {
+		multierr.Errors = append(multierr.Errors, errors.New("No clients configured"))
+	}
+	for id, client := range conf.Clients {
+		if len(client.RedirectURIs) == 0 {
+			multierr.Errors = append(multierr.Errors, errors.Errorf("Client %s has no redirect URIs", id))
+		}
+		for _, uri := range client.RedirectURIs {
+			if u, err := url.Parse(uri); err != nil || !u.IsAbs() || u.Fragment != "" {
+				multierr.Errors = append(multierr.Errors, errors.Errorf("Client %s has invalid redirect URI %s", id, uri))
+			}
+		}
+	}
+	if len(conf.Scopes) == 0 {
+		multierr.Errors = append(multierr.Errors, errors.New("No scopes configured"))
+	}
+	for scope, condiscon := range conf.Scopes {
+		if len(condiscon) == 0 {
+			multierr.Errors = append(multierr.Errors, errors.Errorf("Scope %s has no attributes", scope))
+		}
+		_ = condiscon.Iterate(func(attr *irma.AttributeRequest) error {
+			if conf.IrmaConfiguration.AttributeTypes[attr.Type] == nil {
+				multierr.Errors = append(multierr.Errors, errors.Errorf("Scope %s contains unknown attribute %s", scope, attr.Type))
+			}
+			return nil
+		})
+	}
+	if conf.SubjectAttribute != "" {
+		subject := irma.NewAttributeTypeIdentifier(conf.SubjectAttribute)
+		if conf.IrmaConfiguration.AttributeTypes[subject] == nil {
+			multierr.Errors = append(multierr.Errors, errors.Errorf("Unknown subject attribute %s", conf.SubjectAttribute))
+		}
+		// Otherwise, users authorizing a scope without it would all get the same subject identifier
+		for scope, condiscon := range conf.Scopes {
+			if !requiresAttribute(condiscon, subject) {
+				multierr.Errors = append(multierr.Errors, errors.Errorf("Scope %s does not require the subject attribute %s", scope, conf.SubjectAttribute))
+			}
+		}
+	}
+	if err := multierr.ErrorOrNil(); err != nil {
+		return server.LogError(err)
+	}
+
+	if conf.TokenLifetime == 0 {
+		conf.TokenLifetime = TokenLifetimeDefault
+	}
+	if conf.FrontendScript == "" {
+		conf.FrontendScript = FrontendScriptDefault
+	}
+
+	// Setup IRMA session server url for in QR code
+	if !strings.HasSuffix(conf.URL, "/") {
+		conf.URL += "/"
+	}
+	conf.URL += "irma/"
+
+	return nil
+}
+
+// requiresAttribute returns whether any disclosure satisfying the condiscon contains the
+// specified attribute, i.e. whether one of its discons contains the attribute in all of its
+// cons, without a required value.
+func requiresAttribute(condiscon irma.AttributeConDisCon, attr irma.AttributeTypeIdentifier) bool {
+	for _, discon := range condiscon {
+		if len(discon) == 0 {
+			continue
+		}
+		all := true
+		for _, con := range discon {
+			found := false
+			for _, req := range con {
+				if req.Type == attr && req.Value == nil {
+					found = true
+				}
+			}
+			all = all && found
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// scopes returns the supported scopes, in order.
+func (conf *Configuration) scopes() []string {
+	scopes := []string{"openid"}
+	for scope := range conf.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes[1:])
+	return scopes
+}
+
+// claim returns the name of the claim in which the specified attribute is included.
+func (conf *Configuration) claim(attr irma.AttributeTypeIdentifier) string {
+	if name, ok := conf.Claims[attr.String()]; ok {
+		return name
+	}
+	return attr.String()
+}
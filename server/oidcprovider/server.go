@@ -0,0 +1,606 @@
+package oidcprovider
+
+import (
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/cors"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/jasonlvhit/gocron"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/privacybydesign/irmago/server/irmaserver"
+	"github.com/sirupsen/logrus"
+)
+
+// Server is an OpenID Connect provider that authenticates users by having them disclose IRMA
+// attributes, which it passes to the relying party as claims.
+type Server struct {
+	conf *Configuration
+
+	irmaserv      *irmaserver.Server
+	store         store
+	keyID         string
+	scheduler     *gocron.Scheduler
+	schedulerStop chan<- bool
+}
+
+// OAuth 2.0 error codes (RFC 6749 sections 4.1.2.1 and 5.2)
+const (
+	errInvalidRequest          = "invalid_request"
+	errInvalidClient           = "invalid_client"
+	errInvalidGrant            = "invalid_grant"
+	errInvalidScope            = "invalid_scope"
+	errInvalidToken            = "invalid_token"
+	errAccessDenied            = "access_denied"
+	errUnsupportedResponseType = "unsupported_response_type"
+	errUnsupportedGrantType    = "unsupported_grant_type"
+	errServerError             = "server_error"
+)
+
+func New(conf *Configuration) (*Server, error) {
+	// Unless configured otherwise, the IRMA app connects to the provider at its issuer URL
+	if conf.URL == "" {
+		conf.URL = conf.Issuer
+	}
+	irmaserv, err := irmaserver.New(conf.Configuration)
+	if err != nil {
+		return nil, err
+	}
+	err = processConfiguration(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		conf:      conf,
+		irmaserv:  irmaserv,
+		store:     newMemoryStore(),
+		keyID:     keyID(&conf.JwtRSAPrivateKey.PublicKey),
+		scheduler: gocron.NewScheduler(),
+	}
+
+	s.scheduler.Every(10).Seconds().Do(s.store.flush)
+	s.schedulerStop = s.scheduler.Start()
+
+	if s.conf.LogJSON {
+		s.conf.Logger.WithField("configuration", s.conf).Debug("Configuration")
+	} else {
+		bts, _ := json.MarshalIndent(s.conf, "", "   ")
+		s.conf.Logger.Debug("Configuration: ", string(bts), "\n")
+	}
+
+	return s, nil
+}
+
+func (s *Server) Stop() {
+	s.irmaserv.Stop()
+	s.schedulerStop <- true
+}
+
+func (s *Server) Handler() http.Handler {
+	router := chi.NewRouter()
+
+	router.Group(func(router chi.Router) {
+		router.Use(server.SizeLimitMiddleware)
+		router.Use(server.TimeoutMiddleware(nil, server.WriteTimeout))
+
+		if s.conf.Verbose >= 2 {
+			opts := server.LogOptions{Response: true, Headers: true, From: false, EncodeBinary: false}
+			router.Use(server.LogMiddleware("oidc-provider", opts))
+		}
+
+		// Endpoints used by the relying party, possibly from the browser
+		router.Group(func(router chi.Router) {
+			router.Use(cors.New(cors.Options{
+				AllowedOrigins: []string{"*"},
+				AllowedHeaders: []string{"Accept", "Authorization", "Content-Type"},
+				AllowedMethods: []string{http.MethodGet, http.MethodPost},
+			}).Handler)
+
+			router.Get("/.well-known/openid-configuration", s.handleDiscovery)
+			router.Get("/jwks", s.handleJWKS)
+			router.Post("/token", s.handleToken)
+			router.Get("/userinfo", s.handleUserinfo)
+			router.Post("/userinfo", s.handleUserinfo)
+		})
+
+		// Endpoints visited by the user
+		router.Get("/authorize", s.handleAuthorize)
+		router.Post("/authorize", s.handleAuthorize)
+		router.Get("/login/{id}", s.handleLogin)
+		router.Get("/login/{id}/session", s.handleLoginSession)
+		router.Get("/login/{id}/done", s.handleLoginDone)
+	})
+
+	// IRMA session server
+	router.Mount("/irma/", s.irmaserv.HandlerFunc())
+
+	return router
+}
+
+type discoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, _ *http.Request) {
+	claims := []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce"}
+	seen := map[string]bool{}
+	for _, scope := range s.conf.scopes() {
+		_ = s.conf.Scopes[scope].Iterate(func(attr *irma.AttributeRequest) error {
+			if claim := s.conf.claim(attr.Type); !seen[claim] {
+				seen[claim] = true
+				claims = append(claims, claim)
+			}
+			return nil
+		})
+	}
+
+	server.WriteJson(w, discoveryDocument{
+		Issuer:                            s.conf.Issuer,
+		AuthorizationEndpoint:             s.conf.Issuer + "/authorize",
+		TokenEndpoint:                     s.conf.Issuer + "/token",
+		UserinfoEndpoint:                  s.conf.Issuer + "/userinfo",
+		JWKSURI:                           s.conf.Issuer + "/jwks",
+		ScopesSupported:                   s.conf.scopes(),
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"pairwise"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   claims,
+	})
+}
+
+func (s *Server) handleJWKS(w http.ResponseWriter, _ *http.Request) {
+	key := &s.conf.JwtRSAPrivateKey.PublicKey
+	n, e := rsaKeyParameters(key)
+	server.WriteJson(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": s.keyID,
+			"n":   n,
+			"e":   e,
+		}},
+	})
+}
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		server.WriteError(w, server.ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	// Until the client and redirect URI are validated, errors cannot be reported to the client
+	clientID, redirectURI := r.Form.Get("client_id"), r.Form.Get("redirect_uri")
+	client, ok := s.conf.Clients[clientID]
+	if !ok {
+		server.WriteError(w, server.ErrorInvalidRequest, "unknown client_id")
+		return
+	}
+	if !contains(client.RedirectURIs, redirectURI) {
+		server.WriteError(w, server.ErrorInvalidRequest, "redirect_uri not registered for client")
+		return
+	}
+
+	state := r.Form.Get("state")
+	fail := func(code, description string) {
+		redirect(w, r, redirectURI, url.Values{"error": {code}, "error_description": {description}, "state": {state}})
+	}
+	if r.Form.Get("response_type") != "code" {
+		fail(errUnsupportedResponseType, "only response_type code is supported")
+		return
+	}
+
+	// Gather the attributes to be disclosed for the requested scopes, ignoring unknown scopes
+	var scopes []string
+	var condiscon irma.AttributeConDisCon
+	openid := false
+	for _, scope := range strings.Fields(r.Form.Get("scope")) {
+		if scope == "openid" {
+			openid = true
+		}
+		if c, ok := s.conf.Scopes[scope]; ok && !contains(scopes, scope) {
+			scopes = append(scopes, scope)
+			condiscon = append(condiscon, c...)
+		}
+	}
+	if !openid {
+		fail(errInvalidScope, "scope must include openid")
+		return
+	}
+	if len(condiscon) == 0 {
+		fail(errInvalidScope, "no scope requesting attributes")
+		return
+	}
+
+	challenge := r.Form.Get("code_challenge")
+	if challenge != "" && r.Form.Get("code_challenge_method") != "S256" {
+		fail(errInvalidRequest, "code_challenge_method must be S256")
+		return
+	}
+	if challenge == "" && client.Secret == "" {
+		fail(errInvalidRequest, "public clients must use PKCE")
+		return
+	}
+
+	request := irma.NewDisclosureRequest()
+	request.Disclose = condiscon
+	qr, token, frontendRequest, err := s.irmaserv.StartRequestorSession(clientID, request, nil)
+	binding := common.NewSessionToken()
+	if err != nil {
+		s.conf.Logger.WithField("error", err).Error("Could not start IRMA session")
+		fail(errServerError, "could not start IRMA session")
+		return
+	}
+
+	id := s.store.create(&authorization{
+		clientID:       clientID,
+		redirectURI:    redirectURI,
+		state:          state,
+		nonce:          r.Form.Get("nonce"),
+		codeChallenge:  challenge,
+		scopes:         scopes,
+		sessionToken:   token,
+		sessionPackage: &server.SessionPackage{SessionPtr: qr, FrontendRequest: frontendRequest},
+		browserBinding: binding,
+	})
+	s.setLoginCookie(w, id, binding, int(loginLifetime.Seconds()))
+	s.conf.Logger.WithFields(logrus.Fields{"client": clientID, "scopes": scopes, "session": token}).
+		Info("Authorization started")
+	http.Redirect(w, r, s.conf.Issuer+"/login/"+id, http.StatusFound)
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>Log in with IRMA</title>
+  <script src="{{.Script}}"></script>
+</head>
+<body>
+  <section id="irma-web-form"></section>
+  <script>
+    var done = function() { window.location.href = {{.DoneURL}}; };
+    irma.newWeb({
+      element: '#irma-web-form',
+      session: {
+        start: {url: function() { return {{.SessionURL}}; }, method: 'GET'},
+        result: false
+      }
+    }).start().then(done, done);
+  </script>
+</body>
+</html>
+`))
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if s.store.get(id) == nil {
+		server.WriteError(w, server.ErrorSessionUnknown, "")
+		return
+	}
+
+	loginURL := s.conf.Issuer + "/login/" + id
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := loginPage.Execute(w, map[string]string{
+		"Script":     s.conf.FrontendScript,
+		"SessionURL": loginURL + "/session",
+		"DoneURL":    loginURL + "/done",
+	})
+	if err != nil {
+		_ = server.LogError(err)
+	}
+}
+
+func (s *Server) handleLoginSession(w http.ResponseWriter, r *http.Request) {
+	auth := s.store.get(chi.URLParam(r, "id"))
+	if auth == nil {
+		server.WriteError(w, server.ErrorSessionUnknown, "")
+		return
+	}
+	server.WriteJson(w, auth.sessionPackage)
+}
+
+// loginCookie is the name of the cookie that binds a login to the browser that started it, so
+// that the authorization code is not handed out to whoever else finishes the login.
+const loginCookie = "irma_oidc_login"
+
+func (s *Server) setLoginCookie(w http.ResponseWriter, id, value string, maxAge int) {
+	path := "/login/" + id
+	if u, err := url.Parse(s.conf.Issuer); err == nil {
+		path = strings.TrimSuffix(u.Path, "/") + path
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		Secure:   strings.HasPrefix(s.conf.Issuer, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) handleLoginDone(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	auth := s.store.get(id)
+	if auth == nil {
+		server.WriteError(w, server.ErrorSessionUnknown, "")
+		return
+	}
+	cookie, err := r.Cookie(loginCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(auth.browserBinding)) != 1 {
+		s.conf.Logger.WithFields(logrus.Fields{"client": auth.clientID, "session": auth.sessionToken}).
+			Warn("Login finished from another browser than the one that started it")
+		server.WriteError(w, server.ErrorUnauthorized, "login was not started in this browser")
+		return
+	}
+	if auth = s.store.take(id); auth == nil {
+		server.WriteError(w, server.ErrorSessionUnknown, "")
+		return
+	}
+	s.setLoginCookie(w, id, "", -1)
+
+	result, err := s.irmaserv.GetSessionResult(auth.sessionToken)
+	if err != nil || result.Status != irma.ServerStatusDone || result.ProofStatus != irma.ProofStatusValid {
+		if err == nil && !result.Status.Finished() {
+			_ = s.irmaserv.CancelSession(auth.sessionToken)
+		}
+		redirect(w, r, auth.redirectURI, url.Values{
+			"error":             {errAccessDenied},
+			"error_description": {"user did not disclose the requested attributes"},
+			"state":             {auth.state},
+		})
+		return
+	}
+
+	subject, ok := s.subject(auth.clientID, result.Disclosed)
+	if !ok {
+		s.conf.Logger.WithFields(logrus.Fields{"client": auth.clientID, "session": auth.sessionToken}).
+			Error("Disclosed attributes do not contain the subject attribute")
+		redirect(w, r, auth.redirectURI, url.Values{
+			"error":             {errServerError},
+			"error_description": {"could not determine subject identifier"},
+			"state":             {auth.state},
+		})
+		return
+	}
+	auth.claims = s.claims(result.Disclosed)
+	auth.subject = subject
+	auth.authTime = time.Now()
+	code := s.store.createCode(auth)
+	s.conf.Logger.WithFields(logrus.Fields{"client": auth.clientID, "session": auth.sessionToken}).
+		Info("User authenticated")
+	redirect(w, r, auth.redirectURI, url.Values{"code": {code}, "state": {auth.state}})
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, err.Error())
+		return
+	}
+
+	// Authenticate the client, using either client_secret_basic or client_secret_post
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// Both are form-urlencoded before being put in the Authorization header (RFC 6749 section 2.3.1)
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, ok := s.conf.Clients[clientID]
+	if !ok || (client.Secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) != 1) {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		writeOAuthError(w, http.StatusUnauthorized, errInvalidClient, "client authentication failed")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, errUnsupportedGrantType, "only grant_type authorization_code is supported")
+		return
+	}
+	auth := s.store.redeemCode(r.PostForm.Get("code"))
+	if auth == nil || auth.clientID != clientID {
+		writeOAuthError(w, http.StatusBadRequest, errInvalidGrant, "unknown or expired authorization code")
+		return
+	}
+	if auth.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeOAuthError(w, http.StatusBadRequest, errInvalidGrant, "redirect_uri does not match authorization request")
+		return
+	}
+	if auth.codeChallenge != "" {
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.codeChallenge {
+			writeOAuthError(w, http.StatusBadRequest, errInvalidGrant, "invalid code_verifier")
+			return
+		}
+	}
+
+	lifetime := time.Duration(s.conf.TokenLifetime) * time.Second
+	idToken, err := s.idToken(auth, lifetime)
+	if err != nil {
+		_ = server.LogError(err)
+		writeOAuthError(w, http.StatusInternalServerError, errServerError, "could not sign ID token")
+		return
+	}
+	accessToken := s.store.createAccessToken(auth, lifetime)
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	server.WriteJson(w, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   s.conf.TokenLifetime,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) handleUserinfo(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+	var auth *authorization
+	if strings.HasPrefix(header, "Bearer ") {
+		auth = s.store.accessToken(strings.TrimPrefix(header, "Bearer "))
+	}
+	if auth == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, errInvalidToken, "unknown or expired access token")
+		return
+	}
+
+	claims := map[string]interface{}{"sub": auth.subject}
+	for name, value := range auth.claims {
+		claims[name] = value
+	}
+	server.WriteJson(w, claims)
+}
+
+func (s *Server) idToken(auth *authorization, lifetime time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":       s.conf.Issuer,
+		"sub":       auth.subject,
+		"aud":       auth.clientID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(lifetime).Unix(),
+		"auth_time": auth.authTime.Unix(),
+	}
+	if auth.nonce != "" {
+		claims["nonce"] = auth.nonce
+	}
+	for name, value := range auth.claims {
+		if _, reserved := claims[name]; !reserved {
+			claims[name] = value
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+	return token.SignedString(s.conf.JwtRSAPrivateKey)
+}
+
+// claims returns the claims containing the disclosed attributes.
+func (s *Server) claims(disclosed [][]*irma.DisclosedAttribute) map[string]interface{} {
+	claims := map[string]interface{}{}
+	for _, con := range disclosed {
+		for _, attr := range con {
+			if attr.RawValue != nil {
+				claims[s.conf.claim(attr.Identifier)] = *attr.RawValue
+			}
+		}
+	}
+	return claims
+}
+
+// subject computes the pairwise subject identifier of the user towards the client, from the
+// value of the subject attribute or from all disclosed attribute values. It is keyed with the
+// private key so that clients cannot compute it from attribute values themselves. If the
+// disclosed attributes contain no value to derive it from, false is returned.
+func (s *Server) subject(clientID string, disclosed [][]*irma.DisclosedAttribute) (string, bool) {
+	var values []string
+	for _, con := range disclosed {
+		for _, attr := range con {
+			if attr.RawValue == nil {
+				continue
+			}
+			if s.conf.SubjectAttribute == "" {
+				values = append(values, attr.Identifier.String()+"="+*attr.RawValue)
+			} else if attr.Identifier.String() == s.conf.SubjectAttribute {
+				values = []string{*attr.RawValue}
+			}
+		}
+	}
+	if len(values) == 0 {
+		return "", false
+	}
+	sort.Strings(values)
+
+	key := sha256.Sum256(s.conf.JwtRSAPrivateKey.D.Bytes())
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(clientID))
+	for _, value := range values {
+		mac.Write([]byte{0})
+		mac.Write([]byte(value))
+	}
+	return hex.EncodeToString(mac.Sum(nil)), true
+}
+
+// redirect redirects the user to the redirect URI of a client, with the specified parameters
+// added to its query.
+func redirect(w http.ResponseWriter, r *http.Request, uri string, params url.Values) {
+	u, err := url.Parse(uri) // already validated in processConfiguration
+	if err != nil {
+		server.WriteError(w, server.ErrorInternal, err.Error())
+		return
+	}
+	query := u.Query()
+	for name, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query[name] = values
+		}
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	bts, _ := json.Marshal(map[string]string{"error": code, "error_description": description})
+	_, _ = w.Write(bts)
+}
+
+// rsaKeyParameters returns the base64url-encoded modulus and exponent of the key, as used in JWKs.
+func rsaKeyParameters(key *rsa.PublicKey) (n, e string) {
+	return base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+}
+
+// keyID computes the JWK thumbprint of the key (RFC 7638), which is used as key ID.
+func keyID(key *rsa.PublicKey) string {
+	n, e := rsaKeyParameters(key)
+	thumbprint := sha256.Sum256([]byte(`{"e":"` + e + `","kty":"RSA","n":"` + n + `"}`))
+	return base64.RawURLEncoding.EncodeToString(thumbprint[:])
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,368 @@
+package oidcprovider
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/test"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testIssuer   = "http://localhost:48690"
+	testRedirect = "https://example.com/callback"
+)
+
+func newTestServer(t *testing.T) *Server {
+	testdataPath := test.FindTestdataFolder(t)
+	s, err := New(&Configuration{
+		Configuration: &server.Configuration{
+			SchemesPath:       filepath.Join(testdataPath, "irma_configuration"),
+			JwtPrivateKeyFile: filepath.Join(testdataPath, "jwtkeys", "sk.pem"),
+			Logger:            irma.Logger,
+		},
+		Issuer: testIssuer,
+		Clients: map[string]Client{
+			"confidential": {Secret: "secret", RedirectURIs: []string{testRedirect}},
+			"public":       {RedirectURIs: []string{testRedirect}},
+		},
+		Scopes: map[string]irma.AttributeConDisCon{
+			"student": {{{irma.NewAttributeRequest("irma-demo.RU.studentCard.studentID")}}},
+		},
+		Claims: map[string]string{"irma-demo.RU.studentCard.studentID": "student_id"},
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func do(t *testing.T, handler http.Handler, method, path string, form url.Values, header http.Header) *httptest.ResponseRecorder {
+	var r *http.Request
+	if method == http.MethodPost {
+		r = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, path+"?"+form.Encode(), nil)
+	}
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func redirectParams(t *testing.T, w *httptest.ResponseRecorder) url.Values {
+	require.Equal(t, http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, testRedirect, u.Scheme+"://"+u.Host+u.Path)
+	return u.Query()
+}
+
+func TestConfigurationInvalid(t *testing.T) {
+	testdataPath := test.FindTestdataFolder(t)
+	newConf := func() *Configuration {
+		return &Configuration{
+			Configuration: &server.Configuration{
+				SchemesPath:       filepath.Join(testdataPath, "irma_configuration"),
+				JwtPrivateKeyFile: filepath.Join(testdataPath, "jwtkeys", "sk.pem"),
+				Logger:            irma.Logger,
+			},
+			Issuer:  testIssuer,
+			Clients: map[string]Client{"client": {RedirectURIs: []string{testRedirect}}},
+			Scopes: map[string]irma.AttributeConDisCon{
+				"student": {{{irma.NewAttributeRequest("irma-demo.RU.studentCard.studentID")}}},
+			},
+		}
+	}
+
+	conf := newConf()
+	conf.Issuer = "localhost"
+	_, err := New(conf)
+	require.Error(t, err)
+
+	conf = newConf()
+	conf.Clients["client"] = Client{RedirectURIs: []string{"/relative"}}
+	_, err = New(conf)
+	require.Error(t, err)
+
+	conf = newConf()
+	conf.Scopes["unknown"] = irma.AttributeConDisCon{{{irma.NewAttributeRequest("irma-demo.RU.studentCard.unknown")}}}
+	_, err = New(conf)
+	require.Error(t, err)
+
+	conf = newConf()
+	conf.JwtPrivateKeyFile = ""
+	_, err = New(conf)
+	require.Error(t, err)
+
+	// Each scope must require the subject attribute
+	conf = newConf()
+	conf.SubjectAttribute = "irma-demo.RU.studentCard.university"
+	_, err = New(conf)
+	require.Error(t, err)
+
+	conf = newConf()
+	conf.SubjectAttribute = "irma-demo.RU.studentCard.studentID"
+	conf.Scopes["either"] = irma.AttributeConDisCon{{
+		{irma.NewAttributeRequest("irma-demo.RU.studentCard.studentID")},
+		{irma.NewAttributeRequest("irma-demo.RU.studentCard.university")},
+	}}
+	_, err = New(conf)
+	require.Error(t, err)
+
+	conf = newConf()
+	conf.SubjectAttribute = "irma-demo.RU.studentCard.studentID"
+	s, err := New(conf)
+	require.NoError(t, err)
+	s.Stop()
+}
+
+func TestSubject(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Stop()
+
+	attr := func(id, value string) *irma.DisclosedAttribute {
+		return &irma.DisclosedAttribute{Identifier: irma.NewAttributeTypeIdentifier(id), RawValue: &value}
+	}
+	studentID := func(value string) [][]*irma.DisclosedAttribute {
+		return [][]*irma.DisclosedAttribute{{attr("irma-demo.RU.studentCard.studentID", value)}}
+	}
+
+	sub1, ok := s.subject("confidential", studentID("123"))
+	require.True(t, ok)
+	sub2, ok := s.subject("confidential", studentID("456"))
+	require.True(t, ok)
+	require.NotEqual(t, sub1, sub2)
+	sub3, ok := s.subject("public", studentID("123"))
+	require.True(t, ok)
+	require.NotEqual(t, sub1, sub3)
+
+	_, ok = s.subject("confidential", nil)
+	require.False(t, ok)
+	_, ok = s.subject("confidential", [][]*irma.DisclosedAttribute{{{Identifier: irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")}}})
+	require.False(t, ok)
+
+	// With a subject attribute, it must have been disclosed
+	s.conf.SubjectAttribute = "irma-demo.RU.studentCard.studentID"
+	sub4, ok := s.subject("confidential", [][]*irma.DisclosedAttribute{{
+		attr("irma-demo.RU.studentCard.studentID", "123"), attr("irma-demo.RU.studentCard.university", "Radboud"),
+	}})
+	require.True(t, ok)
+	sub5, ok := s.subject("confidential", studentID("123"))
+	require.True(t, ok)
+	require.Equal(t, sub4, sub5)
+	_, ok = s.subject("confidential", [][]*irma.DisclosedAttribute{{attr("irma-demo.RU.studentCard.university", "Radboud")}})
+	require.False(t, ok)
+}
+
+func TestStoreTakeLogin(t *testing.T) {
+	store := newMemoryStore()
+	id := store.create(&authorization{clientID: "confidential"})
+	require.NotNil(t, store.get(id))
+	require.NotNil(t, store.take(id))
+	require.Nil(t, store.take(id))
+	require.Nil(t, store.get(id))
+}
+
+func TestDiscovery(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Stop()
+	handler := s.Handler()
+
+	w := do(t, handler, http.MethodGet, "/.well-known/openid-configuration", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var doc discoveryDocument
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	require.Equal(t, testIssuer, doc.Issuer)
+	require.Equal(t, testIssuer+"/token", doc.TokenEndpoint)
+	require.Equal(t, []string{"openid", "student"}, doc.ScopesSupported)
+	require.Contains(t, doc.ClaimsSupported, "student_id")
+
+	w = do(t, handler, http.MethodGet, "/jwks", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jwks))
+	require.Len(t, jwks.Keys, 1)
+	require.Equal(t, s.keyID, jwks.Keys[0]["kid"])
+	require.Equal(t, "AQAB", jwks.Keys[0]["e"])
+}
+
+func TestAuthorize(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Stop()
+	handler := s.Handler()
+
+	params := func(client, redirectURI, responseType, scope string) url.Values {
+		return url.Values{
+			"client_id":     {client},
+			"redirect_uri":  {redirectURI},
+			"response_type": {responseType},
+			"scope":         {scope},
+			"state":         {"xyz"},
+		}
+	}
+
+	// Errors concerning the client or redirect URI are not reported to the client
+	w := do(t, handler, http.MethodGet, "/authorize", params("unknown", testRedirect, "code", "openid student"), nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = do(t, handler, http.MethodGet, "/authorize", params("confidential", "https://attacker.com", "code", "openid student"), nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Other errors are
+	w = do(t, handler, http.MethodGet, "/authorize", params("confidential", testRedirect, "token", "openid student"), nil)
+	require.Equal(t, url.Values{"error": {errUnsupportedResponseType}, "error_description": {"only response_type code is supported"}, "state": {"xyz"}}, redirectParams(t, w))
+	w = do(t, handler, http.MethodGet, "/authorize", params("confidential", testRedirect, "code", "student"), nil)
+	require.Equal(t, errInvalidScope, redirectParams(t, w).Get("error"))
+	w = do(t, handler, http.MethodGet, "/authorize", params("confidential", testRedirect, "code", "openid"), nil)
+	require.Equal(t, errInvalidScope, redirectParams(t, w).Get("error"))
+	w = do(t, handler, http.MethodGet, "/authorize", params("public", testRedirect, "code", "openid student"), nil)
+	require.Equal(t, errInvalidRequest, redirectParams(t, w).Get("error"))
+
+	// A valid request starts an IRMA session and sends the user to the login page
+	w = do(t, handler, http.MethodPost, "/authorize", params("confidential", testRedirect, "code", "openid student unknown"), nil)
+	require.Equal(t, http.StatusFound, w.Code)
+	location := w.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, testIssuer+"/login/"))
+	path := strings.TrimPrefix(location, testIssuer)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, loginCookie, cookies[0].Name)
+	require.Equal(t, path, cookies[0].Path)
+	require.True(t, cookies[0].HttpOnly)
+	require.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+	cookie := &http.Cookie{Name: cookies[0].Name, Value: cookies[0].Value}
+
+	w = do(t, handler, http.MethodGet, path, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "irma.newWeb")
+
+	w = do(t, handler, http.MethodGet, path+"/session", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var pkg server.SessionPackage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pkg))
+	require.Equal(t, irma.ActionDisclosing, pkg.SessionPtr.Type)
+	require.True(t, strings.HasPrefix(pkg.SessionPtr.URL, testIssuer+"/irma/session/"))
+
+	// The login can only be finished by the browser that started it
+	w = do(t, handler, http.MethodGet, path+"/done", nil, nil)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	w = do(t, handler, http.MethodGet, path+"/done", nil, http.Header{"Cookie": {loginCookie + "=wrong"}})
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	// Finishing the login without disclosing attributes denies access
+	w = do(t, handler, http.MethodGet, path+"/done", nil, http.Header{"Cookie": {cookie.String()}})
+	require.Equal(t, url.Values{"error": {errAccessDenied}, "error_description": {"user did not disclose the requested attributes"}, "state": {"xyz"}}, redirectParams(t, w))
+	w = do(t, handler, http.MethodGet, path+"/done", nil, http.Header{"Cookie": {cookie.String()}})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestToken(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Stop()
+	handler := s.Handler()
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := sha256.Sum256([]byte(verifier))
+	newCode := func(clientID, codeChallenge string) string {
+		return s.store.createCode(&authorization{
+			clientID:      clientID,
+			redirectURI:   testRedirect,
+			nonce:         "n-0S6_WzA2Mj",
+			codeChallenge: codeChallenge,
+			subject:       "subject",
+			claims:        map[string]interface{}{"student_id": "456"},
+			authTime:      time.Now(),
+		})
+	}
+	form := func(code string) url.Values {
+		return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {testRedirect}}
+	}
+	requireError := func(w *httptest.ResponseRecorder, status int, code string) {
+		require.Equal(t, status, w.Code)
+		var res map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.Equal(t, code, res["error"])
+	}
+
+	// Client authentication
+	code := newCode("confidential", "")
+	f := form(code)
+	f.Set("client_id", "confidential")
+	f.Set("client_secret", "wrong")
+	requireError(do(t, handler, http.MethodPost, "/token", f, nil), http.StatusUnauthorized, errInvalidClient)
+	f.Set("client_id", "unknown")
+	requireError(do(t, handler, http.MethodPost, "/token", f, nil), http.StatusUnauthorized, errInvalidClient)
+
+	// Grants
+	basic := http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte("confidential:secret"))}}
+	f = form("unknown")
+	requireError(do(t, handler, http.MethodPost, "/token", f, basic), http.StatusBadRequest, errInvalidGrant)
+	f = form(code)
+	f.Set("redirect_uri", "https://example.com/other")
+	requireError(do(t, handler, http.MethodPost, "/token", f, basic), http.StatusBadRequest, errInvalidGrant)
+	f = form(code) // codes cannot be used again after a failed attempt
+	requireError(do(t, handler, http.MethodPost, "/token", f, basic), http.StatusBadRequest, errInvalidGrant)
+	f.Set("grant_type", "client_credentials")
+	requireError(do(t, handler, http.MethodPost, "/token", f, basic), http.StatusBadRequest, errUnsupportedGrantType)
+
+	// PKCE
+	f = form(newCode("public", base64.RawURLEncoding.EncodeToString(challenge[:])))
+	f.Set("client_id", "public")
+	f.Set("code_verifier", "wrong")
+	requireError(do(t, handler, http.MethodPost, "/token", f, nil), http.StatusBadRequest, errInvalidGrant)
+
+	f = form(newCode("public", base64.RawURLEncoding.EncodeToString(challenge[:])))
+	f.Set("client_id", "public")
+	f.Set("code_verifier", verifier)
+	w := do(t, handler, http.MethodPost, "/token", f, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+		IDToken     string `json:"id_token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	require.Equal(t, "Bearer", res.TokenType)
+	require.Equal(t, TokenLifetimeDefault, res.ExpiresIn)
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(res.IDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		require.Equal(t, s.keyID, token.Header["kid"])
+		return &s.conf.JwtRSAPrivateKey.PublicKey, nil
+	})
+	require.NoError(t, err)
+	require.True(t, token.Valid)
+	require.Equal(t, testIssuer, claims["iss"])
+	require.Equal(t, "public", claims["aud"])
+	require.Equal(t, "subject", claims["sub"])
+	require.Equal(t, "n-0S6_WzA2Mj", claims["nonce"])
+	require.Equal(t, "456", claims["student_id"])
+
+	// Userinfo
+	w = do(t, handler, http.MethodGet, "/userinfo", nil, http.Header{"Authorization": {"Bearer " + res.AccessToken}})
+	require.Equal(t, http.StatusOK, w.Code)
+	var userinfo map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &userinfo))
+	require.Equal(t, map[string]string{"sub": "subject", "student_id": "456"}, userinfo)
+
+	w = do(t, handler, http.MethodGet, "/userinfo", nil, http.Header{"Authorization": {"Bearer wrong"}})
+	requireError(w, http.StatusUnauthorized, errInvalidToken)
+	require.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+}
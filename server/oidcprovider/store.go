@@ -0,0 +1,161 @@
+package oidcprovider
+
+import (
+	"sync"
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/privacybydesign/irmago/server"
+)
+
+const (
+	// loginLifetime is how long a user has to complete the IRMA session after authorization starts
+	loginLifetime = 15 * time.Minute
+	// codeLifetime is how long an authorization code can be exchanged for tokens
+	codeLifetime = time.Minute
+
+	codeLength = 32
+)
+
+// authorization is an authorization request of a client, which after the user has disclosed the
+// requested attributes is successively identified by a login ID, an authorization code and an
+// access token.
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	state         string
+	nonce         string
+	codeChallenge string
+	scopes        []string
+
+	sessionToken   irma.RequestorToken
+	sessionPackage *server.SessionPackage
+	// browserBinding is the value of the login cookie of the browser that started the
+	// authorization, which only that browser can complete
+	browserBinding string
+
+	// Set once the user has disclosed the requested attributes
+	subject  string
+	claims   map[string]interface{}
+	authTime time.Time
+
+	expiry time.Time
+}
+
+type store interface {
+	// create stores a new authorization and returns its login ID.
+	create(auth *authorization) string
+	// get returns the authorization with the specified login ID.
+	get(id string) *authorization
+	// take removes and returns the authorization with the specified login ID, so that a login
+	// can be completed only once.
+	take(id string) *authorization
+	// createCode stores the authorization under a new authorization code, and returns the code.
+	createCode(auth *authorization) string
+	// redeemCode removes and returns the authorization with the specified authorization code.
+	redeemCode(code string) *authorization
+	// createAccessToken stores the authorization under a new access token, and returns the token.
+	createAccessToken(auth *authorization, lifetime time.Duration) string
+	// accessToken returns the authorization of the specified access token.
+	accessToken(token string) *authorization
+	flush()
+}
+
+type memoryStore struct {
+	sync.Mutex
+
+	logins map[string]*authorization
+	codes  map[string]*authorization
+	tokens map[string]*authorization
+}
+
+func newMemoryStore() store {
+	return &memoryStore{
+		logins: map[string]*authorization{},
+		codes:  map[string]*authorization{},
+		tokens: map[string]*authorization{},
+	}
+}
+
+func (s *memoryStore) create(auth *authorization) string {
+	s.Lock()
+	defer s.Unlock()
+	id := common.NewSessionToken()
+	auth.expiry = time.Now().Add(loginLifetime)
+	s.logins[id] = auth
+	return id
+}
+
+func (s *memoryStore) get(id string) *authorization {
+	s.Lock()
+	defer s.Unlock()
+	auth := s.logins[id]
+	if auth == nil || time.Now().After(auth.expiry) {
+		return nil
+	}
+	return auth
+}
+
+func (s *memoryStore) take(id string) *authorization {
+	s.Lock()
+	defer s.Unlock()
+	auth := s.logins[id]
+	delete(s.logins, id)
+	if auth == nil || time.Now().After(auth.expiry) {
+		return nil
+	}
+	return auth
+}
+
+func (s *memoryStore) createCode(auth *authorization) string {
+	s.Lock()
+	defer s.Unlock()
+	code := common.NewRandomString(codeLength, common.AlphanumericChars)
+	auth.expiry = time.Now().Add(codeLifetime)
+	s.codes[code] = auth
+	return code
+}
+
+func (s *memoryStore) redeemCode(code string) *authorization {
+	s.Lock()
+	defer s.Unlock()
+	auth := s.codes[code]
+	delete(s.codes, code) // codes are single use, also when the exchange fails
+	if auth == nil || time.Now().After(auth.expiry) {
+		return nil
+	}
+	return auth
+}
+
+func (s *memoryStore) createAccessToken(auth *authorization, lifetime time.Duration) string {
+	s.Lock()
+	defer s.Unlock()
+	token := common.NewRandomString(codeLength, common.AlphanumericChars)
+	auth.expiry = time.Now().Add(lifetime)
+	s.tokens[token] = auth
+	return token
+}
+
+func (s *memoryStore) accessToken(token string) *authorization {
+	s.Lock()
+	defer s.Unlock()
+	auth := s.tokens[token]
+	if auth == nil || time.Now().After(auth.expiry) {
+		return nil
+	}
+	return auth
+}
+
+func (s *memoryStore) flush() {
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+	for _, m := range []map[string]*authorization{s.logins, s.codes, s.tokens} {
+		for k, v := range m {
+			if now.After(v.expiry) {
+				delete(m, k)
+			}
+		}
+	}
+}
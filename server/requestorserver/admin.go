@@ -0,0 +1,154 @@
+package requestorserver
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/privacybydesign/irmago/server/irmaserver"
+	"github.com/sirupsen/logrus"
+)
+
+// attachAdminEndpoints mounts the admin API, which is accessible only using the configured admin token.
+func (s *Server) attachAdminEndpoints(router chi.Router) {
+	router.Route("/admin", func(r chi.Router) {
+		r.Use(s.adminAuthMiddleware)
+		r.Get("/callbacks", s.handleFailedCallbacks)
+		r.Post("/callbacks/{id}/replay", s.handleReplayCallback)
+		r.Delete("/callbacks/{id}", s.handleDeleteCallback)
+		r.Get("/sessions", s.handleListSessions)
+		r.Post("/sessions/cancel", s.handleCancelSessions)
+		r.Get("/sessions/{requestorToken}", s.handleSessionInfo)
+	})
+}
+
+// CancelSessionsRequest selects the sessions to be cancelled through the admin API: either the
+// sessions with the specified tokens, or all unfinished sessions of the specified requestor,
+// or all unfinished sessions.
+type CancelSessionsRequest struct {
+	Tokens    []irma.RequestorToken `json:"tokens,omitempty"`
+	Requestor string                `json:"requestor,omitempty"`
+	All       bool                  `json:"all,omitempty"`
+}
+
+// CancelSessionsResponse contains the tokens of the sessions that were cancelled.
+type CancelSessionsResponse struct {
+	Cancelled []irma.RequestorToken `json:"cancelled"`
+}
+
+func (s *Server) adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.conf.AdminToken)) != 1 {
+			s.conf.Logger.WithFields(logrus.Fields{"path": r.URL.Path}).Warn("Unauthorized admin API request")
+			server.WriteError(w, server.ErrorAdminUnauthorized, "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleFailedCallbacks(w http.ResponseWriter, _ *http.Request) {
+	deliveries, err := s.irmaserv.FailedCallbacks()
+	if err != nil {
+		server.WriteError(w, server.ErrorInternal, "")
+		return
+	}
+	server.WriteJson(w, deliveries)
+}
+
+func (s *Server) handleReplayCallback(w http.ResponseWriter, r *http.Request) {
+	if err := s.irmaserv.ReplayCallback(chi.URLParam(r, "id")); err != nil {
+		mapCallbackError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDeleteCallback(w http.ResponseWriter, r *http.Request) {
+	if err := s.irmaserv.DeleteCallback(chi.URLParam(r, "id")); err != nil {
+		mapCallbackError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func mapCallbackError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case *irmaserver.UnknownCallbackError:
+		server.WriteError(w, server.ErrorUnknownCallback, err.Error())
+	case *irmaserver.RedisError, *irmaserver.SQLError:
+		server.WriteError(w, server.ErrorInternal, "")
+	default:
+		server.WriteError(w, server.ErrorInvalidRequest, err.Error())
+	}
+}
+
+// handleListSessions lists the unfinished sessions, optionally including finished ones (?finished=true)
+// or only those of a requestor (?requestor=name).
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := s.irmaserv.Sessions(r.URL.Query().Get("finished") == "true")
+	if err != nil {
+		server.WriteError(w, server.ErrorInternal, "")
+		return
+	}
+	if requestor := r.URL.Query().Get("requestor"); requestor != "" {
+		filtered := make([]*irmaserver.SessionInfo, 0, len(sessions))
+		for _, session := range sessions {
+			if session.Requestor == requestor {
+				filtered = append(filtered, session)
+			}
+		}
+		sessions = filtered
+	}
+	server.WriteJson(w, sessions)
+}
+
+func (s *Server) handleSessionInfo(w http.ResponseWriter, r *http.Request) {
+	requestorToken, err := irma.ParseRequestorToken(chi.URLParam(r, "requestorToken"))
+	if err != nil {
+		server.WriteError(w, server.ErrorInvalidRequest, err.Error())
+		return
+	}
+	info, err := s.irmaserv.Session(requestorToken)
+	if err != nil {
+		mapToServerError(w, err)
+		return
+	}
+	server.WriteJson(w, info)
+}
+
+func (s *Server) handleCancelSessions(w http.ResponseWriter, r *http.Request) {
+	var req CancelSessionsRequest
+	if err := server.ParseBody(r, &req); err != nil {
+		server.WriteError(w, server.ErrorMalformedInput, err.Error())
+		return
+	}
+
+	tokens := req.Tokens
+	if len(tokens) == 0 {
+		if req.Requestor == "" && !req.All {
+			server.WriteError(w, server.ErrorInvalidRequest, "specify tokens, requestor or all")
+			return
+		}
+		sessions, err := s.irmaserv.Sessions(false)
+		if err != nil {
+			server.WriteError(w, server.ErrorInternal, "")
+			return
+		}
+		for _, session := range sessions {
+			if req.All || session.Requestor == req.Requestor {
+				tokens = append(tokens, session.Token)
+			}
+		}
+	}
+
+	cancelled, err := s.irmaserv.CancelSessions(tokens)
+	if err != nil {
+		server.WriteError(w, server.ErrorInternal, "")
+		return
+	}
+	server.WriteJson(w, CancelSessionsResponse{Cancelled: cancelled})
+}
@@ -3,6 +3,7 @@ package requestorserver
 import (
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-errors/errors"
@@ -57,7 +58,21 @@ type PresharedKeyAuthenticator struct {
 }
 type NilAuthenticator struct{}
 
-var authenticators map[AuthenticationMethod]Authenticator
+var (
+	authenticators map[AuthenticationMethod]Authenticator
+
+	// authLock guards the authenticators as well as the requestors and permissions of the
+	// configuration, which may be replaced at runtime when they are reloaded.
+	authLock sync.RWMutex
+)
+
+// currentAuthenticators returns the authenticators currently in use. The returned map is never
+// modified; on reload it is replaced in its entirety.
+func currentAuthenticators() map[AuthenticationMethod]Authenticator {
+	authLock.RLock()
+	defer authLock.RUnlock()
+	return authenticators
+}
 
 func (NilAuthenticator) AuthenticateSession(
 	headers http.Header, body []byte,
@@ -148,6 +163,9 @@ func (pskauth *PresharedKeyAuthenticator) AuthenticateSession(
 	}
 	requestor, ok := pskauth.presharedkeys[auth]
 	if !ok {
+		if _, bearer := bearerJwt(headers); bearer {
+			return false, nil, "", nil // leave OAuth2 access tokens to the OAuth2Authenticator
+		}
 		return true, nil, "", server.RemoteError(server.ErrorUnauthorized, "")
 	}
 	request, err := server.ParseSessionRequest(body)
@@ -164,6 +182,9 @@ func (pskauth *PresharedKeyAuthenticator) AuthenticateRevocation(headers http.He
 	}
 	requestor, ok := pskauth.presharedkeys[auth]
 	if !ok {
+		if _, bearer := bearerJwt(headers); bearer {
+			return false, nil, "", nil // leave OAuth2 access tokens to the OAuth2Authenticator
+		}
 		return true, nil, "", server.RemoteError(server.ErrorUnauthorized, "")
 	}
 	r := &irma.RevocationRequest{}
@@ -3,6 +3,7 @@ package requestorserver
 import (
 	"crypto/tls"
 	"fmt"
+	"net"
 	"strings"
 
 	"github.com/go-errors/errors"
@@ -49,6 +50,30 @@ type Configuration struct {
 	// Requestor-specific permission and authentication configuration
 	Requestors map[string]Requestor `json:"requestors"`
 
+	// Token that must be present in the Authorization header of requests to the admin API
+	// under /admin (leave empty to disable the admin API)
+	AdminToken string `json:"admin_token" mapstructure:"admin_token"`
+
+	// Configuration of the oauth2 requestor authentication method, in which requestors authenticate
+	// using OAuth2 access tokens (JWTs) signed by a key from the JWKS at the specified file or URL
+	OAuth2JwksFile string `json:"oauth2_jwks_file" mapstructure:"oauth2_jwks_file"`
+	OAuth2JwksURL  string `json:"oauth2_jwks_url" mapstructure:"oauth2_jwks_url"`
+	// Required value of the iss and aud claims of access tokens
+	OAuth2Issuer   string `json:"oauth2_issuer" mapstructure:"oauth2_issuer"`
+	OAuth2Audience string `json:"oauth2_audience" mapstructure:"oauth2_audience"`
+	// Claim of access tokens containing the name of the requestor (default "sub")
+	OAuth2RequestorClaim string `json:"oauth2_requestor_claim" mapstructure:"oauth2_requestor_claim"`
+
+	// Configuration of the mtls requestor authentication method. If specified, TLS client certificates
+	// are verified against the certificate authorities in this file
+	MTLSClientCAFile string `json:"mtls_client_ca_file" mapstructure:"mtls_client_ca_file"`
+	// Header in which a TLS-terminating proxy passes the (URL-encoded PEM) client certificate, which is
+	// accepted only from the specified proxy IP addresses or CIDR ranges
+	MTLSProxyHeader    string   `json:"mtls_proxy_header" mapstructure:"mtls_proxy_header"`
+	MTLSTrustedProxies []string `json:"mtls_trusted_proxies" mapstructure:"mtls_trusted_proxies"`
+
+	trustedProxies []*net.IPNet
+
 	// Max age in seconds of a session request JWT (using iat field)
 	MaxRequestAge int `json:"max_request_age" mapstructure:"max_request_age"`
 
@@ -74,6 +99,17 @@ type Requestor struct {
 	AuthenticationMethod  AuthenticationMethod `json:"auth_method" mapstructure:"auth_method"`
 	AuthenticationKey     string               `json:"key" mapstructure:"key"`
 	AuthenticationKeyFile string               `json:"key_file" mapstructure:"key_file"`
+
+	// Expected SHA-256 fingerprint of the public key, or subject, of the TLS client certificate
+	// of requestors using the mtls authentication method
+	CertificateFingerprint string `json:"cert_fingerprint,omitempty" mapstructure:"cert_fingerprint"`
+	CertificateSubject     string `json:"cert_subject,omitempty" mapstructure:"cert_subject"`
+
+	// Maximum amount of sessions per minute, of concurrent live sessions, and of revocations
+	// per hour of the requestor (0 means no limit)
+	MaxSessionsPerMinute  int `json:"max_sessions_per_minute,omitempty" mapstructure:"max_sessions_per_minute"`
+	MaxConcurrentSessions int `json:"max_concurrent_sessions,omitempty" mapstructure:"max_concurrent_sessions"`
+	MaxRevocationsPerHour int `json:"max_revocations_per_hour,omitempty" mapstructure:"max_revocations_per_hour"`
 }
 
 // CanIssue returns whether or not the specified requestor may issue the specified credentials.
@@ -81,6 +117,9 @@ type Requestor struct {
 // the identity provider is allowed to verify the attributes being verified; use CanVerifyOrSign
 // for that).
 func (conf *Configuration) CanIssue(requestor string, creds []*irma.CredentialRequest) (bool, string) {
+	authLock.RLock()
+	defer authLock.RUnlock()
+
 	permissions := append(conf.Requestors[requestor].Issuing, conf.Issuing...)
 	if len(permissions) == 0 { // requestor is not present in the permissions
 		return false, ""
@@ -104,6 +143,9 @@ func (conf *Configuration) CanIssue(requestor string, creds []*irma.CredentialRe
 // CanVerifyOrSign returns whether or not the specified requestor may use the selected attributes
 // in any of the supported session types.
 func (conf *Configuration) CanVerifyOrSign(requestor string, action irma.Action, disjunctions irma.AttributeConDisCon) (bool, string) {
+	authLock.RLock()
+	defer authLock.RUnlock()
+
 	var permissions []string
 	switch action {
 	case irma.ActionDisclosing:
@@ -135,6 +177,9 @@ func (conf *Configuration) CanVerifyOrSign(requestor string, action irma.Action,
 }
 
 func (conf *Configuration) CanRevoke(requestor string, cred irma.CredentialTypeIdentifier) (bool, string) {
+	authLock.RLock()
+	defer authLock.RUnlock()
+
 	permissions := append(conf.Requestors[requestor].Revoking, conf.Revoking...)
 	if len(permissions) == 0 { // requestor is not present in the permissions
 		return false, ""
@@ -152,48 +197,21 @@ func (conf *Configuration) CanRevoke(requestor string, cred irma.CredentialTypeI
 	return false, cred.String()
 }
 
-func (conf *Configuration) initialize() error {
-	if conf.DisableRequestorAuthentication {
-		authenticators = map[AuthenticationMethod]Authenticator{AuthenticationMethodNone: NilAuthenticator{}}
-		conf.Logger.Warn("Authentication of incoming session requests disabled: anyone who can reach this server can use it")
-		havekeys := conf.HavePrivateKeys()
-		if len(conf.Permissions.Issuing) > 0 && havekeys {
-			if conf.separateClientServer() || !conf.Production {
-				conf.Logger.Warn("Issuance enabled and private keys installed: anyone who can reach this server can use it to issue attributes")
-			} else {
-				return errors.New("If issuing is enabled in production mode, requestor authentication must be enabled, or client_listen_addr and client_port must be used")
-			}
-		}
-	} else {
-		if len(conf.Requestors) == 0 {
-			revServer := false
-			for _, s := range conf.RevocationSettings {
-				if s.Server {
-					revServer = true
-				}
-			}
-			if !revServer {
-				return errors.New("No requestors configured; either configure one or more requestors or disable requestor authentication")
-			}
-		}
-		authenticators = map[AuthenticationMethod]Authenticator{
-			AuthenticationMethodHmac:      &HmacAuthenticator{hmackeys: map[string]interface{}{}, maxRequestAge: conf.MaxRequestAge},
-			AuthenticationMethodPublicKey: &PublicKeyAuthenticator{publickeys: map[string]interface{}{}, maxRequestAge: conf.MaxRequestAge},
-			AuthenticationMethodToken:     &PresharedKeyAuthenticator{presharedkeys: map[string]string{}},
-		}
+// requestor returns the configuration of the specified requestor.
+func (conf *Configuration) requestor(name string) Requestor {
+	authLock.RLock()
+	defer authLock.RUnlock()
+	return conf.Requestors[name]
+}
 
-		// Initialize authenticators
-		for name, requestor := range conf.Requestors {
-			authenticator, ok := authenticators[requestor.AuthenticationMethod]
-			if !ok {
-				return errors.Errorf("Requestor %s has unsupported authentication type %s (supported methods: %s, %s, %s)",
-					name, requestor.AuthenticationMethod, AuthenticationMethodToken, AuthenticationMethodHmac, AuthenticationMethodPublicKey)
-			}
-			if err := authenticator.Initialize(name, requestor); err != nil {
-				return err
-			}
-		}
+func (conf *Configuration) initialize() error {
+	auths, err := conf.newAuthenticators()
+	if err != nil {
+		return err
 	}
+	authLock.Lock()
+	authenticators = auths
+	authLock.Unlock()
 
 	if conf.Port <= 0 || conf.Port > 65535 {
 		return errors.Errorf("Port must be between 1 and 65535 (was %d)", conf.Port)
@@ -209,6 +227,9 @@ func (conf *Configuration) initialize() error {
 		return errors.New("client_listen_addr must be combined with a nonzero client_port")
 	}
 
+	if err = conf.verifyMTLS(); err != nil {
+		return err
+	}
 	tlsConf, err := conf.tlsConfig()
 	if err != nil {
 		return errors.WrapPrefix(err, "Failed to read TLS configuration", 0)
@@ -272,9 +293,76 @@ func (conf *Configuration) initialize() error {
 		conf.Logger.Warn("Static sessions enabled and no JWT private key installed. Ensure that POSTs to the callback URLs of static sessions are trustworthy by keeping the callback URLs secret and by using HTTPS.")
 	}
 
+	// The limits are looked up when a session is started, so that reloaded requestors are taken into account
+	conf.RequestorLimits = func(requestor string) (int, int) {
+		r := conf.requestor(requestor)
+		return r.MaxSessionsPerMinute, r.MaxConcurrentSessions
+	}
+
 	return nil
 }
 
+// newAuthenticators creates the authenticators for the configured requestors, reading their
+// authentication keys from disk where applicable.
+func (conf *Configuration) newAuthenticators() (map[AuthenticationMethod]Authenticator, error) {
+	if conf.DisableRequestorAuthentication {
+		conf.Logger.Warn("Authentication of incoming session requests disabled: anyone who can reach this server can use it")
+		havekeys := conf.HavePrivateKeys()
+		if len(conf.Permissions.Issuing) > 0 && havekeys {
+			if conf.separateClientServer() || !conf.Production {
+				conf.Logger.Warn("Issuance enabled and private keys installed: anyone who can reach this server can use it to issue attributes")
+			} else {
+				return nil, errors.New("If issuing is enabled in production mode, requestor authentication must be enabled, or client_listen_addr and client_port must be used")
+			}
+		}
+		return map[AuthenticationMethod]Authenticator{AuthenticationMethodNone: NilAuthenticator{}}, nil
+	}
+
+	if len(conf.Requestors) == 0 {
+		revServer := false
+		for _, s := range conf.RevocationSettings {
+			if s.Server {
+				revServer = true
+			}
+		}
+		if !revServer {
+			return nil, errors.New("No requestors configured; either configure one or more requestors or disable requestor authentication")
+		}
+	}
+	oauth2, err := newOAuth2Authenticator(conf)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := conf.mtlsClientCAs()
+	if err != nil {
+		return nil, err
+	}
+	auths := map[AuthenticationMethod]Authenticator{
+		AuthenticationMethodHmac:      &HmacAuthenticator{hmackeys: map[string]interface{}{}, maxRequestAge: conf.MaxRequestAge},
+		AuthenticationMethodPublicKey: &PublicKeyAuthenticator{publickeys: map[string]interface{}{}, maxRequestAge: conf.MaxRequestAge},
+		AuthenticationMethodToken:     &PresharedKeyAuthenticator{presharedkeys: map[string]string{}},
+		AuthenticationMethodOAuth2:    oauth2,
+		AuthenticationMethodMTLS: &MTLSAuthenticator{
+			fingerprints: map[string]string{},
+			subjects:     map[string]string{},
+			roots:        roots,
+		},
+	}
+
+	// Initialize authenticators
+	for name, requestor := range conf.Requestors {
+		authenticator, ok := auths[requestor.AuthenticationMethod]
+		if !ok {
+			return nil, errors.Errorf("Requestor %s has unsupported authentication type %s (supported methods: %s, %s, %s, %s, %s)",
+				name, requestor.AuthenticationMethod, AuthenticationMethodToken, AuthenticationMethodHmac, AuthenticationMethodPublicKey, AuthenticationMethodOAuth2, AuthenticationMethodMTLS)
+		}
+		if err := authenticator.Initialize(name, requestor); err != nil {
+			return nil, err
+		}
+	}
+	return auths, nil
+}
+
 func (conf *Configuration) validatePermissions() error {
 	if conf.DisableRequestorAuthentication && len(conf.Requestors) != 0 {
 		return errors.New("Requestors must not be configured when requestor authentication is disabled")
@@ -377,7 +465,14 @@ func (conf *Configuration) clientTlsConfig() (*tls.Config, error) {
 }
 
 func (conf *Configuration) tlsConfig() (*tls.Config, error) {
-	return server.TLSConf(conf.TlsCertificate, conf.TlsCertificateFile, conf.TlsPrivateKey, conf.TlsPrivateKeyFile)
+	tlsConf, err := server.TLSConf(conf.TlsCertificate, conf.TlsCertificateFile, conf.TlsPrivateKey, conf.TlsPrivateKeyFile)
+	if err != nil || tlsConf == nil {
+		return tlsConf, err
+	}
+	if err = conf.mtlsClientAuth(tlsConf); err != nil {
+		return nil, err
+	}
+	return tlsConf, nil
 }
 
 func (conf *Configuration) separateClientServer() bool {
@@ -0,0 +1,286 @@
+package requestorserver
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+)
+
+// MTLSAuthenticator authenticates requestors by the TLS client certificate with which they
+// connect to the server, either directly or through a TLS-terminating proxy. Requestors are
+// identified by the SHA-256 fingerprint of the public key (SPKI) of their certificate, or by the
+// subject of their certificate if the certificate is verified against a certificate authority.
+type MTLSAuthenticator struct {
+	fingerprints map[string]string
+	subjects     map[string]string
+	roots        *x509.CertPool // against which certificates are verified before matching their subject
+}
+
+const (
+	AuthenticationMethodMTLS = "mtls"
+
+	// mtlsCertificateHeader is used to pass the client certificate of a request to the
+	// MTLSAuthenticator, in base64-encoded DER form. Any such header sent by clients is removed.
+	mtlsCertificateHeader = "X-Irma-Mtls-Client-Certificate"
+)
+
+func (mauth *MTLSAuthenticator) Initialize(name string, requestor Requestor) error {
+	if requestor.CertificateFingerprint == "" && requestor.CertificateSubject == "" {
+		return errors.Errorf("Requestor %s uses the mtls authentication method but specifies no cert_fingerprint or cert_subject", name)
+	}
+	if requestor.CertificateFingerprint != "" {
+		fingerprint, err := parseCertificateFingerprint(requestor.CertificateFingerprint)
+		if err != nil {
+			return errors.WrapPrefix(err, "Invalid cert_fingerprint of requestor "+name, 0)
+		}
+		mauth.fingerprints[fingerprint] = name
+	}
+	if requestor.CertificateSubject != "" {
+		if mauth.roots == nil {
+			return errors.Errorf("Requestor %s specifies cert_subject, which requires mtls_client_ca_file", name)
+		}
+		mauth.subjects[requestor.CertificateSubject] = name
+	}
+	return nil
+}
+
+func (mauth *MTLSAuthenticator) AuthenticateSession(
+	headers http.Header, body []byte,
+) (bool, irma.RequestorRequest, string, *irma.RemoteError) {
+	applies, requestor, rerr := mauth.authenticate(headers)
+	if !applies || rerr != nil {
+		return applies, nil, "", rerr
+	}
+	request, err := server.ParseSessionRequest(body)
+	if err != nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	return true, request, requestor, nil
+}
+
+func (mauth *MTLSAuthenticator) AuthenticateRevocation(
+	headers http.Header, body []byte,
+) (bool, *irma.RevocationRequest, string, *irma.RemoteError) {
+	applies, requestor, rerr := mauth.authenticate(headers)
+	if !applies || rerr != nil {
+		return applies, nil, "", rerr
+	}
+	r := &irma.RevocationRequest{}
+	if err := irma.UnmarshalValidate(body, r); err != nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	return true, r, requestor, nil
+}
+
+func (mauth *MTLSAuthenticator) authenticate(headers http.Header) (bool, string, *irma.RemoteError) {
+	certheader := headers.Get(mtlsCertificateHeader)
+	if certheader == "" || headers.Get("Authorization") != "" ||
+		!strings.HasPrefix(headers.Get("Content-Type"), "application/json") {
+		return false, "", nil
+	}
+	der, err := base64.StdEncoding.DecodeString(certheader)
+	if err != nil {
+		return true, "", server.RemoteError(server.ErrorUnauthorized, "")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return true, "", server.RemoteError(server.ErrorUnauthorized, "")
+	}
+
+	if requestor, ok := mauth.fingerprints[certificateFingerprint(cert)]; ok {
+		return true, requestor, nil
+	}
+	if requestor, ok := mauth.subjects[cert.Subject.String()]; ok && mauth.verify(cert) == nil {
+		return true, requestor, nil
+	}
+	return true, "", server.RemoteError(server.ErrorUnauthorized, "unknown client certificate")
+}
+
+// verify verifies the certificate against the configured certificate authorities. This is also
+// done for certificates passed by a proxy, which the TLS stack of this server has not seen.
+func (mauth *MTLSAuthenticator) verify(cert *x509.Certificate) error {
+	if mauth.roots == nil {
+		return errors.New("no certificate authorities configured")
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:     mauth.roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+// certificateFingerprint returns the hex-encoded SHA-256 hash of the public key (SPKI) of the certificate.
+func certificateFingerprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(hash[:])
+}
+
+// parseCertificateFingerprint normalizes a hex-encoded SHA-256 fingerprint, which may be
+// in upper case and contain colons.
+func parseCertificateFingerprint(fingerprint string) (string, error) {
+	fingerprint = strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+	bts, err := hex.DecodeString(fingerprint)
+	if err != nil {
+		return "", err
+	}
+	if len(bts) != sha256.Size {
+		return "", errors.New("fingerprint must be a SHA-256 hash")
+	}
+	return fingerprint, nil
+}
+
+// authenticationHeaders returns the headers of the request to be fed to the authenticators,
+// which include the client certificate of the request, if any, in the mtlsCertificateHeader.
+func (s *Server) authenticationHeaders(r *http.Request) http.Header {
+	headers := r.Header.Clone()
+	headers.Del(mtlsCertificateHeader)
+	if cert := s.clientCertificate(r); cert != nil {
+		headers.Set(mtlsCertificateHeader, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	return headers
+}
+
+// clientCertificate returns the TLS client certificate of the request. If a proxy header is
+// configured, the certificate is read from that header, only for requests from trusted proxies;
+// certificates of direct TLS connections are then ignored, as these have not been verified.
+func (s *Server) clientCertificate(r *http.Request) *x509.Certificate {
+	if s.conf.MTLSProxyHeader != "" {
+		if !s.conf.trustedProxy(r.RemoteAddr) {
+			return nil
+		}
+		header := r.Header.Get(s.conf.MTLSProxyHeader)
+		if header == "" {
+			return nil
+		}
+		cert, err := parseProxyCertificate(header)
+		if err != nil {
+			s.conf.Logger.Warn("Failed to parse client certificate from proxy header: ", err.Error())
+			return nil
+		}
+		return cert
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0]
+	}
+	return nil
+}
+
+// parseProxyCertificate parses a URL-encoded PEM certificate, as passed by for example nginx
+// ($ssl_client_escaped_cert).
+func parseProxyCertificate(header string) (*x509.Certificate, error) {
+	unescaped, err := url.QueryUnescape(header)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(unescaped))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func (conf *Configuration) trustedProxy(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range conf.trustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (conf *Configuration) verifyMTLS() error {
+	if conf.MTLSProxyHeader != "" && len(conf.MTLSTrustedProxies) == 0 {
+		return errors.New("mtls_proxy_header requires mtls_trusted_proxies")
+	}
+	conf.trustedProxies = nil
+	for _, proxy := range conf.MTLSTrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return errors.WrapPrefix(err, "Invalid mtls_trusted_proxies", 0)
+		}
+		conf.trustedProxies = append(conf.trustedProxies, ipnet)
+	}
+	return nil
+}
+
+// mtlsClientCAs returns the certificate authorities in mtls_client_ca_file, or nil if it is not configured.
+func (conf *Configuration) mtlsClientCAs() (*x509.CertPool, error) {
+	if conf.MTLSClientCAFile == "" {
+		return nil, nil
+	}
+	bts, err := ioutil.ReadFile(conf.MTLSClientCAFile)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to read mtls_client_ca_file", 0)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bts) {
+		return nil, errors.New("mtls_client_ca_file contains no certificates")
+	}
+	return pool, nil
+}
+
+// mtlsClientAuth configures the TLS configuration of the requestor server to request client
+// certificates, if necessary.
+func (conf *Configuration) mtlsClientAuth(tlsConf *tls.Config) error {
+	pool, err := conf.mtlsClientCAs()
+	if err != nil {
+		return err
+	}
+	if pool != nil {
+		tlsConf.ClientCAs = pool
+		tlsConf.ClientAuth = tls.VerifyClientCertIfGiven
+		return nil
+	}
+
+	// Client certificates are pinned by their fingerprints, so we need not verify them. As mtls
+	// requestors may be added by ReloadRequestors, whether they are requested is decided per
+	// connection, so that clients are not asked for certificates if there are no mtls requestors.
+	requestClientCert := tlsConf.Clone()
+	requestClientCert.ClientAuth = tls.RequestClientCert
+	tlsConf.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		if conf.hasMTLSRequestors() {
+			return requestClientCert, nil
+		}
+		return nil, nil
+	}
+	return nil
+}
+
+// hasMTLSRequestors returns whether any of the current requestors authenticates using mtls.
+func (conf *Configuration) hasMTLSRequestors() bool {
+	authLock.RLock()
+	defer authLock.RUnlock()
+	for _, requestor := range conf.Requestors {
+		if requestor.AuthenticationMethod == AuthenticationMethodMTLS {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package requestorserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	sk   *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Example CA", Organization: []string{"Example"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &sk.PublicKey, sk)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, sk: sk}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issueCertificate creates a client certificate signed by the CA, or a self-signed one if ca is nil.
+func issueCertificate(t *testing.T, ca *testCA, cn string) *x509.Certificate {
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := template, sk
+	if ca != nil {
+		parent, signer = ca.cert, ca.sk
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &sk.PublicKey, signer)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func clientCertificate(t *testing.T, cn string) *x509.Certificate {
+	return issueCertificate(t, nil, cn)
+}
+
+func mtlsHeaders(cert *x509.Certificate) http.Header {
+	return http.Header{
+		mtlsCertificateHeader: {base64.StdEncoding.EncodeToString(cert.Raw)},
+		"Content-Type":        {"application/json"},
+	}
+}
+
+func TestMTLSAuthenticator(t *testing.T) {
+	ca := newTestCA(t)
+	pinned := clientCertificate(t, "pinned")
+	named := issueCertificate(t, ca, "named")
+	unknown := clientCertificate(t, "unknown")
+
+	auth := &MTLSAuthenticator{fingerprints: map[string]string{}, subjects: map[string]string{}, roots: ca.pool()}
+	fingerprint := strings.ToUpper(certificateFingerprint(pinned)) // upper case is accepted
+	require.NoError(t, auth.Initialize("pinnedapp", Requestor{CertificateFingerprint: fingerprint}))
+	require.NoError(t, auth.Initialize("namedapp", Requestor{CertificateSubject: "CN=named,O=Example"}))
+	require.Error(t, auth.Initialize("otherapp", Requestor{}))
+	require.Error(t, auth.Initialize("otherapp", Requestor{CertificateFingerprint: "abcd"}))
+
+	t.Run("fingerprint", func(t *testing.T) {
+		applies, request, requestor, err := auth.AuthenticateSession(mtlsHeaders(pinned), oauth2RequestBody)
+		require.Nil(t, err)
+		require.True(t, applies)
+		require.Equal(t, "pinnedapp", requestor)
+		require.NotNil(t, request)
+	})
+
+	t.Run("subject", func(t *testing.T) {
+		applies, _, requestor, err := auth.AuthenticateSession(mtlsHeaders(named), oauth2RequestBody)
+		require.Nil(t, err)
+		require.True(t, applies)
+		require.Equal(t, "namedapp", requestor)
+	})
+
+	t.Run("self-signed certificate with known subject", func(t *testing.T) {
+		applies, _, _, err := auth.AuthenticateSession(mtlsHeaders(clientCertificate(t, "named")), oauth2RequestBody)
+		require.True(t, applies)
+		require.NotNil(t, err)
+		require.Equal(t, server.ErrorUnauthorized.Status, err.Status)
+	})
+
+	t.Run("certificate of other CA with known subject", func(t *testing.T) {
+		cert := issueCertificate(t, newTestCA(t), "named")
+		applies, _, _, err := auth.AuthenticateSession(mtlsHeaders(cert), oauth2RequestBody)
+		require.True(t, applies)
+		require.NotNil(t, err)
+		require.Equal(t, server.ErrorUnauthorized.Status, err.Status)
+	})
+
+	t.Run("unknown certificate", func(t *testing.T) {
+		applies, _, _, err := auth.AuthenticateSession(mtlsHeaders(unknown), oauth2RequestBody)
+		require.True(t, applies)
+		require.NotNil(t, err)
+		require.Equal(t, server.ErrorUnauthorized.Status, err.Status)
+	})
+
+	t.Run("not applicable", func(t *testing.T) {
+		applies, _, _, err := auth.AuthenticateSession(http.Header{"Content-Type": {"application/json"}}, oauth2RequestBody)
+		require.Nil(t, err)
+		require.False(t, applies)
+
+		headers := mtlsHeaders(pinned)
+		headers.Set("Authorization", "token")
+		applies, _, _, err = auth.AuthenticateSession(headers, oauth2RequestBody)
+		require.Nil(t, err)
+		require.False(t, applies)
+	})
+
+	t.Run("subject requires verification", func(t *testing.T) {
+		auth := &MTLSAuthenticator{fingerprints: map[string]string{}, subjects: map[string]string{}}
+		require.Error(t, auth.Initialize("namedapp", Requestor{CertificateSubject: "CN=named,O=Example"}))
+	})
+}
+
+func TestMTLSClientCertificate(t *testing.T) {
+	cert := clientCertificate(t, "myapp")
+	encoded := base64.StdEncoding.EncodeToString(cert.Raw)
+	escaped := url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+
+	conf := &Configuration{
+		Configuration:      &server.Configuration{Logger: server.Logger},
+		MTLSProxyHeader:    "X-Client-Cert",
+		MTLSTrustedProxies: []string{"10.0.0.0/8", "::1"},
+	}
+	require.NoError(t, conf.verifyMTLS())
+	s := &Server{conf: conf}
+
+	t.Run("client header is removed", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/session", nil)
+		r.Header.Set(mtlsCertificateHeader, encoded)
+		require.Empty(t, s.authenticationHeaders(r).Get(mtlsCertificateHeader))
+	})
+
+	t.Run("trusted proxy", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/session", nil)
+		r.RemoteAddr = "10.1.2.3:1234"
+		r.Header.Set("X-Client-Cert", escaped)
+		require.Equal(t, encoded, s.authenticationHeaders(r).Get(mtlsCertificateHeader))
+
+		r.RemoteAddr = "[::1]:1234"
+		require.Equal(t, encoded, s.authenticationHeaders(r).Get(mtlsCertificateHeader))
+	})
+
+	t.Run("untrusted proxy", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/session", nil)
+		r.RemoteAddr = "192.168.1.2:1234"
+		r.Header.Set("X-Client-Cert", escaped)
+		require.Empty(t, s.authenticationHeaders(r).Get(mtlsCertificateHeader))
+	})
+
+	t.Run("TLS connection is ignored in proxy mode", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/session", nil)
+		r.RemoteAddr = "192.168.1.2:1234"
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		require.Empty(t, s.authenticationHeaders(r).Get(mtlsCertificateHeader))
+	})
+
+	t.Run("TLS connection", func(t *testing.T) {
+		s := &Server{conf: &Configuration{Configuration: conf.Configuration}}
+		r := httptest.NewRequest(http.MethodPost, "/session", nil)
+		r.RemoteAddr = "192.168.1.2:1234"
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		require.Equal(t, encoded, s.authenticationHeaders(r).Get(mtlsCertificateHeader))
+	})
+
+	conf.MTLSTrustedProxies = nil
+	require.Error(t, conf.verifyMTLS())
+	conf.MTLSTrustedProxies = []string{"notanip"}
+	require.Error(t, conf.verifyMTLS())
+}
+
+func TestMTLSProxySelfSignedCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	conf := &Configuration{
+		Configuration:      &server.Configuration{Logger: server.Logger},
+		MTLSProxyHeader:    "X-Client-Cert",
+		MTLSTrustedProxies: []string{"10.0.0.0/8"},
+	}
+	require.NoError(t, conf.verifyMTLS())
+	s := &Server{conf: conf}
+	auth := &MTLSAuthenticator{fingerprints: map[string]string{}, subjects: map[string]string{}, roots: ca.pool()}
+	require.NoError(t, auth.Initialize("namedapp", Requestor{CertificateSubject: "CN=named,O=Example"}))
+
+	authenticate := func(cert *x509.Certificate) (string, *irma.RemoteError) {
+		r := httptest.NewRequest(http.MethodPost, "/session", nil)
+		r.RemoteAddr = "10.1.2.3:1234"
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Client-Cert", url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))))
+		applies, _, requestor, err := auth.AuthenticateSession(s.authenticationHeaders(r), oauth2RequestBody)
+		require.True(t, applies)
+		return requestor, err
+	}
+
+	requestor, err := authenticate(issueCertificate(t, ca, "named"))
+	require.Nil(t, err)
+	require.Equal(t, "namedapp", requestor)
+
+	_, err = authenticate(clientCertificate(t, "named"))
+	require.NotNil(t, err)
+	require.Equal(t, server.ErrorUnauthorized.Status, err.Status)
+}
+
+func TestMTLSClientAuthAfterReload(t *testing.T) {
+	conf := &Configuration{
+		Configuration: &server.Configuration{Logger: server.Logger},
+		Requestors:    map[string]Requestor{"myapp": {AuthenticationMethod: AuthenticationMethodHmac}},
+	}
+	tlsConf := &tls.Config{}
+	require.NoError(t, conf.mtlsClientAuth(tlsConf))
+	require.Equal(t, tls.NoClientCert, tlsConf.ClientAuth)
+	connConf, err := tlsConf.GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.Nil(t, connConf)
+
+	// Simulate ReloadRequestors adding an mtls requestor
+	authLock.Lock()
+	conf.Requestors = map[string]Requestor{"myapp": {AuthenticationMethod: AuthenticationMethodMTLS}}
+	authLock.Unlock()
+	connConf, err = tlsConf.GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.NotNil(t, connConf)
+	require.Equal(t, tls.RequestClientCert, connConf.ClientAuth)
+}
@@ -0,0 +1,371 @@
+package requestorserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/golang-jwt/jwt/v4"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"golang.org/x/sync/singleflight"
+)
+
+// OAuth2Authenticator authenticates requestors that include an OAuth2 access token in the form
+// of a JWT in the Authorization header ("Authorization: Bearer <JWT>"), containing a JSON session
+// request in the request body. The JWT must be signed by one of the keys in the configured JWKS,
+// and contain the configured issuer and audience. The name of the requestor is taken from the
+// configured claim of the JWT, so the JWT must be issued to a requestor having the oauth2
+// authentication method.
+type OAuth2Authenticator struct {
+	jwks           *jwksKeys
+	issuer         string
+	audience       string
+	requestorClaim string
+	requestors     map[string]struct{}
+}
+
+// jwksKeys contains the public keys from a JWKS, read from a file or from a URL. In the latter
+// case the JWKS is fetched again when a JWT is signed by an unknown key, or when it is older than
+// jwksMaxAge. The JWKS is fetched without holding the lock, and concurrent fetches are combined.
+type jwksKeys struct {
+	sync.Mutex
+	file     string
+	url      string
+	keys     map[string]interface{}
+	fetched  time.Time
+	fetching singleflight.Group
+}
+
+// jwk is a JSON Web Key as defined in RFC 7517, of which only the fields that we need for
+// verifying signatures are included.
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+const (
+	AuthenticationMethodOAuth2 = "oauth2"
+
+	oauth2BearerPrefix = "Bearer "
+)
+
+var (
+	jwksMaxAge             = time.Hour
+	jwksMinRefreshInterval = time.Minute
+	jwksClient             = &http.Client{Timeout: 10 * time.Second}
+
+	oauth2SigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+)
+
+func newOAuth2Authenticator(conf *Configuration) (*OAuth2Authenticator, error) {
+	auth := &OAuth2Authenticator{
+		issuer:         conf.OAuth2Issuer,
+		audience:       conf.OAuth2Audience,
+		requestorClaim: conf.OAuth2RequestorClaim,
+		requestors:     map[string]struct{}{},
+	}
+	if auth.requestorClaim == "" {
+		auth.requestorClaim = "sub"
+	}
+	if conf.OAuth2JwksFile == "" && conf.OAuth2JwksURL == "" {
+		return auth, nil
+	}
+	if conf.OAuth2JwksFile != "" && conf.OAuth2JwksURL != "" {
+		return nil, errors.New("oauth2_jwks_file and oauth2_jwks_url must not both be specified")
+	}
+	auth.jwks = &jwksKeys{file: conf.OAuth2JwksFile, url: conf.OAuth2JwksURL}
+	if err := auth.jwks.load(); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+func (oauth *OAuth2Authenticator) Initialize(name string, requestor Requestor) error {
+	if oauth.jwks == nil {
+		return errors.Errorf("Requestor %s uses the oauth2 authentication method, but no JWKS is configured", name)
+	}
+	if oauth.issuer == "" || oauth.audience == "" {
+		return errors.Errorf("Requestor %s uses the oauth2 authentication method, but no issuer and audience are configured", name)
+	}
+	oauth.requestors[name] = struct{}{}
+	return nil
+}
+
+func (oauth *OAuth2Authenticator) AuthenticateSession(
+	headers http.Header, body []byte,
+) (bool, irma.RequestorRequest, string, *irma.RemoteError) {
+	applies, requestor, rerr := oauth.authenticate(headers)
+	if !applies || rerr != nil {
+		return applies, nil, "", rerr
+	}
+	request, err := server.ParseSessionRequest(body)
+	if err != nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	return true, request, requestor, nil
+}
+
+func (oauth *OAuth2Authenticator) AuthenticateRevocation(
+	headers http.Header, body []byte,
+) (bool, *irma.RevocationRequest, string, *irma.RemoteError) {
+	applies, requestor, rerr := oauth.authenticate(headers)
+	if !applies || rerr != nil {
+		return applies, nil, "", rerr
+	}
+	r := &irma.RevocationRequest{}
+	if err := irma.UnmarshalValidate(body, r); err != nil {
+		return true, nil, "", server.RemoteError(server.ErrorInvalidRequest, err.Error())
+	}
+	return true, r, requestor, nil
+}
+
+// authenticate verifies the bearer JWT in the Authorization header, if present, and returns
+// the name of the requestor to which it was issued.
+func (oauth *OAuth2Authenticator) authenticate(headers http.Header) (bool, string, *irma.RemoteError) {
+	token, ok := bearerJwt(headers)
+	if !ok || oauth.jwks == nil {
+		return false, "", nil
+	}
+
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{ValidMethods: oauth2SigningMethods}
+	if _, err := parser.ParseWithClaims(token, claims, oauth.jwks.keyfunc); err != nil {
+		return true, "", server.RemoteError(server.ErrorUnauthorized, err.Error())
+	}
+	now := time.Now().Unix()
+	if !claims.VerifyExpiresAt(now, true) {
+		return true, "", server.RemoteError(server.ErrorUnauthorized, "jwt expired or exp claim missing")
+	}
+	if !claims.VerifyIssuer(oauth.issuer, true) {
+		return true, "", server.RemoteError(server.ErrorUnauthorized, "jwt has invalid issuer")
+	}
+	if !claims.VerifyAudience(oauth.audience, true) {
+		return true, "", server.RemoteError(server.ErrorUnauthorized, "jwt has invalid audience")
+	}
+
+	requestor, ok := claims[oauth.requestorClaim].(string)
+	if !ok {
+		return true, "", server.RemoteError(server.ErrorUnauthorized, "jwt does not contain "+oauth.requestorClaim+" claim")
+	}
+	if _, ok = oauth.requestors[requestor]; !ok {
+		return true, "", server.RemoteError(server.ErrorUnauthorized, "unknown requestor "+requestor)
+	}
+	return true, requestor, nil
+}
+
+// bearerJwt returns the JWT from the Authorization header of a request having a JSON body,
+// if present.
+func bearerJwt(headers http.Header) (string, bool) {
+	auth := headers.Get("Authorization")
+	if !strings.HasPrefix(auth, oauth2BearerPrefix) || !strings.HasPrefix(headers.Get("Content-Type"), "application/json") {
+		return "", false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(auth, oauth2BearerPrefix))
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{}); err != nil {
+		return "", false
+	}
+	return token, true
+}
+
+// keyfunc returns the key with which the JWT should be verified using its "kid" header. If the JWT
+// has no "kid" header, the JWKS must contain exactly one key.
+func (j *jwksKeys) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if j.url != "" && j.age() > jwksMaxAge {
+		j.refresh()
+	}
+	key, err := j.key(kid)
+	if err != nil && j.url != "" && j.age() > jwksMinRefreshInterval {
+		// the key might have been added to the JWKS after we last fetched it
+		j.refresh()
+		key, err = j.key(kid)
+	}
+	return key, err
+}
+
+func (j *jwksKeys) age() time.Duration {
+	j.Lock()
+	defer j.Unlock()
+	return time.Since(j.fetched)
+}
+
+// refresh fetches the JWKS, or waits for the fetch that is already in progress.
+func (j *jwksKeys) refresh() {
+	_, err, _ := j.fetching.Do(j.url, func() (interface{}, error) {
+		return nil, j.fetch()
+	})
+	if err != nil {
+		_ = server.LogWarning(err)
+	}
+}
+
+func (j *jwksKeys) key(kid string) (interface{}, error) {
+	j.Lock()
+	defer j.Unlock()
+	if kid == "" {
+		if len(j.keys) != 1 {
+			return nil, errors.New("jwt has no kid header")
+		}
+		for _, key := range j.keys {
+			return key, nil
+		}
+	}
+	key, ok := j.keys[kid]
+	if !ok {
+		return nil, errors.Errorf("unknown key %s", kid)
+	}
+	return key, nil
+}
+
+func (j *jwksKeys) load() error {
+	if j.url != "" {
+		return j.fetch()
+	}
+	bts, err := ioutil.ReadFile(j.file)
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to read JWKS", 0)
+	}
+	keys, err := parseJwks(bts)
+	if err != nil {
+		return err
+	}
+	j.Lock()
+	j.keys = keys
+	j.Unlock()
+	return nil
+}
+
+// fetch fetches the JWKS from its URL and replaces the keys. The lock is only held while
+// updating the fields, so that other JWTs can be verified during the request.
+func (j *jwksKeys) fetch() error {
+	j.Lock()
+	j.fetched = time.Now()
+	j.Unlock()
+
+	res, err := jwksClient.Get(j.url)
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to fetch JWKS", 0)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("Failed to fetch JWKS: server returned status %d", res.StatusCode)
+	}
+	bts, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.WrapPrefix(err, "Failed to fetch JWKS", 0)
+	}
+	keys, err := parseJwks(bts)
+	if err != nil {
+		return err
+	}
+	j.Lock()
+	j.keys = keys
+	j.Unlock()
+	return nil
+}
+
+// parseJwks parses the RSA and EC signing keys from the specified JWKS, ignoring keys of other types.
+func parseJwks(bts []byte) (map[string]interface{}, error) {
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(bts, &jwks); err != nil {
+		return nil, errors.WrapPrefix(err, "Failed to parse JWKS", 0)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var (
+			key interface{}
+			err error
+		)
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaPublicKey()
+		case "EC":
+			key, err = k.ecdsaPublicKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "Failed to parse key "+k.Kid+" in JWKS", 0)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := jwkInt(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := jwkInt(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k jwk) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, errors.Errorf("unsupported curve %s", k.Crv)
+	}
+	x, err := jwkInt(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := jwkInt(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("point is not on curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func jwkInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing key parameter")
+	}
+	bts, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bts), nil
+}
@@ -0,0 +1,275 @@
+package requestorserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/privacybydesign/irmago/internal/test"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/stretchr/testify/require"
+)
+
+var oauth2RequestBody = []byte(`{"request": {"@context":"https://irma.app/ld/request/disclosure/v2","disclose":[[["irma-demo.RU.studentCard.studentID"]]]}}`)
+
+func oauth2Conf(t *testing.T) *Configuration {
+	return &Configuration{
+		Configuration:  &server.Configuration{Logger: server.Logger},
+		OAuth2JwksFile: filepath.Join(test.FindTestdataFolder(t), "jwtkeys", "oauth2-jwks.json"),
+		OAuth2Issuer:   "https://idp.example.com",
+		OAuth2Audience: "irmaserver",
+		Requestors: map[string]Requestor{
+			"myapp": {AuthenticationMethod: AuthenticationMethodOAuth2},
+		},
+	}
+}
+
+func oauth2Authenticator(t *testing.T, conf *Configuration) *OAuth2Authenticator {
+	auth, err := newOAuth2Authenticator(conf)
+	require.NoError(t, err)
+	for name, requestor := range conf.Requestors {
+		require.NoError(t, auth.Initialize(name, requestor))
+	}
+	return auth
+}
+
+func oauth2Token(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	var key interface{}
+	switch method.(type) {
+	case *jwt.SigningMethodRSA:
+		bts, err := ioutil.ReadFile(filepath.Join(test.FindTestdataFolder(t), "jwtkeys", "oauth2-rsa-sk.pem"))
+		require.NoError(t, err)
+		key, err = jwt.ParseRSAPrivateKeyFromPEM(bts)
+		require.NoError(t, err)
+	case *jwt.SigningMethodECDSA:
+		bts, err := ioutil.ReadFile(filepath.Join(test.FindTestdataFolder(t), "jwtkeys", "oauth2-ec-sk.pem"))
+		require.NoError(t, err)
+		key, err = jwt.ParseECPrivateKeyFromPEM(bts)
+		require.NoError(t, err)
+	case *jwt.SigningMethodHMAC:
+		key = []byte("secret")
+	}
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	j, err := token.SignedString(key)
+	require.NoError(t, err)
+	return j
+}
+
+func oauth2Claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss": "https://idp.example.com",
+		"aud": []string{"irmaserver", "otherservice"},
+		"sub": "myapp",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func bearerHeaders(token string) http.Header {
+	return http.Header{
+		"Authorization": {"Bearer " + token},
+		"Content-Type":  {"application/json"},
+	}
+}
+
+func TestOAuth2Authenticator(t *testing.T) {
+	auth := oauth2Authenticator(t, oauth2Conf(t))
+
+	t.Run("valid RSA", func(t *testing.T) {
+		token := oauth2Token(t, jwt.SigningMethodRS256, "oauth2-rsa", oauth2Claims())
+		applies, request, requestor, err := auth.AuthenticateSession(bearerHeaders(token), oauth2RequestBody)
+		require.Nil(t, err)
+		require.True(t, applies)
+		require.Equal(t, "myapp", requestor)
+		require.Equal(t, "irma-demo.RU.studentCard.studentID", request.SessionRequest().Disclosure().Disclose[0][0][0].Type.String())
+	})
+
+	t.Run("valid ECDSA", func(t *testing.T) {
+		token := oauth2Token(t, jwt.SigningMethodES256, "oauth2-ec", oauth2Claims())
+		applies, _, requestor, err := auth.AuthenticateSession(bearerHeaders(token), oauth2RequestBody)
+		require.Nil(t, err)
+		require.True(t, applies)
+		require.Equal(t, "myapp", requestor)
+	})
+
+	t.Run("requestor claim", func(t *testing.T) {
+		conf := oauth2Conf(t)
+		conf.OAuth2RequestorClaim = "client_id"
+		auth := oauth2Authenticator(t, conf)
+		claims := oauth2Claims()
+		claims["sub"] = "someone"
+		claims["client_id"] = "myapp"
+		token := oauth2Token(t, jwt.SigningMethodRS256, "oauth2-rsa", claims)
+		applies, _, requestor, err := auth.AuthenticateSession(bearerHeaders(token), oauth2RequestBody)
+		require.Nil(t, err)
+		require.True(t, applies)
+		require.Equal(t, "myapp", requestor)
+	})
+
+	t.Run("not applicable", func(t *testing.T) {
+		applies, _, _, err := auth.AuthenticateSession(http.Header{"Content-Type": {"application/json"}}, oauth2RequestBody)
+		require.Nil(t, err)
+		require.False(t, applies)
+
+		applies, _, _, err = auth.AuthenticateSession(bearerHeaders("notajwt"), oauth2RequestBody)
+		require.Nil(t, err)
+		require.False(t, applies)
+	})
+
+	invalid := map[string]func() string{
+		"expired": func() string {
+			claims := oauth2Claims()
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			return oauth2Token(t, jwt.SigningMethodRS256, "oauth2-rsa", claims)
+		},
+		"no expiry": func() string {
+			claims := oauth2Claims()
+			delete(claims, "exp")
+			return oauth2Token(t, jwt.SigningMethodRS256, "oauth2-rsa", claims)
+		},
+		"wrong issuer": func() string {
+			claims := oauth2Claims()
+			claims["iss"] = "https://evil.example.com"
+			return oauth2Token(t, jwt.SigningMethodRS256, "oauth2-rsa", claims)
+		},
+		"wrong audience": func() string {
+			claims := oauth2Claims()
+			claims["aud"] = "otherservice"
+			return oauth2Token(t, jwt.SigningMethodRS256, "oauth2-rsa", claims)
+		},
+		"unknown requestor": func() string {
+			claims := oauth2Claims()
+			claims["sub"] = "yourapp"
+			return oauth2Token(t, jwt.SigningMethodRS256, "oauth2-rsa", claims)
+		},
+		"unknown kid": func() string {
+			return oauth2Token(t, jwt.SigningMethodRS256, "nonexisting", oauth2Claims())
+		},
+		"wrong key": func() string {
+			return oauth2Token(t, jwt.SigningMethodES256, "oauth2-rsa", oauth2Claims())
+		},
+		"hmac": func() string {
+			return oauth2Token(t, jwt.SigningMethodHS256, "oauth2-rsa", oauth2Claims())
+		},
+	}
+	for name, token := range invalid {
+		t.Run(name, func(t *testing.T) {
+			applies, _, _, err := auth.AuthenticateSession(bearerHeaders(token()), oauth2RequestBody)
+			require.True(t, applies)
+			require.NotNil(t, err)
+			require.Equal(t, server.ErrorUnauthorized.Status, err.Status)
+		})
+	}
+
+	t.Run("preshared key authenticator does not apply", func(t *testing.T) {
+		pskauth := PresharedKeyAuthenticator{presharedkeys: map[string]string{"token": "otherapp"}}
+		token := oauth2Token(t, jwt.SigningMethodRS256, "oauth2-rsa", oauth2Claims())
+		applies, _, _, err := pskauth.AuthenticateSession(bearerHeaders(token), oauth2RequestBody)
+		require.Nil(t, err)
+		require.False(t, applies)
+	})
+}
+
+func TestOAuth2JwksURL(t *testing.T) {
+	defer func(interval time.Duration) { jwksMinRefreshInterval = interval }(jwksMinRefreshInterval)
+	jwksMinRefreshInterval = 0
+
+	conf := oauth2Conf(t)
+	jwks, err := ioutil.ReadFile(conf.OAuth2JwksFile)
+	require.NoError(t, err)
+	var fetches int32
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		_, _ = w.Write(jwks)
+	}))
+	defer jwksServer.Close()
+
+	conf.OAuth2JwksFile = ""
+	conf.OAuth2JwksURL = jwksServer.URL
+	auth := oauth2Authenticator(t, conf)
+	require.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	token := oauth2Token(t, jwt.SigningMethodRS256, "oauth2-rsa", oauth2Claims())
+	applies, _, requestor, rerr := auth.AuthenticateSession(bearerHeaders(token), oauth2RequestBody)
+	require.Nil(t, rerr)
+	require.True(t, applies)
+	require.Equal(t, "myapp", requestor)
+	require.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// An unknown key causes the JWKS to be fetched again
+	token = oauth2Token(t, jwt.SigningMethodRS256, "nonexisting", oauth2Claims())
+	_, _, _, rerr = auth.AuthenticateSession(bearerHeaders(token), oauth2RequestBody)
+	require.NotNil(t, rerr)
+	require.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
+
+func TestOAuth2JwksFetchDoesNotBlock(t *testing.T) {
+	defer func(interval time.Duration) { jwksMinRefreshInterval = interval }(jwksMinRefreshInterval)
+	jwksMinRefreshInterval = 0
+
+	conf := oauth2Conf(t)
+	jwks, err := ioutil.ReadFile(conf.OAuth2JwksFile)
+	require.NoError(t, err)
+	var fetches int32
+	release := make(chan struct{})
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		_, _ = w.Write(jwks)
+	}))
+	defer jwksServer.Close()
+	defer close(release)
+
+	conf.OAuth2JwksFile = ""
+	conf.OAuth2JwksURL = jwksServer.URL
+	auth := oauth2Authenticator(t, conf)
+
+	// A JWT signed by an unknown key causes a fetch, which hangs
+	go func() {
+		token := oauth2Token(t, jwt.SigningMethodRS256, "nonexisting", oauth2Claims())
+		_, _, _, _ = auth.AuthenticateSession(bearerHeaders(token), oauth2RequestBody)
+	}()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&fetches) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	// Meanwhile, JWTs signed by known keys are still verified
+	done := make(chan struct{})
+	go func() {
+		token := oauth2Token(t, jwt.SigningMethodRS256, "oauth2-rsa", oauth2Claims())
+		_, _, requestor, rerr := auth.AuthenticateSession(bearerHeaders(token), oauth2RequestBody)
+		require.Nil(t, rerr)
+		require.Equal(t, "myapp", requestor)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("verification blocked by JWKS fetch")
+	}
+}
+
+func TestOAuth2Configuration(t *testing.T) {
+	conf := oauth2Conf(t)
+	conf.OAuth2JwksURL = "https://idp.example.com/jwks"
+	_, err := newOAuth2Authenticator(conf)
+	require.Error(t, err)
+
+	conf = oauth2Conf(t)
+	conf.OAuth2JwksFile = ""
+	auth, err := newOAuth2Authenticator(conf)
+	require.NoError(t, err)
+	require.Error(t, auth.Initialize("myapp", conf.Requestors["myapp"]))
+
+	conf = oauth2Conf(t)
+	conf.OAuth2Audience = ""
+	auth, err = newOAuth2Authenticator(conf)
+	require.NoError(t, err)
+	require.Error(t, auth.Initialize("myapp", conf.Requestors["myapp"]))
+}
@@ -14,10 +14,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/cors"
+	"github.com/go-errors/errors"
 	"github.com/golang-jwt/jwt/v4"
 	irma "github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/internal/common"
@@ -32,6 +34,8 @@ type Server struct {
 	irmaserv *irmaserver.Server
 	stop     chan struct{}
 	stopped  chan struct{}
+
+	reloadLock sync.Mutex
 }
 
 // Start the server. If successful then it will not return until Stop() is called.
@@ -133,6 +137,38 @@ func (s *Server) Stop() {
 	}
 }
 
+// ReloadRequestors replaces the requestors and global permissions of the server, re-reading
+// the authentication keys of the requestors from disk. The new configuration is validated
+// before it is put into use; if it is invalid, an error is returned and the current
+// configuration remains in use. Sessions that have already been started are not affected.
+func (s *Server) ReloadRequestors(requestors map[string]Requestor, permissions Permissions) error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	authLock.RLock()
+	conf := *s.conf
+	authLock.RUnlock()
+	conf.Requestors = requestors
+	conf.Permissions = permissions
+
+	if err := conf.validatePermissions(); err != nil {
+		return server.LogError(errors.WrapPrefix(err, "Reloaded requestor configuration is invalid", 0))
+	}
+	auths, err := conf.newAuthenticators()
+	if err != nil {
+		return server.LogError(errors.WrapPrefix(err, "Reloaded requestor configuration is invalid", 0))
+	}
+
+	authLock.Lock()
+	s.conf.Requestors = requestors
+	s.conf.Permissions = permissions
+	authenticators = auths
+	authLock.Unlock()
+
+	s.conf.Logger.WithField("requestors", len(requestors)).Info("Reloaded requestor configuration")
+	return nil
+}
+
 func New(config *Configuration) (*Server, error) {
 	irmaserv, err := irmaserver.New(config.Configuration)
 	if err != nil {
@@ -227,6 +263,22 @@ func (s *Server) Handler() http.Handler {
 		r.Post("/revocation", s.handleRevocation)
 	})
 
+	router.Get("/health", s.handleHealth)
+	if s.conf.EnableMetrics {
+		router.Get("/metrics", server.MetricsHandler().ServeHTTP)
+	}
+
+	if s.conf.AdminToken != "" {
+		router.Group(func(r chi.Router) {
+			r.Use(server.SizeLimitMiddleware)
+			r.Use(server.TimeoutMiddleware(nil, server.WriteTimeout))
+			if s.conf.Verbose >= 2 {
+				r.Use(server.LogMiddleware("admin", log))
+			}
+			s.attachAdminEndpoints(r)
+		})
+	}
+
 	return s.prefixRouter(router)
 }
 
@@ -261,8 +313,9 @@ func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 		rerr      *irma.RemoteError
 		applies   bool
 	)
-	for _, authenticator := range authenticators { // rrequest abbreviates "requestor request"
-		applies, rrequest, requestor, rerr = authenticator.AuthenticateSession(r.Header, body)
+	headers := s.authenticationHeaders(r)
+	for _, authenticator := range currentAuthenticators() { // rrequest abbreviates "requestor request"
+		applies, rrequest, requestor, rerr = authenticator.AuthenticateSession(headers, body)
 		if applies || rerr != nil {
 			break
 		}
@@ -301,8 +354,9 @@ func (s *Server) handleRevocation(w http.ResponseWriter, r *http.Request) {
 		rerr      *irma.RemoteError
 		applies   bool
 	)
-	for _, authenticator := range authenticators {
-		applies, revreq, requestor, rerr = authenticator.AuthenticateRevocation(r.Header, body)
+	headers := s.authenticationHeaders(r)
+	for _, authenticator := range currentAuthenticators() {
+		applies, revreq, requestor, rerr = authenticator.AuthenticateRevocation(headers, body)
 		if applies || rerr != nil {
 			break
 		}
@@ -314,6 +368,25 @@ func (s *Server) handleRevocation(w http.ResponseWriter, r *http.Request) {
 	s.revoke(w, requestor, revreq)
 }
 
+// handleHealth reports whether the revocation state of the server is fresh enough for nonrevocation
+// proofs to be accepted, with status 503 if it is not.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := s.irmaserv.GetRevocationHealth()
+	bts, err := json.Marshal(health)
+	if err != nil {
+		_ = server.LogError(err)
+		server.WriteError(w, server.ErrorUnknown, err.Error())
+		return
+	}
+	status := http.StatusOK
+	if !health.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(bts)
+}
+
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	requestorToken := r.Context().Value("requestorToken").(irma.RequestorToken)
 
@@ -518,11 +591,12 @@ func (s *Server) createSession(w http.ResponseWriter, requestor string, rrequest
 		s.conf.Logger.WithFields(logrus.Fields{"requestor": requestor}).Warn("nextSession provided with empty URL")
 		server.WriteError(w, server.ErrorInvalidRequest, "nextSession provided with empty URL")
 	}
-	if s.conf.JwtRSAPrivateKey == nil && !s.conf.AllowUnsignedCallbacks {
+	if !s.conf.AllowUnsignedCallbacks {
 		var field string
-		if rrequest.Base().CallbackURL != "" {
+		if rrequest.Base().CallbackURL != "" && !s.conf.CanSignCallbacks() {
 			field = "callbackUrl"
-		} else if rrequest.Base().NextSession != nil {
+		} else if rrequest.Base().NextSession != nil && s.conf.JwtRSAPrivateKey == nil {
+			// the result is POSTed to the next session URL as a JWT, so an HMAC key does not suffice here
 			field = "nextSession"
 		}
 		if field != "" {
@@ -534,11 +608,14 @@ func (s *Server) createSession(w http.ResponseWriter, requestor string, rrequest
 	}
 
 	// Everything is authenticated and parsed, we're good to go!
-	qr, requestorToken, frontendRequest, err := s.irmaserv.StartSession(rrequest, nil)
+	qr, requestorToken, frontendRequest, err := s.irmaserv.StartRequestorSession(requestor, rrequest, nil)
 	if err != nil {
-		if _, ok := err.(*irmaserver.RedisError); ok {
+		switch err.(type) {
+		case *irmaserver.LimitExceededError:
+			s.writeLimitError(w, requestor, err)
+		case *irmaserver.RedisError, *irmaserver.SQLError:
 			server.WriteError(w, server.ErrorInternal, "")
-		} else {
+		default:
 			server.WriteError(w, server.ErrorInvalidRequest, err.Error())
 		}
 		return
@@ -559,21 +636,53 @@ func (s *Server) revoke(w http.ResponseWriter, requestor string, request *irma.R
 		server.WriteError(w, server.ErrorUnauthorized, reason)
 		return
 	}
-	var issued time.Time
-	if request.Issued != 0 {
-		issued = time.Unix(0, request.Issued)
+	keys := request.RevocationKeys()
+	if len(keys) == 0 {
+		server.WriteError(w, server.ErrorInvalidRequest, "no revocationKey specified")
+		return
+	}
+	// Each revoked credential counts towards the limit, also when revoked in a batch
+	max := s.conf.requestor(requestor).MaxRevocationsPerHour
+	if max > 0 && len(keys) > max {
+		s.writeLimitError(w, requestor, &irmaserver.LimitExceededError{
+			Requestor: requestor, Limit: "revocations per hour", Max: max,
+		})
+		return
+	}
+	if err := s.irmaserv.CheckRevocationLimit(requestor, max, len(keys)); err != nil {
+		s.writeLimitError(w, requestor, err)
+		return
 	}
-	if err := s.irmaserv.Revoke(request.CredentialType, request.Key, issued); err != nil {
-		if err == irma.ErrUnknownRevocationKey {
-			server.WriteError(w, server.ErrorUnknownRevocationKey, request.Key)
+	err := s.irmaserv.RevokeBatch(request.CredentialType, keys)
+	if err != nil {
+		// Nothing was revoked, so the batch does not count towards the limit
+		if err := s.irmaserv.RefundRevocationLimit(requestor, max, len(keys)); err != nil {
+			_ = server.LogError(err)
+		}
+		if errors.Is(err, irma.ErrUnknownRevocationKey) {
+			server.WriteError(w, server.ErrorUnknownRevocationKey, err.Error())
 		} else {
 			server.WriteError(w, server.ErrorRevocation, err.Error())
 		}
 		return
 	}
+	s.conf.Audit(&server.AuditEvent{
+		Type:        server.AuditEventRevoked,
+		Requestor:   requestor,
+		Credentials: []string{request.CredentialType.String()},
+	})
 	server.WriteString(w, "OK")
 }
 
+func (s *Server) writeLimitError(w http.ResponseWriter, requestor string, err error) {
+	if _, ok := err.(*irmaserver.LimitExceededError); !ok {
+		server.WriteError(w, server.ErrorInternal, "")
+		return
+	}
+	s.conf.Logger.WithFields(logrus.Fields{"requestor": requestor}).Warn(err.Error())
+	server.WriteError(w, server.ErrorTooManyRequests, err.Error())
+}
+
 func (s *Server) checkAuth(w http.ResponseWriter, r *http.Request, rerr *irma.RemoteError, applies bool, body []byte) bool {
 	if rerr != nil {
 		_ = server.LogError(rerr)
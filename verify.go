@@ -171,6 +171,10 @@ func (pl ProofList) VerifyProofs(
 	if request != nil {
 		revParams = request.Base().Revocation
 	}
+	if validAt == nil {
+		t := time.Now()
+		validAt = &t
+	}
 	for i, proof := range pl {
 		proofd, ok := proof.(*gabi.ProofD)
 		if !ok {
@@ -224,6 +228,14 @@ func (pl ProofList) VerifyProofs(
 		if u := updates[sig.PKCounter]; u != nil {
 			ours = u.Events[len(u.Events)-1].Index
 		}
+		// Without access to the revocation server, the accumulator snapshot (if any) is our reference
+		snapshot, err := settings.snapshotAccumulator(sig.PKCounter, *validAt)
+		if err != nil {
+			return false, nil, err
+		}
+		if snapshot != nil && snapshot.Index > ours {
+			ours = snapshot.Index
+		}
 		if ours > theirs {
 			return false, nil, nil
 		}
@@ -231,10 +243,9 @@ func (pl ProofList) VerifyProofs(
 			if settings.updated.After(acctime) {
 				acctime = settings.updated
 			}
-		}
-		if validAt == nil {
-			t := time.Now()
-			validAt = &t
+			if snapshot != nil && snapshot.Index == theirs && time.Unix(snapshot.Time, 0).After(acctime) {
+				acctime = time.Unix(snapshot.Time, 0)
+			}
 		}
 		tolerance := settings.Tolerance
 		if s := revParams[id]; s != nil && s.Tolerance != 0 {
@@ -289,6 +300,25 @@ func (d *Disclosure) extraIndices(condiscon AttributeConDisCon) []*DisclosedAttr
 // the disjunction list. The first return parameter of this function indicates whether or not all
 // disjunctions (if present) are satisfied.
 func (d *Disclosure) DisclosedAttributes(configuration *Configuration, condiscon AttributeConDisCon, revtimes map[int]*time.Time) (bool, [][]*DisclosedAttribute, error) {
+	return d.disclosedAttributes(configuration, condiscon, revtimes, nil)
+}
+
+// DisclosedRequestAttributes is DisclosedAttributes for the attributes requested in the specified
+// request, which may be nil. In self-revocation requests the holder additionally discloses the
+// revocation attribute of the credential to be revoked, which is not included in the result.
+func (d *Disclosure) DisclosedRequestAttributes(configuration *Configuration, request *DisclosureRequest, revtimes map[int]*time.Time) (bool, [][]*DisclosedAttribute, error) {
+	if request == nil {
+		return d.disclosedAttributes(configuration, nil, revtimes, nil)
+	}
+	return d.disclosedAttributes(configuration, request.Disclose, revtimes, request.SelfRevocation)
+}
+
+func (d *Disclosure) disclosedAttributes(
+	configuration *Configuration,
+	condiscon AttributeConDisCon,
+	revtimes map[int]*time.Time,
+	selfRevocation *CredentialTypeIdentifier,
+) (bool, [][]*DisclosedAttribute, error) {
 	if revtimes == nil {
 		revtimes = map[int]*time.Time{}
 	}
@@ -300,6 +330,9 @@ func (d *Disclosure) DisclosedAttributes(configuration *Configuration, condiscon
 	var extra []*DisclosedAttribute
 	indices := d.extraIndices(condiscon)
 	for _, index := range indices {
+		if selfRevocation != nil && d.isRevocationAttribute(configuration, index, *selfRevocation) {
+			continue
+		}
 		attr, _, err := extractAttribute(d.Proofs, index, revtimes[index.CredentialIndex], configuration)
 		if err != nil {
 			return false, nil, err
@@ -314,6 +347,37 @@ func (d *Disclosure) DisclosedAttributes(configuration *Configuration, condiscon
 	return complete, list, nil
 }
 
+// RevocationAttribute returns the revocation attribute of the credential of the specified type, as
+// disclosed by its holder in a self-revocation session.
+func (d *Disclosure) RevocationAttribute(configuration *Configuration, id CredentialTypeIdentifier) (*big.Int, error) {
+	for _, proof := range d.Proofs {
+		proofd, ok := proof.(*gabi.ProofD)
+		if !ok {
+			continue
+		}
+		credtype := MetadataFromInt(proofd.ADisclosed[1], configuration).CredentialType()
+		if credtype == nil || credtype.Identifier() != id || !credtype.RevocationSupported() {
+			continue
+		}
+		if attr := proofd.ADisclosed[credtype.RevocationIndex+2]; attr != nil {
+			return attr, nil
+		}
+	}
+	return nil, errors.Errorf("revocation attribute of %s not disclosed", id)
+}
+
+// isRevocationAttribute returns whether the attribute at the index is the revocation attribute of
+// a credential of the specified type.
+func (d *Disclosure) isRevocationAttribute(configuration *Configuration, index *DisclosedAttributeIndex, id CredentialTypeIdentifier) bool {
+	proofd, ok := d.Proofs[index.CredentialIndex].(*gabi.ProofD)
+	if !ok {
+		return false
+	}
+	credtype := MetadataFromInt(proofd.ADisclosed[1], configuration).CredentialType()
+	return credtype != nil && credtype.Identifier() == id && credtype.RevocationSupported() &&
+		index.AttributeIndex == credtype.RevocationIndex+2
+}
+
 func parseAttribute(index int, metadata *MetadataAttribute, attr *big.Int) (*DisclosedAttribute, *string, error) {
 	var attrid AttributeTypeIdentifier
 	var attrval *string
@@ -361,11 +425,11 @@ func (d *Disclosure) VerifyAgainstRequest(
 	}
 
 	// Next extract the contained attributes from the proofs, and match them to the signature request if present
-	var required AttributeConDisCon
+	var disclosureRequest *DisclosureRequest
 	if request != nil {
-		required = request.Disclosure().Disclose
+		disclosureRequest = request.Disclosure()
 	}
-	allmatched, list, err := d.DisclosedAttributes(configuration, required, revtimes)
+	allmatched, list, err := d.DisclosedRequestAttributes(configuration, disclosureRequest, revtimes)
 	if err != nil {
 		return nil, ProofStatusInvalid, err
 	}